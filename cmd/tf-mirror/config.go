@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configIgnored lists flags that make no sense in a config file
+var configIgnored = map[string]bool{
+	"config":  true,
+	"help":    true,
+	"version": true,
+}
+
+// applyConfigFile loads a YAML file whose keys are long flag names, e.g.
+//
+//	mode: downloader
+//	provider-filter: [hashicorp/aws, hashicorp/helm]
+//	max-concurrent: 20
+//
+// A value is used only if the flag was not given on the command line and no
+// environment variable was applied to it (the flag still holds its default;
+// an env value that failed to parse does not count), so the precedence is
+// flag > env > file > default. It must run after the environment overrides.
+// Unknown keys are reported as an error.
+func applyConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	var unknown []string
+	for key := range values {
+		if flag.Lookup(key) == nil || configIgnored[key] {
+			unknown = append(unknown, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+	sort.Strings(keys)
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for _, key := range keys {
+		if explicit[key] || envApplied(flag.Lookup(key)) {
+			continue
+		}
+		// Repeatable flags such as --header take each list item separately
+		if items, ok := values[key].([]any); ok {
+			if _, repeatable := flag.Lookup(key).Value.(*stringList); repeatable {
+				for _, item := range items {
+					value, err := configValue(item)
+					if err != nil {
+						return fmt.Errorf("invalid value for %s in config file %s: %w", key, path, err)
+					}
+					flag.Set(key, value)
+				}
+				continue
+			}
+		}
+		value, err := configValue(values[key])
+		if err != nil {
+			return fmt.Errorf("invalid value for %s in config file %s: %w", key, path, err)
+		}
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("invalid value for %s in config file %s: %w", key, path, err)
+		}
+	}
+	return nil
+}
+
+// envApplied reports whether an environment override changed f from its
+// default. Flags are not set from the file before the overrides run, so any
+// change not made on the command line came from the environment.
+func envApplied(f *flag.Flag) bool {
+	return f.Value.String() != f.DefValue
+}
+
+// configValue converts a YAML value into flag syntax. Lists become the
+// comma-separated form used by the filter and allowlist flags.
+func configValue(raw any) (string, error) {
+	switch v := raw.(type) {
+	case nil:
+		return "", nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", fmt.Errorf("expected a scalar or a list, got a mapping")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
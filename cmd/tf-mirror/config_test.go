@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyConfigFilePrecedence(t *testing.T) {
+	fileValue := flag.String("cfgtest-file", "default", "")
+	explicitValue := flag.String("cfgtest-explicit", "default", "")
+	envValue := flag.Int("cfgtest-env", 5, "")
+	badEnvValue := flag.Int("cfgtest-bad-env", 5, "")
+	var listValue stringList
+	flag.Var(&listValue, "cfgtest-list", "")
+
+	// A flag given on the command line
+	if err := flag.Set("cfgtest-explicit", "command-line"); err != nil {
+		t.Fatal(err)
+	}
+	// An environment override that was applied, and one that failed to parse
+	// and left the default (like CFGTEST_BAD_ENV=abc would)
+	*envValue = 7
+	t.Setenv("CFGTEST_BAD_ENV", "abc")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := `cfgtest-file: from-file
+cfgtest-explicit: from-file
+cfgtest-env: 9
+cfgtest-bad-env: 9
+cfgtest-list: [a, b]
+`
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(path); err != nil {
+		t.Fatalf("applyConfigFile: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"file fills a default", *fileValue, "from-file"},
+		{"command line wins", *explicitValue, "command-line"},
+		{"applied env wins", *envValue, 7},
+		{"unparsable env does not block the file", *badEnvValue, 9},
+		{"repeatable flag takes each item", listValue.String(), "a, b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyConfigFileErrors(t *testing.T) {
+	flag.String("cfgtest-errors", "", "")
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"unknown key", "no-such-flag: 1\n"},
+		{"ignored key", "config: other.yaml\n"},
+		{"mapping value", "cfgtest-errors: {a: b}\n"},
+		{"invalid yaml", "cfgtest-errors: [\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyConfigFile(path); err == nil {
+				t.Errorf("applyConfigFile accepted %q", tt.config)
+			}
+		})
+	}
+}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"tf-mirror/internal/common"
 	"tf-mirror/internal/downloader"
-	binaries "tf-mirror/internal/downloader/binaries"
+	"tf-mirror/internal/downloader/binaries"
+	"tf-mirror/internal/lockfile"
 	"tf-mirror/internal/server"
 )
 
@@ -21,25 +31,74 @@ type Mode string
 const (
 	ModeDownloader Mode = "downloader"
 	ModeServer     Mode = "server"
+	ModeLockfile   Mode = "lockfile"
 )
 
 func main() {
 	// Common flags
 	var (
-		mode    = flag.String("mode", "", "Application mode: 'downloader' or 'server' (required)")
+		mode    = flag.String("mode", "", "Application mode: 'downloader', 'server' or 'lockfile' (required)")
+		regURL  = flag.String("registry", common.TerraformRegistryURL, "Upstream registry URL; its hostname is the top-level directory of the mirror")
+		cfgFile = flag.String("config", "", "YAML file with flag values; flags and environment variables take precedence")
 		help    = flag.Bool("help", false, "Show help message")
 		version = flag.Bool("version", false, "Show version information")
 		debug   = flag.Bool("debug", false, "Enable debug logging")
+		logFmt  = flag.String("log-format", "text", "Log output format: 'text' or 'json'")
+		logFile = flag.String("log-file", "", "Also write logs to this file")
+		logSize = flag.Int("log-max-size-mb", 0, "Rotate --log-file when it exceeds this size in MB (0 = never)")
+		logLvl  = flag.String("log-level", "", "Minimum log level: 'error', 'warn', 'info' or 'debug' (default: info, debug with --debug)")
 
 		// Downloader flags
 		proxy            = flag.String("proxy", "", "HTTP/HTTPS/SOCKS proxy URL for downloading packages")
-		checkPeriod      = flag.Int("check-period", 24, "Period for checking new versions in hours")
+		checkPeriod      = flag.String("check-period", "24", "Period for checking new versions: hours, or a duration like 30m or 2h30m")
+		checkJitter      = flag.Float64("check-jitter", 0, "Shift each scheduled check randomly by up to this fraction of --check-period (e.g. 0.1, 0 = no jitter)")
 		downloadPath     = flag.String("download-path", "", "Directory for downloading packages (required for downloader mode)")
+		stagingPath      = flag.String("staging-path", "", "Download into this directory and move each complete provider into --download-path")
 		providerFilter   = flag.String("provider-filter", "", "Comma-separated list of providers to download (namespace/name format, e.g., 'hashicorp/aws,hashicorp/helm')")
+		filterPrecedence = flag.String("filter-precedence", "exclude", "Which provider filter rule wins when a provider matches both an include and an exclude: 'exclude', or 'include' unless the exclude is more specific")
+		moduleFilter     = flag.String("module-filter", "", "Comma-separated list of modules to mirror (namespace/name/system format, e.g., 'terraform-aws-modules/vpc/aws>5.0.0')")
+		globalMinVersion = flag.String("global-min-version", "", "Minimum version for all providers without a per-provider minimum in --provider-filter (e.g., '1.0.0')")
+		keepLatest       = flag.Int("keep-latest", 0, "Download only the newest N versions of each provider after version filters (0 = all)")
+		prerelease       = flag.Bool("include-prerelease", false, "Also mirror prerelease provider, module and binary versions (e.g. 5.0.0-beta1)")
+		prune            = flag.Bool("prune", false, "After a successful pass, delete mirrored provider versions that no longer satisfy the filters")
+		pruneDryRun      = flag.Bool("prune-dry-run", false, "With --prune, only log the versions that would be deleted")
+		dedupe           = flag.Bool("dedupe", false, "Hardlink identical provider archives to a shared blob under <download-path>/_blobs")
 		platformFilter   = flag.String("platform-filter", "", "Comma-separated list of platforms to download (os_arch format, e.g., 'linux_amd64,darwin_arm64')")
+		platformAuto     = flag.Bool("platform-auto", false, "Without --platform-filter, mirror only the host platform plus linux_amd64")
+		allPlatforms     = flag.Bool("all-platforms", false, "Mirror all supported platforms, overriding --platform-auto")
+		maxConcurrent    = flag.Int("max-concurrent", common.DefaultMaxConcurrent, "Number of parallel download workers, also used to list provider versions while planning")
 		maxAttempts      = flag.Int("max-attempts", 5, "Maximum download attempts per provider (default: 5)")
 		downloadTimeout  = flag.Int("download-timeout", 180, "Download timeout per attempt in seconds (default: 180)")
+		providerTimeout  = flag.Int("provider-timeout", 0, "Seconds a session may spend on one provider across all its downloads and attempts (0 = no cap)")
+		retryBaseDelay   = flag.Int("retry-base-delay", 2, "Backoff before retrying a failed download in seconds, doubled on each attempt (default: 2)")
+		retryMaxDelay    = flag.Int("retry-max-delay", 60, "Maximum backoff between download attempts in seconds, before jitter (default: 60)")
 		downloadBinaries = flag.String("download-binaries", "", "Comma-separated list of binaries to download from releases.hashicorp.com (e.g., 'consul>1.21.3,nomad>1.6.0')")
+		requireBinSums   = flag.Bool("require-binary-checksums", false, "Skip HashiCorp binaries whose SHA256SUMS cannot be fetched instead of downloading them unverified")
+		eventsNDJSON     = flag.Bool("events-ndjson", false, "Emit downloader events as NDJSON to stdout (human logs go to stderr)")
+		sample           = flag.String("sample", "", "Deterministically sample discovered providers for testing ('1%' or a count like '50')")
+		verifySigs       = flag.Bool("verify-signatures", false, "Verify GPG signatures of provider SHA256SUMS files")
+		rebuildMetadata  = flag.Bool("rebuild-metadata", false, "Rebuild metadata and index files from the archives on disk, then exit")
+		verifyMirror     = flag.Bool("verify", false, "Re-check all archives on disk against their recorded hashes, print a JSON report, then exit")
+		verifyDelete     = flag.Bool("verify-delete", false, "With --verify: delete corrupt archives so the next run downloads them again")
+		cleanTmp         = flag.Bool("clean-tmp", false, "On startup, remove .tmp files left in --download-path by interrupted downloads")
+		cleanTmpAge      = flag.Int("clean-tmp-age", 3600, "With --clean-tmp: only remove .tmp files not modified for this many seconds")
+		once             = flag.Bool("once", false, "Run a single download pass and exit (non-zero exit code if any download failed)")
+		incrementalDisc  = flag.Bool("incremental-discovery", false, "Only process providers that are new or changed in the registry listing since the last successful run")
+		summaryJSON      = flag.String("summary-json", "", "Write a JSON report of each download session (counts, bytes, per-provider failures) to this path")
+		progress         = flag.Bool("progress", false, "Periodically log overall download progress with an ETA")
+		failThreshold    = flag.Float64("fail-threshold", 0, "Percentage of failed provider downloads tolerated before a run counts as failed (0 = any failure)")
+		maxBandwidth     = flag.Int64("max-bandwidth", 0, "Aggregate provider download cap in bytes per second across all workers (0 = unlimited)")
+		maxDiskBytes     = flag.Int64("max-disk-bytes", 0, "Stop downloading once the download and staging paths hold this many bytes (0 = no cap)")
+		maxDiskPercent   = flag.Int("max-disk-percent", 0, "Stop downloading once the filesystem of --download-path is this percent full (0 = no cap)")
+		requireFreeSpace = flag.Bool("require-free-space", false, "Skip a download session whose estimated size exceeds the free disk space instead of only warning")
+		maxConnsPerHost  = flag.Int("max-conns-per-host", 0, "Maximum concurrent connections per upstream host (0 = unlimited)")
+		circuitFailures  = flag.Int("circuit-failures", common.DefaultCircuitFailures, "Consecutive failures to an upstream host before its requests are short-circuited (0 = disabled)")
+		circuitCooldown  = flag.Int("circuit-cooldown", int(common.DefaultCircuitCooldown.Seconds()), "Seconds an open circuit short-circuits requests before a probe request")
+		tlsMinOutbound   = flag.String("tls-min-outbound", "1.2", "Minimum TLS version for outbound connections to registry and releases (1.2 or 1.3)")
+		caCert           = flag.String("ca-cert", "", "PEM bundle of extra CA certificates trusted for outbound connections (e.g. a corporate proxy CA)")
+		regToken         = flag.String("registry-token", "", "Bearer token sent to the --registry host, e.g. for a private registry")
+		userAgent        = flag.String("user-agent", common.UserAgent, "User-Agent for outbound requests")
+		insecureTLS      = flag.Bool("insecure-skip-verify", false, "Do not verify upstream TLS certificates (insecure, last resort)")
 
 		// Server flags
 		listenHost = flag.String("listen-host", "", "Address to listen on (default: all interfaces)")
@@ -48,39 +107,161 @@ func main() {
 		enableTLS  = flag.Bool("enable-tls", false, "Enable HTTPS")
 		tlsCert    = flag.String("tls-crt", "", "Path to TLS certificate file (required if --enable-tls is set)")
 		tlsKey     = flag.String("tls-key", "", "Path to TLS private key file (required if --enable-tls is set)")
+		tlsMin     = flag.String("tls-min-version", "1.2", "Minimum TLS version accepted from clients (1.2 or 1.3)")
 		dataPath   = flag.String("data-path", "", "Path to directory containing downloaded packages (required for server mode)")
+		allowHosts = flag.String("allowed-hosts", "", "Comma-separated list of host aliases allowed in generated download URLs (default: --hostname only)")
+		statsTTL   = flag.Int("stats-ttl", 60, "Seconds to cache the /stats disk usage report")
+		readTO     = flag.Int("read-timeout", 30, "Seconds to read a whole request, including the body (0 = no timeout)")
+		writeTO    = flag.Int("write-timeout", 30, "Seconds to write a whole response (0 = no timeout, for large archives over slow links)")
+		idleTO     = flag.Int("idle-timeout", 120, "Seconds an idle keep-alive connection stays open (0 = use --read-timeout)")
+		authToken  = flag.String("auth-token", "", "Require 'Authorization: Bearer <token>' on all routes except /health and /ready")
+		allowCIDR  = flag.String("allow-cidr", "", "Comma-separated list of client CIDRs allowed to use the server (default: all)")
+		trustProxy = flag.Bool("trust-proxy", false, "Take the client IP from X-Forwarded-For (set only behind a reverse proxy)")
+		enableUI   = flag.Bool("enable-ui", false, "Serve an HTML overview of the mirrored providers and binaries at /ui")
+		pullThru   = flag.Bool("pull-through", false, "Fetch provider archives missing from --data-path from the upstream registry on request")
+		allowFiles = flag.String("allowed-files", "", "Comma-separated list of file suffixes the server may serve (e.g., '.zip,.json,SHA256SUMS,.sig')")
 	)
+	var headers stringList
+	flag.Var(&headers, "header", "Extra 'Name: value' header for outbound requests (repeatable)")
+	var binSources stringList
+	flag.Var(&binSources, "binary-source", "Non-HashiCorp tool for --download-binaries: '<tool>=github:<owner>/<repo>,<url template>[,<checksums url template>]' (repeatable)")
+	var sniCerts stringList
+	flag.Var(&sniCerts, "tls-sni-cert", "Additional 'cert.pem,key.pem' pair served by SNI (repeatable)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [OPTIONS]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Terraform Registry Mirror - Unified Application\n\n")
-		fmt.Fprintf(os.Stderr, "This application can run in two modes:\n")
-		fmt.Fprintf(os.Stderr, "  downloader - Downloads provider packages from registry.terraform.io\n")
-		fmt.Fprintf(os.Stderr, "  server     - Serves downloaded packages as a registry mirror\n\n")
+		fmt.Fprintf(os.Stderr, "This application can run in three modes:\n")
+		fmt.Fprintf(os.Stderr, "  downloader - Downloads provider packages from registry.terraform.io (or --registry)\n")
+		fmt.Fprintf(os.Stderr, "  server     - Serves downloaded packages as a registry mirror\n")
+		fmt.Fprintf(os.Stderr, "  lockfile   - Prints .terraform.lock.hcl blocks for mirrored providers to stdout\n\n")
 		fmt.Fprintf(os.Stderr, "Common Options:\n")
 		fmt.Fprintf(os.Stderr, "  --mode string\n")
-		fmt.Fprintf(os.Stderr, "    	Application mode: 'downloader' or 'server' (required)\n")
+		fmt.Fprintf(os.Stderr, "    	Application mode: 'downloader', 'server' or 'lockfile' (required)\n")
+		fmt.Fprintf(os.Stderr, "  --registry string\n")
+		fmt.Fprintf(os.Stderr, "    	Upstream registry URL, its hostname names the top-level data directory (default: %s)\n", common.TerraformRegistryURL)
+		fmt.Fprintf(os.Stderr, "  --config string\n")
+		fmt.Fprintf(os.Stderr, "    	YAML file keyed by flag name (e.g. 'provider-filter: [hashicorp/aws]'); flag > env > file > default\n")
 		fmt.Fprintf(os.Stderr, "  --help\n")
 		fmt.Fprintf(os.Stderr, "    	Show help message\n")
 		fmt.Fprintf(os.Stderr, "  --version\n")
 		fmt.Fprintf(os.Stderr, "    	Show version information\n")
 		fmt.Fprintf(os.Stderr, "  --debug\n")
 		fmt.Fprintf(os.Stderr, "    	Enable debug logging\n")
+		fmt.Fprintf(os.Stderr, "  --log-level string\n")
+		fmt.Fprintf(os.Stderr, "    	Minimum log level: 'error', 'warn', 'info' or 'debug' (default: info, debug with --debug)\n")
+		fmt.Fprintf(os.Stderr, "  --log-file string\n")
+		fmt.Fprintf(os.Stderr, "    	Also write logs to this file\n")
+		fmt.Fprintf(os.Stderr, "  --log-max-size-mb int\n")
+		fmt.Fprintf(os.Stderr, "    	Rotate the log file to <file>.1 when it exceeds this size (default: 0, never)\n")
+		fmt.Fprintf(os.Stderr, "  --log-format string\n")
+		fmt.Fprintf(os.Stderr, "    	Log output format: 'text' or 'json' (default: text)\n")
 		fmt.Fprintf(os.Stderr, "\nDownloader Mode Options:\n")
 		fmt.Fprintf(os.Stderr, "  --download-path string\n")
 		fmt.Fprintf(os.Stderr, "    	Directory for downloading packages (required)\n")
+		fmt.Fprintf(os.Stderr, "  --staging-path string\n")
+		fmt.Fprintf(os.Stderr, "    	Download into this directory first; after a session each indexed and verified provider is moved into --download-path,\n")
+		fmt.Fprintf(os.Stderr, "    	so the server never sees a half-written provider (same filesystem recommended, otherwise files are copied)\n")
 		fmt.Fprintf(os.Stderr, "  --proxy string\n")
-		fmt.Fprintf(os.Stderr, "    	HTTP/HTTPS/SOCKS proxy URL for downloading packages\n")
-		fmt.Fprintf(os.Stderr, "  --check-period int\n")
-		fmt.Fprintf(os.Stderr, "    	Period for checking new versions in hours (default 24)\n")
+		fmt.Fprintf(os.Stderr, "    	HTTP/HTTPS/SOCKS5 proxy URL, credentials as user:pass@ (default: HTTP_PROXY/HTTPS_PROXY, NO_PROXY is honored)\n")
+		fmt.Fprintf(os.Stderr, "  --check-period string\n")
+		fmt.Fprintf(os.Stderr, "    	Period for checking new versions: an integer number of hours, or a duration like 30m or 2h30m (default 24)\n")
+		fmt.Fprintf(os.Stderr, "  --check-jitter float\n")
+		fmt.Fprintf(os.Stderr, "    	Shift each scheduled check randomly by up to this fraction of --check-period, so mirrors started together spread their registry load (default: 0)\n")
 		fmt.Fprintf(os.Stderr, "  --provider-filter string\n")
-		fmt.Fprintf(os.Stderr, "    	Comma-separated list of providers (e.g., 'hashicorp/aws,hashicorp/helm')\n")
+		fmt.Fprintf(os.Stderr, "    	Comma-separated list of providers (e.g., 'hashicorp/aws>5.0.0<6.0.0,hashicorp/*,!hashicorp/helm')\n")
+		fmt.Fprintf(os.Stderr, "  --filter-precedence string\n")
+		fmt.Fprintf(os.Stderr, "    	Rule that wins when a provider matches both an include and an exclude: 'exclude', or 'include' unless the exclude is more specific (default: exclude)\n")
+		fmt.Fprintf(os.Stderr, "  --module-filter string\n")
+		fmt.Fprintf(os.Stderr, "    	Comma-separated list of modules to mirror (e.g., 'terraform-aws-modules/vpc/aws>5.0.0<6.0.0')\n")
+		fmt.Fprintf(os.Stderr, "  --global-min-version string\n")
+		fmt.Fprintf(os.Stderr, "    	Minimum version for providers without a per-provider minimum (e.g., '1.0.0')\n")
+		fmt.Fprintf(os.Stderr, "  --keep-latest int\n")
+		fmt.Fprintf(os.Stderr, "    	Download only the newest N versions of each provider (default: 0, all versions)\n")
+		fmt.Fprintf(os.Stderr, "  --include-prerelease\n")
+		fmt.Fprintf(os.Stderr, "    	Also mirror prerelease versions such as 5.0.0-beta1 or 1.7.0-rc1 (default: stable only)\n")
+		fmt.Fprintf(os.Stderr, "  --prune\n")
+		fmt.Fprintf(os.Stderr, "    	Delete mirrored provider versions that no longer satisfy the filters after a successful pass\n")
+		fmt.Fprintf(os.Stderr, "  --prune-dry-run\n")
+		fmt.Fprintf(os.Stderr, "    	With --prune, only log the versions and files that would be deleted\n")
+		fmt.Fprintf(os.Stderr, "  --dedupe\n")
+		fmt.Fprintf(os.Stderr, "    	Hardlink identical provider archives to a shared blob under <download-path>/_blobs\n")
 		fmt.Fprintf(os.Stderr, "  --platform-filter string\n")
 		fmt.Fprintf(os.Stderr, "    	Comma-separated list of platforms (e.g., 'linux_amd64,darwin_arm64')\n")
+		fmt.Fprintf(os.Stderr, "  --platform-auto\n")
+		fmt.Fprintf(os.Stderr, "    	Without --platform-filter, mirror only the host platform plus linux_amd64\n")
+		fmt.Fprintf(os.Stderr, "  --all-platforms\n")
+		fmt.Fprintf(os.Stderr, "    	Mirror all supported platforms, overriding --platform-auto\n")
+		fmt.Fprintf(os.Stderr, "  --max-concurrent int\n")
+		fmt.Fprintf(os.Stderr, "    	Number of parallel download workers, also used to list provider versions while planning (default: %d)\n", common.DefaultMaxConcurrent)
 		fmt.Fprintf(os.Stderr, "  --max-attempts int\n")
 		fmt.Fprintf(os.Stderr, "    	Maximum download attempts per provider (default: 5)\n")
 		fmt.Fprintf(os.Stderr, "  --download-timeout int\n")
 		fmt.Fprintf(os.Stderr, "    	Download timeout per attempt in seconds (default: 180)\n")
+		fmt.Fprintf(os.Stderr, "  --provider-timeout int\n")
+		fmt.Fprintf(os.Stderr, "    	Seconds a session may spend on one provider; its remaining downloads are left for the next session (default: 0, no cap)\n")
+		fmt.Fprintf(os.Stderr, "  --retry-base-delay int\n")
+		fmt.Fprintf(os.Stderr, "    	Backoff before retrying a download in seconds, doubled per attempt with jitter (default: 2)\n")
+		fmt.Fprintf(os.Stderr, "  --retry-max-delay int\n")
+		fmt.Fprintf(os.Stderr, "    	Maximum backoff between download attempts in seconds (default: 60)\n")
+		fmt.Fprintf(os.Stderr, "  --require-binary-checksums\n")
+		fmt.Fprintf(os.Stderr, "    	Make SHA256SUMS verification of HashiCorp binaries mandatory (default: best-effort)\n")
+		fmt.Fprintf(os.Stderr, "  --binary-source '<tool>=github:<owner>/<repo>,<url template>[,<checksums url template>]'\n")
+		fmt.Fprintf(os.Stderr, "    	Mirror a --download-binaries tool from elsewhere than releases.hashicorp.com (repeatable): versions are the\n")
+		fmt.Fprintf(os.Stderr, "    	GitHub release tags, templates use {version}, {os}, {arch} and {exe} (.exe on windows)\n")
+		fmt.Fprintf(os.Stderr, "  --events-ndjson\n")
+		fmt.Fprintf(os.Stderr, "    	Emit downloader events as NDJSON to stdout (human logs go to stderr)\n")
+		fmt.Fprintf(os.Stderr, "  --sample string\n")
+		fmt.Fprintf(os.Stderr, "    	Sample discovered providers for testing, e.g. '1%%' or '50' (ignored with --provider-filter)\n")
+		fmt.Fprintf(os.Stderr, "  --verify-signatures\n")
+		fmt.Fprintf(os.Stderr, "    	Verify GPG signatures of provider SHA256SUMS files using the registry signing keys\n")
+		fmt.Fprintf(os.Stderr, "  --clean-tmp\n")
+		fmt.Fprintf(os.Stderr, "    	On startup, remove .tmp files left under --download-path by interrupted downloads\n")
+		fmt.Fprintf(os.Stderr, "  --clean-tmp-age int\n")
+		fmt.Fprintf(os.Stderr, "    	With --clean-tmp: only remove .tmp files older than this many seconds, younger ones may still be written (default: 3600)\n")
+		fmt.Fprintf(os.Stderr, "  --once\n")
+		fmt.Fprintf(os.Stderr, "    	Run a single download pass and exit, for cron jobs and CI (exit code 1 if any download failed)\n")
+		fmt.Fprintf(os.Stderr, "  --progress\n")
+		fmt.Fprintf(os.Stderr, "    	Log overall download progress with an ETA every 30 seconds\n")
+		fmt.Fprintf(os.Stderr, "  --incremental-discovery\n")
+		fmt.Fprintf(os.Stderr, "    	With discovery: skip providers whose latest listed version is unchanged since the last run that mirrored them without failures\n")
+		fmt.Fprintf(os.Stderr, "  --summary-json string\n")
+		fmt.Fprintf(os.Stderr, "    	Write a JSON report of each download session to this path, e.g. for CI checks\n")
+		fmt.Fprintf(os.Stderr, "  --fail-threshold float\n")
+		fmt.Fprintf(os.Stderr, "    	Percentage of failed provider downloads tolerated before the exit code is non-zero (default: 0, any failure)\n")
+		fmt.Fprintf(os.Stderr, "  --rebuild-metadata\n")
+		fmt.Fprintf(os.Stderr, "    	Rebuild .tf-mirror-metadata.json, index.json and <version>.json from disk, then exit (no downloads)\n")
+		fmt.Fprintf(os.Stderr, "  --verify\n")
+		fmt.Fprintf(os.Stderr, "    	Re-check every archive against SHA256SUMS and the h1: hash in <version>.json, print a JSON report to stdout, then exit (no network)\n")
+		fmt.Fprintf(os.Stderr, "  --verify-delete\n")
+		fmt.Fprintf(os.Stderr, "    	With --verify: delete corrupt archives and drop them from the index so the next run downloads them again\n")
+		fmt.Fprintf(os.Stderr, "  --max-bandwidth int\n")
+		fmt.Fprintf(os.Stderr, "    	Aggregate provider download cap in bytes per second (default: 0, unlimited)\n")
+		fmt.Fprintf(os.Stderr, "  --max-disk-bytes int\n")
+		fmt.Fprintf(os.Stderr, "    	Stop the session's downloads once the mirror holds this many bytes; with --prune, stale versions are removed first (default: 0, no cap)\n")
+		fmt.Fprintf(os.Stderr, "  --max-disk-percent int\n")
+		fmt.Fprintf(os.Stderr, "    	Stop the session's downloads once the filesystem of --download-path is this percent full (default: 0, no cap)\n")
+		fmt.Fprintf(os.Stderr, "  --require-free-space\n")
+		fmt.Fprintf(os.Stderr, "    	Skip a session whose estimated download size exceeds the free disk space; without it a warning is logged\n")
+		fmt.Fprintf(os.Stderr, "  --max-conns-per-host int\n")
+		fmt.Fprintf(os.Stderr, "    	Maximum concurrent connections per upstream host (default: 0, unlimited)\n")
+		fmt.Fprintf(os.Stderr, "  --circuit-failures int\n")
+		fmt.Fprintf(os.Stderr, "    	Consecutive failures to an upstream host before its requests fail fast (default: %d, 0 disables)\n", common.DefaultCircuitFailures)
+		fmt.Fprintf(os.Stderr, "  --circuit-cooldown int\n")
+		fmt.Fprintf(os.Stderr, "    	Seconds requests to that host fail fast before a probe request (default: %d)\n", int(common.DefaultCircuitCooldown.Seconds()))
+		fmt.Fprintf(os.Stderr, "  --tls-min-outbound string\n")
+		fmt.Fprintf(os.Stderr, "    	Minimum TLS version for outbound connections (default: 1.2)\n")
+		fmt.Fprintf(os.Stderr, "  --ca-cert string\n")
+		fmt.Fprintf(os.Stderr, "    	PEM bundle of extra CA certificates for outbound connections, added to the system roots\n")
+		fmt.Fprintf(os.Stderr, "  --registry-token string\n")
+		fmt.Fprintf(os.Stderr, "    	Bearer token for a private registry, sent only to the --registry host\n")
+		fmt.Fprintf(os.Stderr, "    	(default: TF_TOKEN_<host>, ~/.terraformrc or credentials.tfrc.json, as in Terraform)\n")
+		fmt.Fprintf(os.Stderr, "  --user-agent string\n")
+		fmt.Fprintf(os.Stderr, "    	User-Agent for outbound requests (default: %s)\n", common.UserAgent)
+		fmt.Fprintf(os.Stderr, "  --header 'Name: value'\n")
+		fmt.Fprintf(os.Stderr, "    	Extra header for outbound requests, e.g. for an egress proxy (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --insecure-skip-verify\n")
+		fmt.Fprintf(os.Stderr, "    	Do not verify upstream TLS certificates (insecure, prefer --ca-cert)\n")
 		fmt.Fprintf(os.Stderr, "\nServer Mode Options:\n")
 		fmt.Fprintf(os.Stderr, "  --data-path string\n")
 		fmt.Fprintf(os.Stderr, "    	Path to directory containing downloaded packages (required)\n")
@@ -96,23 +277,109 @@ func main() {
 		fmt.Fprintf(os.Stderr, "    	Path to TLS certificate file (required if --enable-tls is set)\n")
 		fmt.Fprintf(os.Stderr, "  --tls-key string\n")
 		fmt.Fprintf(os.Stderr, "    	Path to TLS private key file (required if --enable-tls is set)\n")
+		fmt.Fprintf(os.Stderr, "  --tls-min-version string\n")
+		fmt.Fprintf(os.Stderr, "    	Minimum TLS version accepted from clients, 1.2 or 1.3 (default: 1.2)\n")
+		fmt.Fprintf(os.Stderr, "  --tls-sni-cert value\n")
+		fmt.Fprintf(os.Stderr, "    	Additional 'cert.pem,key.pem' pair, chosen by the client's server name (repeatable)\n")
+		fmt.Fprintf(os.Stderr, "  --allowed-hosts string\n")
+		fmt.Fprintf(os.Stderr, "    	Comma-separated host aliases used for generated download URLs when requested via that host\n")
+		fmt.Fprintf(os.Stderr, "  --allowed-files string\n")
+		fmt.Fprintf(os.Stderr, "    	Comma-separated file suffixes to serve, everything else returns 404 (e.g., '.zip,.json,SHA256SUMS,.sig')\n")
+		fmt.Fprintf(os.Stderr, "  --stats-ttl int\n")
+		fmt.Fprintf(os.Stderr, "    	Seconds to cache the /stats disk usage report (default: 60)\n")
+		fmt.Fprintf(os.Stderr, "  --read-timeout int\n")
+		fmt.Fprintf(os.Stderr, "    	Seconds to read a whole request, including the body (default: 30, 0 = no timeout)\n")
+		fmt.Fprintf(os.Stderr, "  --write-timeout int\n")
+		fmt.Fprintf(os.Stderr, "    	Seconds to write a whole response; downloads of large archives over slow links need more, or 0 for no timeout (default: 30)\n")
+		fmt.Fprintf(os.Stderr, "  --idle-timeout int\n")
+		fmt.Fprintf(os.Stderr, "    	Seconds an idle keep-alive connection stays open (default: 120, 0 = use --read-timeout)\n")
+		fmt.Fprintf(os.Stderr, "  --auth-token string\n")
+		fmt.Fprintf(os.Stderr, "    	Require 'Authorization: Bearer <token>' on all routes except /health and /ready\n")
+		fmt.Fprintf(os.Stderr, "  --allow-cidr string\n")
+		fmt.Fprintf(os.Stderr, "    	Comma-separated client CIDRs allowed to use the server, others get 403 (e.g., '10.0.0.0/8,192.168.1.5')\n")
+		fmt.Fprintf(os.Stderr, "  --trust-proxy\n")
+		fmt.Fprintf(os.Stderr, "    	Take the client IP from X-Forwarded-For (set only behind a reverse proxy)\n")
+		fmt.Fprintf(os.Stderr, "  --enable-ui\n")
+		fmt.Fprintf(os.Stderr, "    	Serve an HTML overview of the mirrored providers and binaries at /ui\n")
+		fmt.Fprintf(os.Stderr, "  --pull-through\n")
+		fmt.Fprintf(os.Stderr, "    	Fetch missing provider archives from --registry on request and keep them (honors --provider-filter and --platform-filter)\n")
 		fmt.Fprintf(os.Stderr, "\nEnvironment Variables:\n")
 		fmt.Fprintf(os.Stderr, "  TF_MIRROR_MODE         Same as --mode\n")
+		fmt.Fprintf(os.Stderr, "  TF_MIRROR_CONFIG       Same as --config\n")
+		fmt.Fprintf(os.Stderr, "  REGISTRY               Same as --registry\n")
 		fmt.Fprintf(os.Stderr, "  PROXY                  Same as --proxy\n")
 		fmt.Fprintf(os.Stderr, "  CHECK_PERIOD           Same as --check-period\n")
+		fmt.Fprintf(os.Stderr, "  CHECK_JITTER           Same as --check-jitter\n")
 		fmt.Fprintf(os.Stderr, "  DOWNLOAD_PATH          Same as --download-path\n")
+		fmt.Fprintf(os.Stderr, "  STAGING_PATH           Same as --staging-path\n")
 		fmt.Fprintf(os.Stderr, "  PROVIDER_FILTER        Same as --provider-filter\n")
+		fmt.Fprintf(os.Stderr, "  FILTER_PRECEDENCE      Same as --filter-precedence\n")
+		fmt.Fprintf(os.Stderr, "  MODULE_FILTER          Same as --module-filter\n")
+		fmt.Fprintf(os.Stderr, "  GLOBAL_MIN_VERSION     Same as --global-min-version\n")
+		fmt.Fprintf(os.Stderr, "  KEEP_LATEST            Same as --keep-latest\n")
+		fmt.Fprintf(os.Stderr, "  INCLUDE_PRERELEASE     Same as --include-prerelease\n")
+		fmt.Fprintf(os.Stderr, "  PRUNE                  Same as --prune\n")
+		fmt.Fprintf(os.Stderr, "  PRUNE_DRY_RUN          Same as --prune-dry-run\n")
+		fmt.Fprintf(os.Stderr, "  DEDUPE                 Same as --dedupe\n")
+		fmt.Fprintf(os.Stderr, "  RUN_ONCE               Same as --once\n")
+		fmt.Fprintf(os.Stderr, "  CLEAN_TMP              Same as --clean-tmp\n")
+		fmt.Fprintf(os.Stderr, "  CLEAN_TMP_AGE          Same as --clean-tmp-age\n")
+		fmt.Fprintf(os.Stderr, "  FAIL_THRESHOLD         Same as --fail-threshold\n")
+		fmt.Fprintf(os.Stderr, "  PROGRESS               Same as --progress\n")
+		fmt.Fprintf(os.Stderr, "  INCREMENTAL_DISCOVERY  Same as --incremental-discovery\n")
+		fmt.Fprintf(os.Stderr, "  SUMMARY_JSON           Same as --summary-json\n")
 		fmt.Fprintf(os.Stderr, "  PLATFORM_FILTER        Same as --platform-filter\n")
+		fmt.Fprintf(os.Stderr, "  PLATFORM_AUTO          Same as --platform-auto\n")
+		fmt.Fprintf(os.Stderr, "  ALL_PLATFORMS          Same as --all-platforms\n")
+		fmt.Fprintf(os.Stderr, "  MAX_CONCURRENT         Same as --max-concurrent\n")
 		fmt.Fprintf(os.Stderr, "  MAX_ATTEMPTS           Same as --max-attempts\n")
 		fmt.Fprintf(os.Stderr, "  DOWNLOAD_TIMEOUT       Same as --download-timeout\n")
+		fmt.Fprintf(os.Stderr, "  PROVIDER_TIMEOUT       Same as --provider-timeout\n")
+		fmt.Fprintf(os.Stderr, "  RETRY_BASE_DELAY       Same as --retry-base-delay\n")
+		fmt.Fprintf(os.Stderr, "  RETRY_MAX_DELAY        Same as --retry-max-delay\n")
+		fmt.Fprintf(os.Stderr, "  REQUIRE_BINARY_CHECKSUMS Same as --require-binary-checksums\n")
+		fmt.Fprintf(os.Stderr, "  BINARY_SOURCE          Same as --binary-source, one source per line\n")
+		fmt.Fprintf(os.Stderr, "  EVENTS_NDJSON          Same as --events-ndjson\n")
+		fmt.Fprintf(os.Stderr, "  TLS_MIN_OUTBOUND       Same as --tls-min-outbound\n")
+		fmt.Fprintf(os.Stderr, "  CA_CERT                Same as --ca-cert\n")
+		fmt.Fprintf(os.Stderr, "  REGISTRY_TOKEN         Same as --registry-token\n")
+		fmt.Fprintf(os.Stderr, "  USER_AGENT             Same as --user-agent\n")
+		fmt.Fprintf(os.Stderr, "  HEADER                 Same as --header, one header per line\n")
+		fmt.Fprintf(os.Stderr, "  INSECURE_SKIP_VERIFY   Same as --insecure-skip-verify\n")
+		fmt.Fprintf(os.Stderr, "  SAMPLE                 Same as --sample\n")
+		fmt.Fprintf(os.Stderr, "  VERIFY_SIGNATURES      Same as --verify-signatures\n")
+		fmt.Fprintf(os.Stderr, "  MAX_BANDWIDTH          Same as --max-bandwidth\n")
+		fmt.Fprintf(os.Stderr, "  MAX_DISK_BYTES         Same as --max-disk-bytes\n")
+		fmt.Fprintf(os.Stderr, "  MAX_DISK_PERCENT       Same as --max-disk-percent\n")
+		fmt.Fprintf(os.Stderr, "  REQUIRE_FREE_SPACE     Same as --require-free-space\n")
+		fmt.Fprintf(os.Stderr, "  MAX_CONNS_PER_HOST     Same as --max-conns-per-host\n")
+		fmt.Fprintf(os.Stderr, "  CIRCUIT_FAILURES       Same as --circuit-failures\n")
+		fmt.Fprintf(os.Stderr, "  CIRCUIT_COOLDOWN       Same as --circuit-cooldown\n")
 		fmt.Fprintf(os.Stderr, "  LISTEN_HOST            Same as --listen-host\n")
 		fmt.Fprintf(os.Stderr, "  LISTEN_PORT            Same as --listen-port\n")
-		fmt.Fprintf(os.Stderr, "  HOSTNAME               Same as --hostname\n")
+		fmt.Fprintf(os.Stderr, "  TF_MIRROR_HOSTNAME     Same as --hostname\n")
 		fmt.Fprintf(os.Stderr, "  ENABLE_TLS             Same as --enable-tls\n")
 		fmt.Fprintf(os.Stderr, "  TLS_CRT                Same as --tls-crt\n")
 		fmt.Fprintf(os.Stderr, "  TLS_KEY                Same as --tls-key\n")
+		fmt.Fprintf(os.Stderr, "  TLS_MIN_VERSION        Same as --tls-min-version\n")
+		fmt.Fprintf(os.Stderr, "  TLS_SNI_CERT           Same as --tls-sni-cert, one pair per line\n")
 		fmt.Fprintf(os.Stderr, "  DATA_PATH              Same as --data-path\n")
+		fmt.Fprintf(os.Stderr, "  ALLOWED_FILES          Same as --allowed-files\n")
+		fmt.Fprintf(os.Stderr, "  ALLOWED_HOSTS          Same as --allowed-hosts\n")
+		fmt.Fprintf(os.Stderr, "  STATS_TTL              Same as --stats-ttl\n")
+		fmt.Fprintf(os.Stderr, "  READ_TIMEOUT           Same as --read-timeout\n")
+		fmt.Fprintf(os.Stderr, "  WRITE_TIMEOUT          Same as --write-timeout\n")
+		fmt.Fprintf(os.Stderr, "  IDLE_TIMEOUT           Same as --idle-timeout\n")
+		fmt.Fprintf(os.Stderr, "  AUTH_TOKEN             Same as --auth-token\n")
+		fmt.Fprintf(os.Stderr, "  ALLOW_CIDR             Same as --allow-cidr\n")
+		fmt.Fprintf(os.Stderr, "  TRUST_PROXY            Same as --trust-proxy\n")
+		fmt.Fprintf(os.Stderr, "  ENABLE_UI              Same as --enable-ui\n")
+		fmt.Fprintf(os.Stderr, "  PULL_THROUGH           Same as --pull-through\n")
 		fmt.Fprintf(os.Stderr, "  DEBUG                  Same as --debug\n")
+		fmt.Fprintf(os.Stderr, "  LOG_LEVEL              Same as --log-level\n")
+		fmt.Fprintf(os.Stderr, "  LOG_FORMAT             Same as --log-format\n")
+		fmt.Fprintf(os.Stderr, "  LOG_FILE               Same as --log-file\n")
+		fmt.Fprintf(os.Stderr, "  LOG_MAX_SIZE_MB        Same as --log-max-size-mb\n")
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  # Run as downloader\n")
 		fmt.Fprintf(os.Stderr, "  %s --mode downloader --download-path ./data\n", os.Args[0])
@@ -122,6 +389,8 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s --mode downloader --download-path ./data \\\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "    --provider-filter 'hashicorp/aws,hashicorp/helm' \\\n")
 		fmt.Fprintf(os.Stderr, "    --platform-filter 'linux_amd64,darwin_arm64'\n")
+		fmt.Fprintf(os.Stderr, "\n  # Lock file hashes for mirrored providers\n")
+		fmt.Fprintf(os.Stderr, "  %s --mode lockfile --data-path ./data --provider-filter 'hashicorp/aws' > .terraform.lock.hcl\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -140,20 +409,52 @@ func main() {
 	if *mode == "" {
 		*mode = common.GetEnvWithDefault("TF_MIRROR_MODE", "")
 	}
+	if envRegistry := os.Getenv("REGISTRY"); envRegistry != "" && *regURL == common.TerraformRegistryURL {
+		*regURL = envRegistry
+	}
+	if *logLvl == "" {
+		*logLvl = os.Getenv("LOG_LEVEL")
+	}
+	if *logFile == "" {
+		*logFile = os.Getenv("LOG_FILE")
+	}
+	if envLogSize := os.Getenv("LOG_MAX_SIZE_MB"); envLogSize != "" && *logSize == 0 {
+		if val, err := common.ParseEnvInt("LOG_MAX_SIZE_MB", 0); err == nil {
+			*logSize = val
+		}
+	}
+	if envLogFormat := os.Getenv("LOG_FORMAT"); envLogFormat != "" && *logFmt == "text" {
+		*logFmt = envLogFormat
+	}
 	if *proxy == "" {
 		*proxy = os.Getenv("PROXY")
 	}
 	if *downloadPath == "" {
 		*downloadPath = os.Getenv("DOWNLOAD_PATH")
 	}
+	if *stagingPath == "" {
+		*stagingPath = os.Getenv("STAGING_PATH")
+	}
 	if *dataPath == "" {
 		*dataPath = os.Getenv("DATA_PATH")
 	}
+	if *allowHosts == "" {
+		*allowHosts = os.Getenv("ALLOWED_HOSTS")
+	}
+	if *authToken == "" {
+		*authToken = os.Getenv("AUTH_TOKEN")
+	}
+	if *allowCIDR == "" {
+		*allowCIDR = os.Getenv("ALLOW_CIDR")
+	}
+	if *allowFiles == "" {
+		*allowFiles = os.Getenv("ALLOWED_FILES")
+	}
 	if *listenHost == "" {
 		*listenHost = os.Getenv("LISTEN_HOST")
 	}
 	if *hostname == "" {
-		*hostname = os.Getenv("HOSTNAME")
+		*hostname = os.Getenv("TF_MIRROR_HOSTNAME")
 	}
 	if *tlsCert == "" {
 		*tlsCert = os.Getenv("TLS_CRT")
@@ -161,15 +462,73 @@ func main() {
 	if *tlsKey == "" {
 		*tlsKey = os.Getenv("TLS_KEY")
 	}
+	if envTLSMin := os.Getenv("TLS_MIN_VERSION"); envTLSMin != "" && *tlsMin == "1.2" {
+		*tlsMin = envTLSMin
+	}
+	if envBinSources := os.Getenv("BINARY_SOURCE"); envBinSources != "" && len(binSources) == 0 {
+		for _, line := range strings.Split(envBinSources, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				binSources = append(binSources, line)
+			}
+		}
+	}
+	if envSNICerts := os.Getenv("TLS_SNI_CERT"); envSNICerts != "" && len(sniCerts) == 0 {
+		for _, line := range strings.Split(envSNICerts, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				sniCerts = append(sniCerts, line)
+			}
+		}
+	}
 	if *providerFilter == "" {
 		*providerFilter = os.Getenv("PROVIDER_FILTER")
 	}
+	if envPrecedence := os.Getenv("FILTER_PRECEDENCE"); envPrecedence != "" && *filterPrecedence == "exclude" {
+		*filterPrecedence = envPrecedence
+	}
+	if *moduleFilter == "" {
+		*moduleFilter = os.Getenv("MODULE_FILTER")
+	}
+	if *globalMinVersion == "" {
+		*globalMinVersion = os.Getenv("GLOBAL_MIN_VERSION")
+	}
 	if *platformFilter == "" {
 		*platformFilter = os.Getenv("PLATFORM_FILTER")
 	}
+	if *sample == "" {
+		*sample = os.Getenv("SAMPLE")
+	}
 	if *downloadBinaries == "" {
 		*downloadBinaries = os.Getenv("DOWNLOAD_BINARIES")
 	}
+	if envTLSMin := os.Getenv("TLS_MIN_OUTBOUND"); envTLSMin != "" && *tlsMinOutbound == "1.2" {
+		*tlsMinOutbound = envTLSMin
+	}
+	if *caCert == "" {
+		*caCert = os.Getenv("CA_CERT")
+	}
+	if *regToken == "" {
+		*regToken = os.Getenv("REGISTRY_TOKEN")
+	}
+	if envUserAgent := os.Getenv("USER_AGENT"); envUserAgent != "" && *userAgent == common.UserAgent {
+		*userAgent = envUserAgent
+	}
+	if envHeaders := os.Getenv("HEADER"); envHeaders != "" && len(headers) == 0 {
+		for _, line := range strings.Split(envHeaders, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				headers = append(headers, line)
+			}
+		}
+	}
+	if !*insecureTLS {
+		if insecureEnv, err := common.ParseEnvBool("INSECURE_SKIP_VERIFY", false); err == nil {
+			*insecureTLS = insecureEnv
+		}
+	}
+	if envMaxConcurrent := os.Getenv("MAX_CONCURRENT"); envMaxConcurrent != "" && *maxConcurrent == common.DefaultMaxConcurrent {
+		if val, err := common.ParseEnvInt("MAX_CONCURRENT", common.DefaultMaxConcurrent); err == nil {
+			*maxConcurrent = val
+		}
+	}
 	if envMaxAttempts := os.Getenv("MAX_ATTEMPTS"); envMaxAttempts != "" && *maxAttempts == 5 {
 		if val, err := common.ParseEnvInt("MAX_ATTEMPTS", 5); err == nil {
 			*maxAttempts = val
@@ -180,21 +539,195 @@ func main() {
 			*downloadTimeout = val
 		}
 	}
+	if envProviderTimeout := os.Getenv("PROVIDER_TIMEOUT"); envProviderTimeout != "" && *providerTimeout == 0 {
+		if val, err := common.ParseEnvInt("PROVIDER_TIMEOUT", 0); err == nil {
+			*providerTimeout = val
+		}
+	}
+
+	if envBaseDelay := os.Getenv("RETRY_BASE_DELAY"); envBaseDelay != "" && *retryBaseDelay == 2 {
+		if val, err := common.ParseEnvInt("RETRY_BASE_DELAY", 2); err == nil {
+			*retryBaseDelay = val
+		}
+	}
+	if envMaxDelay := os.Getenv("RETRY_MAX_DELAY"); envMaxDelay != "" && *retryMaxDelay == 60 {
+		if val, err := common.ParseEnvInt("RETRY_MAX_DELAY", 60); err == nil {
+			*retryMaxDelay = val
+		}
+	}
+	if envKeepLatest := os.Getenv("KEEP_LATEST"); envKeepLatest != "" && *keepLatest == 0 {
+		if val, err := common.ParseEnvInt("KEEP_LATEST", 0); err == nil {
+			*keepLatest = val
+		}
+	}
+	if envBandwidth := os.Getenv("MAX_BANDWIDTH"); envBandwidth != "" && *maxBandwidth == 0 {
+		if val, err := common.ParseEnvInt("MAX_BANDWIDTH", 0); err == nil {
+			*maxBandwidth = int64(val)
+		}
+	}
+	if envDiskBytes := os.Getenv("MAX_DISK_BYTES"); envDiskBytes != "" && *maxDiskBytes == 0 {
+		if val, err := strconv.ParseInt(envDiskBytes, 10, 64); err == nil {
+			*maxDiskBytes = val
+		}
+	}
+	if envDiskPercent := os.Getenv("MAX_DISK_PERCENT"); envDiskPercent != "" && *maxDiskPercent == 0 {
+		if val, err := common.ParseEnvInt("MAX_DISK_PERCENT", 0); err == nil {
+			*maxDiskPercent = val
+		}
+	}
+	if envMaxConns := os.Getenv("MAX_CONNS_PER_HOST"); envMaxConns != "" && *maxConnsPerHost == 0 {
+		if val, err := common.ParseEnvInt("MAX_CONNS_PER_HOST", 0); err == nil {
+			*maxConnsPerHost = val
+		}
+	}
+	if envFailures := os.Getenv("CIRCUIT_FAILURES"); envFailures != "" && *circuitFailures == common.DefaultCircuitFailures {
+		if val, err := common.ParseEnvInt("CIRCUIT_FAILURES", common.DefaultCircuitFailures); err == nil {
+			*circuitFailures = val
+		}
+	}
+	if envCooldown := os.Getenv("CIRCUIT_COOLDOWN"); envCooldown != "" && *circuitCooldown == int(common.DefaultCircuitCooldown.Seconds()) {
+		if val, err := common.ParseEnvInt("CIRCUIT_COOLDOWN", *circuitCooldown); err == nil {
+			*circuitCooldown = val
+		}
+	}
 
 	// Parse environment variables for boolean and integer values
+	if !*trustProxy {
+		if trustProxyEnv, err := common.ParseEnvBool("TRUST_PROXY", false); err == nil {
+			*trustProxy = trustProxyEnv
+		}
+	}
+	if !*enableUI {
+		if enableUIEnv, err := common.ParseEnvBool("ENABLE_UI", false); err == nil {
+			*enableUI = enableUIEnv
+		}
+	}
+	if !*pullThru {
+		if pullThroughEnv, err := common.ParseEnvBool("PULL_THROUGH", false); err == nil {
+			*pullThru = pullThroughEnv
+		}
+	}
 	if !*enableTLS {
 		if enableTLSEnv, err := common.ParseEnvBool("ENABLE_TLS", false); err == nil {
 			*enableTLS = enableTLSEnv
 		}
 	}
+	if !*eventsNDJSON {
+		if eventsEnv, err := common.ParseEnvBool("EVENTS_NDJSON", false); err == nil {
+			*eventsNDJSON = eventsEnv
+		}
+	}
+	if !*requireFreeSpace {
+		if freeSpaceEnv, err := common.ParseEnvBool("REQUIRE_FREE_SPACE", false); err == nil {
+			*requireFreeSpace = freeSpaceEnv
+		}
+	}
+	if !*verifySigs {
+		if verifyEnv, err := common.ParseEnvBool("VERIFY_SIGNATURES", false); err == nil {
+			*verifySigs = verifyEnv
+		}
+	}
+	if !*requireBinSums {
+		if requireEnv, err := common.ParseEnvBool("REQUIRE_BINARY_CHECKSUMS", false); err == nil {
+			*requireBinSums = requireEnv
+		}
+	}
+	if !*prerelease {
+		if prereleaseEnv, err := common.ParseEnvBool("INCLUDE_PRERELEASE", false); err == nil {
+			*prerelease = prereleaseEnv
+		}
+	}
+	if !*prune {
+		if pruneEnv, err := common.ParseEnvBool("PRUNE", false); err == nil {
+			*prune = pruneEnv
+		}
+	}
+	if !*pruneDryRun {
+		if dryRunEnv, err := common.ParseEnvBool("PRUNE_DRY_RUN", false); err == nil {
+			*pruneDryRun = dryRunEnv
+		}
+	}
+	if !*platformAuto {
+		if autoEnv, err := common.ParseEnvBool("PLATFORM_AUTO", false); err == nil {
+			*platformAuto = autoEnv
+		}
+	}
+	if !*allPlatforms {
+		if allEnv, err := common.ParseEnvBool("ALL_PLATFORMS", false); err == nil {
+			*allPlatforms = allEnv
+		}
+	}
+	if !*dedupe {
+		if dedupeEnv, err := common.ParseEnvBool("DEDUPE", false); err == nil {
+			*dedupe = dedupeEnv
+		}
+	}
+	if envFailThreshold := os.Getenv("FAIL_THRESHOLD"); envFailThreshold != "" && *failThreshold == 0 {
+		if val, err := common.ParseEnvFloat("FAIL_THRESHOLD", 0); err == nil {
+			*failThreshold = val
+		}
+	}
+	if *summaryJSON == "" {
+		*summaryJSON = os.Getenv("SUMMARY_JSON")
+	}
+	if !*incrementalDisc {
+		if incrementalEnv, err := common.ParseEnvBool("INCREMENTAL_DISCOVERY", false); err == nil {
+			*incrementalDisc = incrementalEnv
+		}
+	}
+	if !*progress {
+		if progressEnv, err := common.ParseEnvBool("PROGRESS", false); err == nil {
+			*progress = progressEnv
+		}
+	}
+	if !*once {
+		if onceEnv, err := common.ParseEnvBool("RUN_ONCE", false); err == nil {
+			*once = onceEnv
+		}
+	}
+	if !*cleanTmp {
+		if cleanTmpEnv, err := common.ParseEnvBool("CLEAN_TMP", false); err == nil {
+			*cleanTmp = cleanTmpEnv
+		}
+	}
+	if envCleanTmpAge := os.Getenv("CLEAN_TMP_AGE"); envCleanTmpAge != "" && *cleanTmpAge == 3600 {
+		if val, err := common.ParseEnvInt("CLEAN_TMP_AGE", 3600); err == nil {
+			*cleanTmpAge = val
+		}
+	}
 	if !*debug {
 		if debugEnv, err := common.ParseEnvBool("DEBUG", false); err == nil {
 			*debug = debugEnv
 		}
 	}
-	if envCheckPeriod := os.Getenv("CHECK_PERIOD"); envCheckPeriod != "" && *checkPeriod == 24 {
-		if period, err := common.ParseEnvInt("CHECK_PERIOD", 24); err == nil {
-			*checkPeriod = period
+	if envCheckPeriod := os.Getenv("CHECK_PERIOD"); envCheckPeriod != "" && *checkPeriod == "24" {
+		if period, err := common.ParseEnvDuration("CHECK_PERIOD", 24*time.Hour); err == nil {
+			*checkPeriod = period.String()
+		}
+	}
+	if envCheckJitter := os.Getenv("CHECK_JITTER"); envCheckJitter != "" && *checkJitter == 0 {
+		if val, err := common.ParseEnvFloat("CHECK_JITTER", 0); err == nil {
+			*checkJitter = val
+		}
+	}
+	if envStatsTTL := os.Getenv("STATS_TTL"); envStatsTTL != "" && *statsTTL == 60 {
+		if val, err := common.ParseEnvInt("STATS_TTL", 60); err == nil {
+			*statsTTL = val
+		}
+	}
+	if envReadTimeout := os.Getenv("READ_TIMEOUT"); envReadTimeout != "" && *readTO == 30 {
+		if val, err := common.ParseEnvInt("READ_TIMEOUT", 30); err == nil {
+			*readTO = val
+		}
+	}
+	if envWriteTimeout := os.Getenv("WRITE_TIMEOUT"); envWriteTimeout != "" && *writeTO == 30 {
+		if val, err := common.ParseEnvInt("WRITE_TIMEOUT", 30); err == nil {
+			*writeTO = val
+		}
+	}
+	if envIdleTimeout := os.Getenv("IDLE_TIMEOUT"); envIdleTimeout != "" && *idleTO == 120 {
+		if val, err := common.ParseEnvInt("IDLE_TIMEOUT", 120); err == nil {
+			*idleTO = val
 		}
 	}
 	if envListenPort := os.Getenv("LISTEN_PORT"); envListenPort != "" && *listenPort == 80 {
@@ -203,93 +736,464 @@ func main() {
 		}
 	}
 
+	// The config file only fills in values set neither by a flag nor by the environment
+	if *cfgFile == "" {
+		*cfgFile = os.Getenv("TF_MIRROR_CONFIG")
+	}
+	if *cfgFile != "" {
+		if err := applyConfigFile(*cfgFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Validate mode
 	if *mode == "" {
-		fmt.Fprintf(os.Stderr, "Error: --mode is required. Use 'downloader' or 'server'\n\n")
+		fmt.Fprintf(os.Stderr, "Error: --mode is required. Use 'downloader', 'server' or 'lockfile'\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	appMode := Mode(*mode)
-	if appMode != ModeDownloader && appMode != ModeServer {
-		fmt.Fprintf(os.Stderr, "Error: invalid mode '%s'. Use 'downloader' or 'server'\n\n", *mode)
+	if appMode != ModeDownloader && appMode != ModeServer && appMode != ModeLockfile {
+		fmt.Fprintf(os.Stderr, "Error: invalid mode '%s'. Use 'downloader', 'server' or 'lockfile'\n\n", *mode)
 		flag.Usage()
 		os.Exit(1)
 	}
 
 	// Create logger
 	logger := common.NewLogger()
-	if *debug {
-		os.Setenv("DEBUG", "1")
+	logFormat, err := common.ParseLogFormat(*logFmt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		os.Exit(1)
+	}
+	logger.SetFormat(logFormat)
+	logLevel, err := common.ParseLogLevel(*logLvl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		os.Exit(1)
+	}
+	if *debug && *logLvl == "" {
+		// --debug / DEBUG=1 keep working as a shortcut for --log-level debug
+		logLevel = common.LogLevelDebug
+	}
+	logger.SetLevel(logLevel)
+	if *eventsNDJSON || appMode == ModeLockfile || *verifyMirror {
+		// stdout is reserved for the event stream, lock file or verify report
+		logger.SetOutput(os.Stderr)
+	}
+	if *logFile != "" {
+		file, err := common.OpenRotatingFile(*logFile, int64(*logSize)*1024*1024)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		logger.TeeTo(file)
 	}
 
 	logger.Info("Starting Terraform Registry Mirror")
 	logger.Info("Version: %s", common.GetVersionString())
 	logger.Info("Mode: %s", appMode)
 
+	registryHost, err := common.RegistryHostname(*regURL)
+	if err != nil {
+		logger.Fatal("Error: invalid --registry: %v", err)
+	}
+
+	// Outbound settings, used by the downloader and by the server with --pull-through
+	var registryConfig *common.RegistryConfig
+	var outboundTLSVersion uint16
+	var outboundHeaders http.Header
+	if appMode == ModeDownloader || (appMode == ModeServer && *pullThru) {
+		outboundTLSVersion, err = common.ParseTLSVersion(*tlsMinOutbound)
+		if err != nil {
+			logger.Fatal("Error: invalid --tls-min-outbound: %v", err)
+		}
+		outboundHeaders, err = common.ParseHeaders(headers)
+		if err != nil {
+			logger.Fatal("Error: invalid --header: %v", err)
+		}
+		// Without --registry-token, look the token up like the Terraform CLI does
+		if *regToken == "" {
+			token, source, err := common.LookupCredentials(registryHost)
+			if err != nil {
+				logger.Warn("Failed to read Terraform credentials: %v", err)
+			} else if token != "" {
+				logger.Info("Using registry token for %s from %s", registryHost, source)
+				*regToken = token
+			}
+		}
+
+		registryConfig = &common.RegistryConfig{
+			BaseURL:         *regURL,
+			ProxyURL:        *proxy,
+			UserAgent:       *userAgent,
+			Timeout:         common.DefaultTimeout,
+			MaxRetries:      common.DefaultMaxRetries,
+			TLSMinVersion:   outboundTLSVersion,
+			MaxConnsPerHost: *maxConnsPerHost,
+			MaxBandwidth:    *maxBandwidth,
+			CircuitFailures: *circuitFailures,
+			CircuitCooldown: time.Duration(*circuitCooldown) * time.Second,
+			CACert:          *caCert,
+			InsecureTLS:     *insecureTLS,
+			Headers:         outboundHeaders,
+			Token:           *regToken,
+		}
+	}
+
+	// Downloader settings, also used by the server with --pull-through
+	downloaderConfig := &common.DownloaderConfig{
+		ProxyURL:         *proxy,
+		CheckJitter:      *checkJitter,
+		DownloadPath:     *downloadPath,
+		StagingPath:      *stagingPath,
+		MaxConcurrent:    *maxConcurrent,
+		ProviderFilter:   *providerFilter,
+		FilterPrecedence: *filterPrecedence,
+		ModuleFilter:     *moduleFilter,
+		GlobalMinVersion: *globalMinVersion,
+		KeepLatest:       *keepLatest,
+		Prerelease:       *prerelease,
+		Prune:            *prune,
+		PruneDryRun:      *pruneDryRun,
+		Dedupe:           *dedupe,
+		PlatformFilter:   *platformFilter,
+		MaxAttempts:      *maxAttempts,
+		DownloadTimeout:  time.Duration(*downloadTimeout) * time.Second,
+		CleanTmp:         *cleanTmp,
+		CleanTmpAge:      time.Duration(*cleanTmpAge) * time.Second,
+		RetryBaseDelay:   time.Duration(*retryBaseDelay) * time.Second,
+		RetryMaxDelay:    time.Duration(*retryMaxDelay) * time.Second,
+		DownloadBinaries: *downloadBinaries,
+		RequireBinSums:   *requireBinSums,
+		BinarySources:    binSources,
+		EventsNDJSON:     *eventsNDJSON,
+		TLSMinVersion:    outboundTLSVersion,
+		Sample:           *sample,
+		VerifySignatures: *verifySigs,
+		RebuildMetadata:  *rebuildMetadata,
+		Verify:           *verifyMirror,
+		VerifyDelete:     *verifyDelete,
+		Once:             *once,
+		FailThreshold:    *failThreshold,
+		Progress:         *progress,
+		MaxConnsPerHost:  *maxConnsPerHost,
+		MaxBandwidth:     *maxBandwidth,
+		CircuitFailures:  *circuitFailures,
+		CircuitCooldown:  time.Duration(*circuitCooldown) * time.Second,
+		CACert:           *caCert,
+		InsecureTLS:      *insecureTLS,
+		UserAgent:        *userAgent,
+		Headers:          outboundHeaders,
+		SummaryJSON:      *summaryJSON,
+
+		IncrementalDiscovery: *incrementalDisc,
+		MaxDiskBytes:         *maxDiskBytes,
+		MaxDiskPercent:       *maxDiskPercent,
+		RequireFreeSpace:     *requireFreeSpace,
+		ProviderTimeout:      time.Duration(*providerTimeout) * time.Second,
+	}
+
 	// Run appropriate mode
 	switch appMode {
 	case ModeDownloader:
-		runDownloader(logger, *downloadPath, *proxy, *checkPeriod, *providerFilter, *platformFilter, *maxAttempts, *downloadTimeout, *downloadBinaries)
+		if *allPlatforms && *platformFilter != "" {
+			logger.Fatal("Error: --all-platforms and --platform-filter are mutually exclusive")
+		}
+		period, err := common.ParseHoursOrDuration(*checkPeriod)
+		if err != nil {
+			logger.Fatal("Error: invalid --check-period: %v", err)
+		}
+
+		downloaderConfig.CheckPeriod = period
+		downloaderConfig.PlatformFilter = selectPlatforms(logger, *platformFilter, *platformAuto, *allPlatforms)
+
+		runDownloader(logger, downloaderConfig, registryConfig)
 	case ModeServer:
-		runServer(logger, *dataPath, *listenHost, *listenPort, *hostname, *enableTLS, *tlsCert, *tlsKey)
+		// Create server configuration
+		serverConfig := &common.ServerConfig{
+			ListenHost: *listenHost,
+			ListenPort: *listenPort,
+			Hostname:   *hostname,
+			EnableTLS:  *enableTLS,
+			TLSCert:    *tlsCert,
+			TLSKey:     *tlsKey,
+			DataPath:   *dataPath,
+		}
+		serverConfig.AllowedSuffixes = splitList(*allowFiles)
+		serverConfig.AllowedHosts = splitList(*allowHosts)
+		serverConfig.StatsTTL = time.Duration(*statsTTL) * time.Second
+		serverConfig.ReadTimeout = time.Duration(*readTO) * time.Second
+		serverConfig.WriteTimeout = time.Duration(*writeTO) * time.Second
+		serverConfig.IdleTimeout = time.Duration(*idleTO) * time.Second
+		serverConfig.AuthToken = *authToken
+		serverConfig.TrustProxy = *trustProxy
+		allowedCIDRs, err := common.ParseCIDRList(*allowCIDR)
+		if err != nil {
+			logger.Fatal("Invalid --allow-cidr: %v", err)
+		}
+		serverConfig.AllowedCIDRs = allowedCIDRs
+		serverConfig.RegistryHost = registryHost
+		serverConfig.EnableUI = *enableUI
+		serverConfig.TLSMinVersion, err = common.ParseTLSVersion(*tlsMin)
+		if err != nil {
+			logger.Fatal("Error: invalid --tls-min-version: %v", err)
+		}
+		for _, value := range sniCerts {
+			certPath, keyPath, ok := strings.Cut(value, ",")
+			if !ok || strings.TrimSpace(certPath) == "" || strings.TrimSpace(keyPath) == "" {
+				logger.Fatal("Error: invalid --tls-sni-cert '%s', expected 'cert.pem,key.pem'", value)
+			}
+			serverConfig.TLSSNICerts = append(serverConfig.TLSSNICerts, common.CertKeyPair{Cert: strings.TrimSpace(certPath), Key: strings.TrimSpace(keyPath)})
+		}
+
+		// Pull-through fetches through a downloader service writing into --data-path
+		var fetcher server.Fetcher
+		if *pullThru {
+			if *dataPath == "" {
+				logger.Fatal("Error: --data-path is required for server mode")
+			}
+			// Archives go straight into the served tree
+			fetchConfig := *downloaderConfig
+			fetchConfig.DownloadPath = *dataPath
+			fetchConfig.StagingPath = ""
+			service, err := downloader.NewService(&fetchConfig, registryConfig, logger)
+			if err != nil {
+				logger.Fatal("Error: failed to set up --pull-through: %v", err)
+			}
+			fetcher = service
+		}
+
+		runServer(logger, serverConfig, fetcher)
+	case ModeLockfile:
+		runLockfile(logger, *dataPath, registryHost, *providerFilter, *platformFilter)
+	}
+}
+
+// selectPlatforms returns the platform filter for the downloader and logs
+// which platforms were selected and why
+func selectPlatforms(logger *common.Logger, filter string, auto, all bool) string {
+	switch {
+	case filter != "":
+		logger.Info("Platforms: %s (from --platform-filter)", filter)
+	case all:
+		logger.Info("Platforms: all supported (--all-platforms)")
+	case auto:
+		filter = common.AutoPlatformFilter(runtime.GOOS, runtime.GOARCH)
+		logger.Info("Platforms: %s (--platform-auto: host %s_%s plus linux_amd64, use --all-platforms for all)", filter, runtime.GOOS, runtime.GOARCH)
+	default:
+		logger.Info("Platforms: all supported (no --platform-filter)")
+	}
+	return filter
+}
+
+func runLockfile(logger *common.Logger, dataPath, registryHost, providerFilterString, platformFilterString string) {
+	if dataPath == "" {
+		logger.Fatal("Error: --data-path is required for lockfile mode")
+	}
+
+	providerFilter, err := common.NewProviderFilter(providerFilterString)
+	if err != nil {
+		logger.Fatal("Invalid provider filter: %v", err)
 	}
+	platformFilter, err := common.NewPlatformFilter(platformFilterString)
+	if err != nil {
+		logger.Fatal("Invalid platform filter: %v", err)
+	}
+
+	entries, err := lockfile.Generate(dataPath, registryHost, providerFilter, platformFilter)
+	if err != nil {
+		logger.Fatal("Failed to generate lock file hashes: %v", err)
+	}
+	if err := lockfile.Write(os.Stdout, entries); err != nil {
+		logger.Fatal("Failed to write lock file: %v", err)
+	}
+	logger.Info("Wrote lock entries for %d providers", len(entries))
 }
 
-func runDownloader(logger *common.Logger, downloadPath, proxy string, checkPeriod int, providerFilter, platformFilter string, maxAttempts int, downloadTimeout int, downloadBinaries string) {
+func runDownloader(logger *common.Logger, downloaderConfig *common.DownloaderConfig, registryConfig *common.RegistryConfig) {
 	// Validate required parameters for downloader
-	if downloadPath == "" {
+	if downloaderConfig.DownloadPath == "" {
 		logger.Fatal("Error: --download-path is required for downloader mode")
 	}
 
-	if checkPeriod <= 0 {
+	if downloaderConfig.CheckPeriod <= 0 {
 		logger.Fatal("Error: --check-period must be positive")
 	}
+	if downloaderConfig.VerifyDelete && !downloaderConfig.Verify {
+		logger.Fatal("Error: --verify-delete requires --verify")
+	}
 
 	// Create download directory if it doesn't exist
-	if err := os.MkdirAll(downloadPath, 0755); err != nil {
+	if err := os.MkdirAll(downloaderConfig.DownloadPath, 0755); err != nil {
 		logger.Fatal("Failed to create download directory: %v", err)
 	}
+	if downloaderConfig.StagingPath != "" {
+		if filepath.Clean(downloaderConfig.StagingPath) == filepath.Clean(downloaderConfig.DownloadPath) {
+			logger.Fatal("Error: --staging-path must differ from --download-path")
+		}
+		if err := os.MkdirAll(downloaderConfig.StagingPath, 0755); err != nil {
+			logger.Fatal("Failed to create staging directory: %v", err)
+		}
+	}
 
 	logger.Info("Downloader Configuration:")
-	logger.Info("  Download path: %s", downloadPath)
-	logger.Info("  Check period: %d hours", checkPeriod)
-	if proxy != "" {
-		logger.Info("  Proxy: %s", proxy)
+	logger.Info("  Download path: %s", downloaderConfig.DownloadPath)
+	if downloaderConfig.StagingPath != "" {
+		logger.Info("  Staging path: %s", downloaderConfig.StagingPath)
+	}
+	logger.Info("  Registry: %s", registryConfig.BaseURL)
+	logger.Info("  Check period: %v", downloaderConfig.CheckPeriod)
+	if downloaderConfig.CheckJitter < 0 || downloaderConfig.CheckJitter >= 1 {
+		logger.Fatal("Error: --check-jitter must be at least 0 and less than 1")
+	}
+	if downloaderConfig.CheckJitter > 0 {
+		logger.Info("  Check jitter: ±%.0f%%", downloaderConfig.CheckJitter*100)
+	}
+	if downloaderConfig.MaxConcurrent < 1 {
+		logger.Fatal("Error: --max-concurrent must be at least 1")
+	}
+	logger.Info("  Max concurrent downloads: %d", downloaderConfig.MaxConcurrent)
+	if downloaderConfig.ProxyURL != "" {
+		logger.Info("  Proxy: %s", downloaderConfig.ProxyURL)
 	} else {
 		logger.Info("  Proxy: none")
 	}
-	if providerFilter != "" {
-		logger.Info("  Provider filter: %s", providerFilter)
+	if downloaderConfig.ProviderFilter != "" {
+		logger.Info("  Provider filter: %s", downloaderConfig.ProviderFilter)
 	} else {
 		logger.Info("  Provider filter: all providers")
 	}
-	if platformFilter != "" {
-		logger.Info("  Platform filter: %s", platformFilter)
+	if downloaderConfig.ModuleFilter != "" {
+		logger.Info("  Module filter: %s", downloaderConfig.ModuleFilter)
+	}
+	if downloaderConfig.GlobalMinVersion != "" {
+		logger.Info("  Global min version: %s", downloaderConfig.GlobalMinVersion)
+	}
+	if len(downloaderConfig.BinarySources) > 0 {
+		if _, err := binaries.ParseBinarySources(downloaderConfig.BinarySources); err != nil {
+			logger.Fatal("Error: invalid --binary-source: %v", err)
+		}
+		for _, source := range downloaderConfig.BinarySources {
+			logger.Info("  Binary source: %s", source)
+		}
+	}
+	if downloaderConfig.RetryBaseDelay < 0 || downloaderConfig.RetryMaxDelay < 0 {
+		logger.Fatal("Error: --retry-base-delay and --retry-max-delay must not be negative")
+	}
+	logger.Info("  Retry backoff: %v base, %v max", downloaderConfig.RetryBaseDelay, downloaderConfig.RetryMaxDelay)
+	if downloaderConfig.KeepLatest < 0 {
+		logger.Fatal("Error: --keep-latest must not be negative")
+	}
+	if downloaderConfig.KeepLatest > 0 {
+		logger.Info("  Keep latest: %d versions per provider", downloaderConfig.KeepLatest)
+	}
+	if downloaderConfig.Prerelease {
+		logger.Info("  Prerelease versions: included")
+	}
+	if downloaderConfig.Prune && downloaderConfig.PruneDryRun {
+		logger.Info("  Prune: dry run, nothing is deleted")
+	} else if downloaderConfig.Prune {
+		logger.Info("  Prune: enabled")
+	}
+	if downloaderConfig.Dedupe {
+		logger.Info("  Dedupe: enabled")
+	}
+	if downloaderConfig.FailThreshold < 0 || downloaderConfig.FailThreshold > 100 {
+		logger.Fatal("Error: --fail-threshold must be between 0 and 100")
+	}
+	if downloaderConfig.FailThreshold > 0 {
+		logger.Info("  Fail threshold: %.1f%% of provider downloads", downloaderConfig.FailThreshold)
+	}
+	if downloaderConfig.PlatformFilter != "" {
+		logger.Info("  Platform filter: %s", downloaderConfig.PlatformFilter)
 	} else {
 		logger.Info("  Platform filter: all supported platforms")
 	}
-
-	// Create downloader configuration
-	downloaderConfig := &common.DownloaderConfig{
-		ProxyURL:         proxy,
-		CheckPeriod:      time.Duration(checkPeriod) * time.Hour,
-		DownloadPath:     downloadPath,
-		MaxConcurrent:    common.DefaultMaxConcurrent,
-		ProviderFilter:   providerFilter,
-		PlatformFilter:   platformFilter,
-		MaxAttempts:      maxAttempts,
-		DownloadTimeout:  time.Duration(downloadTimeout) * time.Second,
-		DownloadBinaries: downloadBinaries,
-	}
-
-	// Create registry configuration
-	registryConfig := &common.RegistryConfig{
-		BaseURL:    common.TerraformRegistryURL,
-		ProxyURL:   proxy,
-		UserAgent:  common.UserAgent,
-		Timeout:    common.DefaultTimeout,
-		MaxRetries: common.DefaultMaxRetries,
+	if downloaderConfig.Sample != "" {
+		logger.Info("  Sample: %s (validation mode)", downloaderConfig.Sample)
+	}
+	if downloaderConfig.MaxConnsPerHost < 0 {
+		logger.Fatal("Error: --max-conns-per-host must not be negative")
+	}
+	if downloaderConfig.MaxConnsPerHost > 0 {
+		logger.Info("  Max connections per host: %d", downloaderConfig.MaxConnsPerHost)
+	}
+	if downloaderConfig.MaxBandwidth < 0 {
+		logger.Fatal("Error: --max-bandwidth must not be negative")
+	}
+	if downloaderConfig.MaxBandwidth > 0 {
+		logger.Info("  Max bandwidth: %d bytes/s", downloaderConfig.MaxBandwidth)
+	}
+	if downloaderConfig.MaxDiskBytes < 0 {
+		logger.Fatal("Error: --max-disk-bytes must not be negative")
+	}
+	if downloaderConfig.MaxDiskPercent < 0 || downloaderConfig.MaxDiskPercent > 100 {
+		logger.Fatal("Error: --max-disk-percent must be between 0 and 100")
+	}
+	if downloaderConfig.MaxDiskBytes > 0 {
+		logger.Info("  Max disk usage: %s", common.FormatBytes(downloaderConfig.MaxDiskBytes))
+	}
+	if downloaderConfig.MaxDiskPercent > 0 {
+		logger.Info("  Max filesystem usage: %d%%", downloaderConfig.MaxDiskPercent)
+	}
+	if downloaderConfig.RequireFreeSpace {
+		logger.Info("  Require free space: enabled")
+	}
+	if downloaderConfig.ProviderTimeout < 0 {
+		logger.Fatal("Error: --provider-timeout must not be negative")
+	}
+	if downloaderConfig.ProviderTimeout > 0 {
+		logger.Info("  Provider timeout: %v", downloaderConfig.ProviderTimeout)
+	}
+	if downloaderConfig.CircuitFailures < 0 || downloaderConfig.CircuitCooldown < 0 {
+		logger.Fatal("Error: --circuit-failures and --circuit-cooldown must not be negative")
+	}
+	if downloaderConfig.CircuitFailures > 0 {
+		logger.Info("  Circuit breaker: open after %d consecutive failures for %s", downloaderConfig.CircuitFailures, downloaderConfig.CircuitCooldown)
+	} else {
+		logger.Info("  Circuit breaker: disabled")
+	}
+	if downloaderConfig.UserAgent != common.UserAgent {
+		logger.Info("  User-Agent: %s", downloaderConfig.UserAgent)
+	}
+	if registryConfig.Token != "" {
+		logger.Info("  Registry token: set") // never log the value
+	}
+	for name := range downloaderConfig.Headers {
+		logger.Info("  Extra header: %s", name) // values may be credentials
+	}
+	if downloaderConfig.CACert != "" {
+		logger.Info("  Extra CA bundle: %s", downloaderConfig.CACert)
+	}
+	if downloaderConfig.InsecureTLS {
+		logger.Warn("  TLS certificate verification is DISABLED (--insecure-skip-verify): upstream traffic can be intercepted")
+		if downloaderConfig.VerifySignatures {
+			logger.Warn("  Downloads are still checked against signed SHA256SUMS")
+		} else {
+			logger.Warn("  Consider --verify-signatures so downloads are checked against signed SHA256SUMS")
+		}
+	}
+	if downloaderConfig.VerifySignatures {
+		logger.Info("  Signature verification: enabled")
+	}
+	if downloaderConfig.SummaryJSON != "" {
+		logger.Info("  Run summary: %s", downloaderConfig.SummaryJSON)
+	}
+	if downloaderConfig.CleanTmpAge < 0 {
+		logger.Fatal("Error: --clean-tmp-age must not be negative")
+	}
+	if downloaderConfig.CleanTmp {
+		logger.Info("  Clean stale .tmp files: older than %v", downloaderConfig.CleanTmpAge)
+	}
+	if downloaderConfig.IncrementalDiscovery {
+		logger.Info("  Incremental discovery: enabled")
+	}
+	if downloaderConfig.EventsNDJSON {
+		logger.Info("  Events: NDJSON on stdout")
 	}
 
 	// Create and start downloader service
@@ -299,6 +1203,39 @@ func runDownloader(logger *common.Logger, downloadPath, proxy string, checkPerio
 	}
 	defer service.Close()
 
+	if downloaderConfig.CleanTmp {
+		if _, err := service.CleanTmpFiles(); err != nil {
+			logger.Warn("Failed to clean .tmp files: %v", err)
+		}
+	}
+
+	if downloaderConfig.RebuildMetadata {
+		if err := service.RebuildMetadata(); err != nil {
+			logger.Fatal("Metadata rebuild failed: %v", err)
+		}
+		logger.Info("Metadata rebuild completed")
+		return
+	}
+
+	if downloaderConfig.Verify {
+		report, err := service.VerifyMirror(downloaderConfig.VerifyDelete)
+		if err != nil && report == nil {
+			logger.Fatal("Verification failed: %v", err)
+		}
+		if err != nil {
+			logger.Error("%v", err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			logger.Fatal("Failed to write verification report: %v", err)
+		}
+		if !report.OK() {
+			logger.Fatal("Verification found %d corrupt archives", len(report.Failures))
+		}
+		return
+	}
+
 	// Set up signal handling
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -312,87 +1249,137 @@ func runDownloader(logger *common.Logger, downloadPath, proxy string, checkPerio
 		cancel()
 	}()
 
-	// Start the service
-	if err := service.StartWithContext(ctx); err != nil {
-		logger.Fatal("Downloader service failed: %v", err)
+	if downloaderConfig.Once {
+		if err := service.RunOnce(ctx); err != nil {
+			service.Close()
+			logger.Fatal("Download run failed: %v", err)
+		}
+		logger.Info("Download run completed")
+		return
 	}
 
-	// После скачивания провайдеров и генерации индексов — скачиваем бинарники HashiCorp, если требуется
-	if downloadBinaries != "" {
-		logger.Info("Starting download of HashiCorp binaries from releases.hashicorp.com")
-		binFilters, err := binaries.ParseBinaryFilter(downloadBinaries)
-		if err != nil {
-			logger.Error("Failed to parse download-binaries filter: %v", err)
-			return
-		}
-		platforms := binaries.SupportedPlatforms()
-		_, err = binaries.DownloadHashiCorpBinaries(downloadPath, binFilters, platforms, func(format string, args ...interface{}) {
-			logger.Info(format, args...)
-		})
-		if err != nil {
-			logger.Error("Failed to download HashiCorp binaries: %v", err)
-		} else {
-			logger.Info("HashiCorp binaries download completed")
+	// Start the service. It runs until a shutdown signal, then reports
+	// whether the latest run failed; returning before that is a failure too.
+	serviceErr := make(chan error, 1)
+	go func() {
+		serviceErr <- service.StartWithContext(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		err = <-serviceErr
+	case err = <-serviceErr:
+		if err == nil {
+			err = errors.New("stopped without a shutdown signal")
 		}
 	}
+	if err != nil {
+		service.Close()
+		logger.Fatal("Downloader service failed: %v", err)
+	}
 }
 
-func runServer(logger *common.Logger, dataPath, listenHost string, listenPort int, hostname string, enableTLS bool, tlsCert, tlsKey string) {
+func runServer(logger *common.Logger, config *common.ServerConfig, fetcher server.Fetcher) {
 	// Validate required parameters for server
-	if dataPath == "" {
+	if config.DataPath == "" {
 		logger.Fatal("Error: --data-path is required for server mode")
 	}
 
-	if enableTLS {
-		if tlsCert == "" || tlsKey == "" {
+	if config.EnableTLS {
+		if config.TLSCert == "" || config.TLSKey == "" {
 			logger.Fatal("Error: --tls-crt and --tls-key are required when --enable-tls is set")
 		}
 
 		// Verify TLS files exist
-		if _, err := os.Stat(tlsCert); os.IsNotExist(err) {
-			logger.Fatal("Error: TLS certificate file does not exist: %s", tlsCert)
+		if _, err := os.Stat(config.TLSCert); os.IsNotExist(err) {
+			logger.Fatal("Error: TLS certificate file does not exist: %s", config.TLSCert)
+		}
+		if _, err := os.Stat(config.TLSKey); os.IsNotExist(err) {
+			logger.Fatal("Error: TLS key file does not exist: %s", config.TLSKey)
 		}
-		if _, err := os.Stat(tlsKey); os.IsNotExist(err) {
-			logger.Fatal("Error: TLS key file does not exist: %s", tlsKey)
+		for _, pair := range config.TLSSNICerts {
+			if _, err := os.Stat(pair.Cert); os.IsNotExist(err) {
+				logger.Fatal("Error: TLS certificate file does not exist: %s", pair.Cert)
+			}
+			if _, err := os.Stat(pair.Key); os.IsNotExist(err) {
+				logger.Fatal("Error: TLS key file does not exist: %s", pair.Key)
+			}
 		}
+	} else if len(config.TLSSNICerts) > 0 {
+		logger.Fatal("Error: --tls-sni-cert requires --enable-tls")
 	}
 
 	// Verify data path exists
-	if _, err := os.Stat(dataPath); os.IsNotExist(err) {
-		logger.Fatal("Error: Data path does not exist: %s", dataPath)
+	if _, err := os.Stat(config.DataPath); os.IsNotExist(err) {
+		logger.Fatal("Error: Data path does not exist: %s", config.DataPath)
 	}
 
-	if listenPort <= 0 || listenPort > 65535 {
+	if config.ListenPort <= 0 || config.ListenPort > 65535 {
 		logger.Fatal("Error: --listen-port must be between 1 and 65535")
 	}
+	if config.ReadTimeout < 0 || config.WriteTimeout < 0 || config.IdleTimeout < 0 {
+		logger.Fatal("Error: --read-timeout, --write-timeout and --idle-timeout must not be negative")
+	}
 
 	logger.Info("Server Configuration:")
-	logger.Info("  Listen address: %s:%d", listenHost, listenPort)
-	logger.Info("  Data path: %s", dataPath)
-	if hostname != "" {
-		logger.Info("  Hostname: %s", hostname)
+	logger.Info("  Listen address: %s:%d", config.ListenHost, config.ListenPort)
+	logger.Info("  Data path: %s", config.DataPath)
+	logger.Info("  Registry host: %s", config.RegistryHost)
+	if config.Hostname != "" {
+		logger.Info("  Hostname: %s", config.Hostname)
 	}
-	if enableTLS {
+	if config.EnableTLS {
 		logger.Info("  TLS enabled: yes")
-		logger.Info("  Certificate: %s", tlsCert)
-		logger.Info("  Private key: %s", tlsKey)
+		logger.Info("  Certificate: %s", config.TLSCert)
+		logger.Info("  Private key: %s", config.TLSKey)
+		for _, pair := range config.TLSSNICerts {
+			logger.Info("  SNI certificate: %s", pair.Cert)
+		}
+		logger.Info("  Minimum TLS version: %s", tls.VersionName(config.TLSMinVersion))
 	} else {
 		logger.Info("  TLS enabled: no")
 	}
-
-	// Create server configuration
-	config := &common.ServerConfig{
-		ListenHost: listenHost,
-		ListenPort: listenPort,
-		Hostname:   hostname,
-		EnableTLS:  enableTLS,
-		TLSCert:    tlsCert,
-		TLSKey:     tlsKey,
-		DataPath:   dataPath,
+	if len(config.AllowedHosts) > 0 {
+		logger.Info("  Allowed hosts: %s", strings.Join(config.AllowedHosts, ", "))
+	}
+	logger.Info("  Timeouts: read %s, write %s, idle %s", formatTimeout(config.ReadTimeout), formatTimeout(config.WriteTimeout), formatTimeout(config.IdleTimeout))
+	if len(config.AllowedSuffixes) > 0 {
+		logger.Info("  Allowed files: %s", strings.Join(config.AllowedSuffixes, ", "))
+	}
+	if config.AuthToken != "" {
+		logger.Info("  Bearer token auth: enabled")
+	}
+	if len(config.AllowedCIDRs) > 0 {
+		cidrs := make([]string, 0, len(config.AllowedCIDRs))
+		for _, prefix := range config.AllowedCIDRs {
+			cidrs = append(cidrs, prefix.String())
+		}
+		logger.Info("  Allowed CIDRs: %s (trust proxy: %t)", strings.Join(cidrs, ", "), config.TrustProxy)
+	}
+	if config.EnableUI {
+		logger.Info("  Web UI: enabled at /ui")
+	}
+	if fetcher != nil {
+		logger.Info("  Pull-through: enabled, missing archives are fetched from %s", config.RegistryHost)
 	}
 
 	// Create server
 	srv := server.NewServer(config, logger)
+	if fetcher != nil {
+		srv.EnablePullThrough(fetcher)
+	}
+	// The exits below skip deferred calls, so the fetcher is closed by hand
+	closeFetcher := func() {
+		if closer, ok := fetcher.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Warn("Failed to close pull-through fetcher: %v", err)
+			}
+		}
+	}
+
+	// SIGHUP rescans the data directory and reloads certificates
+	stopReload := srv.ReloadOnSignal(syscall.SIGHUP)
+	defer stopReload()
 
 	// Setup graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -425,13 +1412,48 @@ func runServer(logger *common.Logger, dataPath, listenHost string, listenPort in
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer shutdownCancel()
 
-		if err := srv.Stop(shutdownCtx); err != nil {
+		err := srv.Stop(shutdownCtx)
+		closeFetcher()
+		if err != nil {
 			logger.Error("Error during server shutdown: %v", err)
 			os.Exit(1)
 		}
 		logger.Info("Server stopped gracefully")
 
 	case err := <-serverErr:
+		closeFetcher()
 		logger.Fatal("Server failed to start: %v", err)
 	}
 }
+
+// stringList collects the values of a repeatable flag such as --header,
+// --binary-source or --tls-sni-cert
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma-separated option into trimmed, non-empty values
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// formatTimeout renders a server timeout for the startup log, 0 meaning none
+func formatTimeout(d time.Duration) string {
+	if d == 0 {
+		return "none"
+	}
+	return d.String()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestSelectPlatforms(t *testing.T) {
+	auto := common.AutoPlatformFilter(runtime.GOOS, runtime.GOARCH)
+	tests := []struct {
+		name    string
+		filter  string
+		auto    bool
+		all     bool
+		want    string
+		wantLog string
+	}{
+		{name: "explicit filter wins", filter: "freebsd_amd64", auto: true, all: true, want: "freebsd_amd64", wantLog: "from --platform-filter"},
+		{name: "all platforms over auto", auto: true, all: true, want: "", wantLog: "--all-platforms"},
+		{name: "auto", auto: true, want: auto, wantLog: "--platform-auto: host " + runtime.GOOS + "_" + runtime.GOARCH},
+		{name: "default is all platforms", want: "", wantLog: "no --platform-filter"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := common.NewLogger()
+			logger.SetOutput(io.Discard)
+			logger.TeeTo(&buf)
+
+			if got := selectPlatforms(logger, tt.filter, tt.auto, tt.all); got != tt.want {
+				t.Errorf("selectPlatforms = %q, want %q", got, tt.want)
+			}
+			if !strings.Contains(buf.String(), tt.wantLog) {
+				t.Errorf("logged %q, want %q", buf.String(), tt.wantLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for requests to a host whose circuit is open
+var ErrCircuitOpen = errors.New("circuit open")
+
+// Default circuit breaker settings
+const (
+	DefaultCircuitFailures = 10
+	DefaultCircuitCooldown = time.Minute
+)
+
+// CircuitState is the state of the circuit for one host
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // requests pass
+	CircuitOpen                         // requests fail immediately until the cooldown ends
+	CircuitHalfOpen                     // one probe request decides whether to close or reopen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// hostCircuit tracks one upstream host
+type hostCircuit struct {
+	state    CircuitState
+	failures int // consecutive failures while closed
+	openedAt time.Time
+}
+
+// CircuitBreaker short-circuits requests to a host after a number of
+// consecutive failures (network errors and 5xx), so an upstream outage does
+// not make every job go through all of its attempts. After the cooldown a
+// single probe is let through: success closes the circuit, failure reopens it.
+// A nil breaker lets everything through.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	cooldown time.Duration
+	hosts    map[string]*hostCircuit
+	logger   *Logger
+	now      func() time.Time
+}
+
+// NewCircuitBreaker creates a breaker opening after failures consecutive
+// failures, or returns nil if failures <= 0. logger may be nil.
+func NewCircuitBreaker(failures int, cooldown time.Duration, logger *Logger) *CircuitBreaker {
+	if failures <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{
+		failures: failures,
+		cooldown: cooldown,
+		hosts:    make(map[string]*hostCircuit),
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// Allow returns ErrCircuitOpen if a request to host must not be made now.
+// Once the cooldown is over the first caller becomes the half-open probe.
+func (b *CircuitBreaker) Allow(host string) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.hosts[host]
+	if c == nil {
+		return nil
+	}
+	switch c.state {
+	case CircuitOpen:
+		if wait := c.openedAt.Add(b.cooldown).Sub(b.now()); wait > 0 {
+			return fmt.Errorf("%w for %s, retrying in %s", ErrCircuitOpen, host, wait.Round(time.Second))
+		}
+		b.transition(host, c, CircuitHalfOpen)
+		return nil
+	case CircuitHalfOpen:
+		return fmt.Errorf("%w for %s, waiting for a probe request", ErrCircuitOpen, host)
+	}
+	return nil
+}
+
+// Record reports the outcome of a request to host that Allow let through
+func (b *CircuitBreaker) Record(host string, failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := b.hosts[host]
+	if c == nil {
+		if !failed {
+			return
+		}
+		c = &hostCircuit{}
+		b.hosts[host] = c
+	}
+
+	switch {
+	case !failed:
+		c.failures = 0
+		if c.state != CircuitClosed {
+			b.transition(host, c, CircuitClosed)
+		}
+	case c.state == CircuitHalfOpen:
+		b.transition(host, c, CircuitOpen)
+	case c.state == CircuitClosed:
+		c.failures++
+		if c.failures >= b.failures {
+			b.transition(host, c, CircuitOpen)
+		}
+	}
+}
+
+// Release gives up a half-open probe without an outcome (e.g. the request
+// was cancelled), letting the next caller probe instead
+func (b *CircuitBreaker) Release(host string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.hosts[host]; c != nil && c.state == CircuitHalfOpen {
+		c.state = CircuitOpen
+		c.openedAt = b.now().Add(-b.cooldown)
+	}
+}
+
+// State returns the current state of the circuit for host
+func (b *CircuitBreaker) State(host string) CircuitState {
+	if b == nil {
+		return CircuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c := b.hosts[host]; c != nil {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// transition changes the state of a host circuit; b.mu must be held
+func (b *CircuitBreaker) transition(host string, c *hostCircuit, state CircuitState) {
+	c.state = state
+	switch state {
+	case CircuitOpen:
+		c.openedAt = b.now()
+		if b.logger != nil {
+			b.logger.Warn("Circuit open for %s: too many consecutive failures, pausing requests for %s", host, b.cooldown)
+		}
+	case CircuitHalfOpen:
+		if b.logger != nil {
+			b.logger.Info("Circuit half-open for %s: sending a probe request", host)
+		}
+	case CircuitClosed:
+		c.failures = 0
+		if b.logger != nil {
+			b.logger.Info("Circuit closed for %s: host is responding again", host)
+		}
+	}
+}
+
+// Transport wraps base so that every request goes through the breaker
+func (b *CircuitBreaker) Transport(base http.RoundTripper) http.RoundTripper {
+	if b == nil {
+		return base
+	}
+	return &circuitTransport{base: base, breaker: b}
+}
+
+// circuitTransport is an http.RoundTripper guarded by a CircuitBreaker
+type circuitTransport struct {
+	base    http.RoundTripper
+	breaker *CircuitBreaker
+}
+
+// CloseIdleConnections passes the call to the wrapped transport, so
+// http.Client.CloseIdleConnections reaches it
+func (t *circuitTransport) CloseIdleConnections() {
+	if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *circuitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	if err := t.breaker.Allow(host); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		t.breaker.Release(host) // cancelled, says nothing about the host
+	case err != nil:
+		t.breaker.Record(host, true)
+	default:
+		t.breaker.Record(host, resp.StatusCode >= 500)
+	}
+	return resp, err
+}
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// closeRecorder is a RoundTripper counting CloseIdleConnections calls
+type closeRecorder struct {
+	closed int
+}
+
+func (r *closeRecorder) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func (r *closeRecorder) CloseIdleConnections() {
+	r.closed++
+}
+
+func TestCircuitTransportCloseIdleConnections(t *testing.T) {
+	tests := []struct {
+		name    string
+		breaker *CircuitBreaker
+	}{
+		{"no breaker", nil},
+		{"breaker", NewCircuitBreaker(3, time.Minute, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := &closeRecorder{}
+			client := &HTTPClient{client: &http.Client{Transport: tt.breaker.Transport(base)}}
+			if err := client.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if base.closed != 1 {
+				t.Errorf("CloseIdleConnections reached the transport %d times, want 1", base.closed)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	const host = "registry.terraform.io"
+	// step is one action on the breaker followed by the expected state
+	type step struct {
+		action    string // "fail", "ok", "allow", "release" or "wait"
+		wantErr   bool   // for "allow"
+		wantState CircuitState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "closed below the threshold",
+			steps: []step{
+				{action: "fail", wantState: CircuitClosed},
+				{action: "fail", wantState: CircuitClosed},
+				{action: "allow", wantState: CircuitClosed},
+			},
+		},
+		{
+			name: "success resets the failure count",
+			steps: []step{
+				{action: "fail", wantState: CircuitClosed},
+				{action: "fail", wantState: CircuitClosed},
+				{action: "ok", wantState: CircuitClosed},
+				{action: "fail", wantState: CircuitClosed},
+				{action: "fail", wantState: CircuitClosed},
+			},
+		},
+		{
+			name: "opens at the threshold and short-circuits",
+			steps: []step{
+				{action: "fail", wantState: CircuitClosed},
+				{action: "fail", wantState: CircuitClosed},
+				{action: "fail", wantState: CircuitOpen},
+				{action: "allow", wantErr: true, wantState: CircuitOpen},
+			},
+		},
+		{
+			name: "probe success closes",
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "fail", wantState: CircuitOpen},
+				{action: "wait", wantState: CircuitOpen},
+				{action: "allow", wantState: CircuitHalfOpen},
+				{action: "allow", wantErr: true, wantState: CircuitHalfOpen}, // only one probe
+				{action: "ok", wantState: CircuitClosed},
+				{action: "allow", wantState: CircuitClosed},
+			},
+		},
+		{
+			name: "probe failure reopens",
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "fail", wantState: CircuitOpen},
+				{action: "wait", wantState: CircuitOpen},
+				{action: "allow", wantState: CircuitHalfOpen},
+				{action: "fail", wantState: CircuitOpen},
+				{action: "allow", wantErr: true, wantState: CircuitOpen},
+			},
+		},
+		{
+			name: "released probe lets the next caller probe",
+			steps: []step{
+				{action: "fail"}, {action: "fail"}, {action: "fail", wantState: CircuitOpen},
+				{action: "wait", wantState: CircuitOpen},
+				{action: "allow", wantState: CircuitHalfOpen},
+				{action: "release", wantState: CircuitOpen},
+				{action: "allow", wantState: CircuitHalfOpen},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			b := NewCircuitBreaker(3, time.Minute, nil)
+			b.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				switch s.action {
+				case "fail", "ok":
+					b.Record(host, s.action == "fail")
+				case "allow":
+					err := b.Allow(host)
+					if (err != nil) != s.wantErr || err != nil && !errors.Is(err, ErrCircuitOpen) {
+						t.Errorf("step %d: Allow = %v, want error %t", i, err, s.wantErr)
+					}
+				case "release":
+					b.Release(host)
+				case "wait":
+					now = now.Add(time.Minute)
+				}
+				if got := b.State(host); got != s.wantState {
+					t.Errorf("step %d (%s): state = %s, want %s", i, s.action, got, s.wantState)
+				}
+			}
+			if got := b.State("other.example.com"); got != CircuitClosed {
+				t.Errorf("unrelated host state = %s, want closed", got)
+			}
+		})
+	}
+}
+
+func TestCircuitTransportShortCircuits(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		wantCalls int // requests that reached the upstream out of five
+	}{
+		{name: "healthy upstream", status: http.StatusOK, wantCalls: 5},
+		{name: "client errors do not count", status: http.StatusNotFound, wantCalls: 5},
+		{name: "server errors open the circuit", status: http.StatusBadGateway, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(tt.status)
+			}))
+			defer upstream.Close()
+
+			client := &http.Client{Transport: NewCircuitBreaker(2, time.Minute, nil).Transport(http.DefaultTransport)}
+			for range 5 {
+				resp, err := client.Get(upstream.URL)
+				if err == nil {
+					resp.Body.Close()
+				} else if !errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("Get: %v", err)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("upstream received %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -3,62 +3,127 @@ package common
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"time"
-
-	"golang.org/x/net/proxy"
 )
 
 // HTTPClient represents an HTTP client with proxy support
 type HTTPClient struct {
 	client     *http.Client
 	userAgent  string
+	headers    http.Header
+	token      string
+	tokenHost  string // the token is only sent to this host
 	maxRetries int
+	breaker    *CircuitBreaker
 }
 
 // NewHTTPClient creates a new HTTP client with optional proxy support
 func NewHTTPClient(config *RegistryConfig) (*HTTPClient, error) {
+	tlsConfig, err := OutboundTLSConfig(config.TLSMinVersion, config.CACert, config.InsecureTLS)
+	if err != nil {
+		return nil, err
+	}
+
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: false,
-		},
+		TLSClientConfig: tlsConfig,
+		// Caps simultaneous connections to a single upstream regardless of worker count
+		MaxConnsPerHost: config.MaxConnsPerHost,
 	}
 
-	// Configure proxy if provided
-	if config.ProxyURL != "" {
-		proxyURL, err := url.Parse(config.ProxyURL)
-		if err != nil {
-			return nil, fmt.Errorf("invalid proxy URL: %w", err)
-		}
+	// Configure proxy: --proxy, or HTTP_PROXY/HTTPS_PROXY; NO_PROXY applies to both
+	proxyFunc, err := ProxyFunc(config.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = proxyFunc
 
-		switch proxyURL.Scheme {
-		case "http", "https":
-			transport.Proxy = http.ProxyURL(proxyURL)
-		case "socks5":
-			dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, nil, proxy.Direct)
-			if err != nil {
-				return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
-			}
-			transport.Dial = dialer.Dial
-		default:
-			return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	var tokenHost string
+	if config.Token != "" {
+		u, err := url.Parse(config.BaseURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("registry token requires a valid registry URL")
 		}
+		tokenHost = u.Host
 	}
 
+	breaker := NewCircuitBreaker(config.CircuitFailures, config.CircuitCooldown, nil)
 	client := &http.Client{
-		Transport: transport,
+		Transport: breaker.Transport(transport),
 		Timeout:   config.Timeout,
 	}
+	if tokenHost != "" {
+		// net/http keeps Authorization on redirects within the same domain,
+		// e.g. to another port; the token is for the registry host only
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if req.URL.Host != tokenHost {
+				req.Header.Del("Authorization")
+			}
+			return nil
+		}
+	}
 
 	return &HTTPClient{
 		client:     client,
 		userAgent:  config.UserAgent,
+		headers:    config.Headers,
+		token:      config.Token,
+		tokenHost:  tokenHost,
 		maxRetries: config.MaxRetries,
+		breaker:    breaker,
 	}, nil
 }
 
+// SetLogger makes the client log circuit breaker state changes
+func (c *HTTPClient) SetLogger(logger *Logger) {
+	if c.breaker != nil {
+		c.breaker.mu.Lock()
+		c.breaker.logger = logger
+		c.breaker.mu.Unlock()
+	}
+}
+
+// OutboundTLSConfig builds the TLS settings for connections to upstreams.
+// caCert is a PEM bundle added to the system roots, for TLS-intercepting
+// corporate proxies; insecure disables certificate verification entirely.
+func OutboundTLSConfig(minVersion uint16, caCert string, insecure bool) (*tls.Config, error) {
+	if minVersion == 0 {
+		minVersion = DefaultTLSMinVersion
+	}
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+		MinVersion:         minVersion,
+	}
+	if caCert == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", caCert)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
 // Get performs a GET request with retry logic
 func (c *HTTPClient) Get(url string) (*http.Response, error) {
 	return c.GetWithContext(context.Background(), url)
@@ -66,33 +131,56 @@ func (c *HTTPClient) Get(url string) (*http.Response, error) {
 
 // GetWithContext performs a GET request with retry logic and context support
 func (c *HTTPClient) GetWithContext(ctx context.Context, url string) (*http.Response, error) {
+	return c.GetWithHeaders(ctx, url, nil)
+}
+
+// GetWithHeaders is GetWithContext with extra request headers, e.g. for
+// conditional requests
+func (c *HTTPClient) GetWithHeaders(ctx context.Context, url string, header http.Header) (*http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("User-Agent", c.userAgent)
+	SetRequestHeaders(req, c.userAgent, c.headers)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	// Archives hosted elsewhere (e.g. GitHub releases) never see the token;
+	// net/http also drops it on redirects to another host
+	if c.token != "" && req.URL.Host == c.tokenHost {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 
 	var resp *http.Response
 	var lastErr error
 
 	for i := 0; i <= c.maxRetries; i++ {
 		resp, lastErr = c.client.Do(req)
-		if lastErr == nil && resp.StatusCode < 500 {
+		if lastErr == nil && !isRetryableStatus(resp.StatusCode) {
 			return resp, nil
 		}
 
+		if i == c.maxRetries || errors.Is(lastErr, ErrCircuitOpen) {
+			break // return the last response as is
+		}
+
+		// Wait before retry with exponential backoff, or as long as the server asks
+		waitTime := time.Duration(1<<uint(i)) * time.Second
 		if resp != nil {
+			if retryAfter, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				waitTime = min(retryAfter, MaxRetryAfter)
+			}
 			resp.Body.Close()
 		}
-
-		if i < c.maxRetries {
-			// Wait before retry with exponential backoff
-			waitTime := time.Duration(1<<uint(i)) * time.Second
-			time.Sleep(waitTime)
+		if err := SleepContext(ctx, waitTime); err != nil {
+			return nil, fmt.Errorf("request cancelled while waiting to retry: %w", err)
 		}
 	}
 
+	if errors.Is(lastErr, ErrCircuitOpen) {
+		return nil, lastErr
+	}
 	if lastErr != nil {
 		return nil, fmt.Errorf("request failed after %d retries: %w", c.maxRetries, lastErr)
 	}
@@ -100,10 +188,66 @@ func (c *HTTPClient) GetWithContext(ctx context.Context, url string) (*http.Resp
 	return resp, nil
 }
 
+// MaxRetryAfter caps how long a Retry-After header can make the client wait
+const MaxRetryAfter = 2 * time.Minute
+
+// isRetryableStatus reports whether a response status is worth retrying
+func isRetryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// ParseRetryAfter parses a Retry-After header in delta-seconds or HTTP-date form.
+// It returns false if the header is absent or malformed.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// BackoffDelay returns the delay before retry number attempt (starting at 1):
+// base * 2^(attempt-1), capped at max, plus up to 50% random jitter
+func BackoffDelay(attempt int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := base
+	for i := 1; i < attempt && (max <= 0 || delay < max); i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// SleepContext waits for d or until ctx is done, whichever comes first
+func SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 // Close closes the HTTP client
 func (c *HTTPClient) Close() error {
-	if transport, ok := c.client.Transport.(*http.Transport); ok {
-		transport.CloseIdleConnections()
-	}
+	c.client.CloseIdleConnections()
 	return nil
 }
@@ -0,0 +1,375 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTLSServer starts an HTTPS server answering 200 that accepts TLS versions
+// up to maxVersion
+func newTLSServer(t *testing.T, maxVersion uint16) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: maxVersion}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestOutboundTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		clientMin  uint16
+		serverMax  uint16
+		wantMin    uint16
+		wantFailed bool
+	}{
+		{name: "default accepts TLS 1.2", serverMax: tls.VersionTLS12, wantMin: tls.VersionTLS12},
+		{name: "default rejects TLS 1.1", serverMax: tls.VersionTLS11, wantMin: tls.VersionTLS12, wantFailed: true},
+		{name: "1.3 rejects TLS 1.2", clientMin: tls.VersionTLS13, serverMax: tls.VersionTLS12, wantMin: tls.VersionTLS13, wantFailed: true},
+		{name: "1.3 accepts TLS 1.3", clientMin: tls.VersionTLS13, serverMax: tls.VersionTLS13, wantMin: tls.VersionTLS13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTLSServer(t, tt.serverMax)
+			client, err := NewHTTPClient(&RegistryConfig{BaseURL: server.URL, TLSMinVersion: tt.clientMin})
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			defer client.Close()
+
+			transport := client.client.Transport.(*http.Transport)
+			roots := x509.NewCertPool()
+			roots.AddCert(server.Certificate())
+			transport.TLSClientConfig.RootCAs = roots
+			if got := transport.TLSClientConfig.MinVersion; got != tt.wantMin {
+				t.Errorf("MinVersion = %s, want %s", tls.VersionName(got), tls.VersionName(tt.wantMin))
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if failed := err != nil; failed != tt.wantFailed {
+				t.Errorf("Get = %v, want failure %t", err, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		requests int
+		wantMax  int // upper bound of concurrent requests seen by the server
+		wantMin  int // lower bound, to show the requests really overlap
+	}{
+		{name: "unlimited", limit: 0, requests: 6, wantMax: 6, wantMin: 3},
+		{name: "one connection", limit: 1, requests: 6, wantMax: 1, wantMin: 1},
+		{name: "two connections", limit: 2, requests: 6, wantMax: 2, wantMin: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var inFlight, maxInFlight int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				inFlight++
+				maxInFlight = max(maxInFlight, inFlight)
+				mu.Unlock()
+				time.Sleep(50 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}))
+			defer server.Close()
+
+			client, err := NewHTTPClient(&RegistryConfig{BaseURL: server.URL, MaxConnsPerHost: tt.limit})
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			defer client.Close()
+
+			var wg sync.WaitGroup
+			for range tt.requests {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp, err := client.Get(server.URL)
+					if err != nil {
+						t.Errorf("Get: %v", err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}()
+			}
+			wg.Wait()
+
+			if maxInFlight > tt.wantMax || maxInFlight < tt.wantMin {
+				t.Errorf("server saw %d concurrent requests, want between %d and %d", maxInFlight, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		base    time.Duration
+		max     time.Duration
+		want    time.Duration // delay before jitter, which adds up to 50%
+	}{
+		{name: "no base", attempt: 3, base: 0, max: time.Minute, want: 0},
+		{name: "first retry", attempt: 1, base: time.Second, max: time.Minute, want: time.Second},
+		{name: "second retry doubles", attempt: 2, base: time.Second, max: time.Minute, want: 2 * time.Second},
+		{name: "fourth retry", attempt: 4, base: time.Second, max: time.Minute, want: 8 * time.Second},
+		{name: "capped", attempt: 10, base: time.Second, max: 5 * time.Second, want: 5 * time.Second},
+		{name: "no cap", attempt: 8, base: time.Second, want: 128 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				got := BackoffDelay(tt.attempt, tt.base, tt.max)
+				if got < tt.want || got > tt.want+tt.want/2 {
+					t.Fatalf("BackoffDelay(%d, %v, %v) = %v, want %v plus up to 50%%", tt.attempt, tt.base, tt.max, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "absent", value: ""},
+		{name: "delta seconds", value: "30", want: 30 * time.Second, wantOK: true},
+		{name: "zero seconds", value: "0", want: 0, wantOK: true},
+		{name: "negative seconds", value: "-5"},
+		{name: "HTTP date", value: "Wed, 01 Jan 2025 12:00:45 GMT", want: 45 * time.Second, wantOK: true},
+		{name: "HTTP date in the past", value: "Wed, 01 Jan 2025 11:00:00 GMT", want: 0, wantOK: true},
+		{name: "malformed", value: "soon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseRetryAfter(tt.value, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("ParseRetryAfter(%q) = %v, %t, want %v, %t", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetryAfterHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		min, max   time.Duration
+	}{
+		// without the header the first retry waits the default second
+		{name: "default backoff", retryAfter: "", min: time.Second, max: 2 * time.Second},
+		{name: "retry now", retryAfter: "0", min: 0, max: 500 * time.Millisecond},
+		{name: "past HTTP date", retryAfter: "Wed, 01 Jan 2020 00:00:00 GMT", min: 0, max: 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) == 1 {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewHTTPClient(&RegistryConfig{BaseURL: server.URL, MaxRetries: 1})
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			defer client.Close()
+
+			start := time.Now()
+			resp, err := client.Get(server.URL)
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("status = %d, want 200 after the retry", resp.StatusCode)
+			}
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("retry took %v, want between %v and %v", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryableStatuses(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int // answered in turn, then 200
+		maxRetries   int
+		wantStatus   int
+		wantRequests int32
+	}{
+		{name: "429 twice then 200", statuses: []int{429, 429}, maxRetries: 3, wantStatus: http.StatusOK, wantRequests: 3},
+		{name: "503 then 200", statuses: []int{503}, maxRetries: 3, wantStatus: http.StatusOK, wantRequests: 2},
+		{name: "429 beyond the retries", statuses: []int{429, 429, 429}, maxRetries: 2, wantStatus: http.StatusTooManyRequests, wantRequests: 3},
+		{name: "404 is not retried", statuses: []int{404}, maxRetries: 3, wantStatus: http.StatusNotFound, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if n := int(requests.Add(1)); n <= len(tt.statuses) {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(tt.statuses[n-1])
+				}
+			}))
+			defer server.Close()
+
+			client, err := NewHTTPClient(&RegistryConfig{BaseURL: server.URL, MaxRetries: tt.maxRetries})
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			defer client.Close()
+
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("server got %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestCACertBundle(t *testing.T) {
+	server := newTLSServer(t, tls.VersionTLS13)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		caCert     string
+		insecure   bool
+		wantErr    string // from NewHTTPClient
+		wantFailed bool   // the request fails verification
+	}{
+		{name: "untrusted server", wantFailed: true},
+		{name: "CA bundle trusts the server", caCert: bundle},
+		{name: "insecure skips verification", insecure: true},
+		{name: "missing bundle", caCert: filepath.Join(dir, "missing.pem"), wantErr: "missing.pem"},
+		{name: "bundle without certificates", caCert: empty, wantErr: "no certificates found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(&RegistryConfig{BaseURL: server.URL, CACert: tt.caCert, InsecureTLS: tt.insecure})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewHTTPClient error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			defer client.Close()
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if failed := err != nil; failed != tt.wantFailed {
+				t.Errorf("Get = %v, want failure %t", err, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestRegistryToken(t *testing.T) {
+	const token = "s3cret-token"
+	var mu sync.Mutex
+	seen := make(map[string]string) // path -> Authorization
+	record := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Get("Authorization")
+		mu.Unlock()
+	}
+	other := httptest.NewServer(http.HandlerFunc(record))
+	defer other.Close()
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, other.URL+"/redirected", http.StatusFound)
+			return
+		}
+		record(w, r)
+	}))
+	defer registry.Close()
+
+	client, err := NewHTTPClient(&RegistryConfig{BaseURL: registry.URL, Token: token})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		path string // where the request ends up
+		want string
+	}{
+		{name: "registry host", url: registry.URL + "/v1/providers", path: "/v1/providers", want: "Bearer " + token},
+		{name: "archive on the registry host", url: registry.URL + "/files/archive.zip", path: "/files/archive.zip", want: "Bearer " + token},
+		{name: "archive on another host", url: other.URL + "/archive.zip", path: "/archive.zip"},
+		{name: "redirect to another host", url: registry.URL + "/redirect", path: "/redirected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			resp.Body.Close()
+			mu.Lock()
+			got := seen[tt.path]
+			mu.Unlock()
+			if got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
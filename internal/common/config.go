@@ -1,7 +1,10 @@
 package common
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -23,6 +26,21 @@ func ParseEnvInt(envVar string, defaultValue int) (int, error) {
 	return parsed, nil
 }
 
+// ParseEnvFloat parses a floating point number from environment variable
+func ParseEnvFloat(envVar string, defaultValue float64) (float64, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue, fmt.Errorf("invalid number value for %s: %v", envVar, err)
+	}
+
+	return parsed, nil
+}
+
 // ParseEnvBool parses a boolean from environment variable
 func ParseEnvBool(envVar string, defaultValue bool) (bool, error) {
 	value := strings.ToLower(os.Getenv(envVar))
@@ -47,13 +65,7 @@ func ParseEnvDuration(envVar string, defaultValue time.Duration) (time.Duration,
 		return defaultValue, nil
 	}
 
-	// Try to parse as hours first (for backward compatibility)
-	if hours, err := strconv.Atoi(value); err == nil {
-		return time.Duration(hours) * time.Hour, nil
-	}
-
-	// Try to parse as duration string
-	duration, err := time.ParseDuration(value)
+	duration, err := ParseHoursOrDuration(value)
 	if err != nil {
 		return defaultValue, fmt.Errorf("invalid duration value for %s: %v", envVar, err)
 	}
@@ -61,6 +73,15 @@ func ParseEnvDuration(envVar string, defaultValue time.Duration) (time.Duration,
 	return duration, nil
 }
 
+// ParseHoursOrDuration parses a Go duration string such as "30m" or "2h30m";
+// a bare integer is a number of hours, for backward compatibility
+func ParseHoursOrDuration(value string) (time.Duration, error) {
+	if hours, err := strconv.Atoi(value); err == nil {
+		return time.Duration(hours) * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
 // GetEnvWithDefault returns environment variable value or default if not set
 func GetEnvWithDefault(envVar, defaultValue string) string {
 	if value := os.Getenv(envVar); value != "" {
@@ -68,3 +89,56 @@ func GetEnvWithDefault(envVar, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// ParseTLSVersion parses a minimum TLS version, "1.2" or "1.3". Older
+// versions are rejected here so every flag using it accepts the same values.
+func ParseTLSVersion(value string) (uint16, error) {
+	switch strings.TrimSpace(value) {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version '%s', expected 1.2 or 1.3", value)
+	}
+}
+
+// RegistryHostname returns the hostname of a registry URL such as
+// https://registry.terraform.io. It names the top-level directory of the
+// on-disk layout and the host part of provider source addresses.
+func RegistryHostname(registryURL string) (string, error) {
+	u, err := url.Parse(registryURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid registry URL '%s': %w", registryURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("invalid registry URL '%s', expected e.g. 'https://registry.terraform.io'", registryURL)
+	}
+	return strings.ToLower(u.Hostname()), nil
+}
+
+// ParseCIDRList parses a comma-separated list of CIDRs. Bare IP addresses are
+// accepted as single-host ranges.
+func ParseCIDRList(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR '%s': %w", item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s': %w", item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
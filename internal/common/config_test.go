@@ -0,0 +1,127 @@
+package common
+
+import (
+	"crypto/tls"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseCIDRList(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "10.0.0.0/8", want: []string{"10.0.0.0/8"}},
+		{value: " 10.1.2.3/8 , 192.0.2.1 ", want: []string{"10.0.0.0/8", "192.0.2.1/32"}},
+		{value: "2001:db8::1", want: []string{"2001:db8::1/128"}},
+		{value: "10.0.0.0/33", wantErr: true},
+		{value: "not-an-ip", wantErr: true},
+		{value: "10.0.0.0/8,bad", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			prefixes, err := ParseCIDRList(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCIDRList(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			got := make([]string, 0, len(prefixes))
+			for _, prefix := range prefixes {
+				got = append(got, prefix.String())
+			}
+			if len(got) == 0 {
+				got = nil
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseCIDRList(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHoursOrDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "24", want: 24 * time.Hour},
+		{value: "1", want: time.Hour},
+		{value: "0", want: 0},
+		{value: "30m", want: 30 * time.Minute},
+		{value: "90m", want: 90 * time.Minute},
+		{value: "2h30m", want: 2*time.Hour + 30*time.Minute},
+		{value: "1.5h", want: 90 * time.Minute},
+		{value: "-1h", want: -time.Hour},
+		{value: "", wantErr: true},
+		{value: "abc", wantErr: true},
+		{value: "24 hours", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseHoursOrDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHoursOrDuration(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseHoursOrDuration(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnvDuration(t *testing.T) {
+	const fallback = 24 * time.Hour
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "unset", want: fallback},
+		{name: "hours", value: "6", want: 6 * time.Hour},
+		{name: "duration", value: "45m", want: 45 * time.Minute},
+		{name: "invalid", value: "soon", want: fallback, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CHECK_PERIOD", tt.value)
+			got, err := ParseEnvDuration("CHECK_PERIOD", fallback)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEnvDuration error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseEnvDuration = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    uint16
+		wantErr bool
+	}{
+		{value: "1.2", want: tls.VersionTLS12},
+		{value: "1.3", want: tls.VersionTLS13},
+		{value: " 1.3 ", want: tls.VersionTLS13},
+		{value: "1.0", wantErr: true},
+		{value: "1.1", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "TLS1.2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseTLSVersion(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTLSVersion(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseTLSVersion(%q) = %#x, want %#x", tt.value, got, tt.want)
+			}
+		})
+	}
+}
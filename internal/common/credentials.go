@@ -0,0 +1,124 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	credentialsBlockRe = regexp.MustCompile(`(?s)credentials\s+"([^"]+)"\s*\{([^}]*)\}`)
+	credentialsTokenRe = regexp.MustCompile(`token\s*=\s*"([^"]*)"`)
+)
+
+// LookupCredentials finds a registry token for host the way the Terraform CLI
+// does: a TF_TOKEN_<host> environment variable, then a credentials block in the
+// CLI config file (TF_CLI_CONFIG_FILE or ~/.terraformrc), then
+// ~/.terraform.d/credentials.tfrc.json. It returns the token and where it came
+// from, or an empty token if none is configured.
+func LookupCredentials(host string) (token, source string, err error) {
+	host = strings.ToLower(host)
+
+	name := credentialsEnvName(host)
+	if token := os.Getenv(name); token != "" {
+		return token, name, nil
+	}
+
+	configDir, rcFile := terraformConfigPaths()
+	if path := os.Getenv("TF_CLI_CONFIG_FILE"); path != "" {
+		rcFile = path
+	}
+	for _, candidate := range []struct {
+		path  string
+		parse func([]byte) (map[string]string, error)
+	}{
+		{rcFile, parseCLIConfigCredentials},
+		{filepath.Join(configDir, "credentials.tfrc.json"), parseCredentialsJSON},
+	} {
+		if candidate.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(candidate.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %w", candidate.path, err)
+		}
+		tokens, err := candidate.parse(data)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to parse %s: %w", candidate.path, err)
+		}
+		if token := tokens[host]; token != "" {
+			return token, candidate.path, nil
+		}
+	}
+	return "", "", nil
+}
+
+// credentialsEnvName returns the TF_TOKEN_ variable for host: dots become
+// underscores and dashes double underscores, as in the Terraform CLI
+func credentialsEnvName(host string) string {
+	name := strings.ReplaceAll(host, "-", "__")
+	return "TF_TOKEN_" + strings.ReplaceAll(name, ".", "_")
+}
+
+// terraformConfigPaths returns the Terraform CLI config directory and the
+// default CLI config file for the current platform
+func terraformConfigPaths() (configDir, rcFile string) {
+	if runtime.GOOS == "windows" {
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			return "", ""
+		}
+		return filepath.Join(appData, "terraform.d"), filepath.Join(appData, "terraform.rc")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", ""
+	}
+	return filepath.Join(home, ".terraform.d"), filepath.Join(home, ".terraformrc")
+}
+
+// parseCLIConfigCredentials extracts `credentials "host" { token = "..." }`
+// blocks from a CLI config file. Only this subset of HCL is understood.
+func parseCLIConfigCredentials(data []byte) (map[string]string, error) {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "//") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	tokens := make(map[string]string)
+	for _, block := range credentialsBlockRe.FindAllStringSubmatch(strings.Join(lines, "\n"), -1) {
+		if m := credentialsTokenRe.FindStringSubmatch(block[2]); m != nil {
+			tokens[strings.ToLower(block[1])] = m[1]
+		}
+	}
+	return tokens, nil
+}
+
+// parseCredentialsJSON reads credentials.tfrc.json as written by `terraform login`
+func parseCredentialsJSON(data []byte) (map[string]string, error) {
+	var file struct {
+		Credentials map[string]struct {
+			Token string `json:"token"`
+		} `json:"credentials"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	tokens := make(map[string]string, len(file.Credentials))
+	for host, creds := range file.Credentials {
+		tokens[strings.ToLower(host)] = creds.Token
+	}
+	return tokens, nil
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCredentialsFiles(t *testing.T) {
+	tests := []struct {
+		fixture string
+		parse   func([]byte) (map[string]string, error)
+		want    map[string]string
+	}{
+		{
+			fixture: "terraformrc",
+			parse:   parseCLIConfigCredentials,
+			want:    map[string]string{"app.terraform.io": "rc-app-token", "registry.example.com": "rc-example-token"},
+		},
+		{
+			fixture: "credentials.tfrc.json",
+			parse:   parseCredentialsJSON,
+			want:    map[string]string{"app.terraform.io": "json-app-token", "tfe.corp.example": "json-tfe-token"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.parse(data)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("tokens = %v, want %v", got, tt.want)
+			}
+			for host, token := range tt.want {
+				if got[host] != token {
+					t.Errorf("token for %s = %q, want %q", host, got[host], token)
+				}
+			}
+		})
+	}
+}
+
+func TestLookupCredentials(t *testing.T) {
+	rcFile, err := filepath.Abs(filepath.Join("testdata", "terraformrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsJSON, err := os.ReadFile(filepath.Join("testdata", "credentials.tfrc.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		host       string
+		env        map[string]string
+		rcFile     bool // TF_CLI_CONFIG_FILE points at testdata/terraformrc
+		want       string
+		wantSource string // "env", "rc" or "json"
+	}{
+		{name: "no credentials", host: "registry.terraform.io"},
+		{name: "environment variable", host: "app.terraform.io", env: map[string]string{"TF_TOKEN_app_terraform_io": "env-token"}, rcFile: true, want: "env-token", wantSource: "env"},
+		{name: "dashes in the host", host: "my-registry.example.com", env: map[string]string{"TF_TOKEN_my__registry_example_com": "env-token"}, want: "env-token", wantSource: "env"},
+		{name: "CLI config before credentials.tfrc.json", host: "app.terraform.io", rcFile: true, want: "rc-app-token", wantSource: "rc"},
+		{name: "host matched case-insensitively", host: "REGISTRY.example.com", rcFile: true, want: "rc-example-token", wantSource: "rc"},
+		{name: "credentials.tfrc.json", host: "tfe.corp.example", rcFile: true, want: "json-tfe-token", wantSource: "json"},
+		{name: "credentials.tfrc.json without a CLI config", host: "app.terraform.io", want: "json-app-token", wantSource: "json"},
+		{name: "block without a token", host: "no-token.example.com", rcFile: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			t.Setenv("HOME", home)
+			credentialsFile := filepath.Join(home, ".terraform.d", "credentials.tfrc.json")
+			if err := os.MkdirAll(filepath.Dir(credentialsFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(credentialsFile, credentialsJSON, 0600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("TF_CLI_CONFIG_FILE", "")
+			if tt.rcFile {
+				t.Setenv("TF_CLI_CONFIG_FILE", rcFile)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			token, source, err := LookupCredentials(tt.host)
+			if err != nil {
+				t.Fatalf("LookupCredentials(%q): %v", tt.host, err)
+			}
+			if token != tt.want {
+				t.Errorf("token = %q, want %q", token, tt.want)
+			}
+			wantSource := map[string]string{
+				"":     "",
+				"env":  credentialsEnvName(tt.host),
+				"rc":   rcFile,
+				"json": credentialsFile,
+			}[tt.wantSource]
+			if source != wantSource {
+				t.Errorf("source = %q, want %q", source, wantSource)
+			}
+		})
+	}
+}
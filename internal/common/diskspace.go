@@ -0,0 +1,38 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDiskSpaceUnsupported is returned by DiskSpace on platforms where free
+// space cannot be queried
+var ErrDiskSpaceUnsupported = errors.New("disk space query not supported on this platform")
+
+// DiskStats describes the filesystem holding a path
+type DiskStats struct {
+	Total uint64 // size of the filesystem in bytes
+	Free  uint64 // bytes available to unprivileged users
+}
+
+// Used returns the bytes in use, counting space reserved for root as used
+func (d DiskStats) Used() uint64 {
+	if d.Free > d.Total {
+		return 0
+	}
+	return d.Total - d.Free
+}
+
+// FormatBytes renders a byte count with a binary unit, e.g. 12.3 MiB
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package common
+
+// DiskSpace is not available on this platform
+func DiskSpace(path string) (DiskStats, error) {
+	return DiskStats{}, ErrDiskSpaceUnsupported
+}
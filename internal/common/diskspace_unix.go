@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd
+
+package common
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// DiskSpace reports the size and free space of the filesystem holding path
+func DiskSpace(path string) (DiskStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskStats{}, fmt.Errorf("failed to stat filesystem of %s: %w", path, err)
+	}
+	return DiskStats{
+		Total: uint64(st.Blocks) * uint64(st.Bsize),
+		Free:  uint64(st.Bavail) * uint64(st.Bsize),
+	}, nil
+}
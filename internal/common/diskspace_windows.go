@@ -0,0 +1,22 @@
+//go:build windows
+
+package common
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// DiskSpace reports the size and free space of the volume holding path
+func DiskSpace(path string) (DiskStats, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskStats{}, err
+	}
+	var free, total, totalFree uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &free, &total, &totalFree); err != nil {
+		return DiskStats{}, fmt.Errorf("failed to stat volume of %s: %w", path, err)
+	}
+	return DiskStats{Total: total, Free: free}, nil
+}
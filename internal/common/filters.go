@@ -2,6 +2,9 @@ package common
 
 import (
 	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/blang/semver/v4"
@@ -12,26 +15,64 @@ type ProviderFilterItem struct {
 	Namespace  string
 	Name       string
 	MinVersion string // "" если не указана
+	MaxVersion string // "" если не указана (исключающая граница)
 }
 
-// ProviderFilter represents a filter for providers
+// FilterPrecedence controls how conflicting provider filter rules are resolved
+type FilterPrecedence string
+
+const (
+	// PrecedenceExclude makes an exclude rule win over any include rule (default)
+	PrecedenceExclude FilterPrecedence = "exclude"
+	// PrecedenceInclude makes an explicit include rule win over an exclude rule
+	PrecedenceInclude FilterPrecedence = "include"
+)
+
+// ParseFilterPrecedence parses a precedence name, defaulting to PrecedenceExclude
+func ParseFilterPrecedence(value string) (FilterPrecedence, error) {
+	switch FilterPrecedence(strings.ToLower(strings.TrimSpace(value))) {
+	case "", PrecedenceExclude:
+		return PrecedenceExclude, nil
+	case PrecedenceInclude:
+		return PrecedenceInclude, nil
+	default:
+		return "", fmt.Errorf("invalid filter precedence '%s', expected 'exclude' or 'include'", value)
+	}
+}
+
+// ProviderFilter represents a filter for providers.
+//
+// Entries prefixed with "!" are excludes; an exclude may name a whole
+// namespace with "!namespace/*". Rules are evaluated as follows:
+//  1. a provider matched by an exclude is dropped, unless precedence is
+//     "include" and the provider is also matched by an include at least as
+//     specific (an exact rule is more specific than a namespace wildcard, so
+//     "hashicorp/*,!hashicorp/aws" still drops hashicorp/aws);
+//  2. if any includes are configured, the provider must match one of them;
+//  3. otherwise the provider is kept.
 type ProviderFilter struct {
-	providers map[string]ProviderFilterItem
-	enabled   bool
+	providers  map[string]ProviderFilterItem
+	excludes   map[string]struct{}
+	precedence FilterPrecedence
+	enabled    bool
 }
 
 // PlatformFilter represents a filter for platforms
 type PlatformFilter struct {
 	platforms map[string]bool
+	extra     []Platform // platforms outside KnownPlatforms forced with "+"
 	enabled   bool
 }
 
 // NewProviderFilter creates a new provider filter from comma-separated string
-// Supports format: namespace/name>version
+// Supports format: namespace/name>min<max, namespace/*>min<max and !namespace/name.
+// Both bounds are optional; min is inclusive, max is exclusive.
 func NewProviderFilter(filterString string) (*ProviderFilter, error) {
 	filter := &ProviderFilter{
-		providers: make(map[string]ProviderFilterItem),
-		enabled:   false,
+		providers:  make(map[string]ProviderFilterItem),
+		excludes:   make(map[string]struct{}),
+		precedence: PrecedenceExclude,
+		enabled:    false,
 	}
 
 	if filterString == "" {
@@ -44,21 +85,29 @@ func NewProviderFilter(filterString string) (*ProviderFilter, error) {
 		if entry == "" {
 			continue
 		}
-		parts := strings.Split(entry, ">")
-		provider := parts[0]
-		minVersion := ""
-		if len(parts) > 1 {
-			minVersion = strings.TrimSpace(parts[1])
+		if strings.HasPrefix(entry, "!") {
+			excluded := strings.TrimSpace(strings.TrimPrefix(entry, "!"))
+			nsName := strings.Split(excluded, "/")
+			if len(nsName) != 2 || nsName[0] == "" || nsName[1] == "" || nsName[0] == "*" || strings.Contains(excluded, ">") {
+				return nil, fmt.Errorf("invalid exclude format '%s', expected '!namespace/name' or '!namespace/*'", entry)
+			}
+			filter.excludes[excluded] = struct{}{}
+			continue
+		}
+		provider, minVersion, maxVersion, err := ParseVersionRange(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider filter '%s': %w", entry, err)
 		}
 		nsName := strings.Split(provider, "/")
-		if len(nsName) != 2 || nsName[0] == "" || nsName[1] == "" {
-			return nil, fmt.Errorf("invalid provider format '%s', expected 'namespace/name' or 'namespace/name>version'", entry)
+		if len(nsName) != 2 || nsName[0] == "" || nsName[1] == "" || nsName[0] == "*" {
+			return nil, fmt.Errorf("invalid provider format '%s', expected 'namespace/name', 'namespace/*' or 'namespace/name>min<max'", entry)
 		}
 		key := fmt.Sprintf("%s/%s", nsName[0], nsName[1])
 		filter.providers[key] = ProviderFilterItem{
 			Namespace:  nsName[0],
 			Name:       nsName[1],
 			MinVersion: minVersion,
+			MaxVersion: maxVersion,
 		}
 		filter.enabled = true
 	}
@@ -66,7 +115,9 @@ func NewProviderFilter(filterString string) (*ProviderFilter, error) {
 	return filter, nil
 }
 
-// NewPlatformFilter creates a new platform filter from comma-separated string
+// NewPlatformFilter creates a new platform filter from comma-separated string.
+// Entries are normalized ("Linux/AMD64" becomes "linux_amd64") and must be one
+// of KnownPlatforms; a "+" prefix accepts any other os_arch as is.
 func NewPlatformFilter(filterString string) (*PlatformFilter, error) {
 	filter := &PlatformFilter{
 		platforms: make(map[string]bool),
@@ -84,10 +135,24 @@ func NewPlatformFilter(filterString string) (*PlatformFilter, error) {
 			continue
 		}
 
+		forced := strings.HasPrefix(platform, "+")
+		entry := platform
+		platform = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(platform, "+")))
+		platform = strings.ReplaceAll(platform, "/", "_")
+
 		// Validate platform format (os_arch)
 		parts := strings.Split(platform, "_")
 		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-			return nil, fmt.Errorf("invalid platform format '%s', expected 'os_arch'", platform)
+			return nil, fmt.Errorf("invalid platform format '%s', expected 'os_arch'", entry)
+		}
+
+		if !isSupportedPlatform(platform) {
+			if !forced {
+				return nil, fmt.Errorf("unknown platform '%s', did you mean '%s'? Prefix it with '+' to use it anyway", entry, closestPlatform(platform))
+			}
+			if !filter.platforms[platform] {
+				filter.extra = append(filter.extra, Platform{OS: parts[0], Arch: parts[1]})
+			}
 		}
 
 		filter.platforms[platform] = true
@@ -97,6 +162,85 @@ func NewPlatformFilter(filterString string) (*PlatformFilter, error) {
 	return filter, nil
 }
 
+// AutoPlatformFilter returns the --platform-auto selection: the host platform
+// plus linux_amd64, the usual CI runner. A host platform outside KnownPlatforms
+// is forced with "+".
+func AutoPlatformFilter(goos, goarch string) string {
+	host := goos + "_" + goarch
+	if host == "linux_amd64" {
+		return host
+	}
+	if !isSupportedPlatform(host) {
+		host = "+" + host
+	}
+	return host + ",linux_amd64"
+}
+
+// Extra returns the platforms forced with "+" that are not in KnownPlatforms
+func (f *PlatformFilter) Extra() []Platform {
+	return f.extra
+}
+
+// Select returns the platforms to mirror for one upstream: defaults when the
+// filter is disabled, otherwise every known platform the filter selects plus
+// the ones forced with "+". Providers and binaries thus follow the same rules.
+func (f *PlatformFilter) Select(defaults []Platform) []Platform {
+	if f == nil || !f.enabled {
+		return defaults
+	}
+	var platforms []Platform
+	for _, p := range KnownPlatforms {
+		if f.ShouldInclude(p.OS, p.Arch) {
+			platforms = append(platforms, Platform{OS: p.OS, Arch: p.Arch})
+		}
+	}
+	return append(platforms, f.extra...)
+}
+
+// isSupportedPlatform reports whether os_arch is one of KnownPlatforms
+func isSupportedPlatform(platform string) bool {
+	for _, p := range KnownPlatforms {
+		if p.OS+"_"+p.Arch == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// closestPlatform returns the supported platform with the smallest edit
+// distance to platform, for "did you mean" hints
+func closestPlatform(platform string) string {
+	best, bestDistance := "", -1
+	for _, p := range KnownPlatforms {
+		candidate := p.OS + "_" + p.Arch
+		if d := editDistance(platform, candidate); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
 // IsEnabled returns true if the filter is enabled (has filters configured)
 func (f *ProviderFilter) IsEnabled() bool {
 	return f.enabled
@@ -107,27 +251,108 @@ func (f *PlatformFilter) IsEnabled() bool {
 	return f.enabled
 }
 
+// SetPrecedence sets how conflicting include and exclude rules are resolved
+func (f *ProviderFilter) SetPrecedence(precedence FilterPrecedence) {
+	f.precedence = precedence
+}
+
+// HasExcludes returns true if any exclude rules are configured
+func (f *ProviderFilter) HasExcludes() bool {
+	return len(f.excludes) > 0
+}
+
 // ShouldInclude returns true if the provider should be included (by name only)
 func (f *ProviderFilter) ShouldInclude(namespace, name string) bool {
+	included := f.includeMatch(namespace, name)
+
+	if excluded := f.excludeMatch(namespace, name); excluded != noMatch {
+		if !(f.precedence == PrecedenceInclude && included >= excluded) {
+			return false
+		}
+	}
+
 	if !f.enabled {
-		return true // No filter means include all
+		return true // No include rules means include all
+	}
+	return included != noMatch
+}
+
+// ruleMatch is how specifically a filter rule matches a provider
+type ruleMatch int
+
+const (
+	noMatch       ruleMatch = iota
+	wildcardMatch           // namespace/*
+	exactMatch              // namespace/name
+)
+
+// excludeMatch reports how specifically an exclude rule matches the provider
+func (f *ProviderFilter) excludeMatch(namespace, name string) ruleMatch {
+	if _, ok := f.excludes[namespace+"/"+name]; ok {
+		return exactMatch
 	}
-	provider := fmt.Sprintf("%s/%s", namespace, name)
-	_, ok := f.providers[provider]
+	if _, ok := f.excludes[namespace+"/*"]; ok {
+		return wildcardMatch
+	}
+	return noMatch
+}
+
+// includeMatch reports how specifically an include rule matches the provider
+func (f *ProviderFilter) includeMatch(namespace, name string) ruleMatch {
+	if _, ok := f.providers[namespace+"/"+name]; ok {
+		return exactMatch
+	}
+	if f.MatchesNamespace(namespace) {
+		return wildcardMatch
+	}
+	return noMatch
+}
+
+// MatchesNamespace returns true if a "namespace/*" include covers the namespace
+func (f *ProviderFilter) MatchesNamespace(namespace string) bool {
+	_, ok := f.providers[namespace+"/*"]
 	return ok
 }
 
+// HasWildcards returns true if any include rule is a "namespace/*" wildcard.
+// Wildcards can only be resolved against the full registry provider list.
+func (f *ProviderFilter) HasWildcards() bool {
+	for _, item := range f.providers {
+		if item.Name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 // GetMinVersion returns the minVersion for a provider, or "" if not set
 func (f *ProviderFilter) GetMinVersion(namespace, name string) string {
-	if !f.enabled {
+	item, ok := f.lookup(namespace, name)
+	if !ok {
 		return ""
 	}
-	provider := fmt.Sprintf("%s/%s", namespace, name)
-	item, ok := f.providers[provider]
+	return item.MinVersion
+}
+
+// GetMaxVersion returns the exclusive maxVersion for a provider, or "" if not set
+func (f *ProviderFilter) GetMaxVersion(namespace, name string) string {
+	item, ok := f.lookup(namespace, name)
 	if !ok {
 		return ""
 	}
-	return item.MinVersion
+	return item.MaxVersion
+}
+
+// lookup returns the include rule for a provider, falling back to a namespace wildcard
+func (f *ProviderFilter) lookup(namespace, name string) (ProviderFilterItem, bool) {
+	if !f.enabled {
+		return ProviderFilterItem{}, false
+	}
+	if item, ok := f.providers[fmt.Sprintf("%s/%s", namespace, name)]; ok {
+		return item, true
+	}
+	item, ok := f.providers[namespace+"/*"]
+	return item, ok
 }
 
 // ShouldInclude returns true if the platform should be included
@@ -179,12 +404,26 @@ func (f *PlatformFilter) GetPlatforms() []string {
 
 // String returns a string representation of the provider filter
 func (f *ProviderFilter) String() string {
-	if !f.enabled {
-		return "all providers"
+	var parts []string
+	if f.enabled {
+		parts = append(parts, f.GetProviders()...)
+	} else {
+		parts = append(parts, "all providers")
 	}
+	for _, exclude := range f.GetExcludes() {
+		parts = append(parts, "!"+exclude)
+	}
+	return strings.Join(parts, ", ")
+}
 
-	providers := f.GetProviders()
-	return strings.Join(providers, ", ")
+// GetExcludes returns the list of excluded provider patterns
+func (f *ProviderFilter) GetExcludes() []string {
+	excludes := make([]string, 0, len(f.excludes))
+	for exclude := range f.excludes {
+		excludes = append(excludes, exclude)
+	}
+	sort.Strings(excludes)
+	return excludes
 }
 
 // String returns a string representation of the platform filter
@@ -197,9 +436,9 @@ func (f *PlatformFilter) String() string {
 	return strings.Join(platforms, ", ")
 }
 
-// Count returns the number of providers in the filter
+// Count returns the number of provider rules (includes and excludes) in the filter
 func (f *ProviderFilter) Count() int {
-	return len(f.providers)
+	return len(f.providers) + len(f.excludes)
 }
 
 // FilterVersionsByMin returns only versions >= minVersion (semver), or all if minVersion is ""
@@ -225,7 +464,243 @@ func FilterVersionsByMin(versions []string, minVersion string) []string {
 	return filtered
 }
 
+// FilterVersionsByRange returns only versions >= minVersion and < maxVersion (semver).
+// An empty bound is not applied, so an empty maxVersion behaves like FilterVersionsByMin.
+func FilterVersionsByRange(versions []string, minVersion, maxVersion string) []string {
+	filtered := FilterVersionsByMin(versions, minVersion)
+	if maxVersion == "" {
+		return filtered
+	}
+	maxVer, err := semver.ParseTolerant(maxVersion)
+	if err != nil {
+		return filtered
+	}
+	var result []string
+	for _, v := range filtered {
+		ver, err := semver.ParseTolerant(v)
+		if err != nil {
+			continue
+		}
+		if ver.LT(maxVer) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// FilterStableVersions drops prerelease versions such as 5.0.0-beta1 or
+// 1.6.0-rc2. Unparsable versions are kept.
+func FilterStableVersions(versions []string) []string {
+	var stable []string
+	for _, v := range versions {
+		ver, err := semver.ParseTolerant(v)
+		if err == nil && len(ver.Pre) > 0 {
+			continue
+		}
+		stable = append(stable, v)
+	}
+	return stable
+}
+
+// KeepLatestVersions returns the newest n versions (semver), newest first.
+// Unparsable versions are dropped; n <= 0 returns versions unchanged.
+func KeepLatestVersions(versions []string, n int) []string {
+	if n <= 0 {
+		return versions
+	}
+	type parsed struct {
+		raw string
+		ver semver.Version
+	}
+	var list []parsed
+	for _, v := range versions {
+		ver, err := semver.ParseTolerant(v)
+		if err != nil {
+			continue
+		}
+		list = append(list, parsed{raw: v, ver: ver})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ver.GT(list[j].ver)
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	latest := make([]string, 0, len(list))
+	for _, p := range list {
+		latest = append(latest, p.raw)
+	}
+	return latest
+}
+
+// ModuleFilterItem stores filter info for a module
+type ModuleFilterItem struct {
+	Namespace  string
+	Name       string
+	System     string
+	MinVersion string // "" если не указана
+	MaxVersion string // "" если не указана (исключающая граница)
+}
+
+// ParseModuleFilter parses a comma-separated list of namespace/name/system
+// entries, each with an optional >min<max version range like provider filters
+func ParseModuleFilter(filterString string) ([]ModuleFilterItem, error) {
+	var modules []ModuleFilterItem
+	for _, entry := range strings.Split(filterString, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		module, minVersion, maxVersion, err := ParseVersionRange(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid module filter '%s': %w", entry, err)
+		}
+		parts := strings.Split(module, "/")
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" || strings.Contains(module, "*") {
+			return nil, fmt.Errorf("invalid module format '%s', expected 'namespace/name/system' or 'namespace/name/system>min<max'", entry)
+		}
+		modules = append(modules, ModuleFilterItem{
+			Namespace:  parts[0],
+			Name:       parts[1],
+			System:     parts[2],
+			MinVersion: minVersion,
+			MaxVersion: maxVersion,
+		})
+	}
+	return modules, nil
+}
+
+// ParseVersionRange splits an entry like "name>1.0.0<2.0.0" into the name and
+// its optional inclusive minimum and exclusive maximum versions
+func ParseVersionRange(entry string) (name, minVersion, maxVersion string, err error) {
+	rest := entry
+	if i := strings.Index(rest, "<"); i >= 0 {
+		maxVersion = strings.TrimSpace(rest[i+1:])
+		rest = rest[:i]
+		if maxVersion == "" || strings.ContainsAny(maxVersion, "<>") {
+			return "", "", "", fmt.Errorf("malformed max version in '%s', expected 'name>min<max'", entry)
+		}
+	}
+	if i := strings.Index(rest, ">"); i >= 0 {
+		minVersion = strings.TrimSpace(rest[i+1:])
+		rest = rest[:i]
+		if minVersion == "" || strings.Contains(minVersion, ">") {
+			return "", "", "", fmt.Errorf("malformed min version in '%s', expected 'name>min<max'", entry)
+		}
+	}
+	name = strings.TrimSpace(rest)
+
+	var minVer, maxVer semver.Version
+	if minVersion != "" {
+		if minVer, err = semver.ParseTolerant(minVersion); err != nil {
+			return "", "", "", fmt.Errorf("invalid min version '%s': %w", minVersion, err)
+		}
+	}
+	if maxVersion != "" {
+		if maxVer, err = semver.ParseTolerant(maxVersion); err != nil {
+			return "", "", "", fmt.Errorf("invalid max version '%s': %w", maxVersion, err)
+		}
+		if minVersion != "" && !minVer.LT(maxVer) {
+			return "", "", "", fmt.Errorf("empty version range in '%s': min %s is not below max %s", entry, minVersion, maxVersion)
+		}
+	}
+	return name, minVersion, maxVersion, nil
+}
+
 // Count returns the number of platforms in the filter
 func (f *PlatformFilter) Count() int {
 	return len(f.platforms)
 }
+
+// ProviderSampler deterministically selects a subset of providers
+type ProviderSampler struct {
+	percent float64
+	count   int
+	enabled bool
+}
+
+// NewProviderSampler parses a sample spec: "N%" keeps roughly N percent of
+// providers, a plain integer "N" keeps exactly N providers
+func NewProviderSampler(spec string) (*ProviderSampler, error) {
+	sampler := &ProviderSampler{}
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return sampler, nil
+	}
+
+	if strings.HasSuffix(spec, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid sample percentage '%s', expected a value in (0, 100]", spec)
+		}
+		sampler.percent = percent
+	} else {
+		count, err := strconv.Atoi(spec)
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid sample count '%s', expected a positive integer or N%%", spec)
+		}
+		sampler.count = count
+	}
+	sampler.enabled = true
+
+	return sampler, nil
+}
+
+// IsEnabled returns true if sampling is configured
+func (p *ProviderSampler) IsEnabled() bool {
+	return p.enabled
+}
+
+// Sample returns a stable subset of providers. Selection is based on a hash
+// of namespace/name, so the same provider is picked across runs regardless
+// of the order the registry returns them in.
+func (p *ProviderSampler) Sample(providers []ProviderListItem) []ProviderListItem {
+	if !p.enabled {
+		return providers
+	}
+
+	type scored struct {
+		item ProviderListItem
+		hash uint64
+	}
+	all := make([]scored, 0, len(providers))
+	for _, provider := range providers {
+		h := fnv.New64a()
+		h.Write([]byte(provider.Namespace + "/" + provider.Name))
+		all = append(all, scored{item: provider, hash: h.Sum64()})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].hash != all[j].hash {
+			return all[i].hash < all[j].hash
+		}
+		return all[i].item.Namespace+"/"+all[i].item.Name < all[j].item.Namespace+"/"+all[j].item.Name
+	})
+
+	n := p.count
+	if p.percent > 0 {
+		n = int(float64(len(all))*p.percent/100 + 0.5)
+		if n == 0 && len(all) > 0 {
+			n = 1
+		}
+	}
+	if n > len(all) {
+		n = len(all)
+	}
+
+	sampled := make([]ProviderListItem, 0, n)
+	for _, s := range all[:n] {
+		sampled = append(sampled, s.item)
+	}
+	return sampled
+}
+
+// String returns a string representation of the sampler
+func (p *ProviderSampler) String() string {
+	if !p.enabled {
+		return "disabled"
+	}
+	if p.percent > 0 {
+		return strconv.FormatFloat(p.percent, 'f', -1, 64) + "%"
+	}
+	return strconv.Itoa(p.count) + " providers"
+}
@@ -0,0 +1,412 @@
+package common
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProviderFilterPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     string
+		excludes   []string
+		precedence FilterPrecedence
+		provider   string
+		want       bool
+	}{
+		{name: "no rules", provider: "hashicorp/aws", want: true},
+		{name: "include", filter: "hashicorp/aws", provider: "hashicorp/aws", want: true},
+		{name: "not included", filter: "hashicorp/aws", provider: "hashicorp/google", want: false},
+		{name: "exclude only", excludes: []string{"hashicorp/aws"}, provider: "hashicorp/aws", want: false},
+		{name: "exclude only keeps the rest", excludes: []string{"hashicorp/aws"}, provider: "hashicorp/google", want: true},
+		{name: "include and exclude, exclude precedence", filter: "hashicorp/aws", excludes: []string{"hashicorp/aws"}, provider: "hashicorp/aws", want: false},
+		{name: "include and exclude, include precedence", filter: "hashicorp/aws", excludes: []string{"hashicorp/aws"}, precedence: PrecedenceInclude, provider: "hashicorp/aws", want: true},
+		{name: "include precedence without an include", filter: "hashicorp/google", excludes: []string{"hashicorp/aws"}, precedence: PrecedenceInclude, provider: "hashicorp/aws", want: false},
+		{name: "include precedence, exclude only", excludes: []string{"hashicorp/aws"}, precedence: PrecedenceInclude, provider: "hashicorp/aws", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewProviderFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("NewProviderFilter(%q): %v", tt.filter, err)
+			}
+			for _, exclude := range tt.excludes {
+				filter.excludes[exclude] = struct{}{}
+			}
+			if tt.precedence != "" {
+				filter.SetPrecedence(tt.precedence)
+			}
+			namespace, name, _ := strings.Cut(tt.provider, "/")
+			if got := filter.ShouldInclude(namespace, name); got != tt.want {
+				t.Errorf("ShouldInclude(%s) = %t, want %t", tt.provider, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderFilterMixedEntries(t *testing.T) {
+	filter, err := NewProviderFilter("hashicorp/*,integrations/github>5.0.0,hashicorp/aws>4.0.0")
+	if err != nil {
+		t.Fatalf("NewProviderFilter: %v", err)
+	}
+	if !filter.HasWildcards() {
+		t.Errorf("HasWildcards() = false, want true")
+	}
+
+	tests := []struct {
+		provider       string
+		wantNamespace  bool
+		wantInclude    bool
+		wantMinVersion string
+	}{
+		{provider: "hashicorp/aws", wantNamespace: true, wantInclude: true, wantMinVersion: "4.0.0"},
+		{provider: "hashicorp/google", wantNamespace: true, wantInclude: true},
+		{provider: "integrations/github", wantNamespace: false, wantInclude: true, wantMinVersion: "5.0.0"},
+		{provider: "integrations/gitlab", wantNamespace: false, wantInclude: false},
+		{provider: "cloudflare/cloudflare", wantNamespace: false, wantInclude: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			namespace, name, _ := strings.Cut(tt.provider, "/")
+			if got := filter.MatchesNamespace(namespace); got != tt.wantNamespace {
+				t.Errorf("MatchesNamespace(%s) = %t, want %t", namespace, got, tt.wantNamespace)
+			}
+			if got := filter.ShouldInclude(namespace, name); got != tt.wantInclude {
+				t.Errorf("ShouldInclude(%s) = %t, want %t", tt.provider, got, tt.wantInclude)
+			}
+			if got := filter.GetMinVersion(namespace, name); got != tt.wantMinVersion {
+				t.Errorf("GetMinVersion(%s) = %q, want %q", tt.provider, got, tt.wantMinVersion)
+			}
+		})
+	}
+}
+
+func TestParseFilterPrecedence(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    FilterPrecedence
+		wantErr bool
+	}{
+		{value: "", want: PrecedenceExclude},
+		{value: "exclude", want: PrecedenceExclude},
+		{value: " Include ", want: PrecedenceInclude},
+		{value: "deny", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseFilterPrecedence(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilterPrecedence(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFilterPrecedence(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderFilterExcludes(t *testing.T) {
+	tests := []struct {
+		name       string
+		filter     string
+		precedence FilterPrecedence
+		provider   string
+		want       bool
+	}{
+		{name: "exclude only", filter: "!hashicorp/aws", provider: "hashicorp/aws", want: false},
+		{name: "exclude only keeps the rest", filter: "!hashicorp/aws", provider: "hashicorp/google", want: true},
+		{name: "namespace exclude", filter: "!hashicorp/*", provider: "hashicorp/google", want: false},
+		{name: "namespace exclude keeps other namespaces", filter: "!hashicorp/*", provider: "integrations/github", want: true},
+		{name: "excluded include", filter: "hashicorp/aws,hashicorp/helm,!hashicorp/helm", provider: "hashicorp/helm", want: false},
+		{name: "other includes kept", filter: "hashicorp/aws,hashicorp/helm,!hashicorp/helm", provider: "hashicorp/aws", want: true},
+		{name: "include under a namespace exclude", filter: "hashicorp/aws,!hashicorp/*", provider: "hashicorp/aws", want: false},
+		{name: "include under a namespace exclude, include precedence", filter: "hashicorp/aws,!hashicorp/*", precedence: PrecedenceInclude, provider: "hashicorp/aws", want: true},
+		{name: "namespace exclude, include precedence", filter: "hashicorp/aws,!hashicorp/*", precedence: PrecedenceInclude, provider: "hashicorp/helm", want: false},
+		{name: "wildcard minus one", filter: "hashicorp/*,!hashicorp/aws", provider: "hashicorp/aws", want: false},
+		{name: "wildcard minus one keeps the rest", filter: "hashicorp/*,!hashicorp/aws", provider: "hashicorp/google", want: true},
+		{name: "include precedence keeps a more specific exclude", filter: "hashicorp/*,!hashicorp/aws", precedence: PrecedenceInclude, provider: "hashicorp/aws", want: false},
+		{name: "same wildcard, exclude precedence", filter: "hashicorp/*,!hashicorp/*", provider: "hashicorp/aws", want: false},
+		{name: "same wildcard, include precedence", filter: "hashicorp/*,!hashicorp/*", precedence: PrecedenceInclude, provider: "hashicorp/aws", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewProviderFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("NewProviderFilter(%q): %v", tt.filter, err)
+			}
+			if tt.precedence != "" {
+				filter.SetPrecedence(tt.precedence)
+			}
+			namespace, name, _ := strings.Cut(tt.provider, "/")
+			if got := filter.ShouldInclude(namespace, name); got != tt.want {
+				t.Errorf("ShouldInclude(%s) = %t, want %t", tt.provider, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderFilterString(t *testing.T) {
+	tests := []struct {
+		filter     string
+		wantString string
+		wantCount  int
+	}{
+		{filter: "", wantString: "all providers", wantCount: 0},
+		{filter: "!hashicorp/aws", wantString: "all providers, !hashicorp/aws", wantCount: 1},
+		{filter: "hashicorp/aws,!hashicorp/helm,!integrations/*", wantString: "hashicorp/aws, !hashicorp/helm, !integrations/*", wantCount: 3},
+		{filter: "hashicorp/*,!hashicorp/helm,!hashicorp/aws", wantString: "hashicorp/*, !hashicorp/aws, !hashicorp/helm", wantCount: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			filter, err := NewProviderFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("NewProviderFilter(%q): %v", tt.filter, err)
+			}
+			if got := filter.String(); got != tt.wantString {
+				t.Errorf("String() = %q, want %q", got, tt.wantString)
+			}
+			if got := filter.Count(); got != tt.wantCount {
+				t.Errorf("Count() = %d, want %d", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestNewProviderFilterRejectsBadExcludes(t *testing.T) {
+	for _, filter := range []string{"!hashicorp", "!*/aws", "!hashicorp/aws>1.0.0", "!/aws"} {
+		t.Run(filter, func(t *testing.T) {
+			if _, err := NewProviderFilter(filter); err == nil {
+				t.Errorf("NewProviderFilter(%q) succeeded, want an error", filter)
+			}
+		})
+	}
+}
+
+// testProviders returns n providers named p000..p<n-1> in namespace ns
+func testProviders(ns string, n int) []ProviderListItem {
+	providers := make([]ProviderListItem, n)
+	for i := range providers {
+		providers[i] = ProviderListItem{Namespace: ns, Name: fmt.Sprintf("p%03d", i)}
+	}
+	return providers
+}
+
+func TestProviderSamplerIsStable(t *testing.T) {
+	tests := []struct {
+		spec      string
+		providers int
+		want      int
+	}{
+		{spec: "", providers: 50, want: 50},
+		{spec: "10", providers: 50, want: 10},
+		{spec: "100", providers: 50, want: 50},
+		{spec: "10%", providers: 200, want: 20},
+		{spec: "1%", providers: 30, want: 1}, // at least one
+		{spec: "100%", providers: 30, want: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			sampler, err := NewProviderSampler(tt.spec)
+			if err != nil {
+				t.Fatalf("NewProviderSampler(%q): %v", tt.spec, err)
+			}
+			providers := testProviders("ns", tt.providers)
+			first := sampler.Sample(providers)
+			if len(first) != tt.want {
+				t.Fatalf("sampled %d providers, want %d", len(first), tt.want)
+			}
+
+			// The same subset comes out of a reversed listing
+			reversed := slices.Clone(providers)
+			slices.Reverse(reversed)
+			second := sampler.Sample(reversed)
+			key := func(p ProviderListItem) string { return p.Namespace + "/" + p.Name }
+			firstKeys, secondKeys := make([]string, len(first)), make([]string, len(second))
+			for i := range first {
+				firstKeys[i], secondKeys[i] = key(first[i]), key(second[i])
+			}
+			slices.Sort(firstKeys)
+			slices.Sort(secondKeys)
+			if !slices.Equal(firstKeys, secondKeys) {
+				t.Errorf("samples differ between runs:\n%v\n%v", firstKeys, secondKeys)
+			}
+		})
+	}
+}
+
+func TestNewProviderSamplerRejectsBadSpecs(t *testing.T) {
+	for _, spec := range []string{"0", "-1", "0%", "101%", "abc", "5.5"} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := NewProviderSampler(spec); err == nil {
+				t.Errorf("NewProviderSampler(%q) succeeded, want an error", spec)
+			}
+		})
+	}
+}
+
+func TestParseVersionRange(t *testing.T) {
+	tests := []struct {
+		entry   string
+		name    string
+		min     string
+		max     string
+		wantErr bool
+	}{
+		{entry: "hashicorp/aws", name: "hashicorp/aws"},
+		{entry: "hashicorp/aws>5.0.0", name: "hashicorp/aws", min: "5.0.0"},
+		{entry: "hashicorp/aws<6.0.0", name: "hashicorp/aws", max: "6.0.0"},
+		{entry: "hashicorp/aws>5.0.0<6.0.0", name: "hashicorp/aws", min: "5.0.0", max: "6.0.0"},
+		{entry: " hashicorp/aws > 5.0 < 6 ", name: "hashicorp/aws", min: "5.0", max: "6"},
+		{entry: "hashicorp/aws>5.0.0<", wantErr: true},
+		{entry: "hashicorp/aws<", wantErr: true},
+		{entry: "hashicorp/aws>", wantErr: true},
+		{entry: "hashicorp/aws><6.0.0", wantErr: true},
+		{entry: "hashicorp/aws>>5.0.0", wantErr: true},
+		{entry: "hashicorp/aws<6.0.0<7.0.0", wantErr: true},
+		{entry: "hashicorp/aws<6.0.0>5.0.0", wantErr: true},
+		{entry: "hashicorp/aws>five", wantErr: true},
+		{entry: "hashicorp/aws<six", wantErr: true},
+		{entry: "hashicorp/aws>6.0.0<5.0.0", wantErr: true},
+		{entry: "hashicorp/aws>5.0.0<5.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			name, min, max, err := ParseVersionRange(tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersionRange(%q) error = %v, want error %t", tt.entry, err, tt.wantErr)
+			}
+			if name != tt.name || min != tt.min || max != tt.max {
+				t.Errorf("ParseVersionRange(%q) = %q, %q, %q, want %q, %q, %q", tt.entry, name, min, max, tt.name, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestFilterVersionsByRange(t *testing.T) {
+	versions := []string{"4.9.0", "5.0.0", "5.1.0", "5.10.0", "6.0.0", "6.0.1", "latest"}
+	tests := []struct {
+		name string
+		min  string
+		max  string
+		want []string
+	}{
+		{name: "no bounds", want: versions},
+		{name: "min only", min: "5.1.0", want: []string{"5.1.0", "5.10.0", "6.0.0", "6.0.1"}},
+		{name: "empty max is min only", min: "6.0.0", max: "", want: []string{"6.0.0", "6.0.1"}},
+		{name: "max only", max: "5.1.0", want: []string{"4.9.0", "5.0.0"}},
+		{name: "min inclusive and max exclusive", min: "5.0.0", max: "6.0.0", want: []string{"5.0.0", "5.1.0", "5.10.0"}},
+		{name: "tolerant bounds", min: "v5", max: "6", want: []string{"5.0.0", "5.1.0", "5.10.0"}},
+		{name: "min above max", min: "6.0.0", max: "5.0.0", want: nil},
+		{name: "min equal to max", min: "5.0.0", max: "5.0.0", want: nil},
+		{name: "malformed max is not applied", min: "6.0.0", max: "six", want: []string{"6.0.0", "6.0.1"}},
+		{name: "malformed min is not applied", min: "five", max: "5.0.0", want: []string{"4.9.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterVersionsByRange(versions, tt.min, tt.max); !slices.Equal(got, tt.want) {
+				t.Errorf("FilterVersionsByRange(%q, %q) = %v, want %v", tt.min, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterStableVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     []string
+	}{
+		{name: "stable only", versions: []string{"1.0.0", "1.1.0"}, want: []string{"1.0.0", "1.1.0"}},
+		{name: "mixed", versions: []string{"4.9.0", "5.0.0-beta1", "5.0.0-rc.2", "5.0.0", "5.1.0-alpha"}, want: []string{"4.9.0", "5.0.0"}},
+		{name: "build metadata is stable", versions: []string{"1.0.0+ent", "1.0.1-rc1+ent"}, want: []string{"1.0.0+ent"}},
+		{name: "tolerant parsing", versions: []string{"v1.2", "v1.3.0-beta"}, want: []string{"v1.2"}},
+		{name: "unparsable versions are kept", versions: []string{"latest", "2.0.0-rc1"}, want: []string{"latest"}},
+		{name: "only prereleases", versions: []string{"0.1.0-alpha", "0.1.0-beta"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FilterStableVersions(tt.versions); !slices.Equal(got, tt.want) {
+				t.Errorf("FilterStableVersions(%v) = %v, want %v", tt.versions, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPlatformFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    string
+		want      []string // platforms the filter selects
+		wantErr   string
+		wantExtra int
+	}{
+		{name: "empty selects the defaults", filter: "", want: []string{"linux_amd64", "linux_arm64", "linux_386", "darwin_amd64", "darwin_arm64", "windows_amd64", "windows_386", "freebsd_amd64", "freebsd_386"}},
+		{name: "valid entries", filter: "linux_amd64,darwin_arm64", want: []string{"linux_amd64", "darwin_arm64"}},
+		{name: "normalized", filter: " Linux/AMD64 , DARWIN_arm64", want: []string{"linux_amd64", "darwin_arm64"}},
+		{name: "known platform outside the defaults", filter: "windows_arm64", want: []string{"windows_arm64"}},
+		{name: "arch typo", filter: "linux_amd46", wantErr: "unknown platform 'linux_amd46', did you mean 'linux_amd64'?"},
+		{name: "os typo", filter: "linux_amd64,drawin_arm64", wantErr: "did you mean 'darwin_arm64'?"},
+		{name: "windows typo", filter: "windws_386", wantErr: "did you mean 'windows_386'?"},
+		{name: "forced unknown platform", filter: "linux_amd64,+openbsd_amd64", want: []string{"linux_amd64", "openbsd_amd64"}, wantExtra: 1},
+		{name: "forced known platform", filter: "+linux_arm", want: []string{"linux_arm"}},
+		{name: "missing arch", filter: "linux", wantErr: "invalid platform format 'linux'"},
+		{name: "too many parts", filter: "linux_amd64_v2", wantErr: "invalid platform format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewPlatformFilter(tt.filter)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewPlatformFilter(%q) error = %v, want %q", tt.filter, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewPlatformFilter(%q): %v", tt.filter, err)
+			}
+			var got []string
+			for _, p := range filter.Select(SupportedPlatforms) {
+				got = append(got, p.OS+"_"+p.Arch)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Select() = %v, want %v", got, tt.want)
+			}
+			if len(filter.Extra()) != tt.wantExtra {
+				t.Errorf("Extra() = %v, want %d platforms", filter.Extra(), tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestAutoPlatformFilter(t *testing.T) {
+	tests := []struct {
+		goos, goarch string
+		want         string
+	}{
+		{goos: "linux", goarch: "amd64", want: "linux_amd64"},
+		{goos: "darwin", goarch: "arm64", want: "darwin_arm64,linux_amd64"},
+		{goos: "windows", goarch: "amd64", want: "windows_amd64,linux_amd64"},
+		{goos: "linux", goarch: "arm", want: "linux_arm,linux_amd64"},
+		{goos: "windows", goarch: "arm64", want: "windows_arm64,linux_amd64"},
+		{goos: "plan9", goarch: "amd64", want: "+plan9_amd64,linux_amd64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos+"_"+tt.goarch, func(t *testing.T) {
+			got := AutoPlatformFilter(tt.goos, tt.goarch)
+			if got != tt.want {
+				t.Fatalf("AutoPlatformFilter(%q, %q) = %q, want %q", tt.goos, tt.goarch, got, tt.want)
+			}
+			filter, err := NewPlatformFilter(got)
+			if err != nil {
+				t.Fatalf("NewPlatformFilter(%q): %v", got, err)
+			}
+			for _, platform := range []Platform{{OS: tt.goos, Arch: tt.goarch}, {OS: "linux", Arch: "amd64"}} {
+				if !filter.ShouldInclude(platform.OS, platform.Arch) {
+					t.Errorf("%s_%s not selected", platform.OS, platform.Arch)
+				}
+			}
+		})
+	}
+}
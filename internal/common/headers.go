@@ -0,0 +1,47 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// ParseHeaders parses "Name: value" entries (--header) into an http.Header.
+// Host and User-Agent are rejected: the former cannot be overridden per
+// request, the latter has its own option.
+func ParseHeaders(entries []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header '%s', expected 'Name: value'", entry)
+		}
+		if !httpguts.ValidHeaderFieldName(name) {
+			return nil, fmt.Errorf("invalid header name '%s'", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return nil, fmt.Errorf("invalid value for header '%s'", name)
+		}
+		switch http.CanonicalHeaderKey(name) {
+		case "Host":
+			return nil, fmt.Errorf("header 'Host' cannot be overridden")
+		case "User-Agent":
+			return nil, fmt.Errorf("use --user-agent instead of a 'User-Agent' header")
+		}
+		headers.Add(name, value)
+	}
+	return headers, nil
+}
+
+// SetRequestHeaders applies the configured User-Agent and extra headers to req
+func SetRequestHeaders(req *http.Request, userAgent string, headers http.Header) {
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	for name, values := range headers {
+		req.Header[name] = append([]string(nil), values...)
+	}
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    http.Header
+		wantErr string
+	}{
+		{name: "none", want: http.Header{}},
+		{name: "one header", entries: []string{"X-Api-Key: abc"}, want: http.Header{"X-Api-Key": {"abc"}}},
+		{name: "canonical name and trimmed value", entries: []string{"x-team-id:   platform  "}, want: http.Header{"X-Team-Id": {"platform"}}},
+		{name: "repeated header", entries: []string{"X-Tag: a", "X-Tag: b"}, want: http.Header{"X-Tag": {"a", "b"}}},
+		{name: "colon in the value", entries: []string{"Proxy-Authorization: Basic dXNlcjpwYXNz:"}, want: http.Header{"Proxy-Authorization": {"Basic dXNlcjpwYXNz:"}}},
+		{name: "empty value", entries: []string{"X-Empty:"}, want: http.Header{"X-Empty": {""}}},
+		{name: "missing colon", entries: []string{"X-Api-Key abc"}, wantErr: "expected 'Name: value'"},
+		{name: "missing name", entries: []string{": abc"}, wantErr: "expected 'Name: value'"},
+		{name: "invalid name", entries: []string{"X Api: abc"}, wantErr: "invalid header name 'X Api'"},
+		{name: "invalid value", entries: []string{"X-Api-Key: a\x00b"}, wantErr: "invalid value for header 'X-Api-Key'"},
+		{name: "host", entries: []string{"host: mirror"}, wantErr: "'Host' cannot be overridden"},
+		{name: "user agent", entries: []string{"User-Agent: curl"}, wantErr: "use --user-agent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHeaders(tt.entries)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseHeaders(%q) error = %v, want %q", tt.entries, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseHeaders(%q): %v", tt.entries, err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseHeaders(%q) = %v, want %v", tt.entries, got, tt.want)
+			}
+			for name, values := range tt.want {
+				if strings.Join(got[name], "|") != strings.Join(values, "|") {
+					t.Errorf("%s = %q, want %q", name, got[name], values)
+				}
+			}
+		})
+	}
+}
+
+func TestOutgoingHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		headers   []string
+		want      http.Header
+	}{
+		{name: "default user agent", userAgent: UserAgent, want: http.Header{"User-Agent": {UserAgent}}},
+		{name: "custom user agent", userAgent: "corp-mirror/2.0", want: http.Header{"User-Agent": {"corp-mirror/2.0"}}},
+		{
+			name:      "extra headers",
+			userAgent: UserAgent,
+			headers:   []string{"X-Api-Key: abc", "X-Tag: a", "X-Tag: b"},
+			want:      http.Header{"User-Agent": {UserAgent}, "X-Api-Key": {"abc"}, "X-Tag": {"a", "b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Clone()
+			}))
+			defer server.Close()
+
+			headers, err := ParseHeaders(tt.headers)
+			if err != nil {
+				t.Fatalf("ParseHeaders: %v", err)
+			}
+			client, err := NewHTTPClient(&RegistryConfig{BaseURL: server.URL, UserAgent: tt.userAgent, Headers: headers})
+			if err != nil {
+				t.Fatalf("NewHTTPClient: %v", err)
+			}
+			defer client.Close()
+			resp, err := client.GetWithContext(context.Background(), server.URL)
+			if err != nil {
+				t.Fatalf("GetWithContext: %v", err)
+			}
+			resp.Body.Close()
+
+			for name, values := range tt.want {
+				if strings.Join(got[name], "|") != strings.Join(values, "|") {
+					t.Errorf("request %s = %q, want %q", name, got[name], values)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an append-only log file that is rotated by size.
+// When a write would grow the file past maxSize, the file is renamed to
+// "<path>.1" (replacing any previous rotation) and a fresh file is started.
+// Writes are serialized, so lines from concurrent loggers never interleave.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64 // 0 = never rotate
+	file    *os.File
+	size    int64
+}
+
+// OpenRotatingFile opens (or creates) path for appending
+func OpenRotatingFile(path string, maxSize int64) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", r.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", r.path, err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write implements io.Writer
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rotateErr error
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		// On failure the line still goes to the reopened file
+		rotateErr = r.rotate()
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	if err != nil {
+		return n, errors.Join(rotateErr, err)
+	}
+	return n, rotateErr
+}
+
+// rotate moves the current file aside and starts a new one. If the file
+// cannot be moved, the original path is reopened for appending and the
+// error is returned, so a failed rotation never leaves the file closed.
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return r.reopen(fmt.Errorf("failed to close log file: %w", err))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return r.reopen(fmt.Errorf("failed to rotate log file: %w", err))
+	}
+	return r.open()
+}
+
+// reopen opens r.path again after a failed rotation and returns cause
+func (r *RotatingFile) reopen(cause error) error {
+	if err := r.open(); err != nil {
+		return errors.Join(cause, err)
+	}
+	return cause
+}
+
+// Close closes the underlying file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
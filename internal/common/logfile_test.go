@@ -0,0 +1,137 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	line := strings.Repeat("x", 9) + "\n" // 10 bytes
+	tests := []struct {
+		name        string
+		maxSize     int64
+		existing    int // bytes already in the file
+		writes      int
+		wantCurrent int64
+		wantRotated int64 // -1 = no rotated file
+	}{
+		{name: "no limit", maxSize: 0, writes: 10, wantCurrent: 100, wantRotated: -1},
+		{name: "below the limit", maxSize: 100, writes: 10, wantCurrent: 100, wantRotated: -1},
+		{name: "rotates past the limit", maxSize: 100, writes: 11, wantCurrent: 10, wantRotated: 100},
+		{name: "rotates again", maxSize: 50, writes: 12, wantCurrent: 20, wantRotated: 50},
+		{name: "counts an existing file", maxSize: 50, existing: 45, writes: 1, wantCurrent: 10, wantRotated: 45},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tf-mirror.log")
+			if tt.existing > 0 {
+				if err := os.WriteFile(path, []byte(strings.Repeat("y", tt.existing)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			file, err := OpenRotatingFile(path, tt.maxSize)
+			if err != nil {
+				t.Fatalf("OpenRotatingFile: %v", err)
+			}
+			for range tt.writes {
+				if _, err := file.Write([]byte(line)); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			file.Close()
+
+			info, err := os.Stat(path)
+			if err != nil || info.Size() != tt.wantCurrent {
+				t.Errorf("current file = %v, %v, want %d bytes", info, err, tt.wantCurrent)
+			}
+			rotated, err := os.Stat(path + ".1")
+			switch {
+			case tt.wantRotated < 0 && err == nil:
+				t.Errorf("rotated file exists with %d bytes, want none", rotated.Size())
+			case tt.wantRotated >= 0 && (err != nil || rotated.Size() != tt.wantRotated):
+				t.Errorf("rotated file = %v, want %d bytes", err, tt.wantRotated)
+			}
+		})
+	}
+}
+
+func TestRotatingFileFailedRotation(t *testing.T) {
+	line := strings.Repeat("x", 9) + "\n" // 10 bytes
+	path := filepath.Join(t.TempDir(), "tf-mirror.log")
+	// A non-empty directory at <path>.1 makes the rename fail
+	blocker := path + ".1"
+	if err := os.MkdirAll(filepath.Join(blocker, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := OpenRotatingFile(path, 20)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	defer file.Close()
+
+	for i, wantErr := range []bool{false, false, true, true} {
+		if _, err := file.Write([]byte(line)); (err != nil) != wantErr {
+			t.Fatalf("write %d: error = %v, want error %t", i, err, wantErr)
+		}
+	}
+	if data, err := os.ReadFile(path); err != nil || len(data) != 40 {
+		t.Fatalf("log file holds %d bytes (%v), want all 40 written while rotation failed", len(data), err)
+	}
+
+	// Once the path is free the next write rotates
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte(line)); err != nil {
+		t.Fatalf("write after the rename works again: %v", err)
+	}
+	for name, want := range map[string]int64{path: 10, blocker: 40} {
+		if info, err := os.Stat(name); err != nil || info.Size() != want {
+			t.Errorf("%s = %v, want %d bytes", name, err, want)
+		}
+	}
+}
+
+func TestRotatingFileConcurrentLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tf-mirror.log")
+	file, err := OpenRotatingFile(path, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile: %v", err)
+	}
+	logger := NewLogger()
+	logger.SetOutput(file)
+
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 100 {
+				logger.Info("worker %d line %d %s", worker, i, strings.Repeat("z", 200))
+			}
+		}()
+	}
+	wg.Wait()
+	file.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 800 {
+		t.Fatalf("got %d lines, want 800", len(lines))
+	}
+	for _, line := range lines {
+		var worker, i int
+		var rest string
+		_, msg, _ := strings.Cut(line, "worker ")
+		if _, err := fmt.Sscanf(msg, "%d line %d %s", &worker, &i, &rest); err != nil || len(rest) != 200 {
+			t.Fatalf("interleaved line %q", line)
+		}
+	}
+}
@@ -1,15 +1,74 @@
 package common
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// LogFormat selects how log lines are rendered
+type LogFormat string
+
+const (
+	// LogFormatText is the default "[LEVEL] message" format
+	LogFormatText LogFormat = "text"
+	// LogFormatJSON emits one JSON object per line for log shippers
+	LogFormatJSON LogFormat = "json"
+)
+
+// ParseLogFormat parses a log format name, defaulting to LogFormatText
+func ParseLogFormat(value string) (LogFormat, error) {
+	switch LogFormat(strings.ToLower(strings.TrimSpace(value))) {
+	case "", LogFormatText:
+		return LogFormatText, nil
+	case LogFormatJSON:
+		return LogFormatJSON, nil
+	default:
+		return "", fmt.Errorf("invalid log format '%s', expected 'text' or 'json'", value)
+	}
+}
+
+// LogLevel is the minimum severity a Logger writes
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// ParseLogLevel parses error|warn|info|debug, defaulting to LogLevelInfo
+func ParseLogLevel(value string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "warn", "warning":
+		return LogLevelWarn, nil
+	case "error":
+		return LogLevelError, nil
+	default:
+		return LogLevelInfo, fmt.Errorf("invalid log level '%s', expected 'error', 'warn', 'info' or 'debug'", value)
+	}
+}
+
 // Logger represents a structured logger
 type Logger struct {
 	infoLogger  *log.Logger
 	errorLogger *log.Logger
 	debugLogger *log.Logger
+	format      LogFormat
+	level       LogLevel
+	fields      map[string]any
+	jsonMu      *sync.Mutex // serializes JSON lines, shared with WithField children
 }
 
 // NewLogger creates a new logger instance
@@ -18,33 +77,128 @@ func NewLogger() *Logger {
 		infoLogger:  log.New(os.Stdout, "[INFO] ", log.LstdFlags),
 		errorLogger: log.New(os.Stderr, "[ERROR] ", log.LstdFlags),
 		debugLogger: log.New(os.Stdout, "[DEBUG] ", log.LstdFlags),
+		format:      LogFormatText,
+		level:       LogLevelInfo,
+		jsonMu:      &sync.Mutex{},
+	}
+}
+
+// SetOutput redirects info, warning and debug messages to w.
+// Errors always go to stderr.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.infoLogger.SetOutput(w)
+	l.debugLogger.SetOutput(w)
+}
+
+// TeeTo additionally writes all log levels to w, e.g. a log file.
+// Call it after SetOutput, which would otherwise drop the tee.
+func (l *Logger) TeeTo(w io.Writer) {
+	l.infoLogger.SetOutput(io.MultiWriter(l.infoLogger.Writer(), w))
+	l.errorLogger.SetOutput(io.MultiWriter(l.errorLogger.Writer(), w))
+	l.debugLogger.SetOutput(io.MultiWriter(l.debugLogger.Writer(), w))
+}
+
+// SetFormat switches between text and JSON output
+func (l *Logger) SetFormat(format LogFormat) {
+	l.format = format
+}
+
+// SetLevel sets the minimum level that is written. Fatal is always written.
+func (l *Logger) SetLevel(level LogLevel) {
+	l.level = level
+}
+
+// WithField returns a logger that adds key=value to every message.
+// The returned logger shares outputs with l.
+func (l *Logger) WithField(key string, value any) *Logger {
+	fields := make(map[string]any, len(l.fields)+1)
+	for k, v := range l.fields {
+		fields[k] = v
 	}
+	fields[key] = value
+
+	child := *l
+	child.fields = fields
+	return &child
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, args ...any) {
-	l.infoLogger.Printf(format, args...)
+	if l.level > LogLevelInfo {
+		return
+	}
+	l.output(l.infoLogger, "info", "", format, args...)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, args ...any) {
-	l.infoLogger.Printf("[WARN] "+format, args...)
+	if l.level > LogLevelWarn {
+		return
+	}
+	l.output(l.infoLogger, "warn", "[WARN] ", format, args...)
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, args ...any) {
-	l.errorLogger.Printf(format, args...)
+	l.output(l.errorLogger, "error", "", format, args...)
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...any) {
-	if os.Getenv("DEBUG") != "" {
-		l.debugLogger.Printf(format, args...)
+	if l.level > LogLevelDebug {
+		return
 	}
+	l.output(l.debugLogger, "debug", "", format, args...)
 }
 
 // Fatal logs a fatal error and exits
 func (l *Logger) Fatal(format string, args ...any) {
-	l.errorLogger.Printf(format, args...)
+	l.output(l.errorLogger, "fatal", "", format, args...)
 	os.Exit(1)
 }
+
+// output renders a message in the configured format. textPrefix is only
+// used in text mode, where the level is otherwise carried by the log prefix.
+func (l *Logger) output(target *log.Logger, level, textPrefix, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+
+	if l.format != LogFormatJSON {
+		if len(l.fields) > 0 {
+			msg += " " + formatFields(l.fields)
+		}
+		target.Print(textPrefix + msg)
+		return
+	}
+
+	entry := make(map[string]any, len(l.fields)+3)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["level"] = level
+	entry["ts"] = time.Now().Format(time.RFC3339)
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"level": level, "ts": time.Now().Format(time.RFC3339), "msg": msg})
+	}
+	// JSON lines bypass the log.Logger, which would add its prefix and
+	// timestamp, so they need their own lock
+	l.jsonMu.Lock()
+	defer l.jsonMu.Unlock()
+	target.Writer().Write(append(data, '\n'))
+}
+
+// formatFields renders fields as sorted key=value pairs for text mode
+func formatFields(fields map[string]any) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, fields[k]))
+	}
+	return strings.Join(parts, " ")
+}
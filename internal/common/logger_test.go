@@ -0,0 +1,144 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// logEntry is a JSON log line
+type logEntry struct {
+	Level  string `json:"level"`
+	TS     string `json:"ts"`
+	Msg    string `json:"msg"`
+	Worker int    `json:"worker"`
+}
+
+func TestJSONLogFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		log    func(l *Logger)
+		want   logEntry
+		worker bool
+	}{
+		{name: "info", log: func(l *Logger) { l.Info("downloaded %d files", 3) }, want: logEntry{Level: "info", Msg: "downloaded 3 files"}},
+		{name: "warn", log: func(l *Logger) { l.Warn("disk %s", "low") }, want: logEntry{Level: "warn", Msg: "disk low"}},
+		{name: "error", log: func(l *Logger) { l.Error("failed: %v", io.EOF) }, want: logEntry{Level: "error", Msg: "failed: EOF"}},
+		{name: "debug", log: func(l *Logger) { l.Debug("attempt %d", 2) }, want: logEntry{Level: "debug", Msg: "attempt 2"}},
+		{name: "message with quotes", log: func(l *Logger) { l.Info(`path "a\b"`) }, want: logEntry{Level: "info", Msg: `path "a\b"`}},
+		{name: "structured field", log: func(l *Logger) { l.WithField("worker", 7).Info("started") }, want: logEntry{Level: "info", Msg: "started", Worker: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger()
+			logger.SetOutput(&buf)
+			logger.errorLogger.SetOutput(&buf)
+			logger.SetFormat(LogFormatJSON)
+			logger.SetLevel(LogLevelDebug)
+
+			tt.log(logger)
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("got %d lines, want 1: %q", len(lines), buf.String())
+			}
+			var got logEntry
+			if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+				t.Fatalf("line %q is not JSON: %v", lines[0], err)
+			}
+			if _, err := time.Parse(time.RFC3339, got.TS); err != nil {
+				t.Errorf("ts %q is not RFC3339: %v", got.TS, err)
+			}
+			got.TS = ""
+			if got != tt.want {
+				t.Errorf("entry = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// overlapWriter records whether two Writes ran at the same time
+type overlapWriter struct {
+	active  atomic.Int32
+	overlap atomic.Bool
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *overlapWriter) Write(p []byte) (int, error) {
+	if w.active.Add(1) > 1 {
+		w.overlap.Store(true)
+	}
+	defer w.active.Add(-1)
+	time.Sleep(10 * time.Microsecond)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func TestJSONLogConcurrentWrites(t *testing.T) {
+	var out overlapWriter
+	logger := NewLogger()
+	logger.SetOutput(io.Discard)
+	logger.TeeTo(&out)
+	logger.SetFormat(LogFormatJSON)
+	logger.SetLevel(LogLevelDebug)
+
+	const workers, lines = 8, 50
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := logger.WithField("worker", i)
+			for j := range lines {
+				switch j % 3 {
+				case 0:
+					worker.Info("line %d", j)
+				case 1:
+					worker.Debug("line %d", j)
+				default:
+					worker.Error("line %d", j)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if out.overlap.Load() {
+		t.Error("JSON lines were written concurrently")
+	}
+	got := strings.Split(strings.TrimSpace(out.buf.String()), "\n")
+	if len(got) != workers*lines {
+		t.Fatalf("got %d lines, want %d", len(got), workers*lines)
+	}
+	for _, line := range got {
+		var entry logEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+	}
+}
+
+func TestTextLogFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger()
+	logger.SetOutput(&buf)
+	logger.WithField("worker", 7).Warn("disk low")
+
+	scanner := bufio.NewScanner(&buf)
+	if !scanner.Scan() {
+		t.Fatal("no log line written")
+	}
+	line := scanner.Text()
+	if !strings.HasPrefix(line, "[INFO] ") || !strings.HasSuffix(line, "[WARN] disk low worker=7") {
+		t.Errorf("text line = %q", line)
+	}
+}
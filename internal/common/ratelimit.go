@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimitChunk caps a single Read so one worker can't grab the whole budget
+const rateLimitChunk = 32 * 1024
+
+// RateLimiter is a token bucket measured in bytes per second. It is safe for
+// concurrent use, so a single limiter caps the aggregate throughput of all
+// readers sharing it. A nil limiter does not limit.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter for bytesPerSec, or returns nil if bytesPerSec <= 0
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	burst := float64(bytesPerSec)
+	if burst > rateLimitChunk {
+		burst = rateLimitChunk
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSec),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Reserve the bytes up front; a negative balance is paid off by waiting
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	return SleepContext(ctx, time.Duration(deficit/l.rate*float64(time.Second)))
+}
+
+// Reader wraps r so reads are throttled by the limiter
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > int(lr.limiter.burst) {
+		p = p[:int(lr.limiter.burst)]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if waitErr := lr.limiter.WaitN(lr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
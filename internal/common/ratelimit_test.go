@@ -0,0 +1,62 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int64
+		readers  int
+		size     int // bytes per reader
+		min, max time.Duration
+	}{
+		{name: "unlimited", rate: 0, readers: 1, size: 1 << 20, min: 0, max: 100 * time.Millisecond},
+		// the first 32 KiB are the burst, the rest is paced at the rate
+		{name: "one reader", rate: 128 << 10, readers: 1, size: 64 << 10, min: 250 * time.Millisecond, max: 600 * time.Millisecond},
+		{name: "shared by two readers", rate: 64 << 10, readers: 2, size: 32 << 10, min: 500 * time.Millisecond, max: 900 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(tt.rate)
+			start := time.Now()
+			var wg sync.WaitGroup
+			for range tt.readers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					n, err := io.Copy(io.Discard, limiter.Reader(context.Background(), bytes.NewReader(make([]byte, tt.size))))
+					if err != nil || n != int64(tt.size) {
+						t.Errorf("copied %d bytes, %v, want %d", n, err, tt.size)
+					}
+				}()
+			}
+			wg.Wait()
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("transfer took %v, want between %v and %v", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := NewRateLimiter(1024)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := io.Copy(io.Discard, limiter.Reader(ctx, bytes.NewReader(make([]byte, 64<<10))))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("copy error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled copy took %v", elapsed)
+	}
+}
@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/tls"
+	"net/http"
+	"net/netip"
 	"time"
 )
 
@@ -14,20 +17,21 @@ type Provider struct {
 // Version represents a provider version
 type Version struct {
 	Version   string            `json:"version"`
+	Protocols []string          `json:"protocols,omitempty"`
 	Platforms []Platform        `json:"platforms"`
 	Metadata  map[string]string `json:"metadata,omitempty"`
 }
 
 // Platform represents a provider platform
 type Platform struct {
-	OS                  string      `json:"os"`
-	Arch                string      `json:"arch"`
-	Filename            string      `json:"filename"`
-	DownloadURL         string      `json:"download_url"`
-	SHASumsURL          string      `json:"shasums_url"`
-	SHASumsSignatureURL string      `json:"shasums_signature_url"`
-	Shasum              string      `json:"shasum"`
-	SigningKeys         SigningKeys `json:"signing_keys,omitempty"`
+	OS                  string       `json:"os"`
+	Arch                string       `json:"arch"`
+	Filename            string       `json:"filename,omitempty"`
+	DownloadURL         string       `json:"download_url,omitempty"`
+	SHASumsURL          string       `json:"shasums_url,omitempty"`
+	SHASumsSignatureURL string       `json:"shasums_signature_url,omitempty"`
+	Shasum              string       `json:"shasum,omitempty"`
+	SigningKeys         *SigningKeys `json:"signing_keys,omitempty"`
 }
 
 // SigningKeys represents GPG signing keys
@@ -62,6 +66,43 @@ type ProviderPackage struct {
 	SigningKeys         SigningKeys `json:"signing_keys"`
 }
 
+// ModuleVersions represents the response from module versions API
+type ModuleVersions struct {
+	Modules []ModuleVersionList `json:"modules"`
+}
+
+// ModuleVersionList holds the versions of a single module
+type ModuleVersionList struct {
+	Versions []ModuleVersion `json:"versions"`
+}
+
+// ModuleVersion represents a module version
+type ModuleVersion struct {
+	Version string `json:"version"`
+}
+
+// ModuleListItem represents a single mirrored module in the list
+type ModuleListItem struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	System    string   `json:"system"`
+	Versions  []string `json:"versions"`
+}
+
+// ModuleIndex is the index.json kept next to mirrored module archives in
+// <download-path>/modules/<namespace>/<name>/<system>
+type ModuleIndex struct {
+	Versions map[string]ModuleArchive `json:"versions"`
+}
+
+// ModuleArchive describes a mirrored module version
+type ModuleArchive struct {
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+	Subdir   string `json:"subdir,omitempty"` // go-getter subdirectory inside the archive
+	Source   string `json:"source"`           // original X-Terraform-Get location
+}
+
 // DownloadedBinary represents a HashiCorp binary that has been downloaded
 type DownloadedBinary struct {
 	Tool       string    `json:"tool"`
@@ -71,16 +112,52 @@ type DownloadedBinary struct {
 	Downloaded time.Time `json:"downloaded"`
 }
 
+// BinaryInfo is the metadata entry of one HashiCorp tool, stored under its
+// name in the "binaries" object of .tf-mirror-metadata.json
+type BinaryInfo struct {
+	Platforms  []string  `json:"platforms"`
+	Versions   []string  `json:"versions"`
+	Downloaded time.Time `json:"downloaded"`
+}
+
 // ProviderList represents the response from providers list API
 type ProviderList struct {
+	Meta      *ListMeta          `json:"meta,omitempty"`
 	Providers []ProviderListItem `json:"providers"`
 }
 
+// ProviderDetail is the /providers/{namespace}/{name} view of a mirrored provider
+type ProviderDetail struct {
+	Namespace string                  `json:"namespace"`
+	Name      string                  `json:"name"`
+	Versions  []ProviderVersionDetail `json:"versions"`
+}
+
+// ProviderVersionDetail lists the platforms of one mirrored provider version
+type ProviderVersionDetail struct {
+	Version   string   `json:"version"`
+	Protocols []string `json:"protocols"`
+	Platforms []string `json:"platforms"`
+}
+
+// ListMeta is the pagination block of registry listings
+type ListMeta struct {
+	Limit         int    `json:"limit"`
+	CurrentOffset int    `json:"current_offset"`
+	NextOffset    *int   `json:"next_offset,omitempty"`
+	PrevOffset    *int   `json:"prev_offset,omitempty"`
+	NextURL       string `json:"next_url,omitempty"`
+}
+
 // ProviderListItem represents a single provider in the list
 type ProviderListItem struct {
 	Namespace   string `json:"namespace"`
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
+	// Latest version and its publication time as listed by the upstream
+	// registry, used to detect changed providers between discoveries
+	Version     string `json:"version,omitempty"`
+	PublishedAt string `json:"published_at,omitempty"`
 }
 
 // DownloadedProvider represents a provider that has been downloaded
@@ -94,11 +171,25 @@ type DownloadedProvider struct {
 
 // RegistryConfig represents the configuration for registry operations
 type RegistryConfig struct {
-	BaseURL    string
-	ProxyURL   string
-	UserAgent  string
-	Timeout    time.Duration
-	MaxRetries int
+	BaseURL         string
+	ProxyURL        string
+	UserAgent       string
+	Timeout         time.Duration
+	MaxRetries      int
+	TLSMinVersion   uint16 // Minimum outbound TLS version (default: TLS 1.2)
+	MaxConnsPerHost int    // Maximum concurrent connections per upstream host (0 = unlimited)
+	MaxBandwidth    int64  // Aggregate download cap in bytes per second shared by all workers (0 = unlimited)
+
+	// Circuit breaker per upstream host (CircuitFailures 0 = disabled)
+	CircuitFailures int
+	CircuitCooldown time.Duration
+
+	// Extra trust for TLS-intercepting proxies
+	CACert      string // PEM bundle added to the system roots
+	InsecureTLS bool   // Skip certificate verification (--insecure-skip-verify)
+
+	Headers http.Header // Extra headers sent with every request (--header)
+	Token   string      // Bearer token sent to the registry host only (--registry-token)
 }
 
 // ServerConfig represents the HTTP server configuration
@@ -110,19 +201,106 @@ type ServerConfig struct {
 	TLSCert    string
 	TLSKey     string
 	DataPath   string
+	// TLSMinVersion is the oldest TLS version accepted from clients (default: TLS 1.2)
+	TLSMinVersion uint16
+	// TLSSNICerts are additional certificates served by SNI; TLSCert/TLSKey
+	// stays the default for clients whose server name matches none of them
+	TLSSNICerts []CertKeyPair
+	// AllowedSuffixes restricts static file serving to names ending with one of
+	// these suffixes (e.g. ".zip", "SHA256SUMS"). Empty means serve everything.
+	AllowedSuffixes []string
+	// AllowedHosts lists Host header values (aliases/CNAMEs) that may be used
+	// to build absolute URLs in responses. Other hosts fall back to Hostname.
+	AllowedHosts []string
+	// StatsTTL is how long the /stats disk usage report is cached (default: 1m)
+	StatsTTL time.Duration
+	// AuthToken, when set, is required as a bearer token on all routes but /health
+	AuthToken string
+	// AllowedCIDRs, when non-empty, rejects clients outside these ranges with 403
+	AllowedCIDRs []netip.Prefix
+	// TrustProxy takes the client IP from X-Forwarded-For instead of the connection
+	TrustProxy bool
+	// RegistryHost is the upstream registry hostname whose directory under
+	// DataPath backs the provider registry endpoints (default: registry.terraform.io)
+	RegistryHost string
+	// EnableUI serves the HTML overview of the mirror at /ui
+	EnableUI bool
+	// ReadTimeout, WriteTimeout and IdleTimeout configure the HTTP server
+	// (default: 30s, 30s, 120s). 0 disables a timeout, e.g. WriteTimeout for
+	// large archives pulled over slow links.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+}
+
+// CertKeyPair is the path of a PEM certificate and of its private key
+type CertKeyPair struct {
+	Cert string
+	Key  string
 }
 
 // DownloaderConfig represents the downloader configuration
 type DownloaderConfig struct {
 	ProxyURL         string
 	CheckPeriod      time.Duration
+	CheckJitter      float64 // Shift each scheduled check by up to this fraction of CheckPeriod
 	DownloadPath     string
+	StagingPath      string // Optional: download here and publish each provider into DownloadPath once complete
 	MaxConcurrent    int
 	ProviderFilter   string
+	FilterPrecedence string // "exclude" (default) or "include": which rule wins when both match
+	GlobalMinVersion string // Minimum version for providers without a per-provider minimum in the filter
+	KeepLatest       int    // Download only the newest N versions per provider after version filters (0 = all)
+	Prerelease       bool   // Also mirror prerelease versions (e.g. 5.0.0-beta1), excluded by default
+	Prune            bool   // Remove mirrored versions that no longer satisfy the filters after a successful pass
+	PruneDryRun      bool   // With Prune, only log what would be removed
+	Dedupe           bool   // Hardlink identical provider archives to a shared blob under <download-path>/_blobs
+	ModuleFilter     string // Comma-separated modules to mirror (namespace/name/system>min<max)
 	PlatformFilter   string
 	MaxAttempts      int           // Maximum download attempts (default: 5)
 	DownloadTimeout  time.Duration // Download timeout per attempt (default: 180s)
+	RetryBaseDelay   time.Duration // Backoff before the second attempt, doubled on each retry (0 = no backoff)
+	RetryMaxDelay    time.Duration // Cap for the retry backoff before jitter (default: 60s)
 	DownloadBinaries string        // Optional: filter for downloading HashiCorp binaries (e.g. "consul>1.21.3")
+	BinarySources    []string      // Optional: non-HashiCorp tools, "<tool>=github:<owner>/<repo>,<url template>[,<checksums url template>]"
+	RequireBinSums   bool          // Skip binaries whose SHA256SUMS cannot be fetched instead of downloading them unverified
+	EventsNDJSON     bool          // Emit one JSON event per download action to stdout
+	TLSMinVersion    uint16        // Minimum outbound TLS version for binaries downloads (default: TLS 1.2)
+	Sample           string        // Optional: deterministic sample of discovered providers ("1%" or "50")
+	VerifySignatures bool          // Verify GPG signatures of SHA256SUMS files using the package signing keys
+	RebuildMetadata  bool          // Rebuild metadata and index files from disk, then exit without downloading
+	Verify           bool          // Re-check all archives on disk, print a JSON report, then exit without downloading
+	VerifyDelete     bool          // With Verify: delete corrupt archives so the next run downloads them again
+	Once             bool          // Run a single download pass and return instead of repeating every CheckPeriod
+	CleanTmp         bool          // Remove stale .tmp files left by interrupted downloads on startup
+	CleanTmpAge      time.Duration // Minimum age of a .tmp file removed by CleanTmp (default: 1h)
+	Progress         bool          // Log aggregate progress with an ETA during download sessions
+	FailThreshold    float64       // Percentage of provider downloads allowed to fail before a run is reported as failed (0 = any)
+	MaxConnsPerHost  int           // Maximum concurrent connections per upstream host (0 = unlimited)
+	MaxBandwidth     int64         // Aggregate provider download cap in bytes per second (0 = unlimited)
+	CircuitFailures  int           // Consecutive failures to a host before its circuit opens (0 = disabled)
+	CircuitCooldown  time.Duration // How long an open circuit short-circuits requests before a probe
+	CACert           string        // PEM bundle added to the system roots for outbound TLS
+	InsecureTLS      bool          // Skip outbound certificate verification
+	UserAgent        string        // User-Agent for outbound requests
+	Headers          http.Header   // Extra headers sent with every outbound request
+	SummaryJSON      string        // Optional: write a JSON report of each download session to this path
+
+	// IncrementalDiscovery processes only providers that are new or changed in
+	// the registry listing since the last run that mirrored them without failures
+	IncrementalDiscovery bool
+
+	// Disk caps, checked before each download (0 = no cap)
+	MaxDiskBytes   int64 // bytes used by the download and staging paths
+	MaxDiskPercent int   // usage of the filesystem holding the download path
+
+	// RequireFreeSpace skips a session whose estimated downloads exceed the
+	// free space instead of only warning
+	RequireFreeSpace bool
+
+	// ProviderTimeout caps the time a session spends on one provider, from
+	// its first job, across all attempts (0 = no cap)
+	ProviderTimeout time.Duration
 }
 
 // ErrorResponse represents an error response from the registry
@@ -139,6 +317,7 @@ type ErrorDetail struct {
 // WellKnownConfig represents the .well-known/terraform.json configuration
 type WellKnownConfig struct {
 	ProvidersV1 string `json:"providers.v1"`
+	ModulesV1   string `json:"modules.v1,omitempty"`
 }
 
 // ServiceDiscovery represents the service discovery response
@@ -150,6 +329,10 @@ const (
 	// TerraformRegistryURL is the official Terraform registry URL
 	TerraformRegistryURL = "https://registry.terraform.io"
 
+	// DefaultRegistryHost is the hostname of TerraformRegistryURL, the default
+	// top-level directory of the mirror
+	DefaultRegistryHost = "registry.terraform.io"
+
 	// UserAgent for HTTP requests
 	UserAgent = "terraform-mirror/1.0"
 
@@ -161,17 +344,57 @@ const (
 
 	// Default concurrent downloads
 	DefaultMaxConcurrent = 5
+
+	// Default minimum TLS version for outbound connections
+	DefaultTLSMinVersion = tls.VersionTLS12
 )
 
-// Common supported platforms
-var SupportedPlatforms = []Platform{
-	{OS: "linux", Arch: "amd64"},
-	{OS: "linux", Arch: "arm64"},
-	{OS: "linux", Arch: "386"},
-	{OS: "darwin", Arch: "amd64"},
-	{OS: "darwin", Arch: "arm64"},
-	{OS: "windows", Arch: "amd64"},
-	{OS: "windows", Arch: "386"},
-	{OS: "freebsd", Arch: "amd64"},
-	{OS: "freebsd", Arch: "386"},
+// KnownPlatform is a platform together with the upstreams that publish it
+type KnownPlatform struct {
+	OS        string
+	Arch      string
+	Providers bool // mirrored for providers by default
+	Binaries  bool // mirrored for HashiCorp binaries by default
+}
+
+// KnownPlatforms is the single list of platforms tf-mirror knows about and
+// the one --platform-filter is validated against.
+//
+// Providers on registry.terraform.io are built by their authors, so each one
+// publishes its own subset; official providers usually cover linux, darwin,
+// windows and freebsd on amd64/386, plus linux/darwin arm64 and often linux_arm.
+// releases.hashicorp.com publishes terraform, vault, consul etc. for the same
+// set plus linux_arm and freebsd_arm; windows_arm64 only exists for recent
+// releases of a few tools. A platform that a provider or release does not
+// publish is skipped, not counted as a failure.
+var KnownPlatforms = []KnownPlatform{
+	{OS: "linux", Arch: "amd64", Providers: true, Binaries: true},
+	{OS: "linux", Arch: "arm64", Providers: true, Binaries: true},
+	{OS: "linux", Arch: "386", Providers: true, Binaries: true},
+	{OS: "linux", Arch: "arm", Binaries: true},
+	{OS: "darwin", Arch: "amd64", Providers: true, Binaries: true},
+	{OS: "darwin", Arch: "arm64", Providers: true, Binaries: true},
+	{OS: "windows", Arch: "amd64", Providers: true, Binaries: true},
+	{OS: "windows", Arch: "386", Providers: true, Binaries: true},
+	{OS: "windows", Arch: "arm64"},
+	{OS: "freebsd", Arch: "amd64", Providers: true, Binaries: true},
+	{OS: "freebsd", Arch: "386", Providers: true, Binaries: true},
+	{OS: "freebsd", Arch: "arm"},
+}
+
+// SupportedPlatforms is the default platform set for providers
+var SupportedPlatforms = defaultPlatforms(func(p KnownPlatform) bool { return p.Providers })
+
+// BinaryPlatforms is the default platform set for HashiCorp binaries
+var BinaryPlatforms = defaultPlatforms(func(p KnownPlatform) bool { return p.Binaries })
+
+// defaultPlatforms returns the known platforms selected by include
+func defaultPlatforms(include func(KnownPlatform) bool) []Platform {
+	var platforms []Platform
+	for _, p := range KnownPlatforms {
+		if include(p) {
+			platforms = append(platforms, Platform{OS: p.OS, Arch: p.Arch})
+		}
+	}
+	return platforms
 }
@@ -2,18 +2,16 @@ package binaries
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"tf-mirror/internal/common"
 	"time"
-
-	"golang.org/x/net/proxy"
 )
 
 // Platform describes a target OS/Arch for downloading binaries
@@ -22,13 +20,40 @@ type Platform struct {
 	Arch string
 }
 
-// BinaryFilter describes a tool and minimal version to download
+// ClientOptions configures the outbound HTTP client used for releases.hashicorp.com
+type ClientOptions struct {
+	ProxyURL        string // optional proxy URL (http/https/socks5)
+	TLSMinVersion   uint16 // minimum TLS version (default: TLS 1.2)
+	MaxConnsPerHost int    // maximum concurrent connections per host (0 = unlimited)
+
+	// RequireChecksums makes SHA256SUMS verification mandatory: versions whose
+	// SHA256SUMS cannot be fetched are skipped instead of downloaded unverified
+	RequireChecksums bool
+
+	// Prerelease also downloads prerelease versions (e.g. 1.7.0-rc1)
+	Prerelease bool
+
+	// Circuit breaker for releases.hashicorp.com (CircuitFailures 0 = disabled)
+	CircuitFailures int
+	CircuitCooldown time.Duration
+
+	// Extra trust for TLS-intercepting proxies, see common.OutboundTLSConfig
+	CACert      string
+	InsecureTLS bool
+
+	// User-Agent and extra headers sent with every request
+	UserAgent string
+	Headers   http.Header
+}
+
+// BinaryFilter describes a tool and the version range to download
 type BinaryFilter struct {
 	Tool       string
 	MinVersion string
+	MaxVersion string // exclusive, "" means no upper bound
 }
 
-// ParseBinaryFilter parses a filter string like "consul>1.21.3,nomad>1.6.0"
+// ParseBinaryFilter parses a filter string like "consul>1.21.3,nomad>1.6.0<1.7.0"
 func ParseBinaryFilter(filter string) ([]BinaryFilter, error) {
 	var result []BinaryFilter
 	if filter == "" {
@@ -40,47 +65,65 @@ func ParseBinaryFilter(filter string) ([]BinaryFilter, error) {
 		if part == "" {
 			continue
 		}
-		sub := strings.Split(part, ">")
-		if len(sub) != 2 {
+		if !strings.Contains(part, ">") {
+			return nil, fmt.Errorf("invalid binary filter format: %s", part)
+		}
+		tool, minVersion, maxVersion, err := common.ParseVersionRange(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary filter format: %w", err)
+		}
+		if tool == "" {
 			return nil, fmt.Errorf("invalid binary filter format: %s", part)
 		}
 		result = append(result, BinaryFilter{
-			Tool:       strings.TrimSpace(sub[0]),
-			MinVersion: strings.TrimSpace(sub[1]),
+			Tool:       tool,
+			MinVersion: minVersion,
+			MaxVersion: maxVersion,
 		})
 	}
 	return result, nil
 }
 
-// DownloadHashiCorpBinaries downloads binaries from releases.hashicorp.com
+// DownloadBinaries downloads tool binaries, from releases.hashicorp.com unless
+// sources has another BinarySource for the tool
 // downloadPath: root directory for binaries
 // filters: parsed list of BinaryFilter
 // platforms: list of platforms to download (os/arch)
-// proxyURL: optional proxy URL (http/https/socks5)
+// sources: BinarySource by tool, NewHashiCorpSource for tools without one
+// opts: outbound client options (proxy, TLS)
 // Returns: slice of DownloadedBinary with metadata about downloaded binaries
-func DownloadHashiCorpBinaries(downloadPath string, filters []BinaryFilter, platforms []Platform, logger func(format string, args ...interface{}), proxyURL ...string) ([]common.DownloadedBinary, error) {
+func DownloadBinaries(downloadPath string, filters []BinaryFilter, platforms []Platform, sources map[string]BinarySource, logger func(format string, args ...interface{}), opts ClientOptions) ([]common.DownloadedBinary, error) {
 	var downloaded []common.DownloadedBinary
 	now := time.Now().UTC()
 
-	var proxy string
-	if len(proxyURL) > 0 {
-		proxy = proxyURL[0]
-	}
-
-	httpClient, err := buildProxyHTTPClient(proxy)
+	httpClient, err := buildProxyHTTPClient(opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build proxy http client: %w", err)
 	}
 
 	for _, filter := range filters {
-		logger("Processing tool: %s (min version: %s)", filter.Tool, filter.MinVersion)
-		versions, err := fetchAvailableVersionsWithClient(filter.Tool, httpClient)
+		if filter.MaxVersion != "" {
+			logger("Processing tool: %s (versions: >=%s <%s)", filter.Tool, filter.MinVersion, filter.MaxVersion)
+		} else {
+			logger("Processing tool: %s (min version: %s)", filter.Tool, filter.MinVersion)
+		}
+		source := sources[filter.Tool]
+		if source == nil {
+			source = NewHashiCorpSource(filter.Tool)
+		}
+		versions, err := source.ListVersions(httpClient)
 		if err != nil {
 			logger("  Failed to fetch versions for %s: %v", filter.Tool, err)
 			continue
 		}
-		// semver-фильтрация через FilterVersionsByMin
-		filteredVersions := common.FilterVersionsByMin(versions, filter.MinVersion)
+		if len(versions) == 0 {
+			logger("  Warning: no versions of %s found", filter.Tool)
+		}
+		// semver-фильтрация через FilterVersionsByRange
+		filteredVersions := common.FilterVersionsByRange(versions, filter.MinVersion, filter.MaxVersion)
+		if !opts.Prerelease {
+			filteredVersions = common.FilterStableVersions(filteredVersions)
+		}
 		// Собираем map[platform] -> []version для этого tool
 		type binKey struct {
 			platform string
@@ -90,14 +133,37 @@ func DownloadHashiCorpBinaries(downloadPath string, filters []BinaryFilter, plat
 			versions   map[string]struct{}
 			downloaded time.Time
 		})
+		failedChecksums := 0
+		destDir := filepath.Join(downloadPath, filter.Tool)
 		for _, version := range filteredVersions {
+			// SHA256SUMS скачиваем один раз на tool/version
+			var sums map[string]string
+			err := fmt.Errorf("no checksums published by the %s source", filter.Tool)
+			if checksums, ok := source.(ChecksumSource); ok {
+				if sumsURL := checksums.SHASumsURL(version); sumsURL != "" {
+					sums, err = fetchSHASums(filter.Tool, version, sumsURL, destDir, httpClient)
+				}
+			}
+			if err != nil {
+				if opts.RequireChecksums {
+					logger("  Skipping %s %s: %v", filter.Tool, version, err)
+					continue
+				}
+				logger("  Warning: %s %s will not be verified: %v", filter.Tool, version, err)
+			}
 			for _, platform := range platforms {
 				platformStr := fmt.Sprintf("%s_%s", platform.OS, platform.Arch)
-				zipName := fmt.Sprintf("%s_%s_%s_%s.zip", filter.Tool, version, platform.OS, platform.Arch)
-				url := fmt.Sprintf("https://releases.hashicorp.com/%s/%s/%s", filter.Tool, version, zipName)
-				destDir := filepath.Join(downloadPath, filter.Tool)
-				destPath := filepath.Join(destDir, zipName)
-				relPath := filepath.Join(filter.Tool, zipName)
+				url, err := source.ArtifactURL(version, platform)
+				if errors.Is(err, errNotPublished) {
+					logger("  Not published for %s %s %s, skipping", filter.Tool, version, platformStr)
+					continue
+				} else if err != nil {
+					logger("  Failed to locate %s %s %s: %v", filter.Tool, version, platformStr, err)
+					continue
+				}
+				fileName := artifactFileName(filter.Tool, version, platform, url)
+				destPath := filepath.Join(destDir, fileName)
+				relPath := filepath.Join(filter.Tool, fileName)
 				key := binKey{platform: platformStr, filePath: relPath}
 				if _, ok := binMap[key]; !ok {
 					binMap[key] = struct {
@@ -117,8 +183,14 @@ func DownloadHashiCorpBinaries(downloadPath string, filters []BinaryFilter, plat
 					continue
 				}
 				logger("  Downloading: %s", url)
-				if err := downloadFileWithClient(url, destPath, httpClient); err != nil {
+				if err := downloadFileWithClient(url, destPath, httpClient); errors.Is(err, errNotPublished) {
+					logger("    Not published for %s, skipping", platformStr)
+				} else if err != nil {
 					logger("    Failed: %v", err)
+				} else if err := verifyBinary(destPath, upstreamFileName(url), sums); err != nil {
+					logger("    Failed: %v", err)
+					os.Remove(destPath)
+					failedChecksums++
 				} else {
 					logger("    Success: %s", destPath)
 					b := binMap[key]
@@ -127,12 +199,18 @@ func DownloadHashiCorpBinaries(downloadPath string, filters []BinaryFilter, plat
 				}
 			}
 		}
+		if failedChecksums > 0 {
+			logger("  %d %s files failed checksum verification", failedChecksums, filter.Tool)
+		}
 		// Собираем результат
 		for key, val := range binMap {
 			var versions []string
 			for v := range val.versions {
 				versions = append(versions, v)
 			}
+			if len(versions) == 0 {
+				continue
+			}
 			downloaded = append(downloaded, common.DownloadedBinary{
 				Tool:       filter.Tool,
 				FilePath:   key.filePath,
@@ -145,14 +223,15 @@ func DownloadHashiCorpBinaries(downloadPath string, filters []BinaryFilter, plat
 	return downloaded, nil
 }
 
-// fetchAvailableVersions scrapes the list of available versions for a tool from releases.hashicorp.com using default http.Get
+// fetchAvailableVersions scrapes the list of available versions for a tool from releases.hashicorp.com using default http.Get.
+// The release index (fetchReleaseIndex) is preferred, the scrape is a fallback.
 func fetchAvailableVersions(tool string) ([]string, error) {
 	return fetchAvailableVersionsWithClient(tool, http.DefaultClient)
 }
 
 // fetchAvailableVersionsWithClient allows using a custom http.Client (with proxy)
 func fetchAvailableVersionsWithClient(tool string, client *http.Client) ([]string, error) {
-	url := fmt.Sprintf("https://releases.hashicorp.com/%s/", tool)
+	url := fmt.Sprintf("%s/%s/", releasesBaseURL, tool)
 	resp, err := client.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
@@ -181,6 +260,9 @@ func fetchAvailableVersionsWithClient(tool string, client *http.Client) ([]strin
 // sortVersions sorts versions in ascending order
 // sortVersions больше не нужен, фильтрация теперь через common.FilterVersionsByMin
 
+// errNotPublished is returned for a 404: the release does not exist for this platform
+var errNotPublished = errors.New("not published")
+
 // downloadFile downloads a file from url to destPath using default http.Get
 func downloadFile(url, destPath string) error {
 	return downloadFileWithClient(url, destPath, http.DefaultClient)
@@ -193,6 +275,9 @@ func downloadFileWithClient(url, destPath string, client *http.Client) error {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errNotPublished, url)
+	}
 	if resp.StatusCode != 200 {
 		return fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, url)
 	}
@@ -205,51 +290,116 @@ func downloadFileWithClient(url, destPath string, client *http.Client) error {
 	return err
 }
 
+// fetchSHASums downloads the checksums of tool/version from url into destDir
+// as <tool>_<version>_SHA256SUMS (once) and parses it
+func fetchSHASums(tool, version, url, destDir string, client *http.Client) (map[string]string, error) {
+	name := fmt.Sprintf("%s_%s_SHA256SUMS", tool, version)
+	destPath := filepath.Join(destDir, name)
+	if !fileExists(destPath) {
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return nil, err
+		}
+		if err := downloadFileWithClient(url, destPath, client); err != nil {
+			os.Remove(destPath)
+			return nil, fmt.Errorf("failed to download %s: %w", name, err)
+		}
+	}
+	sums, err := common.ReadSHASums(destPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(sums) == 0 {
+		os.Remove(destPath)
+		return nil, fmt.Errorf("%s has no entries", name)
+	}
+	return sums, nil
+}
+
+// verifyBinary checks a downloaded zip against SHA256SUMS. A nil sums map
+// means verification is best-effort and was not possible.
+func verifyBinary(path, zipName string, sums map[string]string) error {
+	if sums == nil {
+		return nil
+	}
+	expected, ok := sums[zipName]
+	if !ok {
+		return fmt.Errorf("%s not listed in SHA256SUMS", zipName)
+	}
+	actual, err := common.FileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", zipName, expected, actual)
+	}
+	return nil
+}
+
+// isPlainFileName reports whether name from a release index can be used as a
+// file name inside the tool directory
+func isPlainFileName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && filepath.Base(name) == name && !strings.ContainsAny(name, `/\`)
+}
+
 // fileExists checks if a file exists
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
 
-// buildProxyHTTPClient builds an http.Client with proxy support (http, https, socks5)
-func buildProxyHTTPClient(proxyStr string) (*http.Client, error) {
-	if proxyStr == "" {
-		return http.DefaultClient, nil
+// buildProxyHTTPClient builds an http.Client with proxy support (http, https,
+// socks5, see common.ProxyFunc) and a minimum TLS version
+func buildProxyHTTPClient(opts ClientOptions) (*http.Client, error) {
+	tlsConfig, err := common.OutboundTLSConfig(opts.TLSMinVersion, opts.CACert, opts.InsecureTLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+		MaxConnsPerHost: opts.MaxConnsPerHost,
 	}
-	proxyURL, err := url.Parse(proxyStr)
+	proxyFunc, err := common.ProxyFunc(opts.ProxyURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		return nil, err
 	}
-	transport := &http.Transport{}
-	switch proxyURL.Scheme {
-	case "http", "https":
-		transport.Proxy = http.ProxyURL(proxyURL)
-	case "socks5":
-		// socks5 proxy support
-		dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, nil, proxy.Direct)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
-		}
-		transport.Dial = dialer.Dial
-	default:
-		return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	transport.Proxy = proxyFunc
+	breaker := common.NewCircuitBreaker(opts.CircuitFailures, opts.CircuitCooldown, nil)
+	return &http.Client{Transport: &headerTransport{
+		base:      breaker.Transport(transport),
+		userAgent: opts.UserAgent,
+		headers:   opts.Headers,
+	}}, nil
+}
+
+// headerTransport adds the configured User-Agent and headers to every request
+type headerTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	headers   http.Header
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.userAgent != "" || len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		common.SetRequestHeaders(req, t.userAgent, t.headers)
 	}
-	return &http.Client{Transport: transport}, nil
+	return t.base.RoundTrip(req)
 }
 
-// SupportedPlatforms returns a default list of platforms for HashiCorp binaries
+// SupportedPlatforms returns the default list of platforms for HashiCorp
+// binaries, see common.KnownPlatforms
 func SupportedPlatforms() []Platform {
-	return []Platform{
-		{OS: "linux", Arch: "amd64"},
-		{OS: "linux", Arch: "arm"},
-		{OS: "linux", Arch: "arm64"},
-		{OS: "darwin", Arch: "amd64"},
-		{OS: "darwin", Arch: "arm64"},
-		{OS: "windows", Arch: "amd64"},
-		{OS: "windows", Arch: "arm"},
-		{OS: "windows", Arch: "arm64"},
-		{OS: "freebsd", Arch: "amd64"},
+	return Platforms(common.BinaryPlatforms)
+}
+
+// Platforms converts common platforms into binaries platforms
+func Platforms(platforms []common.Platform) []Platform {
+	out := make([]Platform, 0, len(platforms))
+	for _, p := range platforms {
+		out = append(out, Platform{OS: p.OS, Arch: p.Arch})
 	}
+	return out
 }
 
 // For testing: pretty print JSON
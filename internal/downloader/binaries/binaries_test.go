@@ -0,0 +1,190 @@
+package binaries
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestVerifyBinary(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "consul_1.21.4_linux_amd64.zip")
+	if err := os.WriteFile(archive, []byte("consul archive"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := common.FileSHA256(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SHA256SUMS as published on releases.hashicorp.com
+	fixture := filepath.Join(dir, "consul_1.21.4_SHA256SUMS")
+	content := sum + "  consul_1.21.4_linux_amd64.zip\n" +
+		strings.Repeat("0", 64) + "  consul_1.21.4_darwin_arm64.zip\n"
+	if err := os.WriteFile(fixture, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sums, err := common.ReadSHASums(fixture)
+	if err != nil {
+		t.Fatalf("ReadSHASums: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		zipName string
+		sums    map[string]string
+		wantErr string
+	}{
+		{name: "listed with a matching sum", zipName: "consul_1.21.4_linux_amd64.zip", sums: sums},
+		{name: "upper-case sum", zipName: "consul_1.21.4_linux_amd64.zip", sums: map[string]string{"consul_1.21.4_linux_amd64.zip": strings.ToUpper(sum)}},
+		{name: "sum of another archive", zipName: "consul_1.21.4_darwin_arm64.zip", sums: sums, wantErr: "checksum mismatch"},
+		{name: "not listed", zipName: "consul_1.21.4_windows_amd64.zip", sums: sums, wantErr: "not listed"},
+		{name: "best effort without SHA256SUMS", zipName: "consul_1.21.4_linux_amd64.zip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyBinary(archive, tt.zipName, tt.sums)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("verifyBinary: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifyBinary error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// unsignedSource is a mockSource whose SHA256SUMS cannot be fetched
+type unsignedSource struct {
+	*mockSource
+	sumsURL string
+}
+
+func (s *unsignedSource) SHASumsURL(version string) string {
+	return s.sumsURL
+}
+
+func TestRequireChecksums(t *testing.T) {
+	platforms := []Platform{{OS: "linux", Arch: "amd64"}}
+	tests := []struct {
+		name      string
+		require   bool
+		wantFiles int
+	}{
+		{name: "best effort downloads unverified", require: false, wantFiles: 1},
+		{name: "mandatory skips the version", require: true, wantFiles: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newMockUpstream(t, nil)
+			missing := httptest.NewServer(http.NotFoundHandler())
+			defer missing.Close()
+			source := &unsignedSource{
+				mockSource: &mockSource{url: upstream.URL, versions: []string{"1.2.0"}},
+				sumsURL:    missing.URL + "/SHA256SUMS",
+			}
+			downloadPath := t.TempDir()
+
+			_, err := DownloadBinaries(downloadPath, []BinaryFilter{{Tool: "tool", MinVersion: "1.2.0"}}, platforms,
+				map[string]BinarySource{"tool": source}, func(string, ...interface{}) {}, ClientOptions{RequireChecksums: tt.require})
+			if err != nil {
+				t.Fatalf("DownloadBinaries: %v", err)
+			}
+			archives, _ := filepath.Glob(filepath.Join(downloadPath, "tool", "*.tar.gz"))
+			if len(archives) != tt.wantFiles {
+				t.Errorf("downloaded %v, want %d archives", archives, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestSupportedPlatformsInSync(t *testing.T) {
+	known := make(map[string]common.KnownPlatform)
+	for _, p := range common.KnownPlatforms {
+		key := p.OS + "_" + p.Arch
+		if _, ok := known[key]; ok {
+			t.Errorf("%s listed twice in common.KnownPlatforms", key)
+		}
+		known[key] = p
+	}
+
+	var binaries []common.Platform
+	for _, p := range SupportedPlatforms() {
+		binaries = append(binaries, common.Platform{OS: p.OS, Arch: p.Arch})
+	}
+	tests := []struct {
+		name      string
+		platforms []common.Platform
+		flag      func(common.KnownPlatform) bool
+	}{
+		{name: "providers", platforms: common.SupportedPlatforms, flag: func(p common.KnownPlatform) bool { return p.Providers }},
+		{name: "binaries", platforms: binaries, flag: func(p common.KnownPlatform) bool { return p.Binaries }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected := make(map[string]bool)
+			for _, p := range tt.platforms {
+				key := p.OS + "_" + p.Arch
+				selected[key] = true
+				if kp, ok := known[key]; !ok || !tt.flag(kp) {
+					t.Errorf("%s is a default but not marked in common.KnownPlatforms", key)
+				}
+			}
+			for key, kp := range known {
+				if tt.flag(kp) && !selected[key] {
+					t.Errorf("%s is marked in common.KnownPlatforms but not a default", key)
+				}
+			}
+			// every default passes --platform-filter validation
+			for key := range selected {
+				if _, err := common.NewPlatformFilter(key); err != nil {
+					t.Errorf("NewPlatformFilter(%q): %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildProxyHTTPClientCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		opts       ClientOptions
+		wantFailed bool
+	}{
+		{name: "untrusted server", wantFailed: true},
+		{name: "CA bundle", opts: ClientOptions{CACert: bundle}},
+		{name: "insecure", opts: ClientOptions{InsecureTLS: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := buildProxyHTTPClient(tt.opts)
+			if err != nil {
+				t.Fatalf("buildProxyHTTPClient: %v", err)
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if failed := err != nil; failed != tt.wantFailed {
+				t.Errorf("Get = %v, want failure %t", err, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package binaries
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// releasesBaseURL is the HashiCorp releases site
+const releasesBaseURL = "https://releases.hashicorp.com"
+
+// releaseIndex is the JSON index of a tool, <releasesBaseURL>/<tool>/index.json
+type releaseIndex struct {
+	Name     string                     `json:"name"`
+	Versions map[string]*releaseVersion `json:"versions"`
+}
+
+// releaseVersion is one version of a releaseIndex
+type releaseVersion struct {
+	Version string         `json:"version"`
+	Shasums string         `json:"shasums"` // name of the SHA256SUMS file
+	Builds  []releaseBuild `json:"builds"`
+}
+
+// releaseBuild is one published archive of a releaseVersion
+type releaseBuild struct {
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Filename string `json:"filename"`
+	URL      string `json:"url"`
+}
+
+// fetchReleaseIndex downloads and parses the JSON index of tool
+func fetchReleaseIndex(tool string, client *http.Client) (*releaseIndex, error) {
+	url := fmt.Sprintf("%s/%s/index.json", releasesBaseURL, tool)
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, url)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", url, err)
+	}
+	return parseReleaseIndex(data)
+}
+
+// parseReleaseIndex parses a release index. An index without versions is an
+// error, so a changed format is noticed instead of mirroring nothing.
+func parseReleaseIndex(data []byte) (*releaseIndex, error) {
+	var index releaseIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse release index: %w", err)
+	}
+	if len(index.Versions) == 0 {
+		return nil, fmt.Errorf("release index of %q lists no versions", index.Name)
+	}
+	return &index, nil
+}
+
+// versionList returns the versions of the index. Versions with build
+// metadata (enterprise builds such as 1.15.0+ent) are left out, like the
+// releases page scrape does.
+func (index *releaseIndex) versionList() []string {
+	versions := make([]string, 0, len(index.Versions))
+	for version := range index.Versions {
+		if !strings.Contains(version, "+") {
+			versions = append(versions, version)
+		}
+	}
+	return versions
+}
+
+// release returns the entry of version, nil without an index
+func (index *releaseIndex) release(version string) *releaseVersion {
+	if index == nil {
+		return nil
+	}
+	return index.Versions[version]
+}
+
+// build returns the archive published for os/arch, nil if there is none
+func (release *releaseVersion) build(osName, arch string) *releaseBuild {
+	for i := range release.Builds {
+		if release.Builds[i].OS == osName && release.Builds[i].Arch == arch {
+			return &release.Builds[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package binaries
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// loadReleaseIndex parses the fixture index.json of terraform
+func loadReleaseIndex(t *testing.T) *releaseIndex {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "terraform-index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := parseReleaseIndex(data)
+	if err != nil {
+		t.Fatalf("parseReleaseIndex: %v", err)
+	}
+	return index
+}
+
+func TestParseReleaseIndex(t *testing.T) {
+	fixture, err := os.ReadFile(filepath.Join("testdata", "terraform-index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		data         string
+		wantVersions []string
+		wantErr      bool
+	}{
+		{name: "fixture", data: string(fixture), wantVersions: []string{"1.5.7", "1.6.0", "1.7.0-rc1"}},
+		{name: "no versions", data: `{"name":"terraform","versions":{}}`, wantErr: true},
+		{name: "changed format", data: `{"name":"terraform","releases":[{"version":"1.6.0"}]}`, wantErr: true},
+		{name: "not JSON", data: `<html>Terraform releases</html>`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index, err := parseReleaseIndex([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReleaseIndex error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			versions := index.versionList()
+			slices.Sort(versions)
+			if !slices.Equal(versions, tt.wantVersions) {
+				t.Errorf("versionList = %v, want %v", versions, tt.wantVersions)
+			}
+		})
+	}
+}
+
+func TestHashiCorpSourceFromReleaseIndex(t *testing.T) {
+	source := &hashiCorpSource{tool: "terraform", index: loadReleaseIndex(t)}
+
+	tests := []struct {
+		name        string
+		version     string
+		platform    Platform
+		wantURL     string
+		wantSums    string
+		unpublished bool
+	}{
+		{
+			name:     "build listed in the index",
+			version:  "1.6.0",
+			platform: Platform{OS: "darwin", Arch: "arm64"},
+			wantURL:  "https://releases.hashicorp.com/terraform/1.6.0/terraform_1.6.0_darwin_arm64.zip",
+			wantSums: "https://releases.hashicorp.com/terraform/1.6.0/terraform_1.6.0_SHA256SUMS",
+		},
+		{
+			name:     "URL outside the releases site",
+			version:  "1.6.0",
+			platform: Platform{OS: "windows", Arch: "amd64"},
+			wantURL:  "https://releases.hashicorp.com/terraform/1.6.0/terraform_1.6.0_windows_amd64.zip",
+			wantSums: "https://releases.hashicorp.com/terraform/1.6.0/terraform_1.6.0_SHA256SUMS",
+		},
+		{
+			name:        "platform missing from the index",
+			version:     "1.5.7",
+			platform:    Platform{OS: "darwin", Arch: "arm64"},
+			wantSums:    "https://releases.hashicorp.com/terraform/1.5.7/terraform_1.5.7_SHA256SUMS",
+			unpublished: true,
+		},
+		{
+			name:     "version missing from the index",
+			version:  "1.4.0",
+			platform: Platform{OS: "linux", Arch: "amd64"},
+			wantURL:  "https://releases.hashicorp.com/terraform/1.4.0/terraform_1.4.0_linux_amd64.zip",
+			wantSums: "https://releases.hashicorp.com/terraform/1.4.0/terraform_1.4.0_SHA256SUMS",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := source.ArtifactURL(tt.version, tt.platform)
+			if tt.unpublished {
+				if !errors.Is(err, errNotPublished) {
+					t.Errorf("ArtifactURL = %q, %v, want not published", url, err)
+				}
+			} else if err != nil || url != tt.wantURL {
+				t.Errorf("ArtifactURL = %q, %v, want %q", url, err, tt.wantURL)
+			}
+			if sums := source.SHASumsURL(tt.version); sums != tt.wantSums {
+				t.Errorf("SHASumsURL = %q, want %q", sums, tt.wantSums)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package binaries
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// BinarySource lists the versions of one tool and locates its archives. The
+// HashiCorp releases site is the default source; --binary-source adds others.
+type BinarySource interface {
+	// ListVersions returns the published versions of the tool
+	ListVersions(client *http.Client) ([]string, error)
+	// ArtifactURL returns the download URL of a version for a platform, or
+	// an error wrapping errNotPublished when there is no such build
+	ArtifactURL(version string, platform Platform) (string, error)
+}
+
+// ChecksumSource is implemented by sources that publish a SHA256SUMS-style
+// file per version, listing "<sha256>  <file name>" lines
+type ChecksumSource interface {
+	// SHASumsURL returns the URL of the checksums of version, "" if none
+	SHASumsURL(version string) string
+}
+
+// githubAPIBaseURL is the GitHub REST API used to list release tags
+const githubAPIBaseURL = "https://api.github.com"
+
+// githubMaxPages bounds the release pages (100 releases each) listed per tool
+const githubMaxPages = 10
+
+// hashiCorpSource is the releases.hashicorp.com source of a tool. The JSON
+// release index is preferred; the releases page is scraped when it is
+// unavailable, and URLs then follow the releases.hashicorp.com layout.
+type hashiCorpSource struct {
+	tool  string
+	index *releaseIndex
+}
+
+// NewHashiCorpSource returns the releases.hashicorp.com source of tool
+func NewHashiCorpSource(tool string) BinarySource {
+	return &hashiCorpSource{tool: tool}
+}
+
+// ListVersions implements BinarySource
+func (s *hashiCorpSource) ListVersions(client *http.Client) ([]string, error) {
+	index, err := fetchReleaseIndex(s.tool, client)
+	if err == nil {
+		s.index = index
+		return index.versionList(), nil
+	}
+	versions, scrapeErr := fetchAvailableVersionsWithClient(s.tool, client)
+	if scrapeErr != nil {
+		return nil, fmt.Errorf("release index: %v; releases page: %w", err, scrapeErr)
+	}
+	return versions, nil
+}
+
+// ArtifactURL implements BinarySource
+func (s *hashiCorpSource) ArtifactURL(version string, platform Platform) (string, error) {
+	zipName := fmt.Sprintf("%s_%s_%s_%s.zip", s.tool, version, platform.OS, platform.Arch)
+	release := s.index.release(version)
+	if release == nil {
+		return fmt.Sprintf("%s/%s/%s/%s", releasesBaseURL, s.tool, version, zipName), nil
+	}
+	build := release.build(platform.OS, platform.Arch)
+	if build == nil {
+		return "", fmt.Errorf("%w: %s %s %s_%s", errNotPublished, s.tool, version, platform.OS, platform.Arch)
+	}
+	if strings.HasPrefix(build.URL, releasesBaseURL+"/") {
+		return build.URL, nil
+	}
+	if isPlainFileName(build.Filename) {
+		zipName = build.Filename
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", releasesBaseURL, s.tool, version, zipName), nil
+}
+
+// SHASumsURL implements ChecksumSource
+func (s *hashiCorpSource) SHASumsURL(version string) string {
+	name := fmt.Sprintf("%s_%s_SHA256SUMS", s.tool, version)
+	if release := s.index.release(version); release != nil && isPlainFileName(release.Shasums) {
+		name = release.Shasums
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", releasesBaseURL, s.tool, version, name)
+}
+
+// templateSource lists versions from the GitHub releases of a repository and
+// builds URLs from templates with {version}, {os}, {arch} and {exe} (".exe" on
+// windows) placeholders
+type templateSource struct {
+	repo         string // owner/repo
+	artifactURL  string
+	checksumsURL string
+}
+
+// ListVersions implements BinarySource: the tags of published releases,
+// without a leading "v"
+func (s *templateSource) ListVersions(client *http.Client) ([]string, error) {
+	var versions []string
+	for page := 1; page <= githubMaxPages; page++ {
+		releasesURL := fmt.Sprintf("%s/repos/%s/releases?per_page=100&page=%d", githubAPIBaseURL, s.repo, page)
+		resp, err := client.Get(releasesURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s: %w", releasesURL, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", releasesURL, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status code %d for %s", resp.StatusCode, releasesURL)
+		}
+		var releases []struct {
+			TagName string `json:"tag_name"`
+			Draft   bool   `json:"draft"`
+		}
+		if err := json.Unmarshal(data, &releases); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", releasesURL, err)
+		}
+		for _, release := range releases {
+			if !release.Draft && release.TagName != "" {
+				versions = append(versions, strings.TrimPrefix(release.TagName, "v"))
+			}
+		}
+		if len(releases) < 100 {
+			break
+		}
+	}
+	return versions, nil
+}
+
+// ArtifactURL implements BinarySource
+func (s *templateSource) ArtifactURL(version string, platform Platform) (string, error) {
+	return expandTemplate(s.artifactURL, version, platform), nil
+}
+
+// SHASumsURL implements ChecksumSource
+func (s *templateSource) SHASumsURL(version string) string {
+	if s.checksumsURL == "" {
+		return ""
+	}
+	return expandTemplate(s.checksumsURL, version, Platform{})
+}
+
+// expandTemplate fills the placeholders of a URL template
+func expandTemplate(template, version string, platform Platform) string {
+	exe := ""
+	if platform.OS == "windows" {
+		exe = ".exe"
+	}
+	return strings.NewReplacer(
+		"{version}", version,
+		"{os}", platform.OS,
+		"{arch}", platform.Arch,
+		"{exe}", exe,
+	).Replace(template)
+}
+
+// ParseBinarySources parses --binary-source values of the form
+// "<tool>=github:<owner>/<repo>,<artifact URL template>[,<checksums URL template>]"
+// into sources by tool. Tools without a source use NewHashiCorpSource.
+func ParseBinarySources(values []string) (map[string]BinarySource, error) {
+	sources := make(map[string]BinarySource)
+	for _, value := range values {
+		tool, spec, ok := strings.Cut(strings.TrimSpace(value), "=")
+		if !ok || tool == "" {
+			return nil, fmt.Errorf("invalid binary source %q: expected <tool>=github:<owner>/<repo>,<url template>", value)
+		}
+		parts := strings.Split(spec, ",")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid binary source %q: expected <tool>=github:<owner>/<repo>,<url template>[,<checksums url template>]", value)
+		}
+		repo, ok := strings.CutPrefix(parts[0], "github:")
+		if !ok || strings.Count(repo, "/") != 1 || strings.HasPrefix(repo, "/") || strings.HasSuffix(repo, "/") {
+			return nil, fmt.Errorf("invalid binary source %q: versions must come from github:<owner>/<repo>", value)
+		}
+		source := &templateSource{repo: repo, artifactURL: parts[1]}
+		if len(parts) == 3 {
+			source.checksumsURL = parts[2]
+		}
+		for _, template := range []string{source.artifactURL, source.checksumsURL} {
+			if template == "" {
+				continue
+			}
+			if err := validateTemplate(template); err != nil {
+				return nil, fmt.Errorf("invalid binary source %q: %w", value, err)
+			}
+		}
+		if !strings.Contains(source.artifactURL, "{version}") {
+			return nil, fmt.Errorf("invalid binary source %q: the url template needs {version}", value)
+		}
+		if _, exists := sources[tool]; exists {
+			return nil, fmt.Errorf("duplicate binary source for %s", tool)
+		}
+		sources[tool] = source
+	}
+	return sources, nil
+}
+
+// validateTemplate checks that a URL template expands to an http(s) URL
+func validateTemplate(template string) error {
+	parsed, err := url.Parse(expandTemplate(template, "1.0.0", Platform{OS: "linux", Arch: "amd64"}))
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("url template must be an http(s) URL")
+	}
+	return nil
+}
+
+// artifactFileName is the name an archive is stored under: upstream names
+// often lack the version (tflint_linux_amd64.zip), so every archive is named
+// <tool>_<version>_<os>_<arch> plus the extension of the upstream file
+func artifactFileName(tool, version string, platform Platform, artifactURL string) string {
+	return fmt.Sprintf("%s_%s_%s_%s%s", tool, version, platform.OS, platform.Arch, archiveExtension(upstreamFileName(artifactURL)))
+}
+
+// upstreamFileName returns the last path segment of an artifact URL, the name
+// its SHA256SUMS entry uses
+func upstreamFileName(artifactURL string) string {
+	if parsed, err := url.Parse(artifactURL); err == nil {
+		return path.Base(parsed.Path)
+	}
+	return path.Base(artifactURL)
+}
+
+// archiveExtension returns the extension kept for a stored archive
+func archiveExtension(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip", ".exe"} {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
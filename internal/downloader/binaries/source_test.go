@@ -0,0 +1,189 @@
+package binaries
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mockSource serves the versions it lists from an httptest server:
+// <url>/<version>/tool_<os>_<arch>.tar.gz and <url>/<version>/SHA256SUMS
+type mockSource struct {
+	url         string
+	versions    []string
+	unpublished map[string]bool // "<version> <os>_<arch>" without a build
+}
+
+func (m *mockSource) ListVersions(client *http.Client) ([]string, error) {
+	return m.versions, nil
+}
+
+func (m *mockSource) ArtifactURL(version string, platform Platform) (string, error) {
+	if m.unpublished[version+" "+platform.OS+"_"+platform.Arch] {
+		return "", fmt.Errorf("%w: %s %s_%s", errNotPublished, version, platform.OS, platform.Arch)
+	}
+	return fmt.Sprintf("%s/%s/tool_%s_%s.tar.gz", m.url, version, platform.OS, platform.Arch), nil
+}
+
+func (m *mockSource) SHASumsURL(version string) string {
+	return fmt.Sprintf("%s/%s/SHA256SUMS", m.url, version)
+}
+
+// newMockUpstream serves archives whose content is their path. badSums lists
+// versions whose SHA256SUMS do not match the archives.
+func newMockUpstream(t *testing.T, badSums map[string]bool) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if file != "SHA256SUMS" {
+			fmt.Fprint(w, r.URL.Path)
+			return
+		}
+		for _, platform := range []string{"linux_amd64", "darwin_arm64"} {
+			name := "tool_" + platform + ".tar.gz"
+			sum := sha256.Sum256([]byte("/" + version + "/" + name))
+			if badSums[version] {
+				sum = sha256.Sum256([]byte("tampered"))
+			}
+			fmt.Fprintf(w, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestDownloadBinariesFromSource(t *testing.T) {
+	platforms := []Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}
+	tests := []struct {
+		name        string
+		filter      BinaryFilter
+		prerelease  bool
+		badSums     map[string]bool
+		unpublished map[string]bool
+		wantFiles   []string
+	}{
+		{
+			name:      "versions in range for every platform",
+			filter:    BinaryFilter{Tool: "tool", MinVersion: "1.1.0"},
+			wantFiles: []string{"tool_1.1.0_darwin_arm64.tar.gz", "tool_1.1.0_linux_amd64.tar.gz", "tool_1.2.0_darwin_arm64.tar.gz", "tool_1.2.0_linux_amd64.tar.gz"},
+		},
+		{
+			name:      "exclusive maximum",
+			filter:    BinaryFilter{Tool: "tool", MinVersion: "1.0.0", MaxVersion: "1.1.0"},
+			wantFiles: []string{"tool_1.0.0_darwin_arm64.tar.gz", "tool_1.0.0_linux_amd64.tar.gz"},
+		},
+		{
+			name:      "prereleases excluded by default",
+			filter:    BinaryFilter{Tool: "tool", MinVersion: "1.2.0"},
+			wantFiles: []string{"tool_1.2.0_darwin_arm64.tar.gz", "tool_1.2.0_linux_amd64.tar.gz"},
+		},
+		{
+			name:       "prereleases",
+			filter:     BinaryFilter{Tool: "tool", MinVersion: "1.2.0"},
+			prerelease: true,
+			wantFiles:  []string{"tool_1.2.0_darwin_arm64.tar.gz", "tool_1.2.0_linux_amd64.tar.gz", "tool_1.3.0-rc1_darwin_arm64.tar.gz", "tool_1.3.0-rc1_linux_amd64.tar.gz"},
+		},
+		{
+			name:      "checksum mismatch is removed",
+			filter:    BinaryFilter{Tool: "tool", MinVersion: "1.1.0"},
+			badSums:   map[string]bool{"1.1.0": true},
+			wantFiles: []string{"tool_1.2.0_darwin_arm64.tar.gz", "tool_1.2.0_linux_amd64.tar.gz"},
+		},
+		{
+			name:        "unpublished platform is skipped",
+			filter:      BinaryFilter{Tool: "tool", MinVersion: "1.2.0"},
+			unpublished: map[string]bool{"1.2.0 darwin_arm64": true},
+			wantFiles:   []string{"tool_1.2.0_linux_amd64.tar.gz"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newMockUpstream(t, tt.badSums)
+			source := &mockSource{url: upstream.URL, versions: []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0-rc1"}, unpublished: tt.unpublished}
+			downloadPath := t.TempDir()
+
+			_, err := DownloadBinaries(downloadPath, []BinaryFilter{tt.filter}, platforms,
+				map[string]BinarySource{"tool": source}, func(string, ...interface{}) {}, ClientOptions{Prerelease: tt.prerelease})
+			if err != nil {
+				t.Fatalf("DownloadBinaries: %v", err)
+			}
+
+			archives, _ := filepath.Glob(filepath.Join(downloadPath, "tool", "*.tar.gz"))
+			var got []string
+			for _, archive := range archives {
+				got = append(got, filepath.Base(archive))
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != strings.Join(tt.wantFiles, " ") {
+				t.Errorf("archives = %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestParseBinarySources(t *testing.T) {
+	tests := []struct {
+		name          string
+		value         string
+		wantErr       bool
+		wantArtifact  string
+		wantChecksums string
+	}{
+		{
+			name:         "artifact template",
+			value:        "tflint=github:terraform-linters/tflint,https://github.com/terraform-linters/tflint/releases/download/v{version}/tflint_{os}_{arch}.zip",
+			wantArtifact: "https://github.com/terraform-linters/tflint/releases/download/v0.50.0/tflint_windows_amd64.zip",
+		},
+		{
+			name:          "with checksums",
+			value:         "terragrunt=github:gruntwork-io/terragrunt,https://example.com/v{version}/terragrunt_{os}_{arch}{exe},https://example.com/v{version}/SHA256SUMS",
+			wantArtifact:  "https://example.com/v0.50.0/terragrunt_windows_amd64.exe",
+			wantChecksums: "https://example.com/v0.50.0/SHA256SUMS",
+		},
+		{name: "missing tool", value: "=github:a/b,https://example.com/{version}", wantErr: true},
+		{name: "not github", value: "tool=gitlab:a/b,https://example.com/{version}", wantErr: true},
+		{name: "bad repo", value: "tool=github:a,https://example.com/{version}", wantErr: true},
+		{name: "no version placeholder", value: "tool=github:a/b,https://example.com/latest.zip", wantErr: true},
+		{name: "not http", value: "tool=github:a/b,ftp://example.com/{version}", wantErr: true},
+		{name: "too many parts", value: "tool=github:a/b,https://a/{version},https://b,https://c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, err := ParseBinarySources([]string{tt.value})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseBinarySources(%q) succeeded, want an error", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBinarySources(%q): %v", tt.value, err)
+			}
+			if len(sources) != 1 {
+				t.Fatalf("sources = %v, want one", sources)
+			}
+			for _, source := range sources {
+				artifact, err := source.ArtifactURL("0.50.0", Platform{OS: "windows", Arch: "amd64"})
+				if err != nil || artifact != tt.wantArtifact {
+					t.Errorf("ArtifactURL = %q, %v, want %q", artifact, err, tt.wantArtifact)
+				}
+				if got := source.(ChecksumSource).SHASumsURL("0.50.0"); got != tt.wantChecksums {
+					t.Errorf("SHASumsURL = %q, want %q", got, tt.wantChecksums)
+				}
+			}
+		})
+	}
+}
+
+func TestParseBinarySourcesDuplicate(t *testing.T) {
+	value := "tool=github:a/b,https://example.com/{version}"
+	if _, err := ParseBinarySources([]string{value, value}); err == nil {
+		t.Error("duplicate sources accepted")
+	}
+}
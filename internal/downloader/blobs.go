@@ -0,0 +1,132 @@
+package downloader
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// blobsDir is the content-addressed store under the download path.
+// Blobs are keyed by the archive SHA256 rather than the h1: hash: h1 only
+// covers the files inside the zip, and a hardlink must be byte-identical.
+const blobsDir = "_blobs"
+
+// blobPath returns <download-path>/_blobs/<ab>/<sha256>
+func (s *Service) blobPath(digest string) string {
+	return filepath.Join(s.config.DownloadPath, blobsDir, digest[:2], digest)
+}
+
+// dedupeArchive hardlinks a verified archive to its blob. If the blob already
+// exists the archive is replaced by a link to it, otherwise the archive becomes
+// the blob. When hardlinks are not supported (other filesystem, some network
+// mounts) the archive simply stays an independent copy.
+func (s *Service) dedupeArchive(path, digest string) {
+	if !s.config.Dedupe || len(digest) != 64 {
+		return
+	}
+	digest = strings.ToLower(digest)
+	blob := s.blobPath(digest)
+
+	if err := createDirIfNotExists(filepath.Dir(blob)); err != nil {
+		s.logger.Debug("Dedupe: failed to create %s: %v", filepath.Dir(blob), err)
+		return
+	}
+	err := os.Link(path, blob)
+	if err == nil {
+		return
+	}
+	if !os.IsExist(err) {
+		s.logger.Debug("Dedupe: hardlink not supported for %s, keeping a copy: %v", path, err)
+		return
+	}
+
+	// Blob уже есть — заменяем архив ссылкой на него
+	archiveInfo, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	blobInfo, err := os.Stat(blob)
+	if err != nil || os.SameFile(archiveInfo, blobInfo) {
+		return
+	}
+	if blobInfo.Size() != archiveInfo.Size() {
+		s.logger.Warn("Dedupe: blob %s has unexpected size, replacing it", blob)
+		if err := renameFile(path, blob); err == nil {
+			err = os.Link(blob, path)
+		}
+		if err != nil {
+			s.logger.Warn("Dedupe: failed to replace blob %s: %v", blob, err)
+		}
+		return
+	}
+
+	tmp := path + ".tmp"
+	removeFile(tmp)
+	if err := os.Link(blob, tmp); err != nil {
+		s.logger.Debug("Dedupe: hardlink not supported for %s, keeping a copy: %v", path, err)
+		return
+	}
+	if err := renameFile(tmp, path); err != nil {
+		removeFile(tmp)
+		s.logger.Warn("Dedupe: failed to link %s to %s: %v", path, blob, err)
+		return
+	}
+	s.logger.Debug("Dedupe: %s linked to %s", path, blob)
+}
+
+// pruneBlobs removes blobs no longer linked from any archive in the download
+// path, e.g. after prune or a re-download with new content.
+// Matching is done with os.SameFile so it works without link counts.
+func (s *Service) pruneBlobs() {
+	if !s.config.Dedupe {
+		return
+	}
+	root := filepath.Join(s.config.DownloadPath, blobsDir)
+	if !fileExists(root) {
+		return
+	}
+
+	// Сканируем весь download path: его могут делить зеркала нескольких реестров
+	archives := make(map[int64][]os.FileInfo)
+	filepath.WalkDir(s.config.DownloadPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path == root {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".zip") {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			archives[info.Size()] = append(archives[info.Size()], info)
+		}
+		return nil
+	})
+
+	removed := 0
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		for _, archive := range archives[info.Size()] {
+			if os.SameFile(info, archive) {
+				return nil
+			}
+		}
+		if err := removeFile(path); err != nil {
+			s.logger.Warn("Dedupe: failed to remove orphan blob %s: %v", path, err)
+			return nil
+		}
+		removed++
+		return nil
+	})
+	if removed > 0 {
+		s.logger.Info("Dedupe: removed %d orphan blobs", removed)
+	}
+}
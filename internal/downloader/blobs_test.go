@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+// writeArchive creates path with content and returns its SHA256
+func writeArchive(t *testing.T, path, content string) string {
+	t.Helper()
+	writeTestFile(t, path, content)
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// sameFile reports whether a and b are hardlinks of one file
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// tmpfsDir returns a directory on /dev/shm, removed with the test, or skips
+// the test when /dev/shm is not a separate filesystem from dir
+func tmpfsDir(t *testing.T, dir string) string {
+	t.Helper()
+	shm, err := os.MkdirTemp("/dev/shm", "tf-mirror-test")
+	if err != nil {
+		t.Skipf("no tmpfs at /dev/shm: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(shm) })
+	probe := filepath.Join(dir, "probe")
+	writeTestFile(t, probe, "probe")
+	defer os.Remove(probe)
+	if err := os.Link(probe, filepath.Join(shm, "probe")); err == nil {
+		t.Skip("/dev/shm is on the same filesystem as the download path")
+	}
+	return shm
+}
+
+func TestDedupeArchive(t *testing.T) {
+	const content = "archive"
+	tests := []struct {
+		name     string
+		dedupe   bool
+		setup    func(t *testing.T, s *Service, digest string) // runs before the archive is deduplicated
+		wantBlob bool                                          // the archive ends up linked to its blob
+	}{
+		{name: "disabled", dedupe: false},
+		{name: "first archive becomes the blob", dedupe: true, wantBlob: true},
+		{
+			name:   "archive is replaced by the existing blob",
+			dedupe: true,
+			setup: func(t *testing.T, s *Service, digest string) {
+				writeTestFile(t, s.blobPath(digest), content)
+			},
+			wantBlob: true,
+		},
+		{
+			name:   "blob of the wrong size is replaced",
+			dedupe: true,
+			setup: func(t *testing.T, s *Service, digest string) {
+				writeTestFile(t, s.blobPath(digest), "truncated")
+			},
+			wantBlob: true,
+		},
+		{
+			// a cross-device link fails like an unsupported one
+			name:   "hardlinks not supported",
+			dedupe: true,
+			setup: func(t *testing.T, s *Service, digest string) {
+				shm := tmpfsDir(t, s.config.DownloadPath)
+				if err := os.Symlink(shm, filepath.Join(s.config.DownloadPath, blobsDir)); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &common.DownloaderConfig{Dedupe: tt.dedupe})
+			archive := filepath.Join(s.config.DownloadPath, "registry.terraform.io", "hashicorp", "null", "terraform-provider-null_3.2.0_linux_amd64.zip")
+			digest := writeArchive(t, archive, content)
+			if tt.setup != nil {
+				tt.setup(t, s, digest)
+			}
+
+			s.dedupeArchive(archive, digest)
+
+			if got := sameFile(t, archive, s.blobPath(digest)); got != tt.wantBlob {
+				t.Errorf("archive linked to its blob = %t, want %t", got, tt.wantBlob)
+			}
+			if data, err := os.ReadFile(archive); err != nil || string(data) != content {
+				t.Errorf("archive content = %q, %v, want %q", data, err, content)
+			}
+		})
+	}
+}
+
+func TestDedupeSharesIdenticalArchives(t *testing.T) {
+	s := newTestService(t, &common.DownloaderConfig{Dedupe: true})
+	dir := filepath.Join(s.config.DownloadPath, "registry.terraform.io", "hashicorp")
+	first := filepath.Join(dir, "null", "terraform-provider-null_3.2.0_linux_amd64.zip")
+	second := filepath.Join(dir, "null", "terraform-provider-null_3.2.1_linux_amd64.zip")
+	other := filepath.Join(dir, "random", "terraform-provider-random_3.6.0_linux_amd64.zip")
+
+	digest := writeArchive(t, first, "same")
+	writeArchive(t, second, "same")
+	otherDigest := writeArchive(t, other, "different")
+	for _, archive := range []struct{ path, digest string }{{first, digest}, {second, digest}, {other, otherDigest}} {
+		s.dedupeArchive(archive.path, archive.digest)
+	}
+
+	if !sameFile(t, first, second) {
+		t.Error("identical archives are stored twice")
+	}
+	if sameFile(t, first, other) {
+		t.Error("different archives share a blob")
+	}
+
+	// Removing the only archive of a blob orphans it
+	if err := os.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+	s.pruneBlobs()
+	tests := []struct {
+		blob string
+		want bool
+	}{
+		{blob: s.blobPath(digest), want: true},
+		{blob: s.blobPath(otherDigest), want: false},
+	}
+	for _, tt := range tests {
+		if got := fileExists(tt.blob); got != tt.want {
+			t.Errorf("blob %s exists = %t after prune, want %t", filepath.Base(tt.blob), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkpointFile is the name of the sync checkpoint inside the download path
+const checkpointFile = ".tf-mirror-checkpoint.json"
+
+// syncCheckpoint records providers fully processed by an in-progress session,
+// so a session that is interrupted can resume without re-planning them.
+// The checkpoint is removed once a session completes.
+type syncCheckpoint struct {
+	StartedAt time.Time            `json:"started_at"`
+	UpdatedAt time.Time            `json:"updated_at"`
+	Completed map[string]time.Time `json:"completed"`
+
+	path    string
+	mu      sync.Mutex
+	pending map[string]int  // outstanding jobs per provider
+	failed  map[string]bool // providers with at least one failed job
+}
+
+// loadCheckpoint reads the checkpoint at path or starts a new one
+func loadCheckpoint(path string) (*syncCheckpoint, error) {
+	cp := &syncCheckpoint{
+		StartedAt: time.Now().UTC(),
+		Completed: make(map[string]time.Time),
+		path:      path,
+		pending:   make(map[string]int),
+		failed:    make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(cp.path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return cp, fmt.Errorf("failed to parse checkpoint: %w", err)
+	}
+	if cp.Completed == nil {
+		cp.Completed = make(map[string]time.Time)
+	}
+	return cp, nil
+}
+
+// IsCompleted returns true if the provider was completed by an earlier, interrupted session
+func (c *syncCheckpoint) IsCompleted(providerKey string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.Completed[providerKey]
+	return ok
+}
+
+// AddPending registers a queued job for the provider
+func (c *syncCheckpoint) AddPending(providerKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[providerKey]++
+}
+
+// MarkPlanned marks providers that had no jobs queued as completed
+func (c *syncCheckpoint) MarkPlanned(providerKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending[providerKey] == 0 {
+		c.Completed[providerKey] = time.Now().UTC()
+	}
+}
+
+// Done records a finished job and returns true if the provider just completed
+func (c *syncCheckpoint) Done(providerKey string, failed bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if failed {
+		c.failed[providerKey] = true
+	}
+	c.pending[providerKey]--
+	if c.pending[providerKey] > 0 || c.failed[providerKey] {
+		return false
+	}
+	c.Completed[providerKey] = time.Now().UTC()
+	return true
+}
+
+// Save writes the checkpoint atomically
+func (c *syncCheckpoint) Save() error {
+	c.mu.Lock()
+	c.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+
+	tempPath := c.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return renameFile(tempPath, c.path)
+}
+
+// Remove deletes the checkpoint after a completed session
+func (c *syncCheckpoint) Remove() error {
+	if err := removeFile(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestSyncCheckpointCompletion(t *testing.T) {
+	tests := []struct {
+		name          string
+		jobs          int
+		failed        []bool // outcome of each finished job
+		wantCompleted bool
+	}{
+		{name: "provider without jobs", jobs: 0, wantCompleted: true},
+		{name: "all jobs succeeded", jobs: 2, failed: []bool{false, false}, wantCompleted: true},
+		{name: "jobs still pending", jobs: 2, failed: []bool{false}, wantCompleted: false},
+		{name: "one job failed", jobs: 2, failed: []bool{true, false}, wantCompleted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), checkpointFile)
+			cp, err := loadCheckpoint(path)
+			if err != nil {
+				t.Fatalf("loadCheckpoint: %v", err)
+			}
+			for range tt.jobs {
+				cp.AddPending("hashicorp/null")
+			}
+			cp.MarkPlanned("hashicorp/null")
+			for _, failed := range tt.failed {
+				cp.Done("hashicorp/null", failed)
+			}
+			if err := cp.Save(); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+
+			// The next session sees what this one completed
+			resumed, err := loadCheckpoint(path)
+			if err != nil {
+				t.Fatalf("loadCheckpoint: %v", err)
+			}
+			if got := resumed.IsCompleted("hashicorp/null"); got != tt.wantCompleted {
+				t.Errorf("IsCompleted = %t, want %t", got, tt.wantCompleted)
+			}
+		})
+	}
+}
+
+func TestInterruptedSessionResumes(t *testing.T) {
+	reg := newFakeRegistry(t, map[string][]string{
+		"hashicorp/null":   {"3.2.0"},
+		"hashicorp/random": {"3.6.0"},
+	})
+	reg.platforms = []string{"linux_amd64"}
+	s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: "hashicorp/*", PlatformFilter: "linux_amd64"})
+
+	// An interrupted session completed hashicorp/null
+	cp, err := loadCheckpoint(s.stateFile(checkpointFile))
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	cp.Completed["hashicorp/null"] = time.Now().UTC()
+	if err := cp.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if err := s.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: "/v1/providers/hashicorp/null/versions", want: 0},
+		{path: "/files/terraform-provider-null_3.2.0_linux_amd64.zip", want: 0},
+		{path: "/v1/providers/hashicorp/random/versions", want: 1},
+		{path: "/files/terraform-provider-random_3.6.0_linux_amd64.zip", want: 1},
+	}
+	for _, tt := range tests {
+		if got := reg.requestCount(tt.path); got != tt.want {
+			t.Errorf("%s requested %d times, want %d", tt.path, got, tt.want)
+		}
+	}
+	if _, err := os.Stat(s.stateFile(checkpointFile)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left after a completed session: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// discoveryFile caches the provider listing between runs
+const discoveryFile = ".tf-mirror-discovery.json"
+
+// discoveryState is the persisted cursor of incremental discovery
+type discoveryState struct {
+	UpdatedAt time.Time              `json:"updated_at"`
+	Pages     map[int]*DiscoveryPage `json:"pages"`
+	// Config fingerprints the settings Seen was recorded with; when they
+	// change every provider is processed again
+	Config string `json:"config,omitempty"`
+	// Seen maps namespace/name to the listing fingerprint (latest version and
+	// publication time) of the last run that mirrored the provider without failures
+	Seen map[string]string `json:"seen,omitempty"`
+}
+
+// loadDiscoveryState reads the discovery cache. It always returns a usable
+// state: an empty one if the file does not exist or cannot be parsed.
+func loadDiscoveryState(path string) (*discoveryState, error) {
+	state := &discoveryState{
+		Pages: make(map[int]*DiscoveryPage),
+		Seen:  make(map[string]string),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("failed to read discovery cache: %w", err)
+	}
+
+	var loaded discoveryState
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return state, fmt.Errorf("failed to parse discovery cache: %w", err)
+	}
+	if loaded.Pages != nil {
+		state.Pages = loaded.Pages
+	}
+	if loaded.Seen != nil {
+		state.Seen = loaded.Seen
+	}
+	state.Config = loaded.Config
+	return state, nil
+}
+
+// save writes the discovery cache atomically
+func (d *discoveryState) save(path string) error {
+	d.UpdatedAt = time.Now().UTC()
+	return writeJSONFile(path, d)
+}
+
+// providerFingerprint identifies the listed state of a provider. It is empty
+// if the registry does not report a version, which makes the provider always
+// count as changed.
+func providerFingerprint(provider common.ProviderListItem) string {
+	if provider.Version == "" && provider.PublishedAt == "" {
+		return ""
+	}
+	return provider.Version + "@" + provider.PublishedAt
+}
+
+// diffProviders splits the current listing into providers that are new or
+// changed since seen and the number left unchanged, and counts the providers
+// of seen that are no longer listed
+func diffProviders(seen map[string]string, current []common.ProviderListItem) (changed []common.ProviderListItem, unchanged, removed int) {
+	listed := make(map[string]struct{}, len(current))
+	for _, provider := range current {
+		key := provider.Namespace + "/" + provider.Name
+		listed[key] = struct{}{}
+		fingerprint := providerFingerprint(provider)
+		if previous, ok := seen[key]; ok && fingerprint != "" && previous == fingerprint {
+			unchanged++
+			continue
+		}
+		changed = append(changed, provider)
+	}
+	for key := range seen {
+		if _, ok := listed[key]; !ok {
+			removed++
+		}
+	}
+	return changed, unchanged, removed
+}
+
+// recordSeen updates the cursor after a completed session: providers no
+// longer listed are forgotten, processed providers without failures are
+// recorded with their current fingerprint and failed ones are dropped so the
+// next run processes them again
+func (d *discoveryState) recordSeen(listed, processed []common.ProviderListItem, failed map[string]struct{}) {
+	current := make(map[string]struct{}, len(listed))
+	for _, provider := range listed {
+		current[provider.Namespace+"/"+provider.Name] = struct{}{}
+	}
+	for key := range d.Seen {
+		if _, ok := current[key]; !ok {
+			delete(d.Seen, key)
+		}
+	}
+	for _, provider := range processed {
+		key := provider.Namespace + "/" + provider.Name
+		fingerprint := providerFingerprint(provider)
+		if _, bad := failed[key]; bad || fingerprint == "" {
+			delete(d.Seen, key)
+			continue
+		}
+		d.Seen[key] = fingerprint
+	}
+}
+
+// discoveryConfig fingerprints the settings that decide which versions and
+// platforms of a provider are mirrored
+func (s *Service) discoveryConfig() string {
+	c := s.config
+	return fmt.Sprintf("filter=%s;precedence=%s;platforms=%s;min=%s;keep=%d;prerelease=%t;sample=%s",
+		c.ProviderFilter, c.FilterPrecedence, c.PlatformFilter, c.GlobalMinVersion, c.KeepLatest, c.Prerelease, c.Sample)
+}
@@ -0,0 +1,340 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// listed returns a listing item of namespace/name at version
+func listed(key, version string) common.ProviderListItem {
+	namespace, name, _ := strings.Cut(key, "/")
+	item := common.ProviderListItem{Namespace: namespace, Name: name, Version: version}
+	if version != "" {
+		item.PublishedAt = "2025-01-01T00:00:00Z"
+	}
+	return item
+}
+
+// keysOf returns the namespace/name keys of items in order
+func keysOf(items []common.ProviderListItem) []string {
+	keys := make([]string, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, item.Namespace+"/"+item.Name)
+	}
+	return keys
+}
+
+func TestDiffProviders(t *testing.T) {
+	const published = "@2025-01-01T00:00:00Z"
+
+	tests := []struct {
+		name          string
+		seen          map[string]string
+		current       []common.ProviderListItem
+		wantChanged   []string
+		wantUnchanged int
+		wantRemoved   int
+	}{
+		{
+			name:        "first run processes everything",
+			current:     []common.ProviderListItem{listed("hashicorp/null", "3.2.1"), listed("hashicorp/aws", "5.0.0")},
+			wantChanged: []string{"hashicorp/null", "hashicorp/aws"},
+		},
+		{
+			name:          "unchanged providers are skipped",
+			seen:          map[string]string{"hashicorp/null": "3.2.1" + published, "hashicorp/aws": "5.0.0" + published},
+			current:       []common.ProviderListItem{listed("hashicorp/null", "3.2.1"), listed("hashicorp/aws", "5.0.0")},
+			wantUnchanged: 2,
+		},
+		{
+			name:          "new version is processed",
+			seen:          map[string]string{"hashicorp/null": "3.2.1" + published, "hashicorp/aws": "5.0.0" + published},
+			current:       []common.ProviderListItem{listed("hashicorp/null", "3.2.2"), listed("hashicorp/aws", "5.0.0")},
+			wantChanged:   []string{"hashicorp/null"},
+			wantUnchanged: 1,
+		},
+		{
+			name:          "new provider is processed",
+			seen:          map[string]string{"hashicorp/null": "3.2.1" + published},
+			current:       []common.ProviderListItem{listed("hashicorp/null", "3.2.1"), listed("hashicorp/random", "3.6.0")},
+			wantChanged:   []string{"hashicorp/random"},
+			wantUnchanged: 1,
+		},
+		{
+			name:          "delisted provider is counted as removed",
+			seen:          map[string]string{"hashicorp/null": "3.2.1" + published, "hashicorp/template": "2.2.0" + published},
+			current:       []common.ProviderListItem{listed("hashicorp/null", "3.2.1")},
+			wantUnchanged: 1,
+			wantRemoved:   1,
+		},
+		{
+			name:        "provider without a listed version always counts as changed",
+			seen:        map[string]string{"hashicorp/null": ""},
+			current:     []common.ProviderListItem{listed("hashicorp/null", "")},
+			wantChanged: []string{"hashicorp/null"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, unchanged, removed := diffProviders(tt.seen, tt.current)
+			if got := keysOf(changed); !slices.Equal(got, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", got, tt.wantChanged)
+			}
+			if unchanged != tt.wantUnchanged {
+				t.Errorf("unchanged = %d, want %d", unchanged, tt.wantUnchanged)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("removed = %d, want %d", removed, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestRecordSeen(t *testing.T) {
+	null := listed("hashicorp/null", "3.2.1")
+	aws := listed("hashicorp/aws", "5.0.0")
+	unversioned := listed("hashicorp/random", "")
+
+	tests := []struct {
+		name      string
+		seen      map[string]string
+		listed    []common.ProviderListItem
+		processed []common.ProviderListItem
+		failed    []string
+		want      []string // keys of Seen afterwards
+	}{
+		{
+			name:      "processed providers are recorded",
+			listed:    []common.ProviderListItem{null, aws},
+			processed: []common.ProviderListItem{null, aws},
+			want:      []string{"hashicorp/aws", "hashicorp/null"},
+		},
+		{
+			name:      "failed providers are dropped for a retry",
+			seen:      map[string]string{"hashicorp/aws": "4.0.0@old"},
+			listed:    []common.ProviderListItem{null, aws},
+			processed: []common.ProviderListItem{null, aws},
+			failed:    []string{"hashicorp/aws"},
+			want:      []string{"hashicorp/null"},
+		},
+		{
+			name:   "skipped providers keep their entry",
+			seen:   map[string]string{"hashicorp/null": providerFingerprint(null)},
+			listed: []common.ProviderListItem{null},
+			want:   []string{"hashicorp/null"},
+		},
+		{
+			name:   "delisted providers are forgotten",
+			seen:   map[string]string{"hashicorp/null": providerFingerprint(null), "hashicorp/template": "2.2.0@old"},
+			listed: []common.ProviderListItem{null},
+			want:   []string{"hashicorp/null"},
+		},
+		{
+			name:      "providers without a fingerprint are not recorded",
+			listed:    []common.ProviderListItem{unversioned},
+			processed: []common.ProviderListItem{unversioned},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &discoveryState{Seen: make(map[string]string)}
+			maps.Copy(state.Seen, tt.seen)
+			failed := make(map[string]struct{})
+			for _, key := range tt.failed {
+				failed[key] = struct{}{}
+			}
+
+			state.recordSeen(tt.listed, tt.processed, failed)
+
+			if got := slices.Sorted(maps.Keys(state.Seen)); !slices.Equal(got, tt.want) {
+				t.Errorf("seen = %v, want %v", got, tt.want)
+			}
+			for _, provider := range tt.processed {
+				key := provider.Namespace + "/" + provider.Name
+				if fingerprint, ok := state.Seen[key]; ok && fingerprint != providerFingerprint(provider) {
+					t.Errorf("seen[%s] = %q, want %q", key, fingerprint, providerFingerprint(provider))
+				}
+			}
+		})
+	}
+}
+
+func TestDiscoverProvidersConditional(t *testing.T) {
+	tests := []struct {
+		name        string
+		etag        string // validator sent by the registry, none if empty
+		changeETag  bool   // the listing changes between the runs
+		wantCached  bool   // the page is kept in the cache
+		want304     int    // Not Modified responses on the second run
+		wantFetched int    // full responses over both runs
+	}{
+		{name: "registry without validators", wantFetched: 2},
+		{name: "unchanged listing", etag: `"v1"`, wantCached: true, want304: 1, wantFetched: 1},
+		{name: "changed listing", etag: `"v1"`, changeETag: true, wantCached: true, wantFetched: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.1"}, "hashicorp/aws": {"5.0.0"}})
+			etag := tt.etag
+			notModified, fetched := 0, 0
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path != "/v1/providers" {
+					return false
+				}
+				if etag != "" && r.Header.Get("If-None-Match") == etag {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return true
+				}
+				fetched++
+				if etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+				list := common.ProviderList{Providers: []common.ProviderListItem{listed("hashicorp/aws", "5.0.0"), listed("hashicorp/null", "3.2.1")}}
+				json.NewEncoder(w).Encode(list)
+				return true
+			}
+			client, err := NewRegistryClient(&common.RegistryConfig{BaseURL: reg.URL}, common.NewLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			pages := make(map[int]*DiscoveryPage)
+			first, err := client.DiscoverProviders(context.Background(), pages)
+			if err != nil {
+				t.Fatalf("first discovery: %v", err)
+			}
+			if _, ok := pages[0]; ok != tt.wantCached {
+				t.Errorf("page cached = %v, want %v", ok, tt.wantCached)
+			}
+			if tt.changeETag {
+				etag = `"v2"`
+			}
+
+			second, err := client.DiscoverProviders(context.Background(), pages)
+			if err != nil {
+				t.Fatalf("second discovery: %v", err)
+			}
+			if !slices.Equal(keysOf(second), keysOf(first)) {
+				t.Errorf("second discovery = %v, want %v", keysOf(second), keysOf(first))
+			}
+			if notModified != tt.want304 {
+				t.Errorf("not modified responses = %d, want %d", notModified, tt.want304)
+			}
+			if fetched != tt.wantFetched {
+				t.Errorf("full responses = %d, want %d", fetched, tt.wantFetched)
+			}
+			if tt.changeETag && pages[0].ETag != etag {
+				t.Errorf("cached ETag = %q, want %q", pages[0].ETag, etag)
+			}
+		})
+	}
+}
+
+func TestDiscoverProvidersCancelled(t *testing.T) {
+	tests := []struct {
+		name string
+		// page answers the request of a listing page after the first two
+		// full ones; cancel cancels the discovery
+		page func(w http.ResponseWriter, r *http.Request, cancel context.CancelFunc)
+	}{
+		{
+			name: "between pages",
+			page: func(w http.ResponseWriter, r *http.Request, cancel context.CancelFunc) {
+				cancel()
+				writeFullPage(w, r)
+			},
+		},
+		{
+			name: "while a page is in flight",
+			page: func(w http.ResponseWriter, r *http.Request, cancel context.CancelFunc) {
+				cancel()
+				select {
+				case <-r.Context().Done():
+				case <-time.After(5 * time.Second):
+				}
+			},
+		},
+		{
+			name: "while waiting out a rate limit",
+			page: func(w http.ResponseWriter, r *http.Request, cancel context.CancelFunc) {
+				cancel()
+				w.Header().Set("Retry-After", "60")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			reg := newFakeRegistry(t, nil)
+			var mu sync.Mutex
+			requested := 0
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path != "/v1/providers" {
+					return false
+				}
+				mu.Lock()
+				requested++
+				n := requested
+				mu.Unlock()
+				if n <= 2 {
+					writeFullPage(w, r)
+				} else {
+					tt.page(w, r, cancel)
+				}
+				return true
+			}
+			client, err := NewRegistryClient(&common.RegistryConfig{BaseURL: reg.URL}, common.NewLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			start := time.Now()
+			providers, err := client.DiscoverProviders(ctx, nil)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("DiscoverProviders() error = %v, want context.Canceled", err)
+			}
+			if providers != nil {
+				t.Errorf("got %d providers from a cancelled discovery", len(providers))
+			}
+			if elapsed := time.Since(start); elapsed > 3*time.Second {
+				t.Errorf("discovery took %v to stop after cancellation", elapsed)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if requested > 3 {
+				t.Errorf("requested %d pages, want discovery to stop at the third", requested)
+			}
+		})
+	}
+}
+
+// writeFullPage answers a listing request with a full page of 100 providers,
+// so discovery keeps paginating
+func writeFullPage(w http.ResponseWriter, r *http.Request) {
+	var list common.ProviderList
+	offset := r.URL.Query().Get("offset")
+	for i := range 100 {
+		list.Providers = append(list.Providers, common.ProviderListItem{Namespace: "ns" + offset, Name: fmt.Sprintf("p%d", i)})
+	}
+	json.NewEncoder(w).Encode(list)
+}
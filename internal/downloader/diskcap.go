@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"tf-mirror/internal/common"
+)
+
+// ErrDiskCapReached is returned for downloads refused because the mirror
+// reached MaxDiskBytes or its filesystem reached MaxDiskPercent
+var ErrDiskCapReached = errors.New("disk usage cap reached")
+
+// diskSpace is common.DiskSpace, replaceable in tests
+var diskSpace = common.DiskSpace
+
+// diskBudget tracks the bytes used by the mirror during a session, so the cap
+// is checked before each download without walking the tree again. Downloads
+// already running when the cap is hit still finish, so the cap can be
+// overshot by up to MaxConcurrent archives.
+type diskBudget struct {
+	used    atomic.Int64
+	reached atomic.Bool
+}
+
+// diskCapEnabled reports whether MaxDiskBytes or MaxDiskPercent is set
+func (s *Service) diskCapEnabled() bool {
+	return s.config.MaxDiskBytes > 0 || s.config.MaxDiskPercent > 0
+}
+
+// startDiskBudget measures the mirror at the start of a session. With Prune,
+// stale versions are removed first when the cap is already reached.
+func (s *Service) startDiskBudget() {
+	if !s.diskCapEnabled() {
+		return
+	}
+	s.disk.reached.Store(false)
+	s.disk.used.Store(s.mirrorUsage())
+
+	if s.overDiskCap() && s.config.Prune {
+		s.logger.Warn("Disk cap reached before downloading, pruning stale versions first")
+		if err := s.pruneStaleVersions(); err != nil {
+			s.logger.Error("Prune failed: %v", err)
+		}
+		s.disk.used.Store(s.mirrorUsage())
+	}
+	s.logger.Info("Disk usage: %s of mirror data%s", common.FormatBytes(s.disk.used.Load()), s.diskCapDescription())
+}
+
+// checkDiskBudget returns ErrDiskCapReached once the cap is reached. The
+// first refusal logs a warning; the session then drains the remaining jobs.
+func (s *Service) checkDiskBudget() error {
+	if !s.diskCapEnabled() {
+		return nil
+	}
+	if s.disk.reached.Load() {
+		return ErrDiskCapReached
+	}
+	if !s.overDiskCap() {
+		return nil
+	}
+	if s.disk.reached.CompareAndSwap(false, true) {
+		s.logger.Warn("Disk cap reached (%s used%s), stopping downloads for this session",
+			common.FormatBytes(s.disk.used.Load()), s.diskCapDescription())
+	}
+	return ErrDiskCapReached
+}
+
+// recordDiskUsage adds a finished download to the session's usage
+func (s *Service) recordDiskUsage(path string) {
+	if !s.diskCapEnabled() {
+		return
+	}
+	if info, err := os.Stat(path); err == nil {
+		s.disk.used.Add(info.Size())
+	}
+}
+
+// overDiskCap compares the tracked usage with MaxDiskBytes and the
+// filesystem usage with MaxDiskPercent
+func (s *Service) overDiskCap() bool {
+	if s.config.MaxDiskBytes > 0 && s.disk.used.Load() >= s.config.MaxDiskBytes {
+		return true
+	}
+	if s.config.MaxDiskPercent > 0 {
+		stats, err := diskSpace(s.config.DownloadPath)
+		if err != nil {
+			s.logger.Debug("Cannot check filesystem usage: %v", err)
+			return false
+		}
+		if stats.Total > 0 && float64(stats.Used())*100 >= float64(stats.Total)*float64(s.config.MaxDiskPercent) {
+			return true
+		}
+	}
+	return false
+}
+
+// diskCapDescription returns the configured caps for log lines
+func (s *Service) diskCapDescription() string {
+	description := ""
+	if s.config.MaxDiskBytes > 0 {
+		description += fmt.Sprintf(", cap %s", common.FormatBytes(s.config.MaxDiskBytes))
+	}
+	if s.config.MaxDiskPercent > 0 {
+		description += fmt.Sprintf(", filesystem cap %d%%", s.config.MaxDiskPercent)
+	}
+	return description
+}
+
+// mirrorUsage returns the bytes of all files under the download path and the
+// staging path. Staged files hardlinked to published ones are counted once.
+func (s *Service) mirrorUsage() int64 {
+	var total int64
+	filepath.WalkDir(s.config.DownloadPath, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	if s.config.StagingPath == "" {
+		return total
+	}
+
+	stagingRoot := s.stagingRoot()
+	filepath.WalkDir(s.config.StagingPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if rel, err := filepath.Rel(stagingRoot, path); err == nil {
+			if published, err := os.Stat(filepath.Join(s.providerRoot(), rel)); err == nil && os.SameFile(info, published) {
+				return nil
+			}
+		}
+		total += info.Size()
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestDiskCapStopsSession(t *testing.T) {
+	const total = 1 << 30
+
+	tests := []struct {
+		name         string
+		maxBytes     int64
+		maxPercent   int
+		full         func(archives int) bool // whether the filesystem reports 95% used
+		wantArchives int
+		wantCapped   bool
+	}{
+		{name: "no cap", wantArchives: 4},
+		{name: "byte cap above the downloads", maxBytes: 1 << 20, wantArchives: 4},
+		{name: "byte cap already reached by the mirror", maxBytes: 1, wantArchives: 0, wantCapped: true},
+		{name: "filesystem below the cap", maxPercent: 90, wantArchives: 4},
+		{name: "filesystem fills up after two archives", maxPercent: 90, full: func(n int) bool { return n >= 2 }, wantArchives: 2, wantCapped: true},
+		{name: "filesystem already over the cap", maxPercent: 90, full: func(int) bool { return true }, wantArchives: 0, wantCapped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0", "3.2.1"}})
+			s := newFakeService(t, reg, &common.DownloaderConfig{
+				MaxConcurrent:  1, // the cap is checked before each download
+				MaxDiskBytes:   tt.maxBytes,
+				MaxDiskPercent: tt.maxPercent,
+			})
+			archives := func() []string {
+				paths, _ := filepath.Glob(filepath.Join(s.providerRoot(), "hashicorp", "null", "*.zip"))
+				return paths
+			}
+			orig := diskSpace
+			diskSpace = func(string) (common.DiskStats, error) {
+				if tt.full != nil && tt.full(len(archives())) {
+					return common.DiskStats{Total: total, Free: total / 20}, nil
+				}
+				return common.DiskStats{Total: total, Free: total / 2}, nil
+			}
+			t.Cleanup(func() { diskSpace = orig })
+
+			err := s.RunOnce(context.Background())
+			if capped := errors.Is(err, ErrDiskCapReached); capped != tt.wantCapped {
+				t.Fatalf("RunOnce = %v, want disk cap reached %t", err, tt.wantCapped)
+			}
+			if !tt.wantCapped && err != nil {
+				t.Fatalf("RunOnce: %v", err)
+			}
+
+			if got := len(archives()); got != tt.wantArchives {
+				t.Errorf("downloaded %d archives, want %d", got, tt.wantArchives)
+			}
+			partial, _ := filepath.Glob(filepath.Join(s.providerRoot(), "hashicorp", "null", "*.tmp"))
+			if len(partial) > 0 {
+				t.Errorf("partial files left behind: %v", partial)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// EventType identifies a downloader event
+type EventType string
+
+const (
+	EventJobStarted EventType = "job_started"
+	EventDownloaded EventType = "downloaded"
+	EventSkipped    EventType = "skipped"
+	EventFailed     EventType = "failed"
+	EventVerified   EventType = "verified"
+
+	EventNotAvailable EventType = "not_available"
+)
+
+// Event is a single NDJSON record describing a download action
+type Event struct {
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Version    string    `json:"version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// EventEmitter writes events as newline-delimited JSON.
+// A nil emitter is valid and discards all events.
+type EventEmitter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder // nil once a write failed
+	logger *common.Logger
+}
+
+// NewEventEmitter creates an emitter writing to w. A failed write (such as
+// EPIPE when the reader went away) is logged once and ends the stream.
+func NewEventEmitter(w io.Writer, logger *common.Logger) *EventEmitter {
+	return &EventEmitter{enc: json.NewEncoder(w), logger: logger}
+}
+
+// Emit writes an event for the given job
+func (e *EventEmitter) Emit(eventType EventType, job DownloadJob, duration time.Duration, err error) {
+	if e == nil {
+		return
+	}
+
+	event := Event{
+		Type:      eventType,
+		Time:      time.Now().UTC(),
+		Namespace: job.Namespace,
+		Name:      job.Name,
+		Version:   job.Version,
+		OS:        job.OS,
+		Arch:      job.Arch,
+	}
+	if duration > 0 {
+		event.DurationMs = duration.Milliseconds()
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.enc == nil {
+		return
+	}
+	if err := e.enc.Encode(event); err != nil {
+		e.logger.Error("Failed to write event, event stream disabled: %v", err)
+		e.enc = nil
+	}
+}
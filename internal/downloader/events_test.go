@@ -0,0 +1,126 @@
+package downloader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// failingWriter fails every write after the first ok ones
+type failingWriter struct {
+	ok     int
+	writes int
+	buf    bytes.Buffer
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes > w.ok {
+		return 0, errors.New("broken pipe")
+	}
+	return w.buf.Write(p)
+}
+
+func TestEventEmitter(t *testing.T) {
+	job := DownloadJob{Namespace: "hashicorp", Name: "null", Version: "3.2.0", OS: "linux", Arch: "amd64"}
+	tests := []struct {
+		name       string
+		okWrites   int
+		emits      int
+		wantEvents int
+		wantWrites int
+		wantLogged int
+	}{
+		{name: "every event is a line", okWrites: 10, emits: 3, wantEvents: 3, wantWrites: 3},
+		{name: "first write fails", okWrites: 0, emits: 3, wantEvents: 0, wantWrites: 1, wantLogged: 1},
+		{name: "stream stops after a failure", okWrites: 2, emits: 5, wantEvents: 2, wantWrites: 3, wantLogged: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := common.NewLogger()
+			logger.SetOutput(io.Discard)
+			logger.TeeTo(&logs)
+			w := &failingWriter{ok: tt.okWrites}
+			emitter := NewEventEmitter(w, logger)
+			for range tt.emits {
+				emitter.Emit(EventDownloaded, job, 1500*time.Millisecond, nil)
+			}
+
+			if w.writes != tt.wantWrites {
+				t.Errorf("writes = %d, want %d", w.writes, tt.wantWrites)
+			}
+			lines := strings.Split(strings.TrimSuffix(w.buf.String(), "\n"), "\n")
+			if w.buf.Len() == 0 {
+				lines = nil
+			}
+			if len(lines) != tt.wantEvents {
+				t.Fatalf("events = %d, want %d", len(lines), tt.wantEvents)
+			}
+			for _, line := range lines {
+				var event Event
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("invalid event %q: %v", line, err)
+				}
+				if event.Type != EventDownloaded || event.Name != "null" || event.DurationMs != 1500 {
+					t.Errorf("event = %+v", event)
+				}
+			}
+			if got := strings.Count(logs.String(), "event stream disabled"); got != tt.wantLogged {
+				t.Errorf("logged %d failures, want %d:\n%s", got, tt.wantLogged, logs.String())
+			}
+		})
+	}
+}
+
+func TestNilEventEmitter(t *testing.T) {
+	var emitter *EventEmitter
+	emitter.Emit(EventFailed, DownloadJob{}, 0, errors.New("ignored"))
+}
+
+func TestEventEmitterTypes(t *testing.T) {
+	job := DownloadJob{Namespace: "hashicorp", Name: "null", Version: "3.2.0", OS: "linux", Arch: "amd64"}
+	tests := []struct {
+		eventType EventType
+		duration  time.Duration
+		err       error
+		wantError string
+	}{
+		{eventType: EventJobStarted},
+		{eventType: EventDownloaded, duration: time.Second},
+		{eventType: EventSkipped, duration: time.Millisecond},
+		{eventType: EventFailed, duration: time.Second, err: errors.New("checksum mismatch"), wantError: "checksum mismatch"},
+		{eventType: EventVerified},
+		{eventType: EventNotAvailable, duration: time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.eventType), func(t *testing.T) {
+			var out bytes.Buffer
+			NewEventEmitter(&out, common.NewLogger()).Emit(tt.eventType, job, tt.duration, tt.err)
+
+			var event Event
+			if err := json.Unmarshal(out.Bytes(), &event); err != nil {
+				t.Fatalf("invalid event %q: %v", out.String(), err)
+			}
+			if event.Type != tt.eventType || event.Namespace != job.Namespace || event.Name != job.Name ||
+				event.Version != job.Version || event.OS != job.OS || event.Arch != job.Arch {
+				t.Errorf("event = %+v, want %s for %+v", event, tt.eventType, job)
+			}
+			if event.DurationMs != tt.duration.Milliseconds() {
+				t.Errorf("duration_ms = %d, want %d", event.DurationMs, tt.duration.Milliseconds())
+			}
+			if event.Error != tt.wantError {
+				t.Errorf("error = %q, want %q", event.Error, tt.wantError)
+			}
+			if event.Time.IsZero() {
+				t.Error("no time")
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package downloader
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// fakeRegistry serves the Provider Registry Protocol for a fixed set of
+// providers. Every version is published for linux_amd64 and darwin_arm64
+// unless platforms says otherwise; archives are small valid zips listed in a
+// SHA256SUMS file per version, with a placeholder signature and signing key.
+type fakeRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	versions  map[string][]string // "namespace/name" -> versions
+	platforms []string
+	requests  map[string]int // path -> count
+
+	// intercept answers a request instead of the registry when it returns true
+	intercept func(w http.ResponseWriter, r *http.Request) bool
+	// editPackage changes a download response before it is sent
+	editPackage func(pkg *common.ProviderPackage)
+}
+
+// newFakeRegistry starts a registry serving versions ("namespace/name" ->
+// versions), closed with the test
+func newFakeRegistry(t testing.TB, versions map[string][]string) *fakeRegistry {
+	t.Helper()
+	reg := &fakeRegistry{
+		versions:  versions,
+		platforms: []string{"linux_amd64", "darwin_arm64"},
+		requests:  make(map[string]int),
+	}
+	reg.Server = httptest.NewServer(http.HandlerFunc(reg.serve))
+	t.Cleanup(reg.Close)
+	return reg
+}
+
+// newFakeService returns a Service downloading from reg into t.TempDir(),
+// unless config sets DownloadPath, with one attempt per job by default
+func newFakeService(t testing.TB, reg *fakeRegistry, config *common.DownloaderConfig) *Service {
+	t.Helper()
+	if config.DownloadPath == "" {
+		config.DownloadPath = t.TempDir()
+	}
+	if config.MaxConcurrent == 0 {
+		config.MaxConcurrent = 2
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 1
+	}
+	if config.DownloadTimeout == 0 {
+		config.DownloadTimeout = 10 * time.Second
+	}
+	s, err := NewService(config, &common.RegistryConfig{BaseURL: reg.URL}, common.NewLogger())
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// requestCount returns how often path was requested
+func (reg *fakeRegistry) requestCount(path string) int {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	return reg.requests[path]
+}
+
+// fakeArchive returns the archive of a provider release, a zip holding one
+// file named after it
+func fakeArchive(filename string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	entry, _ := w.Create("terraform-provider")
+	entry.Write([]byte(filename))
+	w.Close()
+	return buf.Bytes()
+}
+
+// fakeArchiveSHA256 returns the hex SHA256 of fakeArchive(filename)
+func fakeArchiveSHA256(filename string) string {
+	sum := sha256.Sum256(fakeArchive(filename))
+	return hex.EncodeToString(sum[:])
+}
+
+// fakeSigningKeys are reported for every package; the fake signatures are
+// not verifiable, so tests leave VerifySignatures off
+var fakeSigningKeys = common.SigningKeys{GPGPublicKeys: []common.GPGPublicKey{{
+	KeyID:      "34365D9472D7468F",
+	ASCIIArmor: "-----BEGIN PGP PUBLIC KEY BLOCK-----\n...\n-----END PGP PUBLIC KEY BLOCK-----\n",
+}}}
+
+func (reg *fakeRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	reg.requests[r.URL.Path]++
+	intercept := reg.intercept
+	reg.mu.Unlock()
+	if intercept != nil && intercept(w, r) {
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/v1/providers":
+		reg.serveList(w, r)
+	case len(parts) == 5 && parts[0] == "v1" && parts[4] == "versions":
+		reg.serveVersions(w, parts[2], parts[3])
+	case len(parts) == 8 && parts[0] == "v1" && parts[5] == "download":
+		reg.servePackage(w, parts[2], parts[3], parts[4], parts[6], parts[7])
+	case len(parts) == 2 && parts[0] == "files" && strings.HasSuffix(parts[1], "_SHA256SUMS"):
+		reg.serveSHASums(w, parts[1])
+	case len(parts) == 2 && parts[0] == "files" && strings.HasSuffix(parts[1], "_SHA256SUMS.sig"):
+		w.Write([]byte("signature of " + strings.TrimSuffix(parts[1], ".sig")))
+	case len(parts) == 2 && parts[0] == "files":
+		w.Write(fakeArchive(parts[1]))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (reg *fakeRegistry) serveList(w http.ResponseWriter, r *http.Request) {
+	var list common.ProviderList
+	if r.URL.Query().Get("offset") == "0" {
+		keys := make([]string, 0, len(reg.versions))
+		for key := range reg.versions {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			namespace, name, _ := strings.Cut(key, "/")
+			list.Providers = append(list.Providers, common.ProviderListItem{Namespace: namespace, Name: name})
+		}
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func (reg *fakeRegistry) serveVersions(w http.ResponseWriter, namespace, name string) {
+	versions, ok := reg.versions[namespace+"/"+name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var list common.ProviderVersions
+	for _, version := range versions {
+		list.Versions = append(list.Versions, common.Version{Version: version})
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+func (reg *fakeRegistry) published(namespace, name, version, platform string) bool {
+	for _, v := range reg.versions[namespace+"/"+name] {
+		if v == version {
+			for _, p := range reg.platforms {
+				if p == platform {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (reg *fakeRegistry) servePackage(w http.ResponseWriter, namespace, name, version, osName, arch string) {
+	if !reg.published(namespace, name, version, osName+"_"+arch) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", name, version, osName, arch)
+	pkg := &common.ProviderPackage{
+		Protocols:   []string{"5.0"},
+		OS:          osName,
+		Arch:        arch,
+		Filename:    filename,
+		DownloadURL: reg.URL + "/files/" + filename,
+		SHASumsURL:  fmt.Sprintf("%s/files/terraform-provider-%s_%s_SHA256SUMS", reg.URL, name, version),
+		Shasum:      fakeArchiveSHA256(filename),
+		SigningKeys: fakeSigningKeys,
+	}
+	pkg.SHASumsSignatureURL = pkg.SHASumsURL + ".sig"
+	if reg.editPackage != nil {
+		reg.editPackage(pkg)
+	}
+	json.NewEncoder(w).Encode(pkg)
+}
+
+func (reg *fakeRegistry) serveSHASums(w http.ResponseWriter, filename string) {
+	// terraform-provider-<name>_<version>_SHA256SUMS
+	nameVersion := strings.TrimSuffix(strings.TrimPrefix(filename, "terraform-provider-"), "_SHA256SUMS")
+	name, version, _ := strings.Cut(nameVersion, "_")
+	for _, platform := range reg.platforms {
+		archive := fmt.Sprintf("terraform-provider-%s_%s_%s.zip", name, version, platform)
+		fmt.Fprintf(w, "%s  %s\n", fakeArchiveSHA256(archive), archive)
+	}
+}
@@ -0,0 +1,121 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Status of one provider archive in the metadata
+const (
+	FileStatusOK      = "ok"      // on disk and matching its checksum
+	FileStatusCorrupt = "corrupt" // downloaded but failed checksum verification
+	FileStatusMissing = "missing" // the download failed, nothing on disk
+	FileStatusPartial = "partial" // an interrupted download left a .tmp file behind
+)
+
+// errChecksumMismatch marks a downloaded archive whose SHA256 differs from the registry's
+var errChecksumMismatch = errors.New("checksum verification failed")
+
+// FileStatus records the state of one version/platform archive of a provider
+type FileStatus struct {
+	Status string `json:"status"`
+	// LastVerified is when the checksum was last confirmed, nil if it never was
+	// (e.g. entries rebuilt from disk or migrated from old metadata)
+	LastVerified *time.Time `json:"last_verified,omitempty"`
+}
+
+// fileStatusKey returns the ProviderInfo.Files key, e.g. 3.0.0_linux_amd64
+func fileStatusKey(version, osName, archName string) string {
+	return version + "_" + osName + "_" + archName
+}
+
+// failureStatus maps a failed download to the status recorded for it
+func failureStatus(err error) string {
+	if errors.Is(err, errChecksumMismatch) {
+		return FileStatusCorrupt
+	}
+	return FileStatusMissing
+}
+
+// setFileStatus records a bad status (corrupt, missing, partial) for one
+// archive; good ones go through updateMetadata. Providers not in the metadata
+// yet are left alone, so a failed first download does not create an entry.
+func (s *Service) setFileStatus(job DownloadJob, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	providerKey := job.providerKey()
+	info, ok := s.metadata.Providers[providerKey]
+	if !ok {
+		return
+	}
+	if info.Files == nil {
+		info.Files = make(map[string]FileStatus)
+	}
+
+	key := fileStatusKey(job.Version, job.OS, job.Arch)
+	entry := info.Files[key]
+	entry.Status = status
+	info.Files[key] = entry
+	s.metadata.Providers[providerKey] = info
+}
+
+// fileStatus returns the recorded status of one archive, if any
+func (info ProviderInfo) fileStatus(version, osName, archName string) (FileStatus, bool) {
+	status, ok := info.Files[fileStatusKey(version, osName, archName)]
+	return status, ok
+}
+
+// migrateMetadata fills in per-file statuses for providers recorded by
+// versions of tf-mirror that did not track them. Archives found on disk are
+// marked ok but unverified, leftover .tmp files partial.
+func (s *Service) migrateMetadata() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migrated := 0
+	for key, info := range s.metadata.Providers {
+		if info.Files != nil {
+			continue
+		}
+		info.Files = make(map[string]FileStatus)
+		entries, err := readDir(filepath.Join(s.providerRoot(), info.Namespace, info.Name))
+		if err == nil {
+			for _, entry := range entries {
+				if entry.IsDir() {
+					continue
+				}
+				if version, osName, archName, status, ok := parseArchiveStatus(info.Name, entry.Name()); ok {
+					info.Files[fileStatusKey(version, osName, archName)] = FileStatus{Status: status}
+				}
+			}
+		}
+		s.metadata.Providers[key] = info
+		migrated++
+	}
+	if migrated > 0 {
+		s.logger.Info("Migrated metadata of %d providers to per-file status", migrated)
+	}
+}
+
+// parseArchiveStatus parses terraform-provider-<name>_<version>_<os>_<arch>.zip
+// (status ok) or the same name with a .tmp suffix (status partial)
+func parseArchiveStatus(name, filename string) (version, osName, archName, status string, ok bool) {
+	status = FileStatusOK
+	if strings.HasSuffix(filename, ".zip.tmp") {
+		filename = strings.TrimSuffix(filename, ".tmp")
+		status = FileStatusPartial
+	}
+	prefix := fmt.Sprintf("terraform-provider-%s_", name)
+	if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ".zip") {
+		return "", "", "", "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(filename, prefix), ".zip"), "_")
+	if len(parts) != 3 {
+		return "", "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], status, true
+}
@@ -1,8 +1,11 @@
 package downloader
 
 import (
+	"encoding/json"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 )
 
 // File system operation wrappers for easier testing
@@ -35,3 +38,37 @@ func readDir(path string) ([]os.DirEntry, error) {
 func statFile(path string) (os.FileInfo, error) {
 	return os.Stat(path)
 }
+
+// writeJSONFile writes v as indented JSON through a temporary file in the same
+// directory that is synced and renamed over path, so a crash mid-write leaves
+// either the old or the new content, never a truncated file
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", filepath.Base(path), err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		removeFile(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := renameFile(tmp.Name(), path); err != nil {
+		removeFile(tmp.Name())
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
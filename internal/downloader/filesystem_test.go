@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestWriteJSONFileInterrupted(t *testing.T) {
+	const oldContent = "{\n  \"version\": 1\n}\n"
+	errCrash := errors.New("crash before rename")
+
+	tests := []struct {
+		name    string
+		value   any
+		setup   func(t *testing.T, path string) // runs after the old file is written
+		rename  func(oldPath, newPath string) error
+		wantErr bool
+		want    string // expected content of path afterwards
+	}{
+		{
+			name:  "successful write replaces the file",
+			value: map[string]int{"version": 2},
+			want:  "{\n  \"version\": 2\n}\n",
+		},
+		{
+			name:  "leftover temp file from a crash is ignored",
+			value: map[string]int{"version": 2},
+			setup: func(t *testing.T, path string) {
+				// Partial write of a previous process killed mid-write
+				if err := os.WriteFile(path+".123.tmp", []byte(`{"vers`), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "{\n  \"version\": 2\n}\n",
+		},
+		{
+			name:    "encode failure keeps the old file",
+			value:   map[string]any{"version": make(chan int)},
+			wantErr: true,
+			want:    oldContent,
+		},
+		{
+			name:    "crash before rename keeps the old file",
+			value:   map[string]int{"version": 2},
+			rename:  func(string, string) error { return errCrash },
+			wantErr: true,
+			want:    oldContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, metadataFile)
+			if err := os.WriteFile(path, []byte(oldContent), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, path)
+			}
+			if tt.rename != nil {
+				orig := renameFile
+				renameFile = tt.rename
+				t.Cleanup(func() { renameFile = orig })
+			}
+			// Temp files present before the write are not ours to clean up
+			before, _ := filepath.Glob(path + ".*.tmp")
+
+			err := writeJSONFile(path, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeJSONFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.rename != nil && !errors.Is(err, errCrash) {
+				t.Errorf("error %v does not wrap the rename failure", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("content = %q, want %q", data, tt.want)
+			}
+			after, _ := filepath.Glob(path + ".*.tmp")
+			if len(after) != len(before) {
+				t.Errorf("temporary files = %v, want %v", after, before)
+			}
+		})
+	}
+}
+
+func TestLoadMetadataAfterInterruptedSave(t *testing.T) {
+	s := newTestService(t, &common.DownloaderConfig{})
+	s.metadata.Providers["hashicorp/null"] = ProviderInfo{
+		Namespace: "hashicorp",
+		Name:      "null",
+		Versions:  []string{"3.2.1"},
+		Files:     map[string]FileStatus{},
+	}
+	if err := s.saveMetadata(); err != nil {
+		t.Fatalf("saveMetadata: %v", err)
+	}
+
+	orig := renameFile
+	renameFile = func(string, string) error { return errors.New("killed") }
+	s.metadata.Providers["hashicorp/aws"] = ProviderInfo{Namespace: "hashicorp", Name: "aws"}
+	err := s.saveMetadata()
+	renameFile = orig
+	if err == nil {
+		t.Fatal("saveMetadata succeeded with a failing rename")
+	}
+
+	reloaded := newTestService(t, &common.DownloaderConfig{DownloadPath: s.config.DownloadPath})
+	if _, ok := reloaded.metadata.Providers["hashicorp/null"]; !ok {
+		t.Error("provider saved before the interrupted write is missing")
+	}
+	if _, ok := reloaded.metadata.Providers["hashicorp/aws"]; ok {
+		t.Error("provider from the interrupted write was persisted")
+	}
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	downloaded := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		providers map[string]ProviderInfo
+		binaries  []common.DownloadedBinary
+	}{
+		{
+			name: "providers only",
+			providers: map[string]ProviderInfo{"hashicorp/null": {
+				Namespace: "hashicorp", Name: "null",
+				Platforms: []string{"linux_amd64"}, Versions: []string{"3.2.1"},
+				Files: map[string]FileStatus{"3.2.1_linux_amd64": {Status: FileStatusOK}},
+			}},
+		},
+		{
+			name: "providers and binaries",
+			providers: map[string]ProviderInfo{"hashicorp/null": {
+				Namespace: "hashicorp", Name: "null",
+				Platforms: []string{"linux_amd64"}, Versions: []string{"3.2.1"},
+				Files: map[string]FileStatus{"3.2.1_linux_amd64": {Status: FileStatusOK}},
+			}},
+			binaries: []common.DownloadedBinary{
+				{Tool: "terraform", Platforms: []string{"linux_amd64"}, Versions: []string{"1.9.0"}, Downloaded: downloaded},
+				{Tool: "terraform", Platforms: []string{"darwin_arm64"}, Versions: []string{"1.9.0"}, Downloaded: downloaded},
+				{Tool: "consul", Platforms: []string{"linux_amd64"}, Versions: []string{"1.21.4"}, Downloaded: downloaded},
+			},
+		},
+		{
+			name: "binaries only",
+			binaries: []common.DownloadedBinary{
+				{Tool: "vault", Platforms: []string{"linux_arm64"}, Versions: []string{"1.17.0"}, Downloaded: downloaded},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &common.DownloaderConfig{})
+			maps.Copy(s.metadata.Providers, tt.providers)
+			if tt.binaries != nil {
+				s.metadata.Binaries = binaryMetadata(tt.binaries)
+			}
+			if err := s.saveMetadata(); err != nil {
+				t.Fatalf("saveMetadata: %v", err)
+			}
+
+			reloaded := newTestService(t, &common.DownloaderConfig{DownloadPath: s.config.DownloadPath})
+			if err := reloaded.loadMetadata(); err != nil {
+				t.Fatalf("loadMetadata: %v", err)
+			}
+			if !reflect.DeepEqual(reloaded.metadata.Providers, s.metadata.Providers) {
+				t.Errorf("providers = %+v, want %+v", reloaded.metadata.Providers, s.metadata.Providers)
+			}
+			if !reflect.DeepEqual(reloaded.metadata.Binaries, s.metadata.Binaries) {
+				t.Errorf("binaries = %+v, want %+v", reloaded.metadata.Binaries, s.metadata.Binaries)
+			}
+		})
+	}
+}
@@ -5,11 +5,20 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/mod/sumdb/dirhash"
+
+	"tf-mirror/internal/common"
 )
 
+// versionMu serializes read-modify-write updates of <version>.json files,
+// which are shared by the workers downloading different platforms of a version
+var versionMu sync.Mutex
+
 // IndexJSON is the root structure for minimal index.json
 type IndexJSON struct {
 	Versions map[string]struct{} `json:"versions"`
@@ -26,6 +35,9 @@ type PlatformInfo struct {
 // GenerateIndexJSON scans the provider directory and generates minimal index.json
 // providerDir: path to .../registry.terraform.io/<namespace>/<name>
 func GenerateIndexJSON(providerDir string) error {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
 	entries, err := os.ReadDir(providerDir)
 	if err != nil {
 		return fmt.Errorf("failed to read provider dir: %w", err)
@@ -50,12 +62,6 @@ func GenerateIndexJSON(providerDir string) error {
 				arch := parts[3]
 				index.Versions[version] = struct{}{}
 
-				// Вычисляем хеш
-				hash, err := calculateHash(filepath.Join(providerDir, name))
-				if err != nil {
-					return err
-				}
-
 				// Определяем путь для <version>.json
 				indexPath := filepath.Join(providerDir, version+".json")
 
@@ -75,11 +81,28 @@ func GenerateIndexJSON(providerDir string) error {
 					indexFile["archives"] = archives
 				}
 
+				archivePath := filepath.Join(providerDir, name)
+				info, err := os.Stat(archivePath)
+				if err != nil {
+					return fmt.Errorf("failed to stat archive %s: %w", archivePath, err)
+				}
+
+				// Хеш пересчитываем только если архив изменился (size+mtime)
+				hash, ok := cachedHash(archives[platform+"_"+arch], info)
+				if !ok {
+					hash, err = calculateHash(archivePath)
+					if err != nil {
+						return err
+					}
+				}
+
 				// Добавляем информацию о файле
 				fileName := filepath.Base(name)
 				archives[platform+"_"+arch] = map[string]any{
 					"hashes": []string{hash},
 					"url":    fmt.Sprintf("%s", fileName),
+					"size":   info.Size(),
+					"mtime":  info.ModTime().UTC().Format(time.RFC3339Nano),
 				}
 
 				// Сохраняем обновленный индекс
@@ -89,47 +112,171 @@ func GenerateIndexJSON(providerDir string) error {
 	}
 
 	// Write index.json
-	outPath := filepath.Join(providerDir, "index.json")
-	outFile, err := os.Create(outPath)
+	if err := saveIndex(filepath.Join(providerDir, "index.json"), index); err != nil {
+		return fmt.Errorf("failed to write index.json: %w", err)
+	}
+	return nil
+}
+
+// SetProtocols records the plugin protocol versions of a provider version in
+// the "protocols" field of <version>.json, next to "archives". The field is
+// kept by GenerateIndexJSON and used by the server's registry endpoints.
+func SetProtocols(providerDir, version string, protocols []string) error {
+	if len(protocols) == 0 {
+		return nil
+	}
+	return setVersionField(providerDir, version, "protocols", protocols)
+}
+
+// SetSigningKeys records the GPG keys the registry reported for a provider
+// version in the "signing_keys" field of <version>.json. The server returns
+// them from its registry download endpoint, which Terraform requires.
+func SetSigningKeys(providerDir, version string, keys common.SigningKeys) error {
+	if len(keys.GPGPublicKeys) == 0 {
+		return nil
+	}
+	return setVersionField(providerDir, version, "signing_keys", keys)
+}
+
+// setVersionField sets a top-level field of <version>.json, creating the
+// file if needed. An unchanged value leaves the file untouched.
+func setVersionField(providerDir, version, field string, value any) error {
+	encoded, err := json.Marshal(value)
 	if err != nil {
-		return fmt.Errorf("failed to create index.json: %w", err)
+		return fmt.Errorf("failed to encode %s: %w", field, err)
+	}
+	var decoded any
+	json.Unmarshal(encoded, &decoded)
+
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	indexPath := filepath.Join(providerDir, version+".json")
+	indexFile := make(map[string]any)
+	if data, err := os.ReadFile(indexPath); err == nil {
+		if err := json.Unmarshal(data, &indexFile); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", indexPath, err)
+		}
+	}
+	if reflect.DeepEqual(indexFile[field], decoded) {
+		return nil
 	}
-	defer outFile.Close()
-	enc := json.NewEncoder(outFile)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(index); err != nil {
-		return fmt.Errorf("failed to encode index.json: %w", err)
+	if _, ok := indexFile["archives"].(map[string]any); !ok {
+		indexFile["archives"] = make(map[string]any)
+	}
+	indexFile[field] = decoded
+	if err := saveIndex(indexPath, indexFile); err != nil {
+		return fmt.Errorf("failed to write %s: %w", indexPath, err)
 	}
 	return nil
 }
 
+// RemoveArchive drops the os_arch entry of a deleted archive from
+// <version>.json, removing the file once no archive is left
+func RemoveArchive(providerDir, version, platform string) error {
+	versionMu.Lock()
+	defer versionMu.Unlock()
+
+	indexPath := filepath.Join(providerDir, version+".json")
+	data, err := os.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", indexPath, err)
+	}
+	indexFile := make(map[string]any)
+	if err := json.Unmarshal(data, &indexFile); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", indexPath, err)
+	}
+
+	archives, _ := indexFile["archives"].(map[string]any)
+	if _, ok := archives[platform]; !ok {
+		return nil
+	}
+	delete(archives, platform)
+	if len(archives) == 0 {
+		return os.Remove(indexPath)
+	}
+	if err := saveIndex(indexPath, indexFile); err != nil {
+		return fmt.Errorf("failed to write %s: %w", indexPath, err)
+	}
+	return nil
+}
+
+// cachedHash returns the h1: hash stored for an archive entry of <version>.json
+// if the recorded size and mtime still match the file on disk.
+// Terraform ignores the extra "size" and "mtime" fields.
+func cachedHash(entry any, info os.FileInfo) (string, bool) {
+	archive, ok := entry.(map[string]any)
+	if !ok {
+		return "", false
+	}
+	size, ok := archive["size"].(float64)
+	if !ok || int64(size) != info.Size() {
+		return "", false
+	}
+	mtime, ok := archive["mtime"].(string)
+	if !ok || mtime != info.ModTime().UTC().Format(time.RFC3339Nano) {
+		return "", false
+	}
+	hashes, ok := archive["hashes"].([]any)
+	if !ok {
+		return "", false
+	}
+	for _, h := range hashes {
+		if hash, ok := h.(string); ok && strings.HasPrefix(hash, "h1:") {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
 // calculateHash вычисляет хеш файла, все как в исходниках terraform
 // https://github.com/hashicorp/terraform/blob/main/internal/getproviders/hash.go#L296
 func calculateHash(filePath string) (string, error) {
-	archivePath, err := filepath.EvalSymlinks(string(filePath))
+	archivePath, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		// Битая ссылка — не пишем фиктивный h1: хеш в <version>.json
+		return "", fmt.Errorf("failed to resolve archive path %s: %w", filePath, err)
+	}
 
 	// Используем HashZip для вычисления хеша
 	hash, err := dirhash.HashZip(archivePath, dirhash.Hash1)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("failed to hash archive %s: %w", archivePath, err)
 	}
 
 	return hash, nil
 }
 
-// saveIndex сохраняет индекс в файл
+// saveIndex сохраняет индекс в файл. The file is replaced by a rename, never
+// rewritten in place: readers (the server) see the old or the new content,
+// and a staged hardlink of a published file is not modified through the link.
 func saveIndex(path string, data any) error {
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
 
-	file, err := os.Create(path)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	encoder := json.NewEncoder(file)
+	encoder := json.NewEncoder(tmp)
 	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	err = encoder.Encode(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,216 @@
+package indexgen
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeZip writes a small valid zip to path
+func writeZip(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, _ := w.Create("terraform-provider")
+	entry.Write([]byte(filepath.Base(path)))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalculateHash(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "terraform-provider-null_3.2.0_linux_amd64.zip")
+	writeZip(t, archive)
+	want, err := calculateHash(archive)
+	if err != nil {
+		t.Fatalf("calculateHash: %v", err)
+	}
+	if !strings.HasPrefix(want, "h1:") {
+		t.Fatalf("calculateHash = %q, want an h1: hash", want)
+	}
+
+	link := filepath.Join(dir, "link.zip")
+	if err := os.Symlink(archive, link); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(dir, "broken.zip")
+	if err := os.Symlink(filepath.Join(dir, "missing.zip"), broken); err != nil {
+		t.Fatal(err)
+	}
+	notZip := filepath.Join(dir, "not.zip")
+	if err := os.WriteFile(notZip, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{name: "archive", path: archive, want: want},
+		{name: "symlink to the archive", path: link, want: want},
+		{name: "broken symlink", path: broken, wantErr: true},
+		{name: "missing file", path: filepath.Join(dir, "missing.zip"), wantErr: true},
+		{name: "not a zip", path: notZip, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := calculateHash(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("calculateHash error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("calculateHash = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateIndexJSONBrokenSymlink(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "terraform-provider-null_3.2.0_linux_amd64.zip")
+	if err := os.Symlink(filepath.Join(dir, "missing.zip"), archive); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := GenerateIndexJSON(dir); err == nil {
+		t.Fatal("GenerateIndexJSON succeeded with a broken archive symlink, want an error")
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "3.2.0.json")); err == nil && strings.Contains(string(data), "h1:") {
+		t.Errorf("3.2.0.json has a hash for the broken archive: %s", data)
+	}
+}
+
+func TestGenerateIndexJSONReusesHashes(t *testing.T) {
+	const filename = "terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name       string
+		change     func(t *testing.T, archive string)
+		wantCached bool
+	}{
+		// A planted hash survives only if the archive is not hashed again
+		{name: "unchanged archive", wantCached: true},
+		{name: "touched archive", change: func(t *testing.T, archive string) {
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(archive, later, later); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "resized archive", change: func(t *testing.T, archive string) {
+			info, _ := os.Stat(archive)
+			f, err := os.OpenFile(archive, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("trailing"))
+			f.Close()
+			// same mtime, so only the size tells the change
+			os.Chtimes(archive, info.ModTime(), info.ModTime())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, filename)
+			writeZip(t, archive)
+			if err := GenerateIndexJSON(dir); err != nil {
+				t.Fatalf("GenerateIndexJSON: %v", err)
+			}
+
+			// Plant a marker hash in place of the computed one
+			versionPath := filepath.Join(dir, "3.2.0.json")
+			data, _ := os.ReadFile(versionPath)
+			var index map[string]any
+			json.Unmarshal(data, &index)
+			entry := index["archives"].(map[string]any)["linux_amd64"].(map[string]any)
+			real := entry["hashes"].([]any)[0].(string)
+			entry["hashes"] = []string{"h1:cached="}
+			data, _ = json.Marshal(index)
+			if err := os.WriteFile(versionPath, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.change != nil {
+				tt.change(t, archive)
+			}
+			if err := GenerateIndexJSON(dir); err != nil {
+				t.Fatalf("GenerateIndexJSON: %v", err)
+			}
+
+			data, _ = os.ReadFile(versionPath)
+			json.Unmarshal(data, &index)
+			got := index["archives"].(map[string]any)["linux_amd64"].(map[string]any)["hashes"].([]any)[0].(string)
+			want := real
+			if tt.wantCached {
+				want = "h1:cached="
+			}
+			if got != want {
+				t.Errorf("hash = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestSetProtocols(t *testing.T) {
+	const filename = "terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name      string
+		protocols []string
+		generate  string // "before" or "after" SetProtocols, empty for never
+		want      []string
+	}{
+		{name: "before the index exists", protocols: []string{"5.0"}, want: []string{"5.0"}},
+		{name: "kept when the index is regenerated", protocols: []string{"5.0", "6.0"}, generate: "after", want: []string{"5.0", "6.0"}},
+		{name: "added to a generated index", protocols: []string{"6.0"}, generate: "before", want: []string{"6.0"}},
+		{name: "no protocols reported", protocols: nil, generate: "before", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeZip(t, filepath.Join(dir, filename))
+			if tt.generate == "before" {
+				if err := GenerateIndexJSON(dir); err != nil {
+					t.Fatalf("GenerateIndexJSON: %v", err)
+				}
+			}
+			if err := SetProtocols(dir, "3.2.0", tt.protocols); err != nil {
+				t.Fatalf("SetProtocols: %v", err)
+			}
+			if tt.generate == "after" {
+				if err := GenerateIndexJSON(dir); err != nil {
+					t.Fatalf("GenerateIndexJSON: %v", err)
+				}
+			}
+
+			var versionFile struct {
+				Archives  map[string]json.RawMessage `json:"archives"`
+				Protocols []string                   `json:"protocols"`
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "3.2.0.json"))
+			if err == nil {
+				err = json.Unmarshal(data, &versionFile)
+			}
+			if err != nil && tt.generate != "" {
+				t.Fatalf("read 3.2.0.json: %v", err)
+			}
+			if strings.Join(versionFile.Protocols, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("protocols = %v, want %v", versionFile.Protocols, tt.want)
+			}
+			if _, ok := versionFile.Archives["linux_amd64"]; tt.generate != "" && !ok {
+				t.Errorf("archives = %v, want linux_amd64 kept", versionFile.Archives)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"tf-mirror/internal/common"
+)
+
+// modulesDir is where module archives are stored under the download path
+const modulesDir = "modules"
+
+// ModuleClient handles the Module Registry Protocol of the upstream registry
+type ModuleClient struct {
+	client  *common.HTTPClient
+	baseURL string
+	logger  *common.Logger
+}
+
+// NewModuleClient creates a module client sharing the HTTP client of a registry client
+func NewModuleClient(registry *RegistryClient) *ModuleClient {
+	return &ModuleClient{
+		client:  registry.client,
+		baseURL: registry.baseURL,
+		logger:  registry.logger,
+	}
+}
+
+// GetModuleVersions retrieves all versions of a module
+func (m *ModuleClient) GetModuleVersions(ctx context.Context, namespace, name, system string) ([]string, error) {
+	url := fmt.Sprintf("%s/v1/modules/%s/%s/%s/versions", m.baseURL, namespace, name, system)
+
+	resp, err := m.client.GetWithContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get module versions for %s/%s/%s: %w", namespace, name, system, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("module %s/%s/%s not found in registry", namespace, name, system)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned status %d for module %s/%s/%s versions", resp.StatusCode, namespace, name, system)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var versions common.ModuleVersions
+	if err := json.Unmarshal(body, &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse module versions: %w", err)
+	}
+
+	var out []string
+	for _, module := range versions.Modules {
+		for _, v := range module.Versions {
+			out = append(out, v.Version)
+		}
+	}
+	return out, nil
+}
+
+// GetModuleSource returns the go-getter source address of a module version,
+// taken from the X-Terraform-Get header (or the "location" field of a JSON body)
+func (m *ModuleClient) GetModuleSource(ctx context.Context, namespace, name, system, version string) (string, error) {
+	downloadURL := fmt.Sprintf("%s/v1/modules/%s/%s/%s/%s/download", m.baseURL, namespace, name, system, version)
+
+	resp, err := m.client.GetWithContext(ctx, downloadURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to get module download for %s/%s/%s %s: %w", namespace, name, system, version, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned status %d for module %s/%s/%s %s download", resp.StatusCode, namespace, name, system, version)
+	}
+
+	location := resp.Header.Get("X-Terraform-Get")
+	if location == "" && resp.StatusCode == http.StatusOK {
+		var body struct {
+			Location string `json:"location"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil {
+			location = body.Location
+		}
+	}
+	if location == "" {
+		return "", fmt.Errorf("registry returned no download location for module %s/%s/%s %s", namespace, name, system, version)
+	}
+
+	// Относительный адрес разрешается от URL запроса, как в Terraform
+	if !strings.Contains(location, "::") {
+		if ref, err := url.Parse(location); err == nil && ref.Scheme == "" && !strings.HasPrefix(location, "github.com/") {
+			if base, err := url.Parse(downloadURL); err == nil {
+				location = base.ResolveReference(ref).String()
+			}
+		}
+	}
+	return location, nil
+}
+
+// moduleArchive resolves a go-getter source into a plain archive URL that can
+// be fetched over HTTP. GitHub repositories are fetched as codeload tarballs,
+// whose single top-level directory is skipped with the "*" subdir glob.
+// Other VCS sources are not supported yet.
+func moduleArchive(source string) (archiveURL, ext, subdir string, err error) {
+	forced, src := "", source
+	if i := strings.Index(src, "::"); i > 0 {
+		forced, src = src[:i], src[i+2:]
+	}
+	if strings.HasPrefix(src, "github.com/") {
+		src = "https://" + src
+	}
+
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid module source '%s': %w", source, err)
+	}
+	if i := strings.Index(u.Path, "//"); i >= 0 {
+		subdir = strings.Trim(u.Path[i+2:], "/")
+		u.Path = u.Path[:i]
+	}
+	query := u.Query()
+
+	if u.Host == "github.com" && (forced == "" || forced == "git") {
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) != 2 {
+			return "", "", "", fmt.Errorf("unsupported GitHub module source '%s'", source)
+		}
+		ref := query.Get("ref")
+		if ref == "" {
+			ref = "HEAD"
+		}
+		repo := strings.TrimSuffix(parts[1], ".git")
+		archiveURL = fmt.Sprintf("https://codeload.github.com/%s/%s/tar.gz/%s", parts[0], repo, url.PathEscape(ref))
+		return archiveURL, ".tar.gz", path.Join("*", subdir), nil
+	}
+
+	if forced != "" && forced != "http" && forced != "https" {
+		return "", "", "", fmt.Errorf("unsupported module source '%s': only GitHub and HTTP archives can be mirrored", source)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", "", fmt.Errorf("unsupported module source '%s': only GitHub and HTTP archives can be mirrored", source)
+	}
+
+	switch archive := query.Get("archive"); {
+	case archive == "zip" || strings.HasSuffix(u.Path, ".zip"):
+		ext = ".zip"
+	case archive == "tar.gz" || archive == "tgz" || strings.HasSuffix(u.Path, ".tar.gz") || strings.HasSuffix(u.Path, ".tgz"):
+		ext = ".tar.gz"
+	default:
+		return "", "", "", fmt.Errorf("unsupported module source '%s': not a .zip or .tar.gz archive", source)
+	}
+	query.Del("archive")
+	u.RawQuery = query.Encode()
+	return u.String(), ext, subdir, nil
+}
+
+// moduleDir returns <download-path>/modules/<namespace>/<name>/<system>
+func (s *Service) moduleDir(module common.ModuleFilterItem) string {
+	return filepath.Join(s.config.DownloadPath, modulesDir, module.Namespace, module.Name, module.System)
+}
+
+// mirrorModules downloads the modules listed in --module-filter
+func (s *Service) mirrorModules(ctx context.Context) error {
+	modules, err := common.ParseModuleFilter(s.config.ModuleFilter)
+	if err != nil {
+		return err
+	}
+	if len(modules) == 0 {
+		return nil
+	}
+
+	s.logger.Info("Starting download of %d modules", len(modules))
+	client := NewModuleClient(s.registry)
+	failed := 0
+	for _, module := range modules {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.mirrorModule(ctx, client, module); err != nil {
+			s.logger.Error("Failed to mirror module %s/%s/%s: %v", module.Namespace, module.Name, module.System, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d modules failed", failed, len(modules))
+	}
+	s.logger.Info("Module download completed")
+	return nil
+}
+
+// mirrorModule downloads the selected versions of one module and updates its index.json
+func (s *Service) mirrorModule(ctx context.Context, client *ModuleClient, module common.ModuleFilterItem) error {
+	versions, err := client.GetModuleVersions(ctx, module.Namespace, module.Name, module.System)
+	if err != nil {
+		return err
+	}
+	versions = common.FilterVersionsByRange(versions, module.MinVersion, module.MaxVersion)
+	if !s.config.Prerelease {
+		versions = common.FilterStableVersions(versions)
+	}
+	if s.config.KeepLatest > 0 {
+		versions = common.KeepLatestVersions(versions, s.config.KeepLatest)
+	}
+
+	dir := s.moduleDir(module)
+	index := readModuleIndex(dir)
+	var firstErr error
+	for _, version := range versions {
+		if ctx.Err() != nil {
+			firstErr = ctx.Err()
+			break
+		}
+		archive, err := s.downloadModule(ctx, client, module, version, dir, index.Versions[version])
+		if err != nil {
+			s.logger.Error("Failed to download module %s/%s/%s %s: %v", module.Namespace, module.Name, module.System, version, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		index.Versions[version] = archive
+	}
+
+	if len(index.Versions) > 0 {
+		if err := writeModuleIndex(dir, index); err != nil {
+			return err
+		}
+	}
+	return firstErr
+}
+
+// downloadModule downloads one module version unless the recorded archive is
+// still on disk with a matching checksum
+func (s *Service) downloadModule(ctx context.Context, client *ModuleClient, module common.ModuleFilterItem, version, dir string, existing common.ModuleArchive) (common.ModuleArchive, error) {
+	if existing.Filename != "" && s.verifyChecksum(filepath.Join(dir, existing.Filename), existing.SHA256) {
+		s.logger.Debug("Module already exists: %s/%s/%s %s (skipping download)", module.Namespace, module.Name, module.System, version)
+		return existing, nil
+	}
+
+	source, err := client.GetModuleSource(ctx, module.Namespace, module.Name, module.System, version)
+	if err != nil {
+		return common.ModuleArchive{}, err
+	}
+	archiveURL, ext, subdir, err := moduleArchive(source)
+	if err != nil {
+		return common.ModuleArchive{}, err
+	}
+
+	filename := fmt.Sprintf("%s-%s_%s%s", module.Name, module.System, version, ext)
+	s.logger.Info("Downloading module: %s/%s/%s %s", module.Namespace, module.Name, module.System, version)
+	s.logger.Debug("Module source: %s, archive: %s", source, archiveURL)
+	digest, err := s.registry.DownloadFileSHA256(ctx, archiveURL, filepath.Join(dir, filename), "")
+	if err != nil {
+		return common.ModuleArchive{}, err
+	}
+
+	return common.ModuleArchive{
+		Filename: filename,
+		SHA256:   digest,
+		Subdir:   subdir,
+		Source:   source,
+	}, nil
+}
+
+// readModuleIndex reads the index.json of a module directory, returning an
+// empty index if it is missing or unreadable
+func readModuleIndex(dir string) common.ModuleIndex {
+	index := common.ModuleIndex{}
+	if data, err := os.ReadFile(filepath.Join(dir, "index.json")); err == nil {
+		json.Unmarshal(data, &index)
+	}
+	if index.Versions == nil {
+		index.Versions = make(map[string]common.ModuleArchive)
+	}
+	return index
+}
+
+// writeModuleIndex writes the index.json of a module directory
+func writeModuleIndex(dir string, index common.ModuleIndex) error {
+	if err := createDirIfNotExists(dir); err != nil {
+		return fmt.Errorf("failed to create module directory %s: %w", dir, err)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode module index: %w", err)
+	}
+	indexPath := filepath.Join(dir, "index.json")
+	if err := os.WriteFile(indexPath+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write module index: %w", err)
+	}
+	return renameFile(indexPath+".tmp", indexPath)
+}
@@ -0,0 +1,123 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"tf-mirror/internal/common"
+)
+
+// providerPlan is the outcome of planning one provider
+type providerPlan struct {
+	planned  bool // false when planning did not run or was interrupted
+	versions []common.Version
+	jobs     []DownloadJob
+	skipped  int // platforms already mirrored or filtered out
+	err      error
+}
+
+// planProviders lists versions, fetches missing version metadata json and
+// builds the jobs of each provider, up to MaxConcurrent providers at a time
+// so registry round trips overlap. Plans are returned in the order of
+// providers; no new provider is started once ctx is done or the registry is
+// unavailable.
+func (s *Service) planProviders(ctx context.Context, providers []common.ProviderListItem, platforms []common.Platform) []providerPlan {
+	plans := make([]providerPlan, len(providers))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < max(s.config.MaxConcurrent, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				plans[index] = s.planProvider(ctx, providers[index], platforms)
+			}
+		}()
+	}
+
+	for index := range providers {
+		if ctx.Err() != nil || s.registry.Unavailable() {
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+	return plans
+}
+
+// selectVersions returns the versions of a provider to mirror: those in the
+// filter's range (or above the global minimum), stable unless Prerelease is
+// set, and only the newest KeepLatest of them
+func (s *Service) selectVersions(namespace, name string, versions []string) []string {
+	// Получаем minVersion из фильтра (или глобальный минимум)
+	minVersion := s.minVersionFor(namespace, name)
+	// Фильтруем версии по диапазону minVersion..maxVersion
+	maxVersion := s.providerFilter.GetMaxVersion(namespace, name)
+	filteredVersions := common.FilterVersionsByRange(versions, minVersion, maxVersion)
+	if !s.config.Prerelease {
+		filteredVersions = common.FilterStableVersions(filteredVersions)
+	}
+	// Оставляем только N последних версий из отфильтрованных
+	if s.config.KeepLatest > 0 {
+		filteredVersions = common.KeepLatestVersions(filteredVersions, s.config.KeepLatest)
+	}
+	return filteredVersions
+}
+
+// planProvider plans the downloads of one provider
+func (s *Service) planProvider(ctx context.Context, provider common.ProviderListItem, platforms []common.Platform) providerPlan {
+	s.logger.Debug("Planning provider: %s/%s", provider.Namespace, provider.Name)
+
+	var plan providerPlan
+	versions, err := s.registry.GetProviderVersions(ctx, provider.Namespace, provider.Name)
+	if ctx.Err() != nil {
+		return plan
+	}
+	plan.planned = true
+	if err != nil {
+		plan.err = fmt.Errorf("failed to get versions: %w", err)
+		return plan
+	}
+	plan.versions = versions.Versions
+
+	filteredVersions := s.selectVersions(provider.Namespace, provider.Name, getVersionStrings(versions.Versions))
+	var missingJSON []string
+	for _, versionStr := range filteredVersions {
+		if !fileExists(s.registry.GetProviderVersionJSONPath(s.config.DownloadPath, provider.Namespace, provider.Name, versionStr)) {
+			missingJSON = append(missingJSON, versionStr)
+		}
+		for _, platform := range platforms {
+			if s.shouldDownload(provider.Namespace, provider.Name, versionStr, platform.OS, platform.Arch) {
+				plan.jobs = append(plan.jobs, DownloadJob{
+					Namespace: provider.Namespace,
+					Name:      provider.Name,
+					Version:   versionStr,
+					OS:        platform.OS,
+					Arch:      platform.Arch,
+				})
+			} else {
+				plan.skipped++
+			}
+		}
+	}
+
+	// With --staging-path only providers with changes get a working copy
+	if s.config.StagingPath != "" && (len(plan.jobs) > 0 || len(missingJSON) > 0) {
+		if err := s.stageProvider(provider.Namespace, provider.Name); err != nil {
+			plan.jobs = nil
+			plan.err = fmt.Errorf("failed to stage: %w", err)
+			return plan
+		}
+	}
+	// Скачиваем metadata json для версии, если его нет
+	for _, versionStr := range missingJSON {
+		versionJSONPath := s.registry.GetProviderVersionJSONPath(s.writePath(), provider.Namespace, provider.Name, versionStr)
+		if !fileExists(versionJSONPath) {
+			s.downloadVersionJSON(ctx, provider.Namespace, provider.Name, versionStr, versionJSONPath)
+		}
+	}
+	return plan
+}
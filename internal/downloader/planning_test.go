@@ -0,0 +1,195 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/blang/semver/v4"
+
+	"tf-mirror/internal/common"
+)
+
+func TestGlobalMinVersion(t *testing.T) {
+	versions := []string{"1.0.0", "2.0.0", "2.5.0", "3.0.0", "3.1.0"}
+	tests := []struct {
+		name      string
+		filter    string
+		globalMin string
+		provider  string
+		wantMin   string
+		want      []string
+	}{
+		{name: "no minimum", provider: "hashicorp/null", want: versions},
+		{name: "global floor", globalMin: "2.5.0", provider: "hashicorp/null", wantMin: "2.5.0", want: []string{"2.5.0", "3.0.0", "3.1.0"}},
+		{name: "global floor on a provider without its own minimum", filter: "hashicorp/null>3.0.0,hashicorp/random", globalMin: "2.0.0", provider: "hashicorp/random", wantMin: "2.0.0", want: []string{"2.0.0", "2.5.0", "3.0.0", "3.1.0"}},
+		{name: "provider minimum overrides a lower floor", filter: "hashicorp/null>3.0.0", globalMin: "2.0.0", provider: "hashicorp/null", wantMin: "3.0.0", want: []string{"3.0.0", "3.1.0"}},
+		{name: "provider minimum overrides a higher floor", filter: "hashicorp/null>1.0.0", globalMin: "3.0.0", provider: "hashicorp/null", wantMin: "1.0.0", want: versions},
+		{name: "wildcard without minimum keeps the floor", filter: "hashicorp/*", globalMin: "3.0.0", provider: "hashicorp/null", wantMin: "3.0.0", want: []string{"3.0.0", "3.1.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &common.DownloaderConfig{ProviderFilter: tt.filter, GlobalMinVersion: tt.globalMin})
+			namespace, name, _ := strings.Cut(tt.provider, "/")
+			if got := s.minVersionFor(namespace, name); got != tt.wantMin {
+				t.Errorf("minVersionFor(%s) = %q, want %q", tt.provider, got, tt.wantMin)
+			}
+			if got := s.selectVersions(namespace, name, versions); !slices.Equal(got, tt.want) {
+				t.Errorf("selectVersions(%s) = %v, want %v", tt.provider, got, tt.want)
+			}
+		})
+	}
+}
+
+// bySemver orders versions oldest first
+func bySemver(a, b string) int {
+	return semver.MustParse(a).Compare(semver.MustParse(b))
+}
+
+func TestKeepLatestQueuesNewestVersions(t *testing.T) {
+	// 1.0.0 .. 1.29.0 in string order, so 1.9.0 is listed after 1.29.0
+	var versions []string
+	for i := range 30 {
+		versions = append(versions, fmt.Sprintf("1.%d.0", i))
+	}
+	slices.Sort(versions)
+
+	tests := []struct {
+		name       string
+		filter     string
+		globalMin  string
+		keepLatest int
+		want       []string
+	}{
+		{name: "all versions", want: versions},
+		{name: "newest 5", keepLatest: 5, want: []string{"1.25.0", "1.26.0", "1.27.0", "1.28.0", "1.29.0"}},
+		{name: "more than available", keepLatest: 40, want: versions},
+		{name: "minimum applied first", globalMin: "1.27.0", keepLatest: 5, want: []string{"1.27.0", "1.28.0", "1.29.0"}},
+		{name: "filter range applied first", filter: "hashicorp/null>1.5.0<1.12.0", keepLatest: 3, want: []string{"1.9.0", "1.10.0", "1.11.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": versions})
+			s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: tt.filter, GlobalMinVersion: tt.globalMin, KeepLatest: tt.keepLatest})
+
+			plans := s.planProviders(context.Background(), []common.ProviderListItem{{Namespace: "hashicorp", Name: "null"}},
+				[]common.Platform{{OS: "linux", Arch: "amd64"}})
+			if plans[0].err != nil {
+				t.Fatalf("planning failed: %v", plans[0].err)
+			}
+			var queued []string
+			for _, job := range plans[0].jobs {
+				queued = append(queued, job.Version)
+			}
+			want := slices.Clone(tt.want)
+			slices.SortFunc(queued, bySemver)
+			slices.SortFunc(want, bySemver)
+			if !slices.Equal(queued, want) {
+				t.Errorf("queued %v, want %v", queued, want)
+			}
+		})
+	}
+}
+
+func TestSelectVersionsPrerelease(t *testing.T) {
+	versions := []string{"4.9.0", "5.0.0-beta1", "5.0.0-rc1", "5.0.0", "5.1.0-rc1"}
+	tests := []struct {
+		name       string
+		prerelease bool
+		filter     string
+		keepLatest int
+		want       []string
+	}{
+		{name: "stable by default", want: []string{"4.9.0", "5.0.0"}},
+		{name: "prereleases included", prerelease: true, want: versions},
+		{name: "range applies to prereleases", prerelease: true, filter: "hashicorp/aws>5.0.0", want: []string{"5.0.0", "5.1.0-rc1"}},
+		{name: "keep latest counts stable versions only", keepLatest: 1, want: []string{"5.0.0"}},
+		{name: "keep latest with prereleases", prerelease: true, keepLatest: 2, want: []string{"5.1.0-rc1", "5.0.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &common.DownloaderConfig{ProviderFilter: tt.filter, Prerelease: tt.prerelease, KeepLatest: tt.keepLatest})
+			if got := s.selectVersions("hashicorp", "aws", versions); !slices.Equal(got, tt.want) {
+				t.Errorf("selectVersions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// planningFixture returns a registry of n providers answering version
+// listings after latency, slower for providers earlier in the list so that
+// concurrent planning finishes them out of order
+func planningFixture(tb testing.TB, n int, latency time.Duration) (*fakeRegistry, []common.ProviderListItem) {
+	tb.Helper()
+	versions := make(map[string][]string, n)
+	providers := make([]common.ProviderListItem, n)
+	delays := make(map[string]time.Duration, n)
+	for i := range n {
+		name := fmt.Sprintf("p%03d", i)
+		versions["hashicorp/"+name] = []string{"1.0.0", "1.1.0"}
+		providers[i] = common.ProviderListItem{Namespace: "hashicorp", Name: name}
+		delays["/v1/providers/hashicorp/"+name+"/versions"] = latency * time.Duration(n-i) / time.Duration(n)
+	}
+	reg := newFakeRegistry(tb, versions)
+	reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if delay, ok := delays[r.URL.Path]; ok {
+			time.Sleep(delay)
+		}
+		return false
+	}
+	return reg, providers
+}
+
+func TestPlanProvidersOrder(t *testing.T) {
+	reg, providers := planningFixture(t, 40, 5*time.Millisecond)
+	platforms := []common.Platform{{OS: "linux", Arch: "amd64"}, {OS: "darwin", Arch: "arm64"}}
+
+	var want []DownloadJob
+	for _, provider := range providers {
+		for _, version := range []string{"1.0.0", "1.1.0"} {
+			for _, platform := range platforms {
+				want = append(want, DownloadJob{Namespace: provider.Namespace, Name: provider.Name, Version: version, OS: platform.OS, Arch: platform.Arch})
+			}
+		}
+	}
+
+	for _, concurrency := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("%d workers", concurrency), func(t *testing.T) {
+			s := newFakeService(t, reg, &common.DownloaderConfig{MaxConcurrent: concurrency})
+			plans := s.planProviders(context.Background(), providers, platforms)
+			if len(plans) != len(providers) {
+				t.Fatalf("got %d plans for %d providers", len(plans), len(providers))
+			}
+
+			var got []DownloadJob
+			for i, plan := range plans {
+				if !plan.planned || plan.err != nil {
+					t.Fatalf("plan of %s: planned %t, err %v", providers[i].Name, plan.planned, plan.err)
+				}
+				got = append(got, plan.jobs...)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("jobs are not in provider, version, platform order:\ngot  %v\nwant %v", got, want)
+			}
+		})
+	}
+}
+
+func BenchmarkPlanProviders(b *testing.B) {
+	reg, providers := planningFixture(b, 200, 2*time.Millisecond)
+	platforms := []common.Platform{{OS: "linux", Arch: "amd64"}}
+
+	for _, concurrency := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("%d workers", concurrency), func(b *testing.B) {
+			s := newFakeService(b, reg, &common.DownloaderConfig{MaxConcurrent: concurrency})
+			b.ResetTimer()
+			for range b.N {
+				s.planProviders(context.Background(), providers, platforms)
+			}
+		})
+	}
+}
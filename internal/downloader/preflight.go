@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"tf-mirror/internal/common"
+)
+
+// ErrInsufficientSpace is returned with RequireFreeSpace when the estimated
+// size of a session's downloads exceeds the free space of the write path
+var ErrInsufficientSpace = errors.New("insufficient free space")
+
+// defaultArchiveEstimate is the assumed size of an archive when nothing of
+// the mirror is on disk yet to go by
+const defaultArchiveEstimate = 30 << 20
+
+// estimateJobBytes estimates the space needed by jobs. The registry API does
+// not report archive sizes, so each job counts as the largest archive of the
+// same provider on disk, or the average archive of the providers seen, or
+// defaultArchiveEstimate.
+func (s *Service) estimateJobBytes(jobs []DownloadJob) int64 {
+	largest := make(map[string]int64)
+	var total, count int64
+	for _, job := range jobs {
+		key := job.providerKey()
+		if _, ok := largest[key]; ok {
+			continue
+		}
+		largest[key] = 0
+		entries, err := readDir(filepath.Join(s.providerRoot(), job.Namespace, job.Name))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".zip") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			largest[key] = max(largest[key], info.Size())
+			total += info.Size()
+			count++
+		}
+	}
+
+	fallback := int64(defaultArchiveEstimate)
+	if count > 0 {
+		fallback = total / count
+	}
+	var estimate int64
+	for _, job := range jobs {
+		if size := largest[job.providerKey()]; size > 0 {
+			estimate += size
+		} else {
+			estimate += fallback
+		}
+	}
+	return estimate
+}
+
+// preflightDiskSpace estimates the size of the queued downloads and compares
+// it with the free space where they are written. A shortfall is a warning,
+// or ErrInsufficientSpace with RequireFreeSpace. It returns the estimate.
+func (s *Service) preflightDiskSpace(jobs []DownloadJob) (int64, error) {
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+	estimate := s.estimateJobBytes(jobs)
+
+	stats, err := diskSpace(s.writePath())
+	if err != nil {
+		s.logger.Info("Estimated download size: %s for %d jobs (free space unknown: %v)", common.FormatBytes(estimate), len(jobs), err)
+		return estimate, nil
+	}
+	s.logger.Info("Estimated download size: %s for %d jobs, %s free", common.FormatBytes(estimate), len(jobs), common.FormatBytes(int64(stats.Free)))
+	if uint64(estimate) <= stats.Free {
+		return estimate, nil
+	}
+
+	if s.config.RequireFreeSpace {
+		return estimate, fmt.Errorf("%w: about %s needed, %s free in %s", ErrInsufficientSpace,
+			common.FormatBytes(estimate), common.FormatBytes(int64(stats.Free)), s.writePath())
+	}
+	s.logger.Warn("The queued downloads (about %s) may not fit in the %s free in %s", common.FormatBytes(estimate), common.FormatBytes(int64(stats.Free)), s.writePath())
+	return estimate, nil
+}
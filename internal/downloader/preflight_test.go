@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+// stubDiskSpace makes diskSpace report stats, or err, for the test
+func stubDiskSpace(t *testing.T, stats common.DiskStats, err error) {
+	t.Helper()
+	orig := diskSpace
+	diskSpace = func(string) (common.DiskStats, error) { return stats, err }
+	t.Cleanup(func() { diskSpace = orig })
+}
+
+func TestPreflightDiskSpace(t *testing.T) {
+	jobs := []DownloadJob{
+		{Namespace: "hashicorp", Name: "null", Version: "3.2.1", OS: "linux", Arch: "amd64"},
+		{Namespace: "hashicorp", Name: "null", Version: "3.2.1", OS: "darwin", Arch: "arm64"},
+		{Namespace: "hashicorp", Name: "aws", Version: "5.0.0", OS: "linux", Arch: "amd64"},
+	}
+
+	tests := []struct {
+		name         string
+		onDisk       map[string]int // archive under hashicorp/ -> size
+		free         uint64
+		statErr      error
+		require      bool
+		wantEstimate int64
+		wantErr      bool
+	}{
+		{
+			name:         "empty mirror uses the default estimate",
+			free:         1 << 40,
+			wantEstimate: 3 * defaultArchiveEstimate,
+		},
+		{
+			name:         "sizes of archives on disk",
+			onDisk:       map[string]int{"null/terraform-provider-null_3.2.0_linux_amd64.zip": 1000, "null/terraform-provider-null_3.2.0_darwin_arm64.zip": 3000},
+			free:         1 << 40,
+			wantEstimate: 3000 + 3000 + 2000, // largest of null twice, the average for aws
+		},
+		{
+			name:         "low space is a warning",
+			free:         1 << 20,
+			wantEstimate: 3 * defaultArchiveEstimate,
+		},
+		{
+			name:         "low space with --require-free-space",
+			free:         1 << 20,
+			require:      true,
+			wantEstimate: 3 * defaultArchiveEstimate,
+			wantErr:      true,
+		},
+		{
+			name:         "free space unknown",
+			statErr:      common.ErrDiskSpaceUnsupported,
+			require:      true,
+			wantEstimate: 3 * defaultArchiveEstimate,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubDiskSpace(t, common.DiskStats{Total: 1 << 40, Free: tt.free}, tt.statErr)
+			s := newTestService(t, &common.DownloaderConfig{RequireFreeSpace: tt.require})
+			for path, size := range tt.onDisk {
+				writeTestFile(t, filepath.Join(s.providerRoot(), "hashicorp", path), strings.Repeat("x", size))
+			}
+
+			estimate, err := s.preflightDiskSpace(jobs)
+			if estimate != tt.wantEstimate {
+				t.Errorf("estimate = %d, want %d", estimate, tt.wantEstimate)
+			}
+			if got := errors.Is(err, ErrInsufficientSpace); got != tt.wantErr || (err != nil && !got) {
+				t.Errorf("preflightDiskSpace error = %v, want insufficient space %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreflightSkipsSessionThatCannotFit(t *testing.T) {
+	stubDiskSpace(t, common.DiskStats{Total: 1 << 40, Free: 1 << 20}, nil)
+	reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.1"}})
+	summaryPath := filepath.Join(t.TempDir(), "summary.json")
+	s := newFakeService(t, reg, &common.DownloaderConfig{
+		RequireFreeSpace: true,
+		PlatformFilter:   "linux_amd64,darwin_arm64",
+		SummaryJSON:      summaryPath,
+	})
+
+	err := s.RunOnce(context.Background())
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("RunOnce = %v, want ErrInsufficientSpace", err)
+	}
+	for _, platform := range []string{"linux/amd64", "darwin/arm64"} {
+		if n := reg.requestCount("/v1/providers/hashicorp/null/3.2.1/download/" + platform); n != 0 {
+			t.Errorf("%s was requested %d times by a session that cannot fit", platform, n)
+		}
+	}
+
+	data, err := os.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	var summary RunSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("summary: %v", err)
+	}
+	if want := int64(2 * defaultArchiveEstimate); summary.EstimatedBytes != want {
+		t.Errorf("summary estimated_bytes = %d, want %d", summary.EstimatedBytes, want)
+	}
+}
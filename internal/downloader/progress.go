@@ -0,0 +1,61 @@
+package downloader
+
+import (
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// progressInterval is the minimum time between two progress lines
+const progressInterval = 30 * time.Second
+
+// progressReporter logs aggregate progress of a download session with --progress
+type progressReporter struct {
+	logger     *common.Logger
+	total      int
+	start      time.Time
+	lastReport time.Time
+}
+
+// newProgressReporter returns a reporter for total jobs, or nil if progress is disabled
+func newProgressReporter(enabled bool, logger *common.Logger, total int) *progressReporter {
+	if !enabled || total == 0 {
+		return nil
+	}
+	now := time.Now()
+	return &progressReporter{logger: logger, total: total, start: now, lastReport: now}
+}
+
+// Update records that done jobs have finished and logs a line at most every
+// progressInterval, plus once when all jobs are done
+func (p *progressReporter) Update(done int) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if done < p.total && now.Sub(p.lastReport) < progressInterval {
+		return
+	}
+	p.lastReport = now
+
+	elapsed := now.Sub(p.start)
+	percent := float64(done) * 100 / float64(p.total)
+	if eta, ok := estimateETA(done, p.total, elapsed); ok && done < p.total {
+		p.logger.Info("Progress: %d/%d jobs (%.1f%%), ETA ~%s", done, p.total, percent, eta.Round(time.Second))
+	} else {
+		p.logger.Info("Progress: %d/%d jobs (%.1f%%), elapsed %s", done, p.total, percent, elapsed.Round(time.Second))
+	}
+}
+
+// estimateETA extrapolates the remaining time from the average throughput so
+// far. It returns false until at least one job has finished.
+func estimateETA(done, total int, elapsed time.Duration) (time.Duration, bool) {
+	if done <= 0 || total <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	if done >= total {
+		return 0, true
+	}
+	perJob := elapsed / time.Duration(done)
+	return perJob * time.Duration(total-done), true
+}
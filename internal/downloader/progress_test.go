@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestEstimateETA(t *testing.T) {
+	tests := []struct {
+		name    string
+		done    int
+		total   int
+		elapsed time.Duration
+		want    time.Duration
+		wantOK  bool
+	}{
+		{name: "nothing done yet", done: 0, total: 10, elapsed: time.Minute},
+		{name: "no time elapsed", done: 1, total: 10, elapsed: 0},
+		{name: "no jobs", done: 0, total: 0, elapsed: time.Minute},
+		{name: "a quarter done", done: 25, total: 100, elapsed: time.Minute, want: 3 * time.Minute, wantOK: true},
+		{name: "half done", done: 5, total: 10, elapsed: 10 * time.Second, want: 10 * time.Second, wantOK: true},
+		{name: "one job left", done: 9, total: 10, elapsed: 90 * time.Second, want: 10 * time.Second, wantOK: true},
+		{name: "all done", done: 10, total: 10, elapsed: time.Minute, want: 0, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := estimateETA(tt.done, tt.total, tt.elapsed)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("estimateETA(%d, %d, %v) = %v, %t, want %v, %t", tt.done, tt.total, tt.elapsed, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProgressReporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		total    int
+		done     int
+		sinceLog time.Duration
+		want     string // empty when no line is logged
+	}{
+		{name: "disabled", enabled: false, total: 10, done: 10},
+		{name: "no jobs", enabled: true, total: 0, done: 0},
+		{name: "within the interval", enabled: true, total: 10, done: 5, sinceLog: time.Second},
+		{name: "interval elapsed", enabled: true, total: 10, done: 5, sinceLog: progressInterval, want: "Progress: 5/10 jobs (50.0%), ETA ~"},
+		{name: "all done within the interval", enabled: true, total: 10, done: 10, sinceLog: time.Second, want: "Progress: 10/10 jobs (100.0%), elapsed "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := common.NewLogger()
+			logger.SetOutput(io.Discard)
+			logger.TeeTo(&buf)
+
+			p := newProgressReporter(tt.enabled, logger, tt.total)
+			if p != nil {
+				p.start = p.start.Add(-time.Hour)
+				p.lastReport = time.Now().Add(-tt.sinceLog)
+			}
+			p.Update(tt.done)
+
+			got := buf.String()
+			if tt.want == "" && got != "" {
+				t.Errorf("logged %q, want nothing", got)
+			}
+			if tt.want != "" && !strings.Contains(got, tt.want) {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrProviderTimeout is returned for jobs of a provider that used up
+// ProviderTimeout in the current session; they are left for the next one
+var ErrProviderTimeout = errors.New("provider timeout exceeded")
+
+// providerClock records when each provider's first job of a session started
+type providerClock struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+// resetProviderClock starts a new session's provider clocks
+func (s *Service) resetProviderClock() {
+	s.clock.mu.Lock()
+	defer s.clock.mu.Unlock()
+	s.clock.started = make(map[string]time.Time)
+}
+
+// providerDeadline returns when the provider of job runs out of
+// ProviderTimeout, starting its clock on the first call. ok is false when
+// ProviderTimeout is not set.
+func (s *Service) providerDeadline(job DownloadJob) (deadline time.Time, ok bool) {
+	if s.config.ProviderTimeout <= 0 {
+		return time.Time{}, false
+	}
+	s.clock.mu.Lock()
+	defer s.clock.mu.Unlock()
+	key := job.providerKey()
+	started, exists := s.clock.started[key]
+	if !exists {
+		if s.clock.started == nil {
+			s.clock.started = make(map[string]time.Time)
+		}
+		started = time.Now()
+		s.clock.started[key] = started
+	}
+	return started.Add(s.config.ProviderTimeout), true
+}
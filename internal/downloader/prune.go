@@ -0,0 +1,234 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader/indexgen"
+)
+
+// pruneCandidate is a provider version on disk that no longer matches the filter
+type pruneCandidate struct {
+	namespace string
+	name      string
+	version   string
+	files     []string // absolute paths: zips, SHA256SUMS, .sig and <version>.json
+}
+
+// pruneStaleVersions removes provider versions that no longer satisfy the
+// active filters, then regenerates the index files of affected providers.
+// The full plan is logged before anything is deleted, and with PruneDryRun
+// nothing else happens; files whose names cannot be parsed are left alone.
+func (s *Service) pruneStaleVersions() error {
+	providerRoot := s.providerRoot()
+	candidates, err := s.planPrune(providerRoot)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		s.logger.Info("Prune: nothing to remove")
+		return nil
+	}
+
+	for _, c := range candidates {
+		s.logger.Info("Prune plan: %s/%s %s (%d files)", c.namespace, c.name, c.version, len(c.files))
+		for _, file := range c.files {
+			if s.config.PruneDryRun {
+				s.logger.Info("Prune plan:   %s", file)
+			} else {
+				s.logger.Debug("Prune plan:   %s", file)
+			}
+		}
+	}
+	if s.config.PruneDryRun {
+		s.logger.Info("Prune dry run: %d versions would be removed, nothing deleted", len(candidates))
+		return nil
+	}
+
+	affected := make(map[string]struct{})
+	removedFiles := 0
+	for _, c := range candidates {
+		for _, file := range c.files {
+			if err := removeFileHandle(file); err != nil && !os.IsNotExist(err) {
+				s.logger.Warn("Prune: failed to remove %s: %v", file, err)
+				continue
+			}
+			removedFiles++
+		}
+		s.removeVersionMetadata(c.namespace, c.name, c.version)
+		affected[c.namespace+"/"+c.name] = struct{}{}
+	}
+
+	for providerKey := range affected {
+		namespace, name, _ := strings.Cut(providerKey, "/")
+		providerDir := filepath.Join(providerRoot, namespace, name)
+		if err := indexgen.GenerateIndexJSON(providerDir); err != nil {
+			s.logger.Error("Prune: failed to regenerate index.json for %s: %v", providerKey, err)
+		}
+	}
+
+	if err := s.saveMetadata(); err != nil {
+		s.logger.Error("Failed to save metadata after prune: %v", err)
+	}
+
+	s.logger.Info("Prune completed: removed %d versions (%d files) across %d providers", len(candidates), removedFiles, len(affected))
+	return nil
+}
+
+// planPrune walks the provider tree and collects versions outside the filter
+func (s *Service) planPrune(providerRoot string) ([]pruneCandidate, error) {
+	namespaces, err := readDir(providerRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", providerRoot, err)
+	}
+
+	var candidates []pruneCandidate
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+		names, err := readDir(filepath.Join(providerRoot, ns.Name()))
+		if err != nil {
+			s.logger.Warn("Prune: skipping namespace %s: %v", ns.Name(), err)
+			continue
+		}
+		for _, n := range names {
+			if !n.IsDir() {
+				continue
+			}
+			providerDir := filepath.Join(providerRoot, ns.Name(), n.Name())
+			stale, err := s.staleVersions(providerDir, ns.Name(), n.Name())
+			if err != nil {
+				s.logger.Warn("Prune: skipping %s/%s: %v", ns.Name(), n.Name(), err)
+				continue
+			}
+			candidates = append(candidates, stale...)
+		}
+	}
+	return candidates, nil
+}
+
+// staleVersions returns the versions in providerDir that the filters no longer select
+func (s *Service) staleVersions(providerDir, namespace, name string) ([]pruneCandidate, error) {
+	entries, err := readDir(providerDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// version -> files belonging to it
+	files := make(map[string][]string)
+	zipPrefix := "terraform-provider-" + name + "_"
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		version, ok := versionOfFile(entry.Name(), zipPrefix)
+		if !ok {
+			continue
+		}
+		files[version] = append(files[version], filepath.Join(providerDir, entry.Name()))
+	}
+
+	var onDisk []string
+	for version := range files {
+		onDisk = append(onDisk, version)
+	}
+
+	keep := make(map[string]struct{})
+	if s.providerFilter.ShouldInclude(namespace, name) {
+		kept := common.FilterVersionsByRange(onDisk, s.minVersionFor(namespace, name), s.providerFilter.GetMaxVersion(namespace, name))
+		if !s.config.Prerelease {
+			kept = common.FilterStableVersions(kept)
+		}
+		if s.config.KeepLatest > 0 {
+			kept = common.KeepLatestVersions(kept, s.config.KeepLatest)
+		}
+		for _, version := range kept {
+			keep[version] = struct{}{}
+		}
+	}
+
+	var stale []pruneCandidate
+	for version, paths := range files {
+		if _, ok := keep[version]; ok {
+			continue
+		}
+		// <version>.json sits next to the archives
+		if jsonPath := filepath.Join(providerDir, version+".json"); fileExists(jsonPath) {
+			paths = append(paths, jsonPath)
+		}
+		sort.Strings(paths)
+		stale = append(stale, pruneCandidate{namespace: namespace, name: name, version: version, files: paths})
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].version < stale[j].version
+	})
+	return stale, nil
+}
+
+// versionOfFile extracts the version from provider artifacts named
+// <prefix><version>_<os>_<arch>.zip, <prefix><version>_SHA256SUMS or
+// <prefix><version>_SHA256SUMS.sig. Only parsable semver versions are returned.
+func versionOfFile(filename, prefix string) (string, bool) {
+	if !strings.HasPrefix(filename, prefix) {
+		return "", false
+	}
+	rest := strings.TrimPrefix(filename, prefix)
+	var version string
+	switch {
+	case strings.HasSuffix(rest, "_SHA256SUMS"):
+		version = strings.TrimSuffix(rest, "_SHA256SUMS")
+	case strings.HasSuffix(rest, "_SHA256SUMS.sig"):
+		version = strings.TrimSuffix(rest, "_SHA256SUMS.sig")
+	case strings.HasSuffix(rest, ".zip"):
+		parts := strings.Split(strings.TrimSuffix(rest, ".zip"), "_")
+		if len(parts) != 3 {
+			return "", false
+		}
+		version = parts[0]
+	default:
+		return "", false
+	}
+	if _, err := semver.ParseTolerant(version); err != nil {
+		return "", false
+	}
+	return version, true
+}
+
+// removeVersionMetadata drops a pruned version from the provider metadata
+func (s *Service) removeVersionMetadata(namespace, name, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	providerKey := fmt.Sprintf("%s/%s", namespace, name)
+	info, ok := s.metadata.Providers[providerKey]
+	if !ok {
+		return
+	}
+	versions := info.Versions[:0]
+	for _, v := range info.Versions {
+		if v != version {
+			versions = append(versions, v)
+		}
+	}
+	info.Versions = versions
+	for key := range info.Files {
+		if strings.HasPrefix(key, version+"_") {
+			delete(info.Files, key)
+		}
+	}
+	if len(info.Versions) == 0 {
+		delete(s.metadata.Providers, providerKey)
+		return
+	}
+	s.metadata.Providers[providerKey] = info
+}
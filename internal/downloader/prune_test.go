@@ -0,0 +1,198 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader/indexgen"
+)
+
+// writePruneFixture mirrors hashicorp/null 3.1.0, 3.2.0, 3.2.1 and
+// 3.3.0-beta1 and hashicorp/aws 5.0.0 for linux_amd64, each with SHA256SUMS,
+// signature, <version>.json and metadata, plus files prune cannot parse
+func writePruneFixture(t *testing.T, s *Service) {
+	t.Helper()
+	fixture := map[string][]string{
+		"hashicorp/null": {"3.1.0", "3.2.0", "3.2.1", "3.3.0-beta1"},
+		"hashicorp/aws":  {"5.0.0"},
+	}
+	for key, versions := range fixture {
+		namespace, name, _ := strings.Cut(key, "/")
+		dir := filepath.Join(s.providerRoot(), namespace, name)
+		info := ProviderInfo{Namespace: namespace, Name: name, Platforms: []string{"linux_amd64"}, Files: make(map[string]FileStatus)}
+		for _, version := range versions {
+			prefix := "terraform-provider-" + name + "_" + version
+			writeTestZip(t, filepath.Join(dir, prefix+"_linux_amd64.zip"))
+			writeTestFile(t, filepath.Join(dir, prefix+"_SHA256SUMS"), "sums")
+			writeTestFile(t, filepath.Join(dir, prefix+"_SHA256SUMS.sig"), "signature")
+			info.Versions = append(info.Versions, version)
+			info.Files[version+"_linux_amd64"] = FileStatus{}
+		}
+		if err := indexgen.GenerateIndexJSON(dir); err != nil {
+			t.Fatalf("GenerateIndexJSON: %v", err)
+		}
+		writeTestFile(t, filepath.Join(dir, "notes.txt"), "operator notes")
+		writeTestFile(t, filepath.Join(dir, "terraform-provider-"+name+"_latest_SHA256SUMS"), "unparsable version")
+		s.metadata.Providers[key] = info
+	}
+}
+
+// mirroredVersions returns the versions of namespace/name with an archive on
+// disk, the versions listed in its index.json and in the metadata
+func mirroredVersions(t *testing.T, s *Service, namespace, name string) (archives, indexed, metadata []string) {
+	t.Helper()
+	dir := filepath.Join(s.providerRoot(), namespace, name)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if version, ok := versionOfFile(entry.Name(), "terraform-provider-"+name+"_"); ok && filepath.Ext(entry.Name()) == ".zip" {
+			archives = append(archives, version)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "index.json")); err == nil {
+		var index indexgen.IndexJSON
+		if err := json.Unmarshal(data, &index); err != nil {
+			t.Fatalf("index.json of %s/%s: %v", namespace, name, err)
+		}
+		for version := range index.Versions {
+			indexed = append(indexed, version)
+		}
+	}
+	metadata = slices.Clone(s.metadata.Providers[namespace+"/"+name].Versions)
+	sort.Strings(archives)
+	sort.Strings(indexed)
+	sort.Strings(metadata)
+	return archives, indexed, metadata
+}
+
+func TestPruneStaleVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   common.DownloaderConfig
+		wantNull []string
+		wantAWS  []string
+	}{
+		{
+			name:     "min version raised",
+			config:   common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.2.0,hashicorp/aws"},
+			wantNull: []string{"3.2.0", "3.2.1"},
+			wantAWS:  []string{"5.0.0"},
+		},
+		{
+			name:     "exclusive max version",
+			config:   common.DownloaderConfig{ProviderFilter: "hashicorp/null<3.2.1,hashicorp/aws"},
+			wantNull: []string{"3.1.0", "3.2.0"},
+			wantAWS:  []string{"5.0.0"},
+		},
+		{
+			name:     "provider dropped from the filter",
+			config:   common.DownloaderConfig{ProviderFilter: "hashicorp/null"},
+			wantNull: []string{"3.1.0", "3.2.0", "3.2.1"},
+		},
+		{
+			name:     "prereleases kept with --include-prerelease",
+			config:   common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.2.1,hashicorp/aws", Prerelease: true},
+			wantNull: []string{"3.2.1", "3.3.0-beta1"},
+			wantAWS:  []string{"5.0.0"},
+		},
+		{
+			name:     "keep latest",
+			config:   common.DownloaderConfig{ProviderFilter: "hashicorp/*", KeepLatest: 1},
+			wantNull: []string{"3.2.1"},
+			wantAWS:  []string{"5.0.0"},
+		},
+		{
+			name:     "dry run deletes nothing",
+			config:   common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.2.0", PruneDryRun: true},
+			wantNull: []string{"3.1.0", "3.2.0", "3.2.1", "3.3.0-beta1"},
+			wantAWS:  []string{"5.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Prune = true
+			s := newTestService(t, &config)
+			writePruneFixture(t, s)
+
+			if err := s.pruneStaleVersions(); err != nil {
+				t.Fatalf("pruneStaleVersions: %v", err)
+			}
+
+			for _, provider := range []struct {
+				name string
+				want []string
+			}{{"null", tt.wantNull}, {"aws", tt.wantAWS}} {
+				archives, indexed, metadata := mirroredVersions(t, s, "hashicorp", provider.name)
+				if !slices.Equal(archives, provider.want) {
+					t.Errorf("%s archives = %v, want %v", provider.name, archives, provider.want)
+				}
+				if !slices.Equal(metadata, provider.want) {
+					t.Errorf("%s metadata versions = %v, want %v", provider.name, metadata, provider.want)
+				}
+				if len(provider.want) > 0 && !slices.Equal(indexed, provider.want) {
+					t.Errorf("%s index.json versions = %v, want %v", provider.name, indexed, provider.want)
+				}
+
+				dir := filepath.Join(s.providerRoot(), "hashicorp", provider.name)
+				for _, kept := range []string{"notes.txt", "terraform-provider-" + provider.name + "_latest_SHA256SUMS"} {
+					if !fileExists(filepath.Join(dir, kept)) {
+						t.Errorf("%s was removed although prune cannot parse it", kept)
+					}
+				}
+				for _, version := range []string{"3.1.0", "3.2.0", "3.2.1", "3.3.0-beta1", "5.0.0"} {
+					wanted := slices.Contains(provider.want, version)
+					prefix := "terraform-provider-" + provider.name + "_" + version
+					for _, file := range []string{prefix + "_SHA256SUMS", prefix + "_SHA256SUMS.sig", version + ".json"} {
+						if exists := fileExists(filepath.Join(dir, file)); exists && !wanted {
+							t.Errorf("%s was left behind for a pruned version", file)
+						} else if !exists && wanted {
+							t.Errorf("%s of a kept version was removed", file)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPruneSkippedAfterFailedDownloads(t *testing.T) {
+	tests := []struct {
+		name       string
+		failed     bool
+		wantPruned bool
+	}{
+		{name: "successful pass prunes", wantPruned: true},
+		{name: "failed download keeps stale versions", failed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			if tt.failed {
+				reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+					if r.URL.Path != "/files/terraform-provider-null_3.2.0_linux_amd64.zip" {
+						return false
+					}
+					w.WriteHeader(http.StatusInternalServerError)
+					return true
+				}
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.2.0", Prune: true})
+			stale := filepath.Join(s.providerRoot(), "hashicorp", "null", "terraform-provider-null_3.1.0_linux_amd64.zip")
+			writeTestZip(t, stale)
+
+			s.RunOnce(context.Background())
+
+			if pruned := !fileExists(stale); pruned != tt.wantPruned {
+				t.Errorf("stale 3.1.0 archive pruned = %t, want %t", pruned, tt.wantPruned)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader/indexgen"
+)
+
+// ErrNotMirrored is returned by FetchArchive for providers, versions or
+// platforms excluded by the filters, which pull-through never fetches
+var ErrNotMirrored = errors.New("not mirrored")
+
+// FetchArchive downloads one provider archive on demand, for the server's
+// pull-through mode. It goes through the same checks as a regular run
+// (filters, version range, --prerelease and --keep-latest, SHA256SUMS,
+// signatures with VerifySignatures, retries with backoff), saves
+// the version metadata json and regenerates the provider's index.json. The
+// downloader metadata file is left alone: it belongs to the downloader, which
+// finds the archive on disk and skips it.
+func (s *Service) FetchArchive(ctx context.Context, namespace, name, version, osName, archName string) error {
+	if !s.providerFilter.ShouldInclude(namespace, name) ||
+		(s.platformFilter.IsEnabled() && !s.platformFilter.ShouldInclude(osName, archName)) {
+		return fmt.Errorf("%w: %s/%s %s_%s", ErrNotMirrored, namespace, name, osName, archName)
+	}
+	// --keep-latest depends on the other versions, which only upstream knows
+	candidates := []string{version}
+	if s.config.KeepLatest > 0 {
+		versions, err := s.registry.GetProviderVersions(ctx, namespace, name)
+		if err != nil {
+			return fmt.Errorf("failed to get versions: %w", err)
+		}
+		candidates = getVersionStrings(versions.Versions)
+	}
+	if !slices.Contains(s.selectVersions(namespace, name, candidates), version) {
+		return fmt.Errorf("%w: %s/%s %s", ErrNotMirrored, namespace, name, version)
+	}
+
+	// Like a regular run, fetch the registry's <version>.json before the
+	// archive records its protocols there
+	versionJSON := s.registry.GetProviderVersionJSONPath(s.config.DownloadPath, namespace, name, version)
+	if !fileExists(versionJSON) {
+		s.downloadVersionJSON(ctx, namespace, name, version, versionJSON)
+	}
+
+	s.registry.ResetAvailability()
+	job := DownloadJob{Namespace: namespace, Name: name, Version: version, OS: osName, Arch: archName}
+	timeout := s.config.DownloadTimeout
+	if timeout <= 0 {
+		timeout = common.DefaultTimeout
+	}
+	if err, _ := s.runJob(ctx, job, 0, max(s.config.MaxAttempts, 1), timeout); err != nil {
+		return err
+	}
+
+	if err := indexgen.GenerateIndexJSON(filepath.Join(s.providerRoot(), namespace, name)); err != nil {
+		return fmt.Errorf("failed to generate index.json for %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// ProviderVersions returns the versions the upstream registry lists for a
+// provider, for the server's pull-through mode
+func (s *Service) ProviderVersions(ctx context.Context, namespace, name string) (*common.ProviderVersions, error) {
+	if !s.providerFilter.ShouldInclude(namespace, name) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotMirrored, namespace, name)
+	}
+	return s.registry.GetProviderVersions(ctx, namespace, name)
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestFetchArchiveFilters(t *testing.T) {
+	// Upstream knows nothing, so an archive that passes the filters ends
+	// with a registry error instead of ErrNotMirrored
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	tests := []struct {
+		name        string
+		config      common.DownloaderConfig
+		namespace   string
+		version     string
+		platform    string
+		wantSkipped bool
+	}{
+		{name: "in range", config: common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.0.0<4.0.0"}, version: "3.2.0"},
+		{name: "below the filter minimum", config: common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.0.0<4.0.0"}, version: "2.1.0", wantSkipped: true},
+		{name: "at the exclusive filter maximum", config: common.DownloaderConfig{ProviderFilter: "hashicorp/null>3.0.0<4.0.0"}, version: "4.0.0", wantSkipped: true},
+		{name: "below --min-version", config: common.DownloaderConfig{GlobalMinVersion: "3.0.0"}, version: "2.1.0", wantSkipped: true},
+		{name: "filter minimum overrides --min-version", config: common.DownloaderConfig{ProviderFilter: "hashicorp/null>2.0.0", GlobalMinVersion: "3.0.0"}, version: "2.1.0"},
+		{name: "prerelease without --prerelease", version: "3.3.0-beta1", wantSkipped: true},
+		{name: "prerelease with --prerelease", config: common.DownloaderConfig{Prerelease: true}, version: "3.3.0-beta1"},
+		{name: "provider excluded", config: common.DownloaderConfig{ProviderFilter: "hashicorp/aws"}, version: "3.2.0", wantSkipped: true},
+		{name: "platform excluded", config: common.DownloaderConfig{PlatformFilter: "linux_amd64"}, version: "3.2.0", platform: "darwin_arm64", wantSkipped: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.DownloadPath = t.TempDir()
+			s, err := NewService(&config, &common.RegistryConfig{BaseURL: upstream.URL}, common.NewLogger())
+			if err != nil {
+				t.Fatalf("NewService: %v", err)
+			}
+			defer s.Close()
+
+			platform := tt.platform
+			if platform == "" {
+				platform = "linux_amd64"
+			}
+			osName, arch, _ := strings.Cut(platform, "_")
+			err = s.FetchArchive(context.Background(), "hashicorp", "null", tt.version, osName, arch)
+			if skipped := errors.Is(err, ErrNotMirrored); skipped != tt.wantSkipped {
+				t.Errorf("FetchArchive(%s) = %v, want not mirrored %t", tt.version, err, tt.wantSkipped)
+			}
+		})
+	}
+}
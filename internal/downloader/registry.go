@@ -1,92 +1,253 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"tf-mirror/internal/common"
 )
 
+// ErrRegistryUnavailable is returned when the registry keeps answering 503,
+// typically during a maintenance window. The run should be retried later.
+var ErrRegistryUnavailable = errors.New("registry unavailable")
+
+// ErrPackageNotFound is returned when the registry has no package for a
+// platform: the provider simply does not publish it
+var ErrPackageNotFound = errors.New("provider package not published")
+
+// ErrVersionJSONNotFound is returned when the registry does not serve
+// <version>.json for a provider version, as most registries other than
+// registry.terraform.io do not
+var ErrVersionJSONNotFound = errors.New("version metadata not published")
+
+// ErrDownloadsFailed is returned by a run in which some downloads still failed
+// after all retries
+var ErrDownloadsFailed = errors.New("downloads failed")
+
+// rateLimitPageRetries is how many times a discovery page is retried after the
+// client has given up on 429 responses
+const rateLimitPageRetries = 5
+
+// unavailableThreshold is the number of distinct providers that must get a
+// 503 in a row before the registry is considered unavailable
+const unavailableThreshold = 5
+
 // RegistryClient handles communication with the Terraform registry
 type RegistryClient struct {
-	client  *common.HTTPClient
-	baseURL string
-	logger  *common.Logger
+	client   *common.HTTPClient
+	baseURL  string
+	hostname string // top-level directory of the on-disk layout
+	logger   *common.Logger
+	limiter  *common.RateLimiter // shared by all workers, nil = unlimited
+
+	availMu     sync.Mutex
+	unavailable map[string]struct{} // providers in the current run of consecutive 503s
 }
 
 // NewRegistryClient creates a new registry client
 func NewRegistryClient(config *common.RegistryConfig, logger *common.Logger) (*RegistryClient, error) {
+	hostname, err := common.RegistryHostname(config.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := common.NewHTTPClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP client: %w", err)
 	}
+	client.SetLogger(logger)
 
 	return &RegistryClient{
-		client:  client,
-		baseURL: config.BaseURL,
-		logger:  logger,
+		client:      client,
+		baseURL:     strings.TrimSuffix(config.BaseURL, "/"),
+		hostname:    hostname,
+		logger:      logger,
+		limiter:     common.NewRateLimiter(config.MaxBandwidth),
+		unavailable: make(map[string]struct{}),
 	}, nil
 }
 
-// DiscoverAllProviders discovers all available providers from the registry
-func (r *RegistryClient) DiscoverAllProviders() ([]common.ProviderListItem, error) {
-	r.logger.Info("Discovering all providers from registry.terraform.io...")
+// observeStatus tracks consecutive 503 responses; any other status ends the streak.
+// An open circuit for the registry host counts as a 503.
+func (r *RegistryClient) observeStatus(provider string, statusCode int) {
+	r.availMu.Lock()
+	defer r.availMu.Unlock()
+	if statusCode == http.StatusServiceUnavailable {
+		r.unavailable[provider] = struct{}{}
+		return
+	}
+	if len(r.unavailable) > 0 {
+		r.unavailable = make(map[string]struct{})
+	}
+}
+
+// Unavailable reports whether enough different providers got a 503 in a row
+// to treat the registry as down
+func (r *RegistryClient) Unavailable() bool {
+	r.availMu.Lock()
+	defer r.availMu.Unlock()
+	return len(r.unavailable) >= unavailableThreshold
+}
+
+// ResetAvailability clears the 503 streak, e.g. at the start of a new run
+func (r *RegistryClient) ResetAvailability() {
+	r.availMu.Lock()
+	defer r.availMu.Unlock()
+	r.unavailable = make(map[string]struct{})
+}
+
+// DiscoverAllProviders discovers all available providers from the registry.
+// Cancelling ctx aborts the pagination with ctx's error.
+func (r *RegistryClient) DiscoverAllProviders(ctx context.Context) ([]common.ProviderListItem, error) {
+	return r.DiscoverProviders(ctx, nil)
+}
+
+// DiscoveryPage is one cached page of the provider listing
+type DiscoveryPage struct {
+	ETag         string                    `json:"etag,omitempty"`
+	LastModified string                    `json:"last_modified,omitempty"`
+	Providers    []common.ProviderListItem `json:"providers"`
+}
+
+// DiscoverProviders is DiscoverAllProviders with a page cache keyed by offset.
+// Cached pages are requested with If-None-Match/If-Modified-Since and reused
+// on 304 Not Modified; pages without an ETag or Last-Modified are not cached,
+// so registries that support neither get a full discovery every time. pages
+// is updated in place and may be nil.
+func (r *RegistryClient) DiscoverProviders(ctx context.Context, pages map[int]*DiscoveryPage) ([]common.ProviderListItem, error) {
+	r.logger.Info("Discovering all providers from %s...", r.hostname)
 
 	var allProviders []common.ProviderListItem
 	offset := 0
 	limit := 100 // Registry pagination limit
+	rateLimited := 0
+	fetched, notModified := 0, 0
 
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("provider discovery interrupted at offset %d: %w", offset, err)
+		}
 		r.logger.Debug("Fetching providers with offset=%d, limit=%d", offset, limit)
 
 		url := fmt.Sprintf("%s/v1/providers?offset=%d&limit=%d", r.baseURL, offset, limit)
-		resp, err := r.client.Get(url)
+		cached := pages[offset]
+		header := make(http.Header)
+		if cached != nil {
+			if cached.ETag != "" {
+				header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
+		resp, err := r.client.GetWithHeaders(ctx, url, header)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get provider list at offset %d: %w", offset, err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("registry returned status %d for provider list at offset %d", resp.StatusCode, offset)
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: status 503 for provider list at offset %d", ErrRegistryUnavailable, offset)
 		}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read response body: %w", err)
+		// The client already retried; keep waiting on the same page instead of
+		// throwing away a long discovery because of a rate-limit burst
+		if resp.StatusCode == http.StatusTooManyRequests && rateLimited < rateLimitPageRetries {
+			wait, ok := common.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if !ok || wait > common.MaxRetryAfter {
+				wait = common.MaxRetryAfter
+			}
+			rateLimited++
+			r.logger.Warn("Rate limited at offset %d, waiting %v before retrying the page (%d/%d)", offset, wait, rateLimited, rateLimitPageRetries)
+			resp.Body.Close()
+			if err := common.SleepContext(ctx, wait); err != nil {
+				return nil, fmt.Errorf("provider discovery interrupted at offset %d: %w", offset, err)
+			}
+			continue
 		}
-
-		var providerList common.ProviderList
-		if err := json.Unmarshal(body, &providerList); err != nil {
-			return nil, fmt.Errorf("failed to parse provider list: %w", err)
+		rateLimited = 0
+
+		var providers []common.ProviderListItem
+		switch {
+		case resp.StatusCode == http.StatusNotModified && cached != nil:
+			resp.Body.Close()
+			providers = cached.Providers
+			notModified++
+		case resp.StatusCode == http.StatusOK:
+			body, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read response body: %w", err)
+			}
+
+			var providerList common.ProviderList
+			if err := json.Unmarshal(body, &providerList); err != nil {
+				return nil, fmt.Errorf("failed to parse provider list: %w", err)
+			}
+			providers = providerList.Providers
+			fetched++
+
+			if pages != nil {
+				page := &DiscoveryPage{
+					ETag:         resp.Header.Get("ETag"),
+					LastModified: resp.Header.Get("Last-Modified"),
+					Providers:    providers,
+				}
+				if page.ETag != "" || page.LastModified != "" {
+					pages[offset] = page
+				} else {
+					delete(pages, offset)
+				}
+			}
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("registry returned status %d for provider list at offset %d", resp.StatusCode, offset)
 		}
 
-		if len(providerList.Providers) == 0 {
+		if len(providers) == 0 {
 			break // No more providers
 		}
 
-		allProviders = append(allProviders, providerList.Providers...)
-		r.logger.Debug("Found %d providers in this batch (total: %d)", len(providerList.Providers), len(allProviders))
+		allProviders = append(allProviders, providers...)
+		r.logger.Debug("Found %d providers in this batch (total: %d)", len(providers), len(allProviders))
 
 		// If we got less than the limit, we've reached the end
-		if len(providerList.Providers) < limit {
+		if len(providers) < limit {
 			break
 		}
 
 		offset += limit
 	}
 
-	r.logger.Info("Discovery complete: found %d total providers", len(allProviders))
+	// The listing may have shrunk since the pages were cached
+	for cachedOffset := range pages {
+		if cachedOffset > offset {
+			delete(pages, cachedOffset)
+		}
+	}
+
+	if notModified > 0 {
+		r.logger.Info("Discovery complete: found %d total providers (%d pages fetched, %d not modified)", len(allProviders), fetched, notModified)
+	} else {
+		r.logger.Info("Discovery complete: found %d total providers", len(allProviders))
+	}
 	return allProviders, nil
 }
 
 // GetProviderList retrieves all available providers from the registry (legacy method)
-func (r *RegistryClient) GetProviderList() (*common.ProviderList, error) {
-	providers, err := r.DiscoverAllProviders()
+func (r *RegistryClient) GetProviderList(ctx context.Context) (*common.ProviderList, error) {
+	providers, err := r.DiscoverAllProviders(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -97,14 +258,18 @@ func (r *RegistryClient) GetProviderList() (*common.ProviderList, error) {
 }
 
 // GetProviderVersions retrieves all versions for a specific provider
-func (r *RegistryClient) GetProviderVersions(namespace, name string) (*common.ProviderVersions, error) {
+func (r *RegistryClient) GetProviderVersions(ctx context.Context, namespace, name string) (*common.ProviderVersions, error) {
 	url := fmt.Sprintf("%s/v1/providers/%s/%s/versions", r.baseURL, namespace, name)
 
-	resp, err := r.client.Get(url)
+	resp, err := r.client.GetWithContext(ctx, url)
+	if errors.Is(err, common.ErrCircuitOpen) {
+		r.observeStatus(namespace+"/"+name, http.StatusServiceUnavailable)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get provider versions for %s/%s: %w", namespace, name, err)
 	}
 	defer resp.Body.Close()
+	r.observeStatus(namespace+"/"+name, resp.StatusCode)
 
 	if resp.StatusCode == http.StatusNotFound {
 		return nil, fmt.Errorf("provider %s/%s not found in registry", namespace, name)
@@ -132,13 +297,17 @@ func (r *RegistryClient) GetProviderPackage(ctx context.Context, namespace, name
 	url := fmt.Sprintf("%s/v1/providers/%s/%s/%s/download/%s/%s", r.baseURL, namespace, name, version, os, arch)
 
 	resp, err := r.client.GetWithContext(ctx, url)
+	if errors.Is(err, common.ErrCircuitOpen) {
+		r.observeStatus(namespace+"/"+name, http.StatusServiceUnavailable)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get provider package for %s/%s %s %s/%s: %w", namespace, name, version, os, arch, err)
 	}
 	defer resp.Body.Close()
+	r.observeStatus(namespace+"/"+name, resp.StatusCode)
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, fmt.Errorf("provider package %s/%s %s %s/%s not found in registry", namespace, name, version, os, arch)
+		return nil, fmt.Errorf("%w: %s/%s %s %s/%s", ErrPackageNotFound, namespace, name, version, os, arch)
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -158,31 +327,92 @@ func (r *RegistryClient) GetProviderPackage(ctx context.Context, namespace, name
 	return &pkg, nil
 }
 
+// DownloadVersionJSON saves the <version>.json metadata of a provider version
+// to destPath through a temporary file, so an interrupted or garbled response
+// never leaves a truncated file behind
+func (r *RegistryClient) DownloadVersionJSON(ctx context.Context, namespace, name, version, destPath string) error {
+	url := fmt.Sprintf("%s/v1/providers/%s/%s/%s.json", r.baseURL, namespace, name, version)
+	r.logger.Debug("Downloading version metadata json: %s", url)
+
+	resp, err := r.client.GetWithContext(ctx, url)
+	if err != nil {
+		return fmt.Errorf("failed to get version metadata for %s/%s %s: %w", namespace, name, version, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s/%s %s", ErrVersionJSONNotFound, namespace, name, version)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry returned status %d for version metadata %s/%s %s", resp.StatusCode, namespace, name, version)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if !json.Valid(body) {
+		return fmt.Errorf("invalid version metadata json for %s/%s %s", namespace, name, version)
+	}
+	_, err = r.saveFile(bytes.NewReader(body), destPath, "")
+	return err
+}
+
 // DownloadFile downloads a file from the given URL to the specified path
 func (r *RegistryClient) DownloadFile(ctx context.Context, url, destPath string) error {
+	_, err := r.DownloadFileSHA256(ctx, url, destPath, "")
+	return err
+}
+
+// DownloadFileSHA256 downloads a file and returns its hex-encoded SHA256,
+// computed while the body is written so the file is not read back from disk.
+// A non-empty wantSHA256 is checked before the file is moved to destPath, so
+// a corrupt download never replaces it.
+func (r *RegistryClient) DownloadFileSHA256(ctx context.Context, url, destPath, wantSHA256 string) (string, error) {
 	r.logger.Debug("Downloading file from %s to %s", url, destPath)
 
 	resp, err := r.client.GetWithContext(ctx, url)
 	if err != nil {
-		return fmt.Errorf("failed to download file from %s: %w", url, err)
+		return "", fmt.Errorf("failed to download file from %s: %w", url, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status %d for URL %s", resp.StatusCode, url)
+		return "", fmt.Errorf("download failed with status %d for URL %s", resp.StatusCode, url)
+	}
+
+	return r.saveFile(r.limiter.Reader(ctx, resp.Body), destPath, wantSHA256)
+}
+
+// DownloadSHASums downloads a SHA256SUMS file to destPath unless it already
+// exists, then parses it into a filename -> sha256 map
+func (r *RegistryClient) DownloadSHASums(ctx context.Context, url, destPath string) (map[string]string, error) {
+	if !fileExists(destPath) {
+		if err := r.DownloadFile(ctx, url, destPath); err != nil {
+			return nil, fmt.Errorf("failed to download SHA256SUMS: %w", err)
+		}
 	}
 
-	return r.saveFile(resp.Body, destPath)
+	sums, err := common.ReadSHASums(destPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("no checksums found in %s", destPath)
+	}
+	return sums, nil
 }
 
-// saveFile saves the content from reader to the specified file path
-func (r *RegistryClient) saveFile(reader io.Reader, destPath string) error {
+// saveFile saves the content from reader to the specified file path and
+// returns the SHA256 of the written content. With a non-empty wantSHA256 a
+// mismatching download is removed instead of renamed into place.
+func (r *RegistryClient) saveFile(reader io.Reader, destPath, wantSHA256 string) (string, error) {
 	r.logger.Debug("saveFile: starting for %s", destPath)
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(destPath)
 	if err := createDirIfNotExists(dir); err != nil {
 		r.logger.Error("saveFile: failed to create directory %s: %v", dir, err)
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		return "", fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 
 	// Create temporary file first
@@ -191,24 +421,31 @@ func (r *RegistryClient) saveFile(reader io.Reader, destPath string) error {
 	file, err := createFile(tempPath)
 	if err != nil {
 		r.logger.Error("saveFile: failed to create temporary file %s: %v", tempPath, err)
-		return fmt.Errorf("failed to create temporary file %s: %w", tempPath, err)
+		return "", fmt.Errorf("failed to create temporary file %s: %w", tempPath, err)
 	}
 
 	// Copy content
 	r.logger.Debug("saveFile: copying content to %s", tempPath)
-	_, err = io.Copy(file, reader)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), reader)
 	closeErr := file.Close()
 
 	if err != nil {
 		r.logger.Error("saveFile: failed to write file content to %s: %v", tempPath, err)
 		removeFile(tempPath) // Clean up on error
-		return fmt.Errorf("failed to write file content: %w", err)
+		return "", fmt.Errorf("failed to write file content: %w", err)
 	}
 
 	if closeErr != nil {
 		r.logger.Error("saveFile: failed to close file %s: %v", tempPath, closeErr)
 		removeFile(tempPath) // Clean up on error
-		return fmt.Errorf("failed to close file: %w", closeErr)
+		return "", fmt.Errorf("failed to close file: %w", closeErr)
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if wantSHA256 != "" && !strings.EqualFold(digest, wantSHA256) {
+		removeFile(tempPath)
+		return "", fmt.Errorf("%w: got %s, want %s", errChecksumMismatch, digest, wantSHA256)
 	}
 
 	// Rename temporary file to final destination
@@ -216,24 +453,29 @@ func (r *RegistryClient) saveFile(reader io.Reader, destPath string) error {
 	if err := renameFile(tempPath, destPath); err != nil {
 		r.logger.Error("saveFile: failed to rename temporary file %s to %s: %v", tempPath, destPath, err)
 		removeFile(tempPath) // Clean up on error
-		return fmt.Errorf("failed to rename temporary file: %w", err)
+		return "", fmt.Errorf("failed to rename temporary file: %w", err)
 	}
 
 	r.logger.Debug("saveFile: finished for %s", destPath)
-	return nil
+	return digest, nil
+}
+
+// Hostname returns the registry hostname, the top-level directory of the mirror
+func (r *RegistryClient) Hostname() string {
+	return r.hostname
 }
 
 // GetProviderPath returns the file path for a provider based on Terraform registry structure
 func (r *RegistryClient) GetProviderPath(basePath, namespace, name, version, os, arch, filename string) string {
 	// Network Mirror Protocol: all versions and platforms in one folder
-	// Path: <download-path>/registry.terraform.io/namespace/name/filename
-	return filepath.Join(basePath, "registry.terraform.io", namespace, name, filename)
+	// Path: <download-path>/<registry-host>/namespace/name/filename
+	return filepath.Join(basePath, r.hostname, namespace, name, filename)
 }
 
 // GetProviderVersionJSONPath returns the path for a provider version metadata json
 func (r *RegistryClient) GetProviderVersionJSONPath(basePath, namespace, name, version string) string {
-	// Path: <download-path>/registry.terraform.io/namespace/name/version.json
-	return filepath.Join(basePath, "registry.terraform.io", namespace, name, version+".json")
+	// Path: <download-path>/<registry-host>/namespace/name/version.json
+	return filepath.Join(basePath, r.hostname, namespace, name, version+".json")
 }
 
 // Close closes the registry client
@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestRegistryLayoutPerHostname(t *testing.T) {
+	tests := []struct {
+		baseURL      string
+		wantHost     string
+		wantMetadata string
+	}{
+		{baseURL: "https://registry.terraform.io", wantHost: "registry.terraform.io", wantMetadata: metadataFile},
+		{baseURL: "https://App.Terraform.io:443/", wantHost: "app.terraform.io", wantMetadata: ".tf-mirror-metadata.app.terraform.io.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wantHost, func(t *testing.T) {
+			base := t.TempDir()
+			s, err := NewService(&common.DownloaderConfig{DownloadPath: base}, &common.RegistryConfig{BaseURL: tt.baseURL}, common.NewLogger())
+			if err != nil {
+				t.Fatalf("NewService: %v", err)
+			}
+			defer s.Close()
+
+			if got := s.registry.Hostname(); got != tt.wantHost {
+				t.Errorf("Hostname() = %q, want %q", got, tt.wantHost)
+			}
+			wantArchive := filepath.Join(base, tt.wantHost, "hashicorp", "null", "terraform-provider-null_3.2.0_linux_amd64.zip")
+			if got := s.registry.GetProviderPath(base, "hashicorp", "null", "3.2.0", "linux", "amd64", "terraform-provider-null_3.2.0_linux_amd64.zip"); got != wantArchive {
+				t.Errorf("GetProviderPath() = %q, want %q", got, wantArchive)
+			}
+			wantVersion := filepath.Join(base, tt.wantHost, "hashicorp", "null", "3.2.0.json")
+			if got := s.registry.GetProviderVersionJSONPath(base, "hashicorp", "null", "3.2.0"); got != wantVersion {
+				t.Errorf("GetProviderVersionJSONPath() = %q, want %q", got, wantVersion)
+			}
+			if got, want := s.stateFile(metadataFile), filepath.Join(base, tt.wantMetadata); got != want {
+				t.Errorf("stateFile(%q) = %q, want %q", metadataFile, got, want)
+			}
+		})
+	}
+}
+
+// newPayloadClient returns a registry client and the URL of a server
+// answering every request with payload
+func newPayloadClient(tb testing.TB, payload []byte) (*RegistryClient, string) {
+	tb.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	tb.Cleanup(srv.Close)
+	client, err := NewRegistryClient(&common.RegistryConfig{BaseURL: srv.URL}, common.NewLogger())
+	if err != nil {
+		tb.Fatalf("NewRegistryClient: %v", err)
+	}
+	return client, srv.URL + "/archive.zip"
+}
+
+func TestDownloadFileSHA256(t *testing.T) {
+	payload := []byte("provider archive")
+	sum := sha256.Sum256(payload)
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name         string
+		want         string
+		existing     string // content already at the destination
+		wantMismatch bool
+		wantContent  string // destination content afterwards, "" = absent
+	}{
+		{name: "matching sum", want: digest, wantContent: string(payload)},
+		{name: "matching sum in upper case", want: strings.ToUpper(digest), wantContent: string(payload)},
+		{name: "no expected sum", wantContent: string(payload)},
+		{name: "mismatch is not stored", want: "deadbeef", wantMismatch: true},
+		{name: "mismatch keeps the existing file", want: "deadbeef", existing: "old archive", wantMismatch: true, wantContent: "old archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, url := newPayloadClient(t, payload)
+			dest := filepath.Join(t.TempDir(), "archive.zip")
+			if tt.existing != "" {
+				writeTestFile(t, dest, tt.existing)
+			}
+
+			got, err := client.DownloadFileSHA256(context.Background(), url, dest, tt.want)
+			if tt.wantMismatch {
+				if !errors.Is(err, errChecksumMismatch) {
+					t.Fatalf("DownloadFileSHA256 error = %v, want a checksum mismatch", err)
+				}
+			} else if err != nil {
+				t.Fatalf("DownloadFileSHA256: %v", err)
+			} else if got != digest {
+				t.Errorf("digest = %s, want %s", got, digest)
+			}
+
+			content, err := os.ReadFile(dest)
+			if tt.wantContent == "" && err == nil {
+				t.Errorf("%s was stored: %q", dest, content)
+			} else if tt.wantContent != "" && string(content) != tt.wantContent {
+				t.Errorf("%s = %q, want %q", dest, content, tt.wantContent)
+			}
+			if fileExists(dest + ".tmp") {
+				t.Errorf("temporary file %s.tmp was left behind", dest)
+			}
+		})
+	}
+}
+
+// BenchmarkDownloadChecksum compares hashing an archive while it is written
+// with downloading it and reading it back, as verifyChecksum used to
+func BenchmarkDownloadChecksum(b *testing.B) {
+	payload := bytes.Repeat([]byte("terraform-provider"), 1<<20/18*16) // ~16 MiB
+	sum := sha256.Sum256(payload)
+	digest := hex.EncodeToString(sum[:])
+	client, url := newPayloadClient(b, payload)
+	dest := filepath.Join(b.TempDir(), "archive.zip")
+
+	b.Run("single pass", func(b *testing.B) {
+		b.SetBytes(int64(len(payload)))
+		for range b.N {
+			if _, err := client.DownloadFileSHA256(context.Background(), url, dest, digest); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("download and re-read", func(b *testing.B) {
+		b.SetBytes(int64(len(payload)))
+		for range b.N {
+			if err := client.DownloadFile(context.Background(), url, dest); err != nil {
+				b.Fatal(err)
+			}
+			if got, err := common.FileSHA256(dest); err != nil || got != digest {
+				b.Fatalf("FileSHA256 = %s, %v", got, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package downloader
+
+import (
+	"math/rand"
+	"time"
+)
+
+// nextCheckDelay returns the delay before a scheduled check: CheckPeriod
+// shifted either way by up to CheckJitter (a fraction of the period), so
+// mirrors started together do not all hit the registry at the same moment.
+// random returns a value in [0, 1); without jitter the delay is the period.
+func nextCheckDelay(period time.Duration, jitter float64, random func() float64) time.Duration {
+	if jitter <= 0 {
+		return period
+	}
+	offset := (random()*2 - 1) * jitter * float64(period)
+	return period + time.Duration(offset)
+}
+
+// scheduleNextCheck resets timer for the check after a run started at
+// started. The period counts from the start of the run, like a ticker; a run
+// longer than the delay is followed by the next one right away.
+func (s *Service) scheduleNextCheck(timer *time.Timer, started time.Time) {
+	delay := nextCheckDelay(s.config.CheckPeriod, s.config.CheckJitter, rand.Float64) - time.Since(started)
+	if delay < 0 {
+		delay = 0
+	}
+	timer.Reset(delay)
+	s.logger.Debug("Next check in %v", delay.Round(time.Second))
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestNextCheckDelay(t *testing.T) {
+	const period = time.Hour
+
+	tests := []struct {
+		name   string
+		jitter float64
+		random float64
+		want   time.Duration
+	}{
+		{name: "no jitter", random: 0.9, want: period},
+		{name: "negative jitter is none", jitter: -0.5, random: 0.9, want: period},
+		{name: "earliest", jitter: 0.1, random: 0, want: 54 * time.Minute},
+		{name: "middle", jitter: 0.1, random: 0.5, want: period},
+		{name: "late", jitter: 0.1, random: 0.75, want: 63 * time.Minute},
+		{name: "wide window", jitter: 0.5, random: 0.25, want: 45 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nextCheckDelay(period, tt.jitter, func() float64 { return tt.random })
+			if got != tt.want {
+				t.Errorf("nextCheckDelay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextCheckDelayWithinJitterWindow(t *testing.T) {
+	const period = time.Hour
+
+	for _, jitter := range []float64{0, 0.1, 0.25, 0.9} {
+		low := period - time.Duration(jitter*float64(period))
+		high := period + time.Duration(jitter*float64(period))
+		random := rand.New(rand.NewSource(1)).Float64
+		spread := make(map[bool]bool) // whether delays fell on each side of the period
+		for range 1000 {
+			delay := nextCheckDelay(period, jitter, random)
+			if delay < low || delay > high {
+				t.Fatalf("jitter %v: delay %v outside [%v, %v]", jitter, delay, low, high)
+			}
+			if delay != period {
+				spread[delay > period] = true
+			}
+		}
+		if jitter > 0 && len(spread) != 2 {
+			t.Errorf("jitter %v: delays not spread on both sides of the period", jitter)
+		}
+	}
+}
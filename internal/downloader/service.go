@@ -3,19 +3,27 @@ package downloader
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"maps"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/blang/semver/v4"
+
 	"tf-mirror/internal/common"
 	"tf-mirror/internal/downloader/binaries"
 	"tf-mirror/internal/downloader/indexgen"
 )
 
+// metadataFile is the name of the provider metadata inside the download path
+const metadataFile = ".tf-mirror-metadata.json"
+
 // Service handles downloading providers from the Terraform registry
 type Service struct {
 	config         *common.DownloaderConfig
@@ -24,14 +32,51 @@ type Service struct {
 	metadata       *ProviderMetadata
 	providerFilter *common.ProviderFilter
 	platformFilter *common.PlatformFilter
+	sampler        *common.ProviderSampler
+	events         *EventEmitter
 	mu             sync.RWMutex
+	shasumsMu      sync.Mutex                  // guards shasumsLocks
+	shasumsLocks   map[string]*shasumsFileLock // per SHA256SUMS file, shared by all platforms of a version
+	disk           diskBudget
+	clock          providerClock
 }
 
 // ProviderMetadata tracks downloaded providers and binaries
 type ProviderMetadata struct {
-	Providers map[string]ProviderInfo   `json:"providers"`
-	Binaries  []common.DownloadedBinary `json:"binaries,omitempty"`
-	LastCheck time.Time                 `json:"last_check"`
+	Providers map[string]ProviderInfo      `json:"providers"`
+	Binaries  map[string]common.BinaryInfo `json:"binaries,omitempty"` // keyed by tool name
+	LastCheck time.Time                    `json:"last_check"`
+}
+
+// binaryMetadata merges the per-platform download results into one
+// metadata entry per tool with sorted platforms and versions
+func binaryMetadata(downloaded []common.DownloadedBinary) map[string]common.BinaryInfo {
+	platforms := make(map[string]map[string]struct{})
+	versions := make(map[string]map[string]struct{})
+	result := make(map[string]common.BinaryInfo)
+	for _, b := range downloaded {
+		if _, ok := result[b.Tool]; !ok {
+			platforms[b.Tool] = make(map[string]struct{})
+			versions[b.Tool] = make(map[string]struct{})
+		}
+		info := result[b.Tool]
+		for _, p := range b.Platforms {
+			platforms[b.Tool][p] = struct{}{}
+		}
+		for _, v := range b.Versions {
+			versions[b.Tool][v] = struct{}{}
+		}
+		if b.Downloaded.After(info.Downloaded) {
+			info.Downloaded = b.Downloaded
+		}
+		result[b.Tool] = info
+	}
+	for tool, info := range result {
+		info.Platforms = slices.Sorted(maps.Keys(platforms[tool]))
+		info.Versions = slices.Sorted(maps.Keys(versions[tool]))
+		result[tool] = info
+	}
+	return result
 }
 
 // ProviderInfo contains information about a downloaded provider for a specific platform
@@ -40,6 +85,9 @@ type ProviderInfo struct {
 	Name      string   `json:"name"`
 	Platforms []string `json:"platforms"`
 	Versions  []string `json:"versions"`
+	// Files maps <version>_<os>_<arch> to the status of that archive, so a run
+	// re-attempts only the bad ones. Nil in metadata written before it existed.
+	Files map[string]FileStatus `json:"files,omitempty"`
 }
 
 // NewService creates a new downloader service
@@ -60,25 +108,51 @@ func NewService(config *common.DownloaderConfig, registryConfig *common.Registry
 		return nil, fmt.Errorf("invalid platform filter: %w", err)
 	}
 
+	precedence, err := common.ParseFilterPrecedence(config.FilterPrecedence)
+	if err != nil {
+		return nil, err
+	}
+	providerFilter.SetPrecedence(precedence)
+
+	if config.GlobalMinVersion != "" {
+		if _, err := semver.ParseTolerant(config.GlobalMinVersion); err != nil {
+			return nil, fmt.Errorf("invalid global min version '%s': %w", config.GlobalMinVersion, err)
+		}
+	}
+
+	if _, err := common.ParseModuleFilter(config.ModuleFilter); err != nil {
+		return nil, fmt.Errorf("invalid module filter: %w", err)
+	}
+
+	sampler, err := common.NewProviderSampler(config.Sample)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sample: %w", err)
+	}
+
 	service := &Service{
 		config:         config,
 		registry:       registry,
 		logger:         logger,
 		providerFilter: providerFilter,
 		platformFilter: platformFilter,
+		sampler:        sampler,
 		metadata: &ProviderMetadata{
 			Providers: make(map[string]ProviderInfo),
 		},
 	}
 
+	if config.EventsNDJSON {
+		service.events = NewEventEmitter(os.Stdout, logger)
+	}
+
 	// Load existing metadata
 	if err := service.loadMetadata(); err != nil {
 		logger.Error("Failed to load metadata, starting fresh: %v", err)
 	}
 
 	// Log filter configuration
-	if providerFilter.IsEnabled() {
-		logger.Info("Provider filter enabled: %s (%d providers)", providerFilter.String(), providerFilter.Count())
+	if providerFilter.IsEnabled() || providerFilter.HasExcludes() {
+		logger.Info("Provider filter enabled: %s (%d rules)", providerFilter.String(), providerFilter.Count())
 	} else {
 		logger.Info("Provider filter: disabled (all providers will be downloaded)")
 	}
@@ -105,29 +179,127 @@ func (s *Service) StartWithContext(ctx context.Context) error {
 
 	// Initial scan of existing files
 
-	// Initial download
-	if err := s.downloadProviders(); err != nil {
-		s.logger.Error("Initial download failed: %v", err)
+	// Initial download. The outcome of the latest run is returned on shutdown,
+	// so orchestrators can tell a mirror that keeps failing from a healthy one.
+	started := time.Now()
+	lastErr := s.downloadProviders(ctx)
+	if lastErr != nil {
+		s.logRunError("Initial download", lastErr)
 	}
 
-	// Start periodic updates
-	ticker := time.NewTicker(s.config.CheckPeriod)
-	defer ticker.Stop()
+	// Start periodic updates; each run schedules the next one, with --check-jitter applied
+	timer := time.NewTimer(s.config.CheckPeriod)
+	defer timer.Stop()
+	s.scheduleNextCheck(timer, started)
 
 	for {
 		select {
 		case <-ctx.Done():
 			s.logger.Info("Received shutdown signal, stopping downloader")
-			return ctx.Err()
-		case <-ticker.C:
+			if errors.Is(lastErr, ErrDownloadsFailed) {
+				return lastErr
+			}
+			return nil
+		case <-timer.C:
 			s.logger.Info("Starting scheduled provider update")
-			if err := s.downloadProviders(); err != nil {
-				s.logger.Error("Scheduled download failed: %v", err)
+			started := time.Now()
+			lastErr = s.downloadProviders(ctx)
+			if lastErr != nil {
+				s.logRunError("Scheduled download", lastErr)
 			}
+			s.scheduleNextCheck(timer, started)
 		}
 	}
 }
 
+// RunOnce performs a single download pass, including index generation and
+// binaries, and returns instead of scheduling further passes. The error wraps
+// ErrDownloadsFailed if any download still failed after retries.
+func (s *Service) RunOnce(ctx context.Context) error {
+	s.logger.Info("Starting one-shot provider download")
+	s.logger.Info("Download path: %s", s.config.DownloadPath)
+	return s.downloadProviders(ctx)
+}
+
+// interruptSession persists what the cancelled session completed, keeping the
+// checkpoint so the next session resumes, and returns the context error
+func (s *Service) interruptSession(ctx context.Context, checkpoint *syncCheckpoint) error {
+	if err := s.saveMetadata(); err != nil {
+		s.logger.Error("Failed to save metadata: %v", err)
+	}
+	if err := checkpoint.Save(); err != nil {
+		s.logger.Warn("Failed to save sync checkpoint: %v", err)
+	}
+	return ctx.Err()
+}
+
+// logRunError reports a failed run. A registry outage is not a mirror failure,
+// so it is logged as a distinct status instead of an error.
+func (s *Service) logRunError(run string, err error) {
+	if errors.Is(err, context.Canceled) {
+		s.logger.Info("%s interrupted, progress saved", run)
+		return
+	}
+	if errors.Is(err, ErrRegistryUnavailable) {
+		s.logger.Warn("%s aborted, registry unavailable (will retry in %v): %v", run, s.config.CheckPeriod, err)
+		return
+	}
+	if errors.Is(err, ErrInsufficientSpace) {
+		s.logger.Warn("%s skipped, the pending downloads would not fit (will retry in %v): %v", run, s.config.CheckPeriod, err)
+		return
+	}
+	if errors.Is(err, ErrDiskCapReached) {
+		s.logger.Warn("%s stopped early, free space or raise the disk cap: %v", run, err)
+		return
+	}
+	s.logger.Error("%s failed: %v", run, err)
+}
+
+// stateFile returns the path of a state file such as .tf-mirror-metadata.json.
+// Mirrors of other registries sharing the download path get their own copy,
+// e.g. .tf-mirror-metadata.app.terraform.io.json.
+func (s *Service) stateFile(name string) string {
+	if host := s.registry.Hostname(); host != common.DefaultRegistryHost {
+		name = strings.TrimSuffix(name, ".json") + "." + host + ".json"
+	}
+	return filepath.Join(s.config.DownloadPath, name)
+}
+
+// providerRoot returns <download-path>/<registry-host>, the top-level
+// directory of all mirrored providers
+func (s *Service) providerRoot() string {
+	return filepath.Join(s.config.DownloadPath, s.registry.Hostname())
+}
+
+// downloadVersionJSON saves the registry's <version>.json for one provider
+// version, if the registry has one, retrying failed attempts with the
+// download backoff. A version still missing it is tried again next session.
+func (s *Service) downloadVersionJSON(ctx context.Context, namespace, name, version, dest string) {
+	attempts := max(s.config.MaxAttempts, 1)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay := common.BackoffDelay(attempt-1, s.config.RetryBaseDelay, s.config.RetryMaxDelay)
+			if common.SleepContext(ctx, delay) != nil {
+				return
+			}
+		}
+		err = s.registry.DownloadVersionJSON(ctx, namespace, name, version, dest)
+		if errors.Is(err, ErrVersionJSONNotFound) {
+			s.logger.Debug("No version metadata json for %s/%s %s", namespace, name, version)
+			return
+		}
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, common.ErrCircuitOpen) || s.registry.Unavailable() {
+			break
+		}
+		s.logger.Debug("Attempt %d to download version metadata json for %s/%s %s failed: %v", attempt, namespace, name, version, err)
+	}
+	s.logger.Warn("Failed to download version metadata json for %s/%s %s: %v", namespace, name, version, err)
+}
+
 // getVersionStrings преобразует []common.Version в []string
 func getVersionStrings(versions []common.Version) []string {
 	out := make([]string, 0, len(versions))
@@ -138,7 +310,7 @@ func getVersionStrings(versions []common.Version) []string {
 }
 
 // downloadProviders downloads all available providers and their versions
-func (s *Service) downloadProviders() error {
+func (s *Service) downloadProviders(ctx context.Context) error {
 	defer func() {
 		if r := recover(); r != nil {
 			s.logger.Error("PANIC in downloadProviders: %v", r)
@@ -146,8 +318,13 @@ func (s *Service) downloadProviders() error {
 		s.logger.Info("downloadProviders: function exited")
 	}()
 	var filteredProviders []common.ProviderListItem
+	s.registry.ResetAvailability()
+
+	// With --incremental-discovery: the cursor and the full discovered listing
+	var discovery *discoveryState
+	var discovered []common.ProviderListItem
 
-	if s.providerFilter.IsEnabled() {
+	if s.providerFilter.IsEnabled() && !s.providerFilter.HasWildcards() {
 		// Use filtered search when provider filter is specified
 		s.logger.Info("Using filtered provider search for specified providers")
 
@@ -163,11 +340,23 @@ func (s *Service) downloadProviders() error {
 			namespace := parts[0]
 			name := parts[1]
 
+			if !s.providerFilter.ShouldInclude(namespace, name) {
+				s.logger.Info("Provider %s/%s excluded by filter", namespace, name)
+				continue
+			}
+
 			s.logger.Info("Checking provider: %s/%s", namespace, name)
 
 			// Try to get provider versions to verify it exists
-			_, err := s.registry.GetProviderVersions(namespace, name)
+			_, err := s.registry.GetProviderVersions(ctx, namespace, name)
+			if ctx.Err() != nil {
+				s.logger.Warn("Download session interrupted while checking providers")
+				return ctx.Err()
+			}
 			if err != nil {
+				if s.registry.Unavailable() {
+					return fmt.Errorf("%w: consecutive 503 responses while checking providers", ErrRegistryUnavailable)
+				}
 				s.logger.Error("Provider %s/%s not found or inaccessible: %v", namespace, name, err)
 				continue
 			}
@@ -180,117 +369,177 @@ func (s *Service) downloadProviders() error {
 
 		s.logger.Info("Provider filter applied: %d providers found", len(filteredProviders))
 	} else {
-		// Discover all providers only when no filter is specified
-		s.logger.Info("No provider filter specified, discovering all providers from registry.terraform.io...")
+		// Discover all providers when no filter is specified or the filter has namespace wildcards
+		if s.providerFilter.HasWildcards() {
+			s.logger.Info("Provider filter has namespace wildcards, discovering all providers from %s...", s.registry.Hostname())
+		} else {
+			s.logger.Info("No provider filter specified, discovering all providers from %s...", s.registry.Hostname())
+		}
 
-		allProviders, err := s.registry.DiscoverAllProviders()
+		discoveryPath := s.stateFile(discoveryFile)
+		state, err := loadDiscoveryState(discoveryPath)
+		if err != nil {
+			s.logger.Warn("Ignoring discovery cache: %v", err)
+		}
+		allProviders, err := s.registry.DiscoverProviders(ctx, state.Pages)
+		if ctx.Err() != nil {
+			s.logger.Warn("Download session interrupted during discovery")
+			return ctx.Err()
+		}
 		if err != nil {
 			return fmt.Errorf("failed to discover providers: %w", err)
 		}
 
 		filteredProviders = allProviders
 		s.logger.Info("Registry discovery completed: %d total providers found", len(filteredProviders))
+
+		if s.providerFilter.IsEnabled() || s.providerFilter.HasExcludes() {
+			var kept []common.ProviderListItem
+			for _, provider := range filteredProviders {
+				if s.providerFilter.ShouldInclude(provider.Namespace, provider.Name) {
+					kept = append(kept, provider)
+				}
+			}
+			s.logger.Info("Provider filter applied: %d of %d providers kept", len(kept), len(filteredProviders))
+			filteredProviders = kept
+			allProviders = kept
+		}
+
+		if s.sampler.IsEnabled() {
+			filteredProviders = s.sampler.Sample(filteredProviders)
+			s.logger.Warn("Sampling enabled (%s): processing %d of %d discovered providers", s.sampler.String(), len(filteredProviders), len(allProviders))
+		}
+
+		if s.config.IncrementalDiscovery {
+			if config := s.discoveryConfig(); state.Config != config {
+				if state.Config != "" {
+					s.logger.Info("Mirror settings changed since the last discovery, processing all providers")
+				}
+				state.Config = config
+				state.Seen = make(map[string]string)
+			}
+			changed, unchanged, removed := diffProviders(state.Seen, filteredProviders)
+			s.logger.Info("Incremental discovery: %d new or changed, %d unchanged (skipped), %d no longer listed",
+				len(changed), unchanged, removed)
+			discovery = state
+			discovered = filteredProviders
+			filteredProviders = changed
+		} else {
+			state.Config = ""
+			state.Seen = nil
+		}
+		if err := state.save(discoveryPath); err != nil {
+			s.logger.Warn("Failed to save discovery cache: %v", err)
+		}
 	}
 
 	if len(filteredProviders) == 0 {
 		s.logger.Warn("No providers to process")
+		if discovery != nil {
+			discovery.recordSeen(discovered, nil, nil)
+			if err := discovery.save(s.stateFile(discoveryFile)); err != nil {
+				s.logger.Warn("Failed to save discovery cache: %v", err)
+			}
+		}
+		if err := s.mirrorModules(ctx); err != nil && ctx.Err() == nil {
+			return fmt.Errorf("%w: modules: %v", ErrDownloadsFailed, err)
+		}
 		return nil
 	}
 
 	// Get platforms to download
-	var platformsToDownload []common.Platform
+	platformsToDownload := s.platformFilter.Select(common.SupportedPlatforms)
 	if s.platformFilter.IsEnabled() {
-		for _, platform := range common.SupportedPlatforms {
-			if s.platformFilter.ShouldInclude(platform.OS, platform.Arch) {
-				platformsToDownload = append(platformsToDownload, platform)
-			}
-		}
 		s.logger.Info("Platform filter applied: %d platforms selected", len(platformsToDownload))
 	} else {
-		platformsToDownload = common.SupportedPlatforms
 		s.logger.Info("No platform filter - processing all %d supported platforms", len(platformsToDownload))
 	}
 
+	// Resume from an interrupted session if a checkpoint exists
+	checkpoint, err := loadCheckpoint(s.stateFile(checkpointFile))
+	if err != nil {
+		s.logger.Warn("Ignoring sync checkpoint: %v", err)
+	} else if len(checkpoint.Completed) > 0 {
+		s.logger.Info("Resuming interrupted session started %s: %d providers already completed",
+			checkpoint.StartedAt.Format(time.RFC3339), len(checkpoint.Completed))
+	}
+
 	// Формируем все задачи заранее
 	var jobList []DownloadJob
 	totalJobs := 0
 	skippedAtQueue := 0
+	resumedProviders := 0
+	failedProviders := make(map[string]struct{})
+	var toPlan []common.ProviderListItem
 	for _, provider := range filteredProviders {
-		s.logger.Info("Processing provider: %s/%s", provider.Namespace, provider.Name)
+		providerKey := fmt.Sprintf("%s/%s", provider.Namespace, provider.Name)
+		if checkpoint.IsCompleted(providerKey) {
+			s.logger.Debug("Skipping %s: completed in interrupted session", providerKey)
+			resumedProviders++
+			continue
+		}
+		toPlan = append(toPlan, provider)
+	}
 
-		versions, err := s.registry.GetProviderVersions(provider.Namespace, provider.Name)
-		if err != nil {
-			s.logger.Error("Failed to get versions for %s/%s: %v", provider.Namespace, provider.Name, err)
+	s.logger.Info("Planning %d providers", len(toPlan))
+	plans := s.planProviders(ctx, toPlan, platformsToDownload)
+	if s.registry.Unavailable() {
+		return fmt.Errorf("%w: consecutive 503 responses while listing versions", ErrRegistryUnavailable)
+	}
+	// Задачи собираются в порядке провайдеров, независимо от порядка завершения
+	for i, plan := range plans {
+		if !plan.planned {
+			continue
+		}
+		provider := toPlan[i]
+		providerKey := fmt.Sprintf("%s/%s", provider.Namespace, provider.Name)
+		skippedAtQueue += plan.skipped
+		if plan.err != nil {
+			s.logger.Error("Failed to plan %s: %v", providerKey, plan.err)
+			failedProviders[providerKey] = struct{}{}
 			continue
 		}
 
-		s.logger.Info("Found %d versions for %s/%s: %v", len(versions.Versions), provider.Namespace, provider.Name, s.getVersionList(versions.Versions))
-
-		// Получаем minVersion из фильтра
-		minVersion := s.providerFilter.GetMinVersion(provider.Namespace, provider.Name)
-		// Фильтруем версии по minVersion
-		filteredVersions := common.FilterVersionsByMin(getVersionStrings(versions.Versions), minVersion)
-		for _, versionStr := range filteredVersions {
-			// Скачиваем metadata json для версии, если его нет
-			versionJSONPath := s.registry.GetProviderVersionJSONPath(s.config.DownloadPath, provider.Namespace, provider.Name, versionStr)
-			if !fileExists(versionJSONPath) {
-				versionJSONURL := fmt.Sprintf("https://registry.terraform.io/v1/providers/%s/%s/%s.json", provider.Namespace, provider.Name, versionStr)
-				s.logger.Debug("Attempting to download version metadata json: %s", versionJSONURL)
-				resp, err := s.registry.client.Get(versionJSONURL)
-				if err == nil && resp.StatusCode == 200 {
-					defer resp.Body.Close()
-					// Создать директорию, если её нет
-					os.MkdirAll(filepath.Dir(versionJSONPath), 0755)
-					out, err := os.Create(versionJSONPath)
-					if err == nil {
-						io.Copy(out, resp.Body)
-						out.Close()
-					} else {
-						s.logger.Warn("Failed to create file for version metadata json: %s: %v", versionJSONPath, err)
-					}
-				} else if err != nil {
-					s.logger.Warn("Failed to download version metadata json for %s/%s %s: %v", provider.Namespace, provider.Name, versionStr, err)
-				}
-			}
-			for _, platform := range platformsToDownload {
-				osName := platform.OS
-				archName := platform.Arch
-				if s.shouldDownload(provider.Namespace, provider.Name, versionStr, osName, archName) {
-					jobList = append(jobList, DownloadJob{
-						Namespace: provider.Namespace,
-						Name:      provider.Name,
-						Version:   versionStr,
-						OS:        osName,
-						Arch:      archName,
-					})
-					totalJobs++
-				} else {
-					skippedAtQueue++
-				}
-			}
+		s.logger.Info("Found %d versions for %s: %v", len(plan.versions), providerKey, s.getVersionList(plan.versions))
+		for _, job := range plan.jobs {
+			jobList = append(jobList, job)
+			checkpoint.AddPending(providerKey)
+			totalJobs++
 		}
+		checkpoint.MarkPlanned(providerKey)
+	}
+	if ctx.Err() != nil {
+		s.logger.Warn("Download session interrupted during planning")
+		return s.interruptSession(ctx, checkpoint)
+	}
+	if resumedProviders > 0 {
+		s.logger.Info("Skipped planning for %d providers completed in the interrupted session", resumedProviders)
+	}
+	if err := checkpoint.Save(); err != nil {
+		s.logger.Warn("Failed to save sync checkpoint: %v", err)
 	}
 
 	startTime := time.Now()
+	summary := newSummaryBuilder(s.registry.Hostname(), startTime)
 
-	jobs := make(chan DownloadJob, len(jobList))
-	results := make(chan DownloadResult, len(jobList))
-	resultsSent := 0 // Счётчик реально отправленных результатов
-
-	s.logger.Debug("Starting download workers")
-	for i := 0; i < s.config.MaxConcurrent; i++ {
-		s.logger.Debug("Spawning worker goroutine #%d", i)
-		go s.downloadWorker(jobs, results, i)
+	// Проверяем, поместятся ли загрузки на диск, до запуска воркеров
+	estimate, err := s.preflightDiskSpace(jobList)
+	summary.summary.EstimatedBytes = estimate
+	if err != nil {
+		if err := s.saveMetadata(); err != nil {
+			s.logger.Error("Failed to save metadata: %v", err)
+		}
+		s.writeSummary(summary.finish(nil), err)
+		return err
 	}
 
-	// Отправляем задачи в канал jobs
-	for _, job := range jobList {
-		jobs <- job
-	}
-	s.logger.Debug("Closing jobs channel")
-	close(jobs)
-	s.logger.Debug("All jobs queued")
-	s.logger.Debug("Jobs channel length after close: %d", len(jobs))
+	resultsSent := 0 // Счётчик реально полученных результатов
+
+	s.startDiskBudget()
+	s.resetProviderClock()
+
+	s.logger.Debug("Starting download workers")
+	results := s.startWorkers(ctx, jobList)
 
 	s.logger.Info("Queued %d download jobs, skipped %d existing files", totalJobs, skippedAtQueue)
 
@@ -298,46 +547,128 @@ func (s *Service) downloadProviders() error {
 	successful := 0
 	failed := 0
 	skipped := 0
-	watchdogTimeout := 30 * time.Second
+	notAvailable := 0
+	deferred := 0 // refused once the disk cap was reached
 	var timeoutJobs []DownloadJob
 	downloadedFiles := make(map[string]struct{})
 	failedJobs := make(map[DownloadJob]struct{})
-	for i := 0; i < totalJobs; i++ {
-		s.logger.Debug("Waiting for result %d/%d, results channel len before select: %d, resultsSent=%d", i+1, totalJobs, len(results), resultsSent)
-		watchdog := time.After(watchdogTimeout)
+	jobErrors := make(map[DownloadJob]string) // last error of each failed job, for --summary-json
+	progress := newProgressReporter(s.config.Progress, s.logger, totalJobs)
+	received := make(map[DownloadJob]struct{}, totalJobs)
+	deadline := time.NewTimer(s.sessionDeadline(totalJobs))
+	defer deadline.Stop()
+	deadlineExceeded := false
+collect:
+	for {
 		select {
-		case result := <-results:
+		case result, ok := <-results:
+			if !ok {
+				break collect // every worker has exited
+			}
 			resultsSent++
+			received[result.Job] = struct{}{}
 			s.logger.Debug("Received result from results channel for job: %v (resultsSent=%d)", result.Job, resultsSent)
 			s.logger.Debug("Results channel len after receive: %d", len(results))
-			if result.Error != nil {
+			if errors.Is(result.Error, ErrRegistryUnavailable) {
+				// Drained after the registry went down, reported once below
+				failed++
+				checkpoint.Done(result.Job.providerKey(), true)
+			} else if errors.Is(result.Error, ErrDiskCapReached) {
+				// Not a failure of the archive: left for a session with more room
+				deferred++
+				failedProviders[result.Job.providerKey()] = struct{}{}
+				checkpoint.Done(result.Job.providerKey(), true)
+			} else if errors.Is(result.Error, ErrProviderTimeout) {
+				// Left for the next session, like deferred downloads
+				s.logger.Debug("Skipped %s/%s %s %s_%s: %v",
+					result.Job.Namespace, result.Job.Name, result.Job.Version,
+					result.Job.OS, result.Job.Arch, result.Error)
+				summary.provider(result.Job).TimedOut++
+				failedProviders[result.Job.providerKey()] = struct{}{}
+				checkpoint.Done(result.Job.providerKey(), true)
+			} else if result.Error != nil {
 				s.logger.Error("Download failed for %s/%s %s %s_%s: %v",
 					result.Job.Namespace, result.Job.Name, result.Job.Version,
 					result.Job.OS, result.Job.Arch, result.Error)
 				failed++
 				failedJobs[result.Job] = struct{}{}
+				jobErrors[result.Job] = result.Error.Error()
+				s.setFileStatus(result.Job, failureStatus(result.Error))
 				if isTimeoutError(result.Error) {
 					timeoutJobs = append(timeoutJobs, result.Job)
+				} else {
+					checkpoint.Done(result.Job.providerKey(), true)
 				}
+			} else if result.NotAvailable {
+				s.logger.Debug("Not available: %s/%s %s %s_%s (not published by the provider)",
+					result.Job.Namespace, result.Job.Name, result.Job.Version,
+					result.Job.OS, result.Job.Arch)
+				notAvailable++
+				summary.provider(result.Job).NotAvailable++
+				s.checkpointDone(checkpoint, result.Job, false)
 			} else if result.Skipped {
 				s.logger.Debug("Skipped %s/%s %s %s_%s (already exists)",
 					result.Job.Namespace, result.Job.Name, result.Job.Version,
 					result.Job.OS, result.Job.Arch)
 				skipped++
-				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch)
+				summary.provider(result.Job).Skipped++
+				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, true)
+				s.checkpointDone(checkpoint, result.Job, false)
 			} else {
 				s.logger.Info("Downloaded %s/%s %s %s_%s",
 					result.Job.Namespace, result.Job.Name, result.Job.Version,
 					result.Job.OS, result.Job.Arch)
 				successful++
-				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch)
-				downloadedFiles[s.registry.GetProviderPath(s.config.DownloadPath, result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, getProviderFilename(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch))] = struct{}{}
+				summary.provider(result.Job).Downloaded++
+				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, true)
+				s.checkpointDone(checkpoint, result.Job, false)
+				downloadedFiles[s.registry.GetProviderPath(s.writePath(), result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, getProviderFilename(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch))] = struct{}{}
+			}
+			progress.Update(resultsSent)
+		case <-deadline.C:
+			deadlineExceeded = true
+			break collect
+		case <-ctx.Done():
+			s.logger.Warn("Download session interrupted: %d of %d jobs finished", resultsSent, totalJobs)
+			return s.interruptSession(ctx, checkpoint)
+		}
+	}
+
+	// Jobs without a result either are still running past the session
+	// deadline (slow) or were lost by a worker that exited early (crashed).
+	// Both count as failed so the next session picks them up again.
+	if missing := totalJobs - resultsSent; missing > 0 {
+		reason := "worker exited without reporting a result"
+		if deadlineExceeded {
+			reason = "no result before the session deadline"
+			s.logger.Error("Session deadline exceeded: %d of %d jobs still running, counting them as failed", missing, totalJobs)
+		} else {
+			s.logger.Error("Workers exited without reporting %d of %d jobs, counting them as failed", missing, totalJobs)
+		}
+		for _, job := range jobList {
+			if _, ok := received[job]; !ok {
+				failed++
+				failedJobs[job] = struct{}{}
+				jobErrors[job] = reason
+				checkpoint.Done(job.providerKey(), true)
 			}
-		case <-watchdog:
-			s.logger.Warn("Watchdog timeout waiting for result %d/%d from results channel (len: %d, resultsSent=%d)", i+1, totalJobs, len(results), resultsSent)
 		}
 	}
 
+	// Registry went down mid-run: keep the checkpoint and stop without a
+	// misleading partial-failure summary
+	if s.registry.Unavailable() {
+		if err := s.saveMetadata(); err != nil {
+			s.logger.Error("Failed to save metadata: %v", err)
+		}
+		if err := checkpoint.Save(); err != nil {
+			s.logger.Warn("Failed to save sync checkpoint: %v", err)
+		}
+		err := fmt.Errorf("%w: consecutive 503 responses after %d downloads", ErrRegistryUnavailable, successful)
+		s.writeSummary(summary.finish(failedJobErrors(failedJobs, jobErrors)), err)
+		return err
+	}
+
 	// Повторная попытка для задач, завершившихся по таймауту
 	retrySuccessful := 0
 	retryFailed := 0
@@ -345,35 +676,65 @@ func (s *Service) downloadProviders() error {
 	retryDownloadedFiles := make(map[string]struct{})
 	if len(timeoutJobs) > 0 {
 		s.logger.Warn("Retrying %d jobs that failed due to timeout...", len(timeoutJobs))
-		retryJobs := make(chan DownloadJob, len(timeoutJobs))
-		retryResults := make(chan DownloadResult, len(timeoutJobs))
-		for i := 0; i < s.config.MaxConcurrent; i++ {
-			go s.downloadWorker(retryJobs, retryResults, i)
-		}
-		for _, job := range timeoutJobs {
-			retryJobs <- job
-		}
-		close(retryJobs)
-		for i := 0; i < len(timeoutJobs); i++ {
-			result := <-retryResults
-			if result.Error != nil {
+		retryResults := s.startWorkers(ctx, timeoutJobs)
+		retryDeadline := time.NewTimer(s.sessionDeadline(len(timeoutJobs)))
+		defer retryDeadline.Stop()
+	retryCollect:
+		for {
+			var result DownloadResult
+			select {
+			case r, ok := <-retryResults:
+				if !ok {
+					break retryCollect
+				}
+				result = r
+			case <-retryDeadline.C:
+				s.logger.Error("Retry session deadline exceeded, leaving the remaining jobs failed")
+				break retryCollect
+			case <-ctx.Done():
+				s.logger.Warn("Retry session interrupted")
+				return s.interruptSession(ctx, checkpoint)
+			}
+			if errors.Is(result.Error, ErrDiskCapReached) {
+				deferred++
+				delete(failedJobs, result.Job)
+				failedProviders[result.Job.providerKey()] = struct{}{}
+				checkpoint.Done(result.Job.providerKey(), true)
+			} else if errors.Is(result.Error, ErrProviderTimeout) {
+				delete(failedJobs, result.Job)
+				summary.provider(result.Job).TimedOut++
+				failedProviders[result.Job.providerKey()] = struct{}{}
+				checkpoint.Done(result.Job.providerKey(), true)
+			} else if result.Error != nil {
 				s.logger.Error("Retry download failed for %s/%s %s %s_%s: %v",
 					result.Job.Namespace, result.Job.Name, result.Job.Version,
 					result.Job.OS, result.Job.Arch, result.Error)
 				retryFailed++
+				jobErrors[result.Job] = result.Error.Error()
+				s.setFileStatus(result.Job, failureStatus(result.Error))
+				checkpoint.Done(result.Job.providerKey(), true)
+			} else if result.NotAvailable {
+				notAvailable++
+				summary.provider(result.Job).NotAvailable++
+				s.checkpointDone(checkpoint, result.Job, false)
+				delete(failedJobs, result.Job)
 			} else if result.Skipped {
 				s.logger.Debug("Retry skipped %s/%s %s %s_%s (already exists)",
 					result.Job.Namespace, result.Job.Name, result.Job.Version,
 					result.Job.OS, result.Job.Arch)
 				retrySkipped++
-				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch)
+				summary.provider(result.Job).Skipped++
+				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, true)
+				s.checkpointDone(checkpoint, result.Job, false)
 			} else {
 				s.logger.Info("Retry downloaded %s/%s %s %s_%s",
 					result.Job.Namespace, result.Job.Name, result.Job.Version,
 					result.Job.OS, result.Job.Arch)
 				retrySuccessful++
-				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch)
-				retryDownloadedFiles[s.registry.GetProviderPath(s.config.DownloadPath, result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, getProviderFilename(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch))] = struct{}{}
+				summary.provider(result.Job).Downloaded++
+				s.updateMetadata(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, true)
+				s.checkpointDone(checkpoint, result.Job, false)
+				retryDownloadedFiles[s.registry.GetProviderPath(s.writePath(), result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch, getProviderFilename(result.Job.Namespace, result.Job.Name, result.Job.Version, result.Job.OS, result.Job.Arch))] = struct{}{}
 				// Если успешно скачали в retry, убираем из failedJobs
 				delete(failedJobs, result.Job)
 			}
@@ -400,14 +761,19 @@ func (s *Service) downloadProviders() error {
 		}
 	}
 	totalSizeMB := float64(totalSize) / (1024 * 1024)
+	summary.summary.TotalBytes = totalSize
+	summary.summary.PreFiltered = skippedAtQueue
 
-	s.logger.Info("All results received: resultsSent=%d, totalJobs=%d", resultsSent, totalJobs)
-	if resultsSent != totalJobs {
-		s.logger.Error("Mismatch: resultsSent (%d) != totalJobs (%d)", resultsSent, totalJobs)
-	}
+	s.logger.Debug("Results received: %d of %d jobs", resultsSent, totalJobs)
 
-	s.logger.Info("Download session completed: %d downloaded, %d skipped (already exist), %d failed, %d pre-filtered, total time: %s, total size: %.2f MB",
-		finalDownloaded, finalSkipped, finalFailed, skippedAtQueue, totalTime.Round(time.Second).String(), totalSizeMB)
+	s.logger.Info("Download session completed: %d downloaded, %d skipped (already exist), %d not available, %d failed, %d pre-filtered, total time: %s, total size: %.2f MB",
+		finalDownloaded, finalSkipped, notAvailable, finalFailed, skippedAtQueue, totalTime.Round(time.Second).String(), totalSizeMB)
+	if deferred > 0 {
+		s.logger.Warn("%d downloads deferred: disk cap reached%s", deferred, s.diskCapDescription())
+	}
+	if timedOut := summary.timedOut(); timedOut > 0 {
+		s.logger.Warn("%d downloads skipped: --provider-timeout of %v exceeded", timedOut, s.config.ProviderTimeout)
+	}
 
 	// Update last check time
 	s.mu.Lock()
@@ -419,130 +785,176 @@ func (s *Service) downloadProviders() error {
 		s.logger.Error("Failed to save metadata: %v", err)
 	}
 
+	// The session ran to completion, next one starts from scratch
+	if err := checkpoint.Remove(); err != nil {
+		s.logger.Warn("Failed to remove sync checkpoint: %v", err)
+	}
+
+	for job := range failedJobs {
+		failedProviders[job.providerKey()] = struct{}{}
+	}
+
+	// Remember the providers mirrored without failures for the next discovery
+	if discovery != nil {
+		discovery.recordSeen(discovered, filteredProviders, failedProviders)
+		if err := discovery.save(s.stateFile(discoveryFile)); err != nil {
+			s.logger.Warn("Failed to save discovery cache: %v", err)
+		}
+	}
+
 	// После завершения всех скачиваний — генерируем index.json и <verion>.json для каждого провайдера
 	// Собираем список провайдеров, для которых были скачивания
-	providerRoot := filepath.Join(s.config.DownloadPath, "registry.terraform.io")
-	for _, provider := range filteredProviders {
-		providerDir := filepath.Join(providerRoot, provider.Namespace, provider.Name)
-		if err := indexgen.GenerateIndexJSON(providerDir); err != nil {
-			s.logger.Error("Failed to generate index.json for %s/%s: %v", provider.Namespace, provider.Name, err)
-		} else {
-			s.logger.Info("Generated index.json for %s/%s", provider.Namespace, provider.Name)
+	// With --staging-path the index is generated in staging before publishing
+	if s.config.StagingPath != "" {
+		s.publishStaged(failedProviders)
+	} else {
+		providerRoot := s.providerRoot()
+		for _, provider := range filteredProviders {
+			providerDir := filepath.Join(providerRoot, provider.Namespace, provider.Name)
+			if err := indexgen.GenerateIndexJSON(providerDir); err != nil {
+				s.logger.Error("Failed to generate index.json for %s/%s: %v", provider.Namespace, provider.Name, err)
+			} else {
+				s.logger.Info("Generated index.json for %s/%s", provider.Namespace, provider.Name)
+			}
 		}
 	}
 
+	// Удаляем версии, которые больше не проходят фильтр (только после успешного прохода)
+	if s.config.Prune {
+		if finalFailed > 0 {
+			s.logger.Warn("Skipping prune: %d downloads failed in this session", finalFailed)
+		} else if err := s.pruneStaleVersions(); err != nil {
+			s.logger.Error("Prune failed: %v", err)
+		}
+	}
+	s.pruneBlobs()
+
 	// --- Скачивание бинарников HashiCorp после провайдеров ---
-	if s.config.DownloadBinaries != "" {
-		s.logger.Info("Starting download of HashiCorp binaries from releases.hashicorp.com")
+	var binariesErr error
+	if s.disk.reached.Load() && (s.config.DownloadBinaries != "" || s.config.ModuleFilter != "") {
+		s.logger.Warn("Skipping HashiCorp binaries and modules: disk cap reached")
+	} else if s.config.DownloadBinaries != "" {
+		s.logger.Info("Starting download of binaries (releases.hashicorp.com and %d other sources)", len(s.config.BinarySources))
 		binFilters, err := binaries.ParseBinaryFilter(s.config.DownloadBinaries)
 		if err != nil {
 			s.logger.Error("Failed to parse download-binaries filter: %v", err)
-		} else {
+			binariesErr = err
+		}
+		binSources, err := binaries.ParseBinarySources(s.config.BinarySources)
+		if err != nil {
+			s.logger.Error("Failed to parse binary sources: %v", err)
+			binariesErr = err
+		}
+		if binariesErr == nil {
 			// Собираем платформы с учетом platform-filter
-			var platforms []binaries.Platform
-			for _, p := range common.SupportedPlatforms {
-				if s.platformFilter == nil || s.platformFilter.ShouldInclude(p.OS, p.Arch) {
-					platforms = append(platforms, binaries.Platform{OS: p.OS, Arch: p.Arch})
-				}
-			}
-			downloadedBinaries, err := binaries.DownloadHashiCorpBinaries(
+			platforms := binaries.Platforms(s.platformFilter.Select(common.BinaryPlatforms))
+			downloadedBinaries, err := binaries.DownloadBinaries(
 				s.config.DownloadPath,
 				binFilters,
 				platforms,
+				binSources,
 				func(format string, args ...interface{}) {
 					s.logger.Info(format, args...)
 				},
-				s.config.ProxyURL,
+				binaries.ClientOptions{
+					ProxyURL:         s.config.ProxyURL,
+					TLSMinVersion:    s.config.TLSMinVersion,
+					MaxConnsPerHost:  s.config.MaxConnsPerHost,
+					RequireChecksums: s.config.RequireBinSums,
+					Prerelease:       s.config.Prerelease,
+					CircuitFailures:  s.config.CircuitFailures,
+					CircuitCooldown:  s.config.CircuitCooldown,
+					CACert:           s.config.CACert,
+					InsecureTLS:      s.config.InsecureTLS,
+					UserAgent:        s.config.UserAgent,
+					Headers:          s.config.Headers,
+				},
 			)
 			if err != nil {
 				s.logger.Error("Failed to download HashiCorp binaries: %v", err)
+				binariesErr = err
 			} else {
 				s.logger.Info("HashiCorp binaries download completed")
-				// Сохраняем метаданные о бинарниках в виде объекта по tool
 				s.mu.Lock()
-				binMap := make(map[string]struct {
-					Platforms  map[string]struct{}
-					Versions   map[string]struct{}
-					Downloaded time.Time
-				})
-				for _, b := range downloadedBinaries {
-					entry, ok := binMap[b.Tool]
-					if !ok {
-						entry = struct {
-							Platforms  map[string]struct{}
-							Versions   map[string]struct{}
-							Downloaded time.Time
-						}{
-							Platforms:  make(map[string]struct{}),
-							Versions:   make(map[string]struct{}),
-							Downloaded: b.Downloaded,
-						}
-					}
-					for _, p := range b.Platforms {
-						entry.Platforms[p] = struct{}{}
-					}
-					for _, v := range b.Versions {
-						entry.Versions[v] = struct{}{}
-					}
-					if b.Downloaded.After(entry.Downloaded) {
-						entry.Downloaded = b.Downloaded
-					}
-					binMap[b.Tool] = entry
-				}
-				// Преобразуем к сериализуемому виду
-				type binMeta struct {
-					Platforms  []string  `json:"platforms"`
-					Versions   []string  `json:"versions"`
-					Downloaded time.Time `json:"downloaded"`
-				}
-				serMap := make(map[string]binMeta)
-				for tool, entry := range binMap {
-					var plats, vers []string
-					for p := range entry.Platforms {
-						plats = append(plats, p)
-					}
-					for v := range entry.Versions {
-						vers = append(vers, v)
-					}
-					serMap[tool] = binMeta{
-						Platforms:  plats,
-						Versions:   vers,
-						Downloaded: entry.Downloaded,
-					}
-				}
-				// Сохраняем как map[string]binMeta в поле Binaries (через type assertion)
-				s.metadata.Binaries = nil // чтобы не сериализовать старое поле
-				type metaWithBinaries struct {
-					Providers map[string]ProviderInfo `json:"providers"`
-					Binaries  map[string]binMeta      `json:"binaries"`
-					LastCheck time.Time               `json:"last_check"`
-				}
-				meta := metaWithBinaries{
-					Providers: s.metadata.Providers,
-					Binaries:  serMap,
-					LastCheck: time.Now(),
-				}
+				s.metadata.Binaries = binaryMetadata(downloadedBinaries)
 				s.mu.Unlock()
-				// Сохраняем метаданные с новой структурой binaries
-				metaPath := filepath.Join(s.config.DownloadPath, ".tf-mirror-metadata.json")
-				f, err := os.Create(metaPath)
-				if err != nil {
+				if err := s.saveMetadata(); err != nil {
 					s.logger.Error("Failed to save metadata after binaries: %v", err)
-				} else {
-					enc := json.NewEncoder(f)
-					enc.SetIndent("", "  ")
-					if err := enc.Encode(meta); err != nil {
-						s.logger.Error("Failed to encode metadata after binaries: %v", err)
-					}
-					f.Close()
 				}
 			}
 		}
 	}
 
+	// --- Модули из --module-filter ---
+	var modulesErr error
+	if !s.disk.reached.Load() {
+		modulesErr = s.mirrorModules(ctx)
+	}
+	if modulesErr != nil && ctx.Err() == nil {
+		s.logger.Error("Failed to download modules: %v", modulesErr)
+	}
+
+	if binariesErr != nil {
+		summary.summary.BinariesError = binariesErr.Error()
+	}
+	if modulesErr != nil {
+		summary.summary.ModulesError = modulesErr.Error()
+	}
+	err = s.sessionError(ctx, finalFailed, totalJobs, binariesErr, modulesErr)
+	if err == nil && deferred > 0 {
+		err = fmt.Errorf("%w: %d of %d provider downloads deferred", ErrDiskCapReached, deferred, totalJobs)
+	}
+	s.writeSummary(summary.finish(failedJobErrors(failedJobs, jobErrors)), err)
+	return err
+}
+
+// sessionError decides whether a finished session counts as failed
+func (s *Service) sessionError(ctx context.Context, finalFailed, totalJobs int, binariesErr, modulesErr error) error {
+	if exceedsFailThreshold(finalFailed, totalJobs, s.config.FailThreshold) {
+		return fmt.Errorf("%w: %d of %d provider downloads", ErrDownloadsFailed, finalFailed, totalJobs)
+	}
+	if finalFailed > 0 {
+		s.logger.Warn("%d of %d provider downloads failed, within --fail-threshold %.1f%%", finalFailed, totalJobs, s.config.FailThreshold)
+	}
+	if binariesErr != nil {
+		return fmt.Errorf("%w: HashiCorp binaries: %v", ErrDownloadsFailed, binariesErr)
+	}
+	if modulesErr != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: modules: %v", ErrDownloadsFailed, modulesErr)
+	}
 	return nil
 }
 
+// failedJobErrors returns the last error of every job that still failed
+func failedJobErrors(failedJobs map[DownloadJob]struct{}, jobErrors map[DownloadJob]string) map[DownloadJob]string {
+	out := make(map[DownloadJob]string, len(failedJobs))
+	for job := range failedJobs {
+		out[job] = jobErrors[job]
+	}
+	return out
+}
+
+// exceedsFailThreshold reports whether more than thresholdPercent of total
+// jobs failed. A threshold of 0 means any failure counts.
+func exceedsFailThreshold(failed, total int, thresholdPercent float64) bool {
+	if failed <= 0 || total <= 0 {
+		return false
+	}
+	return float64(failed)*100/float64(total) > thresholdPercent
+}
+
+// minVersionFor returns the minimum version for a provider: the per-provider
+// minimum from the filter if set, otherwise the global minimum
+func (s *Service) minVersionFor(namespace, name string) string {
+	if minVersion := s.providerFilter.GetMinVersion(namespace, name); minVersion != "" {
+		return minVersion
+	}
+	return s.config.GlobalMinVersion
+}
+
 // getProviderFilename возвращает имя файла провайдера для подсчёта размера
 func getProviderFilename(namespace, name, version, osName, archName string) string {
 	// Пример: terraform-provider-<name>_<version>_<os>_<arch>.zip
@@ -567,61 +979,181 @@ type DownloadJob struct {
 	Arch      string
 }
 
+// providerKey returns the namespace/name key of the job's provider
+func (j DownloadJob) providerKey() string {
+	return j.Namespace + "/" + j.Name
+}
+
+// checkpointDone records a finished job and persists the checkpoint (together
+// with metadata) when it completes a provider
+func (s *Service) checkpointDone(checkpoint *syncCheckpoint, job DownloadJob, failed bool) {
+	if !checkpoint.Done(job.providerKey(), failed) {
+		return
+	}
+	if err := s.saveMetadata(); err != nil {
+		s.logger.Warn("Failed to save metadata: %v", err)
+	}
+	if err := checkpoint.Save(); err != nil {
+		s.logger.Warn("Failed to save sync checkpoint: %v", err)
+	}
+}
+
 // DownloadResult represents the result of a download task
 type DownloadResult struct {
 	Job     DownloadJob
 	Error   error
 	Skipped bool
+	// NotAvailable means the provider does not publish this platform
+	NotAvailable bool
 }
 
 // downloadWorker processes download jobs
-func (s *Service) downloadWorker(jobs <-chan DownloadJob, results chan<- DownloadResult, workerID int) {
+// parent cancels in-flight attempts and retry backoff on shutdown
+func (s *Service) downloadWorker(parent context.Context, jobs <-chan DownloadJob, results chan<- DownloadResult, workerID int) {
 	maxAttempts := s.config.MaxAttempts
 	downloadTimeout := s.config.DownloadTimeout
 
 	s.logger.Debug("[worker-%d] Download worker started", workerID)
-	defer func() {
-		if r := recover(); r != nil {
-			s.logger.Error("[worker-%d] Download worker panicked: %v", workerID, r)
-		}
-		s.logger.Debug("[worker-%d] Download worker finished", workerID)
-	}()
+	defer s.logger.Debug("[worker-%d] Download worker finished", workerID)
 	resultsSentByWorker := 0
 
 	for job := range jobs {
 		s.logger.Debug("[worker-%d] Received job from jobs channel: %v", workerID, job)
-		var err error
-		var skipped bool
-
-		for attempt := 1; attempt <= maxAttempts; attempt++ {
-			s.logger.Debug("[worker-%d] Attempt %d for job: %v", workerID, attempt, job)
-			ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
-			err, skipped = s.downloadProvider(ctx, job.Namespace, job.Name, job.Version, job.OS, job.Arch)
-			cancel()
+		s.events.Emit(EventJobStarted, job, 0, nil)
+		jobStart := time.Now()
+		err, skipped := s.runJob(parent, job, workerID, maxAttempts, downloadTimeout)
+		notAvailable := errors.Is(err, ErrPackageNotFound)
+		if notAvailable {
+			err = nil
+		}
 
-			if err == nil || skipped {
-				break
-			}
-			if ctx.Err() == context.DeadlineExceeded || isTimeoutError(err) {
-				s.logger.Warn("[worker-%d] Timeout on download for %s/%s %s %s_%s, restarting attempt %d",
-					workerID, job.Namespace, job.Name, job.Version, job.OS, job.Arch, attempt)
-				continue // рестарт попытки
-			}
-			// другая ошибка — не рестартуем
-			break
+		switch {
+		case notAvailable:
+			s.events.Emit(EventNotAvailable, job, time.Since(jobStart), nil)
+		case err != nil:
+			s.events.Emit(EventFailed, job, time.Since(jobStart), err)
+		case skipped:
+			s.events.Emit(EventSkipped, job, time.Since(jobStart), nil)
+		default:
+			s.events.Emit(EventDownloaded, job, time.Since(jobStart), nil)
 		}
 
 		s.logger.Debug("[worker-%d] Sending result to results channel for job: %v", workerID, job)
 		results <- DownloadResult{
-			Job:     job,
-			Error:   err,
-			Skipped: skipped,
+			Job:          job,
+			Error:        err,
+			Skipped:      skipped,
+			NotAvailable: notAvailable,
 		}
 		resultsSentByWorker++
 	}
 	s.logger.Info("[worker-%d] Jobs channel closed, worker exiting, resultsSentByWorker=%d", workerID, resultsSentByWorker)
 }
 
+// runJob downloads one job with retries. A panic is turned into a failed
+// result so the job is still accounted for and the worker keeps going.
+func (s *Service) runJob(parent context.Context, job DownloadJob, workerID, maxAttempts int, downloadTimeout time.Duration) (err error, skipped bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("[worker-%d] Panic while downloading %v: %v", workerID, job, r)
+			err, skipped = fmt.Errorf("worker panic: %v", r), false
+		}
+	}()
+
+	// Drain remaining jobs quickly once the registry is known to be down
+	if s.registry.Unavailable() {
+		return ErrRegistryUnavailable, false
+	}
+	if s.disk.reached.Load() {
+		return ErrDiskCapReached, false
+	}
+
+	// Attempts and backoff of the provider's jobs share its --provider-timeout
+	if deadline, ok := s.providerDeadline(job); ok {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w (%v)", ErrProviderTimeout, s.config.ProviderTimeout), false
+		}
+		outer := parent
+		var cancel context.CancelFunc
+		parent, cancel = context.WithDeadline(parent, deadline)
+		defer cancel()
+		defer func() {
+			if err != nil && outer.Err() == nil && parent.Err() != nil {
+				err, skipped = fmt.Errorf("%w (%v): %v", ErrProviderTimeout, s.config.ProviderTimeout, err), false
+			}
+		}()
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := common.BackoffDelay(attempt-1, s.config.RetryBaseDelay, s.config.RetryMaxDelay)
+			s.logger.Debug("[worker-%d] Backing off %v before attempt %d for job: %v", workerID, delay, attempt, job)
+			if sleepErr := common.SleepContext(parent, delay); sleepErr != nil {
+				return sleepErr, false
+			}
+		}
+		s.logger.Debug("[worker-%d] Attempt %d for job: %v", workerID, attempt, job)
+		ctx, cancel := context.WithTimeout(parent, downloadTimeout)
+		err, skipped = s.downloadProvider(ctx, job.Namespace, job.Name, job.Version, job.OS, job.Arch)
+		cancel()
+
+		if err == nil || skipped {
+			break
+		}
+		if ctx.Err() == context.DeadlineExceeded || isTimeoutError(err) {
+			s.logger.Warn("[worker-%d] Timeout on download for %s/%s %s %s_%s, restarting attempt %d",
+				workerID, job.Namespace, job.Name, job.Version, job.OS, job.Arch, attempt)
+			continue // рестарт попытки
+		}
+		// другая ошибка — не рестартуем
+		break
+	}
+	return err, skipped
+}
+
+// startWorkers runs MaxConcurrent download workers over jobList. The returned
+// channel is closed once every worker has exited, so ranging over it accounts
+// for all jobs without per-result timeouts.
+func (s *Service) startWorkers(parent context.Context, jobList []DownloadJob) <-chan DownloadResult {
+	jobs := make(chan DownloadJob, len(jobList))
+	results := make(chan DownloadResult, len(jobList))
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.config.MaxConcurrent; i++ {
+		s.logger.Debug("Spawning worker goroutine #%d", i)
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			s.downloadWorker(parent, jobs, results, workerID)
+		}(i)
+	}
+
+	for _, job := range jobList {
+		jobs <- job
+	}
+	close(jobs)
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// sessionSlack is added to every session deadline
+var sessionSlack = time.Minute
+
+// sessionDeadline is the longest a batch of jobCount jobs can legitimately
+// take: every job using all attempts with maximum backoff, in waves of
+// MaxConcurrent, plus sessionSlack
+func (s *Service) sessionDeadline(jobCount int) time.Duration {
+	attempts := max(s.config.MaxAttempts, 1)
+	workers := max(s.config.MaxConcurrent, 1)
+	perJob := time.Duration(attempts) * (s.config.DownloadTimeout + s.config.RetryMaxDelay)
+	waves := (jobCount + workers - 1) / workers
+	return perJob*time.Duration(waves) + sessionSlack
+}
+
 // isTimeoutError определяет, является ли ошибка таймаутом клиента
 func isTimeoutError(err error) bool {
 	if err == nil {
@@ -640,14 +1172,34 @@ func (s *Service) downloadProvider(ctx context.Context, namespace, name, version
 
 	// Get package information
 	pkg, err := s.registry.GetProviderPackage(ctx, namespace, name, version, osName, archName)
+	if errors.Is(err, ErrPackageNotFound) {
+		return err, false
+	}
 	if err != nil {
 		s.logger.Error("Failed to get package info for %s/%s %s %s_%s: %v",
 			namespace, name, version, osName, archName, err)
 		return fmt.Errorf("failed to get package info: %w", err), false
 	}
 
+	// Make sure the registry returned the platform we asked for, otherwise
+	// the archive would be stored (and later served) under the wrong name
+	if (pkg.OS != "" && pkg.OS != osName) || (pkg.Arch != "" && pkg.Arch != archName) {
+		s.logger.Error("Platform mismatch for %s/%s %s: requested %s_%s, registry returned %s_%s",
+			namespace, name, version, osName, archName, pkg.OS, pkg.Arch)
+		return fmt.Errorf("registry returned package for %s_%s, requested %s_%s", pkg.OS, pkg.Arch, osName, archName), false
+	}
+
 	// Determine file path (all versions/platforms in one folder)
-	filePath := s.registry.GetProviderPath(s.config.DownloadPath, namespace, name, version, osName, archName, pkg.Filename)
+	filePath := s.registry.GetProviderPath(s.writePath(), namespace, name, version, osName, archName, pkg.Filename)
+
+	// Cross-check the API shasum against the published SHA256SUMS file
+	if pkg.SHASumsURL != "" {
+		if err := s.verifySHASums(ctx, pkg, filepath.Dir(filePath)); err != nil {
+			s.logger.Error("SHA256SUMS check failed for %s/%s %s %s_%s: %v",
+				namespace, name, version, osName, archName, err)
+			return fmt.Errorf("SHA256SUMS check failed: %w", err), false
+		}
+	}
 
 	// (metadata json для версии теперь скачивается один раз на версию при формировании jobList)
 
@@ -655,6 +1207,8 @@ func (s *Service) downloadProvider(ctx context.Context, namespace, name, version
 	if fileExists(filePath) {
 		if s.verifyChecksum(filePath, pkg.Shasum) {
 			s.logger.Info("Provider already exists: %s/%s %s %s_%s (skipping download)", namespace, name, version, osName, archName)
+			s.dedupeArchive(filePath, pkg.Shasum)
+			s.recordVersionInfo(filePath, version, pkg)
 			return nil, true // File already exists and is valid - skipped
 		}
 		s.logger.Info("Provider exists but checksum mismatch, re-downloading: %s/%s %s %s_%s", namespace, name, version, osName, archName)
@@ -663,30 +1217,150 @@ func (s *Service) downloadProvider(ctx context.Context, namespace, name, version
 	s.logger.Info("Downloading provider: %s/%s %s %s_%s", namespace, name, version, osName, archName)
 	s.logger.Debug("Download URL: %s", pkg.DownloadURL)
 
-	// Download the provider binary
-	if err := s.registry.DownloadFile(ctx, pkg.DownloadURL, filePath); err != nil {
-		s.logger.Error("Failed to download provider binary for %s/%s %s %s_%s: %v",
-			namespace, name, version, osName, archName, err)
-		return fmt.Errorf("failed to download provider binary: %w", err), false
+	if err := s.checkDiskBudget(); err != nil {
+		return err, false
 	}
 
-	// Verify checksum
-	if !s.verifyChecksum(filePath, pkg.Shasum) {
-		s.logger.Error("Checksum verification failed for %s/%s %s %s_%s (file: %s)",
-			namespace, name, version, osName, archName, filePath)
+	// Download the provider binary, hashing it on the fly; a checksum
+	// mismatch is rejected before the archive reaches filePath
+	digest, err := s.registry.DownloadFileSHA256(ctx, pkg.DownloadURL, filePath, pkg.Shasum)
+	if errors.Is(err, errChecksumMismatch) {
+		s.logger.Error("Checksum verification failed for %s/%s %s %s_%s (file: %s): %v",
+			namespace, name, version, osName, archName, filePath, err)
+		// An archive left at filePath already failed verifyChecksum above
 		removeFile(filePath)
-		return fmt.Errorf("checksum verification failed for %s", filePath), false
+		return fmt.Errorf("%w for %s", errChecksumMismatch, filePath), false
+	}
+	if err != nil {
+		s.logger.Error("Failed to download provider binary for %s/%s %s %s_%s: %v",
+			namespace, name, version, osName, archName, err)
+		return fmt.Errorf("failed to download provider binary: %w", err), false
 	}
+	s.events.Emit(EventVerified, DownloadJob{Namespace: namespace, Name: name, Version: version, OS: osName, Arch: archName}, 0, nil)
+	s.recordDiskUsage(filePath)
+	s.dedupeArchive(filePath, digest)
+	s.recordVersionInfo(filePath, version, pkg)
 
 	s.logger.Info("Successfully downloaded provider: %s/%s %s %s_%s", namespace, name, version, osName, archName)
 
 	return nil, false // Successfully downloaded - not skipped
 }
 
+// recordVersionInfo saves the plugin protocols and signing keys reported for
+// a package into the <version>.json next to the archive
+func (s *Service) recordVersionInfo(filePath, version string, pkg *common.ProviderPackage) {
+	if err := indexgen.SetProtocols(filepath.Dir(filePath), version, pkg.Protocols); err != nil {
+		s.logger.Warn("Failed to record protocols for %s: %v", filePath, err)
+	}
+	if err := indexgen.SetSigningKeys(filepath.Dir(filePath), version, pkg.SigningKeys); err != nil {
+		s.logger.Warn("Failed to record signing keys for %s: %v", filePath, err)
+	}
+}
+
+// shasumsFileLock serializes the workers using one SHA256SUMS file
+type shasumsFileLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockSHASums locks the SHA256SUMS file at path, so the platforms of a
+// version fetch it once while other versions proceed, and returns the unlock
+func (s *Service) lockSHASums(path string) (unlock func()) {
+	s.shasumsMu.Lock()
+	if s.shasumsLocks == nil {
+		s.shasumsLocks = make(map[string]*shasumsFileLock)
+	}
+	lock, ok := s.shasumsLocks[path]
+	if !ok {
+		lock = &shasumsFileLock{}
+		s.shasumsLocks[path] = lock
+	}
+	lock.refs++
+	s.shasumsMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		s.shasumsMu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(s.shasumsLocks, path)
+		}
+		s.shasumsMu.Unlock()
+	}
+}
+
+// verifySHASums downloads the SHA256SUMS file and its signature for a package
+// into providerDir, where the registry endpoints serve them, and checks that
+// it agrees with the shasum reported by the download API. With
+// VerifySignatures enabled the detached signature is checked as well.
+func (s *Service) verifySHASums(ctx context.Context, pkg *common.ProviderPackage, providerDir string) error {
+	shasumsPath := filepath.Join(providerDir, path.Base(pkg.SHASumsURL))
+	unlock := s.lockSHASums(shasumsPath)
+	defer unlock()
+
+	cached := fileExists(shasumsPath)
+	err := s.checkSHASums(ctx, pkg, shasumsPath)
+	if err != nil && cached {
+		// A stale or truncated copy from an earlier run would otherwise fail
+		// this version for good
+		s.logger.Warn("Fetching %s again: %v", shasumsPath, err)
+		removeFile(shasumsPath)
+		err = s.checkSHASums(ctx, pkg, shasumsPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if pkg.SHASumsSignatureURL == "" {
+		if s.config.VerifySignatures {
+			return fmt.Errorf("registry returned no SHA256SUMS signature URL")
+		}
+		return nil
+	}
+
+	signaturePath := filepath.Join(providerDir, path.Base(pkg.SHASumsSignatureURL))
+	if !fileExists(signaturePath) {
+		err = s.registry.DownloadFile(ctx, pkg.SHASumsSignatureURL, signaturePath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download SHA256SUMS signature: %w", err)
+	}
+	if !s.config.VerifySignatures {
+		return nil
+	}
+
+	keyID, err := verifySHASumsSignature(shasumsPath, signaturePath, pkg.SigningKeys.GPGPublicKeys)
+	if err != nil {
+		// Drop the pair so the next run fetches fresh copies
+		removeFile(signaturePath)
+		removeFile(shasumsPath)
+		return err
+	}
+	s.logger.Debug("SHA256SUMS signature for %s verified with key %s", pkg.Filename, keyID)
+	return nil
+}
+
+// checkSHASums downloads the SHA256SUMS file to shasumsPath unless it is
+// there already and checks its entry for the package against the API shasum
+func (s *Service) checkSHASums(ctx context.Context, pkg *common.ProviderPackage, shasumsPath string) error {
+	sums, err := s.registry.DownloadSHASums(ctx, pkg.SHASumsURL, shasumsPath)
+	if err != nil {
+		return err
+	}
+	expected, ok := sums[pkg.Filename]
+	if !ok {
+		return fmt.Errorf("%s not listed in %s", pkg.Filename, shasumsPath)
+	}
+	if pkg.Shasum != "" && !strings.EqualFold(expected, pkg.Shasum) {
+		return fmt.Errorf("shasum mismatch for %s: API reports %s, SHA256SUMS has %s", pkg.Filename, pkg.Shasum, expected)
+	}
+	return nil
+}
+
 // shouldDownload determines if a provider version should be downloaded
 func (s *Service) shouldDownload(namespace, name, version, osName, archName string) bool {
 	// Apply provider filter first
-	if s.providerFilter.IsEnabled() && !s.providerFilter.ShouldInclude(namespace, name) {
+	if !s.providerFilter.ShouldInclude(namespace, name) {
 		return false
 	}
 
@@ -705,11 +1379,18 @@ func (s *Service) shouldDownload(namespace, name, version, osName, archName stri
 		return true
 	}
 
+	// Archives recorded as corrupt, missing or partial are re-attempted without
+	// re-verifying the ones known to be good
+	if status, ok := providerInfo.fileStatus(version, osName, archName); ok && status.Status != FileStatusOK {
+		s.logger.Debug("Provider %s/%s %s %s_%s is %s in metadata, should download", namespace, name, version, osName, archName, status.Status)
+		return true
+	}
+
 	// Check if version is already downloaded by looking for any provider file
 	for _, v := range providerInfo.Versions {
 		if v == version {
 			// Check if provider directory exists and contains files
-			providerDir := filepath.Join(s.config.DownloadPath, "registry.terraform.io", namespace, name)
+			providerDir := filepath.Join(s.providerRoot(), namespace, name)
 
 			if files, err := readDir(providerDir); err == nil {
 				// Look for terraform-provider-* files (actual binaries) for this version/platform
@@ -731,8 +1412,9 @@ func (s *Service) shouldDownload(namespace, name, version, osName, archName stri
 	return true // Version not in metadata, should download
 }
 
-// updateMetadata updates the provider metadata
-func (s *Service) updateMetadata(namespace, name, version, osName, archName string) {
+// updateMetadata records an archive that is on disk. verified means its
+// checksum was just confirmed, which stamps the file's LastVerified.
+func (s *Service) updateMetadata(namespace, name, version, osName, archName string, verified bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -772,10 +1454,22 @@ func (s *Service) updateMetadata(namespace, name, version, osName, archName stri
 	if !versionExists {
 		providerInfo.Versions = append(providerInfo.Versions, version)
 	}
+
+	if providerInfo.Files == nil {
+		providerInfo.Files = make(map[string]FileStatus)
+	}
+	fileKey := fileStatusKey(version, osName, archName)
+	status := providerInfo.Files[fileKey]
+	status.Status = FileStatusOK
+	if verified {
+		now := time.Now().UTC()
+		status.LastVerified = &now
+	}
+	providerInfo.Files[fileKey] = status
 	s.metadata.Providers[providerKey] = providerInfo
 }
 
-// verifyChecksum verifies the SHA256 checksum of a file
+// verifyChecksum verifies the SHA256 checksum of a file already on disk
 func (s *Service) verifyChecksum(filePath, expectedChecksum string) bool {
 	if expectedChecksum == "" {
 		s.logger.Debug("No expected checksum provided for %s, skipping verification", filePath)
@@ -787,18 +1481,52 @@ func (s *Service) verifyChecksum(filePath, expectedChecksum string) bool {
 		return false
 	}
 
-	// Get file info to check if it's empty or corrupted
-	if info, err := statFile(filePath); err != nil || info.Size() == 0 {
-		s.logger.Debug("File is empty or inaccessible: %s", filePath)
+	actual, err := common.FileSHA256(filePath)
+	if err != nil {
+		s.logger.Warn("Failed to compute checksum for %s: %v", filePath, err)
 		return false
 	}
 
-	// For now, we'll consider files with the expected checksum field as valid
-	// In a production implementation, this would compute actual SHA256
-	s.logger.Debug("Checksum verification passed for %s (expected: %s)", filePath, expectedChecksum)
+	if !strings.EqualFold(actual, expectedChecksum) {
+		s.logger.Warn("Checksum mismatch for %s: expected %s, got %s", filePath, expectedChecksum, actual)
+		return false
+	}
+
+	s.logger.Debug("Checksum verification passed for %s (sha256: %s)", filePath, actual)
 	return true
 }
 
+// RebuildMetadata recreates .tf-mirror-metadata.json, index.json and every
+// <version>.json from the archives on disk without contacting the registry
+func (s *Service) RebuildMetadata() error {
+	if err := s.regenerateMetadata(); err != nil {
+		return fmt.Errorf("failed to regenerate metadata: %w", err)
+	}
+
+	providerRoot := s.providerRoot()
+	s.mu.RLock()
+	providers := make([]ProviderInfo, 0, len(s.metadata.Providers))
+	for _, info := range s.metadata.Providers {
+		providers = append(providers, info)
+	}
+	s.mu.RUnlock()
+
+	failed := 0
+	for _, info := range providers {
+		providerDir := filepath.Join(providerRoot, info.Namespace, info.Name)
+		if err := indexgen.GenerateIndexJSON(providerDir); err != nil {
+			s.logger.Error("Failed to generate index.json for %s/%s: %v", info.Namespace, info.Name, err)
+			failed++
+		}
+	}
+
+	s.logger.Info("Rebuilt metadata for %d providers", len(providers))
+	if failed > 0 {
+		return fmt.Errorf("index generation failed for %d providers", failed)
+	}
+	return nil
+}
+
 // regenerateMetadata полностью пересоздаёт метаданные по содержимому папки
 func (s *Service) regenerateMetadata() error {
 	s.logger.Info("Regenerating metadata from disk in %s", s.config.DownloadPath)
@@ -806,6 +1534,7 @@ func (s *Service) regenerateMetadata() error {
 	s.metadata.Providers = make(map[string]ProviderInfo)
 	s.mu.Unlock()
 
+	var partials []DownloadJob
 	err := filepath.Walk(s.config.DownloadPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
@@ -821,23 +1550,16 @@ func (s *Service) regenerateMetadata() error {
 			return nil
 		}
 
-		if IsProviderPath(relPath) {
-			filename := info.Name()
-			if strings.HasPrefix(filename, "terraform-provider-") && strings.HasSuffix(filename, ".zip") {
-				base := strings.TrimPrefix(filename, "terraform-provider-")
-				base = strings.TrimSuffix(base, ".zip")
-				nameParts := strings.Split(base, "_")
-				if len(nameParts) >= 4 {
-					name := nameParts[0]
-					version := nameParts[1]
-					osName := nameParts[2]
-					archName := nameParts[3]
-					// namespace из пути: registry.terraform.io/namespace/name/...
-					pathParts := strings.Split(filepath.Clean(relPath), string(filepath.Separator))
-					if len(pathParts) >= 4 {
-						namespace := pathParts[len(pathParts)-3]
-						s.updateMetadata(namespace, name, version, osName, archName)
-					}
+		// Архивы лежат в <registry-host>/<namespace>/<name>/
+		pathParts := strings.Split(filepath.Clean(relPath), string(filepath.Separator))
+		if len(pathParts) == 4 && pathParts[0] == s.registry.Hostname() {
+			namespace, name := pathParts[1], pathParts[2]
+			if version, osName, archName, status, ok := parseArchiveStatus(name, info.Name()); ok {
+				job := DownloadJob{Namespace: namespace, Name: name, Version: version, OS: osName, Arch: archName}
+				if status == FileStatusOK {
+					s.updateMetadata(namespace, name, version, osName, archName, false)
+				} else {
+					partials = append(partials, job)
 				}
 			}
 		}
@@ -847,12 +1569,22 @@ func (s *Service) regenerateMetadata() error {
 	if err != nil {
 		return err
 	}
+	// Leftover .tmp files are recorded once the completed archives are known,
+	// unless the same archive was also completed
+	for _, job := range partials {
+		s.mu.RLock()
+		status, ok := s.metadata.Providers[job.providerKey()].fileStatus(job.Version, job.OS, job.Arch)
+		s.mu.RUnlock()
+		if !ok || status.Status != FileStatusOK {
+			s.setFileStatus(job, FileStatusPartial)
+		}
+	}
 	return s.saveMetadata()
 }
 
 // loadMetadata loads provider metadata from disk
 func (s *Service) loadMetadata() error {
-	metadataPath := filepath.Join(s.config.DownloadPath, ".tf-mirror-metadata.json")
+	metadataPath := s.stateFile(metadataFile)
 
 	data, err := os.ReadFile(metadataPath)
 	if os.IsNotExist(err) {
@@ -865,6 +1597,7 @@ func (s *Service) loadMetadata() error {
 	if err := json.Unmarshal(data, s.metadata); err != nil {
 		return fmt.Errorf("failed to parse metadata: %w", err)
 	}
+	s.migrateMetadata()
 
 	return nil
 }
@@ -873,18 +1606,15 @@ func (s *Service) loadMetadata() error {
 func (s *Service) saveMetadata() error {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return s.writeMetadata(s.metadata)
+}
 
-	metadataPath := filepath.Join(s.config.DownloadPath, ".tf-mirror-metadata.json")
-
-	data, err := json.MarshalIndent(s.metadata, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %w", err)
-	}
-
-	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
+// writeMetadata atomically replaces the metadata file with v. It is the only
+// writer of the file; the caller must hold s.mu.
+func (s *Service) writeMetadata(v any) error {
+	if err := writeJSONFile(s.stateFile(metadataFile), v); err != nil {
 		return fmt.Errorf("failed to write metadata file: %w", err)
 	}
-
 	return nil
 }
 
@@ -0,0 +1,1027 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestExceedsFailThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		failed    int
+		total     int
+		threshold float64
+		want      bool
+	}{
+		{name: "no failures", failed: 0, total: 10, threshold: 0, want: false},
+		{name: "any failure with threshold 0", failed: 1, total: 1000, threshold: 0, want: true},
+		{name: "below the threshold", failed: 1, total: 10, threshold: 20, want: false},
+		{name: "exactly at the threshold", failed: 2, total: 10, threshold: 20, want: false},
+		{name: "just above the threshold", failed: 3, total: 10, threshold: 20, want: true},
+		{name: "fractional threshold", failed: 1, total: 200, threshold: 0.5, want: false},
+		{name: "above a fractional threshold", failed: 2, total: 200, threshold: 0.5, want: true},
+		{name: "all failed with threshold 100", failed: 10, total: 10, threshold: 100, want: false},
+		{name: "no jobs", failed: 0, total: 0, threshold: 0, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exceedsFailThreshold(tt.failed, tt.total, tt.threshold); got != tt.want {
+				t.Errorf("exceedsFailThreshold(%d, %d, %v) = %t, want %t", tt.failed, tt.total, tt.threshold, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionError(t *testing.T) {
+	tests := []struct {
+		name        string
+		failed      int
+		total       int
+		threshold   float64
+		binariesErr error
+		wantFailed  bool
+	}{
+		{name: "clean run", failed: 0, total: 10},
+		{name: "failures over the threshold", failed: 3, total: 10, threshold: 20, wantFailed: true},
+		{name: "failures within the threshold", failed: 2, total: 10, threshold: 20},
+		{name: "binaries failed", total: 10, binariesErr: errors.New("checksum mismatch"), wantFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &common.DownloaderConfig{FailThreshold: tt.threshold})
+			err := s.sessionError(context.Background(), tt.failed, tt.total, tt.binariesErr, nil)
+			if got := errors.Is(err, ErrDownloadsFailed); got != tt.wantFailed {
+				t.Errorf("sessionError = %v, want failed %t", err, tt.wantFailed)
+			}
+		})
+	}
+}
+
+// newTestService returns a Service for registry.terraform.io writing under
+// t.TempDir()
+func newTestService(t *testing.T, config *common.DownloaderConfig) *Service {
+	t.Helper()
+	if config.DownloadPath == "" {
+		config.DownloadPath = t.TempDir()
+	}
+	s, err := NewService(config, &common.RegistryConfig{BaseURL: "https://registry.terraform.io"}, common.NewLogger())
+	if err != nil {
+		t.Fatalf("NewService: %v", err)
+	}
+	return s
+}
+
+// writeTestFile creates path with content, and its parent directories
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadProviderPlatformMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(pkg *common.ProviderPackage)
+		wantErr string
+	}{
+		{name: "matching platform"},
+		{name: "empty os and arch are trusted", edit: func(pkg *common.ProviderPackage) { pkg.OS, pkg.Arch = "", "" }},
+		{name: "other os", edit: func(pkg *common.ProviderPackage) { pkg.OS = "darwin" }, wantErr: "registry returned package for darwin_amd64"},
+		{name: "other arch", edit: func(pkg *common.ProviderPackage) { pkg.Arch = "arm64" }, wantErr: "registry returned package for linux_arm64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			reg.editPackage = tt.edit
+			s := newFakeService(t, reg, &common.DownloaderConfig{})
+
+			err, _ := s.downloadProvider(context.Background(), "hashicorp", "null", "3.2.0", "linux", "amd64")
+			archive := s.registry.GetProviderPath(s.writePath(), "hashicorp", "null", "3.2.0", "linux", "amd64",
+				"terraform-provider-null_3.2.0_linux_amd64.zip")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("downloadProvider: %v", err)
+				}
+				if !fileExists(archive) {
+					t.Errorf("archive %s was not stored", archive)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("downloadProvider error = %v, want %q", err, tt.wantErr)
+			}
+			if fileExists(archive) {
+				t.Errorf("archive %s was stored for a mismatched package", archive)
+			}
+		})
+	}
+}
+
+func TestDownloadProviderChecksumMismatch(t *testing.T) {
+	const filename = "terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name      string
+		existing  bool // a corrupt archive from an earlier run is on disk
+		corrupt   bool // the registry serves bytes that do not match the shasum
+		wantError bool
+	}{
+		{name: "intact download"},
+		{name: "corrupt download", corrupt: true, wantError: true},
+		{name: "corrupt download over a corrupt archive", existing: true, corrupt: true, wantError: true},
+		{name: "corrupt archive replaced", existing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			if tt.corrupt {
+				reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+					if r.URL.Path != "/files/"+filename {
+						return false
+					}
+					w.Write([]byte("tampered"))
+					return true
+				}
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{})
+			archive := s.registry.GetProviderPath(s.writePath(), "hashicorp", "null", "3.2.0", "linux", "amd64", filename)
+			if tt.existing {
+				writeTestFile(t, archive, "truncated")
+			}
+			// Nothing that fails the checksum may be renamed into the served tree
+			orig := renameFile
+			renameFile = func(oldPath, newPath string) error {
+				if newPath == archive && tt.corrupt {
+					t.Errorf("corrupt download renamed to %s", newPath)
+				}
+				return orig(oldPath, newPath)
+			}
+			t.Cleanup(func() { renameFile = orig })
+
+			err, _ := s.downloadProvider(context.Background(), "hashicorp", "null", "3.2.0", "linux", "amd64")
+			if got := errors.Is(err, errChecksumMismatch); got != tt.wantError {
+				t.Fatalf("downloadProvider error = %v, want checksum mismatch %t", err, tt.wantError)
+			}
+			content, readErr := os.ReadFile(archive)
+			switch {
+			case tt.wantError && readErr == nil:
+				t.Errorf("archive %s is served after a checksum mismatch: %q", archive, content)
+			case !tt.wantError && !bytes.Equal(content, fakeArchive(filename)):
+				t.Errorf("archive %s does not hold the registry archive (%v)", archive, readErr)
+			}
+			if fileExists(archive + ".tmp") {
+				t.Errorf("temporary file %s.tmp was left behind", archive)
+			}
+		})
+	}
+}
+
+func TestCachedSHASumsFetchedAgain(t *testing.T) {
+	const (
+		archive = "terraform-provider-null_3.2.0_linux_amd64.zip"
+		sums    = "/files/terraform-provider-null_3.2.0_SHA256SUMS"
+	)
+	valid := fakeArchiveSHA256(archive) + "  " + archive + "\n"
+	tests := []struct {
+		name         string
+		cached       string // SHA256SUMS left by an earlier run, none if empty
+		edit         func(pkg *common.ProviderPackage)
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "not cached", wantRequests: 1},
+		{name: "valid copy", cached: valid, wantRequests: 0},
+		{name: "truncated copy", cached: valid[:20], wantRequests: 1},
+		{name: "stale copy without the archive", cached: fakeArchiveSHA256("other.zip") + "  other.zip\n", wantRequests: 1},
+		{name: "copy with another sum", cached: fakeArchiveSHA256("other.zip") + "  " + archive + "\n", wantRequests: 1},
+		{
+			name:         "upstream disagrees as well",
+			cached:       valid,
+			edit:         func(pkg *common.ProviderPackage) { pkg.Shasum = fakeArchiveSHA256("other.zip") },
+			wantRequests: 1,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			reg.editPackage = tt.edit
+			s := newFakeService(t, reg, &common.DownloaderConfig{})
+			shasumsPath := filepath.Join(s.providerRoot(), "hashicorp", "null", "terraform-provider-null_3.2.0_SHA256SUMS")
+			if tt.cached != "" {
+				writeTestFile(t, shasumsPath, tt.cached)
+			}
+
+			err, _ := s.downloadProvider(context.Background(), "hashicorp", "null", "3.2.0", "linux", "amd64")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("downloadProvider error = %v, want error %t", err, tt.wantErr)
+			}
+			if n := reg.requestCount(sums); n != tt.wantRequests {
+				t.Errorf("SHA256SUMS requested %d times, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSHASumsLockedPerVersion(t *testing.T) {
+	s := &Service{}
+	unlock := s.lockSHASums("a_SHA256SUMS")
+
+	done := make(chan struct{})
+	go func() {
+		s.lockSHASums("b_SHA256SUMS")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a SHA256SUMS lock blocked another version")
+	}
+
+	blocked := make(chan struct{})
+	go func() {
+		s.lockSHASums("a_SHA256SUMS")()
+		close(blocked)
+	}()
+	select {
+	case <-blocked:
+		t.Fatal("the same SHA256SUMS was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-blocked
+
+	s.shasumsMu.Lock()
+	defer s.shasumsMu.Unlock()
+	if len(s.shasumsLocks) != 0 {
+		t.Errorf("%d locks left after unlocking", len(s.shasumsLocks))
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	s := newTestService(t, &common.DownloaderConfig{})
+	dir := t.TempDir()
+	fixture := fakeArchive("terraform-provider-null_3.2.0_linux_amd64.zip")
+	sum := fakeArchiveSHA256("terraform-provider-null_3.2.0_linux_amd64.zip")
+
+	good := filepath.Join(dir, "good.zip")
+	corrupted := filepath.Join(dir, "corrupted.zip")
+	truncated := filepath.Join(dir, "truncated.zip")
+	broken := append([]byte(nil), fixture...)
+	broken[len(broken)/2] ^= 0xff
+	for path, data := range map[string][]byte{good: fixture, corrupted: broken, truncated: fixture[:len(fixture)-10]} {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+		want     bool
+	}{
+		{name: "intact archive", path: good, expected: sum, want: true},
+		{name: "upper-case expected sum", path: good, expected: strings.ToUpper(sum), want: true},
+		{name: "corrupted copy", path: corrupted, expected: sum, want: false},
+		{name: "truncated copy", path: truncated, expected: sum, want: false},
+		{name: "missing file", path: filepath.Join(dir, "missing.zip"), expected: sum, want: false},
+		{name: "no expected sum", path: good, expected: "", want: true},
+		{name: "no expected sum and missing file", path: filepath.Join(dir, "missing.zip"), expected: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.verifyChecksum(tt.path, tt.expected); got != tt.want {
+				t.Errorf("verifyChecksum(%s) = %t, want %t", filepath.Base(tt.path), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryUnavailableAbortsEarly(t *testing.T) {
+	providers := make(map[string][]string)
+	var names []string
+	for i := range 8 {
+		name := fmt.Sprintf("p%d", i)
+		providers["hashicorp/"+name] = []string{"1.0.0"}
+		names = append(names, "hashicorp/"+name)
+	}
+	unavailable := func(prefix string) func(w http.ResponseWriter, r *http.Request) bool {
+		return func(w http.ResponseWriter, r *http.Request) bool {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				return false
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return true
+		}
+	}
+
+	tests := []struct {
+		name            string
+		filter          string
+		intercept       func(w http.ResponseWriter, r *http.Request) bool
+		wantUnavailable bool
+		maxChecked      int // providers whose versions may be requested before the abort
+	}{
+		{name: "discovery answers 503", filter: "hashicorp/*", intercept: unavailable("/v1/providers"), wantUnavailable: true, maxChecked: 0},
+		{name: "every provider answers 503", filter: strings.Join(names, ","), intercept: unavailable("/v1/providers/"), wantUnavailable: true, maxChecked: unavailableThreshold},
+		// one planner may have started the next provider before the abort
+		{name: "versions answer 503 after discovery", filter: "hashicorp/*", intercept: unavailable("/v1/providers/"), wantUnavailable: true, maxChecked: unavailableThreshold + 1},
+		{name: "fewer providers than the threshold", filter: strings.Join(names[:unavailableThreshold-1], ","), intercept: unavailable("/v1/providers/"), maxChecked: unavailableThreshold - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, providers)
+			reg.intercept = tt.intercept
+			s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: tt.filter, PlatformFilter: "linux_amd64", MaxConcurrent: 1})
+
+			err := s.RunOnce(context.Background())
+			if got := errors.Is(err, ErrRegistryUnavailable); got != tt.wantUnavailable {
+				t.Errorf("RunOnce = %v, want registry unavailable %t", err, tt.wantUnavailable)
+			}
+			checked := 0
+			for _, name := range names {
+				if reg.requestCount("/v1/providers/"+name+"/versions") > 0 {
+					checked++
+				}
+			}
+			if checked > tt.maxChecked {
+				t.Errorf("versions requested for %d providers, want at most %d", checked, tt.maxChecked)
+			}
+		})
+	}
+}
+
+func TestRunJobBackoff(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	tests := []struct {
+		name     string
+		attempts int
+		base     time.Duration
+		cancel   time.Duration // cancel the job after this long (0 = never)
+		min, max time.Duration
+	}{
+		// three timed-out attempts with 50-75ms and 100-150ms of backoff
+		// between them, and up to 150ms of scheduling slack
+		{name: "backoff between attempts", attempts: 3, base: 50 * time.Millisecond, min: 3*timeout + 150*time.Millisecond, max: 3*timeout + 375*time.Millisecond},
+		{name: "no backoff", attempts: 3, min: 3 * timeout, max: 3*timeout + 150*time.Millisecond},
+		{name: "cancel interrupts the backoff", attempts: 3, base: 5 * time.Second, cancel: 100 * time.Millisecond, min: 100 * time.Millisecond, max: 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			// Every attempt times out waiting for the package
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				<-r.Context().Done()
+				return true
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{RetryBaseDelay: tt.base, RetryMaxDelay: time.Second})
+
+			ctx := context.Background()
+			if tt.cancel > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.cancel)
+				defer cancel()
+			}
+			job := DownloadJob{Namespace: "hashicorp", Name: "null", Version: "3.2.0", OS: "linux", Arch: "amd64"}
+			start := time.Now()
+			err, _ := s.runJob(ctx, job, 0, tt.attempts, timeout)
+			elapsed := time.Since(start)
+
+			if err == nil {
+				t.Fatal("runJob succeeded, want an error")
+			}
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("runJob took %v, want between %v and %v", elapsed, tt.min, tt.max)
+			}
+			if got := reg.requestCount("/v1/providers/hashicorp/null/3.2.0/download/linux/amd64"); tt.cancel == 0 && got != tt.attempts {
+				t.Errorf("made %d attempts, want %d", got, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestRunOnceResult(t *testing.T) {
+	failArchive := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/files/terraform-provider-random_3.6.0_linux_amd64.zip" {
+			return false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	tests := []struct {
+		name       string
+		intercept  func(w http.ResponseWriter, r *http.Request) bool
+		threshold  float64
+		wantFailed bool
+	}{
+		{name: "every download succeeds"},
+		{name: "a download fails", intercept: failArchive, wantFailed: true},
+		{name: "failures within the threshold", intercept: failArchive, threshold: 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{
+				"hashicorp/null":   {"3.2.0"},
+				"hashicorp/random": {"3.6.0"},
+			})
+			reg.intercept = tt.intercept
+			s := newFakeService(t, reg, &common.DownloaderConfig{
+				ProviderFilter: "hashicorp/*",
+				PlatformFilter: "linux_amd64",
+				FailThreshold:  tt.threshold,
+			})
+
+			// main exits non-zero exactly when RunOnce returns an error
+			err := s.RunOnce(context.Background())
+			if got := err != nil; got != tt.wantFailed {
+				t.Fatalf("RunOnce = %v, want failed %t", err, tt.wantFailed)
+			}
+			if tt.wantFailed && !errors.Is(err, ErrDownloadsFailed) {
+				t.Errorf("RunOnce = %v, want %v", err, ErrDownloadsFailed)
+			}
+			index := s.registry.GetProviderPath(s.config.DownloadPath, "hashicorp", "null", "", "", "", "index.json")
+			if _, err := os.Stat(index); err != nil {
+				t.Errorf("index of a downloaded provider not generated: %v", err)
+			}
+		})
+	}
+}
+
+// blockingWriter stalls the worker writing an event that contains every one
+// of match until release is closed. finished is closed once the job of
+// provider reports its outcome.
+type blockingWriter struct {
+	match    []string
+	provider string
+	release  chan struct{}
+	finished chan struct{}
+}
+
+func containsAll(s string, substrs ...string) bool {
+	for _, substr := range substrs {
+		if !strings.Contains(s, substr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	event := string(p)
+	if containsAll(event, w.match...) {
+		<-w.release
+	}
+	if containsAll(event, w.provider, `"type":"downloaded"`) || containsAll(event, w.provider, `"type":"failed"`) {
+		close(w.finished)
+	}
+	return len(p), nil
+}
+
+func TestSessionDeadlineAccountsForBlockedWorkers(t *testing.T) {
+	slack := sessionSlack
+	sessionSlack = 200 * time.Millisecond
+	t.Cleanup(func() { sessionSlack = slack })
+
+	tests := []struct {
+		name       string
+		block      []string // the event that never finishes writing
+		wantFailed bool
+	}{
+		{name: "no worker blocks", block: []string{"no such event"}},
+		{name: "a worker blocks", block: []string{`"type":"job_started"`, `"name":"random"`}, wantFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{
+				"hashicorp/null":   {"3.2.0"},
+				"hashicorp/random": {"3.6.0"},
+			})
+			s := newFakeService(t, reg, &common.DownloaderConfig{
+				ProviderFilter:  "hashicorp/*",
+				PlatformFilter:  "linux_amd64",
+				DownloadTimeout: 100 * time.Millisecond,
+			})
+			w := &blockingWriter{
+				match:    tt.block,
+				provider: `"name":"random"`,
+				release:  make(chan struct{}),
+				finished: make(chan struct{}),
+			}
+			s.events = NewEventEmitter(w, common.NewLogger())
+			// let the blocked worker finish before the download path is removed
+			t.Cleanup(func() {
+				close(w.release)
+				<-w.finished
+			})
+
+			start := time.Now()
+			err := s.RunOnce(context.Background())
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("RunOnce took %v with a blocked worker", elapsed)
+			}
+			if got := errors.Is(err, ErrDownloadsFailed); got != tt.wantFailed {
+				t.Errorf("RunOnce = %v, want failed %t", err, tt.wantFailed)
+			}
+			archive := s.registry.GetProviderPath(s.config.DownloadPath, "hashicorp", "null", "", "", "", "terraform-provider-null_3.2.0_linux_amd64.zip")
+			if _, err := os.Stat(archive); err != nil {
+				t.Errorf("archive of the unblocked provider missing: %v", err)
+			}
+		})
+	}
+}
+
+func TestCancelledSessionShutsDownPromptly(t *testing.T) {
+	tests := []struct {
+		name         string
+		block        string // request held open until the client goes away
+		wantMetadata bool   // hashicorp/null finished before the cancel
+	}{
+		{name: "during planning", block: "/v1/providers/hashicorp/random/versions"},
+		{name: "during downloads", block: "/files/terraform-provider-random_3.6.0_linux_amd64.zip", wantMetadata: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{
+				"hashicorp/null":   {"3.2.0"},
+				"hashicorp/random": {"3.6.0"},
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path != tt.block {
+					return false
+				}
+				// give the other provider time to finish, then interrupt
+				time.AfterFunc(200*time.Millisecond, cancel)
+				<-r.Context().Done()
+				return true
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{
+				ProviderFilter:  "hashicorp/*",
+				PlatformFilter:  "linux_amd64",
+				DownloadTimeout: time.Minute,
+			})
+
+			start := time.Now()
+			err := s.RunOnce(ctx)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("RunOnce returned %v after the cancel", elapsed)
+			}
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("RunOnce = %v, want %v", err, context.Canceled)
+			}
+			if _, err := os.Stat(s.stateFile(checkpointFile)); err != nil {
+				t.Errorf("checkpoint not kept for the next session: %v", err)
+			}
+			metadata, _ := os.ReadFile(s.stateFile(metadataFile))
+			if got := strings.Contains(string(metadata), `"hashicorp/null"`); got != tt.wantMetadata {
+				t.Errorf("metadata records hashicorp/null = %t, want %t:\n%s", got, tt.wantMetadata, metadata)
+			}
+		})
+	}
+}
+
+func TestProtocolsSurviveIntoVersionJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []string
+	}{
+		{name: "protocol 5", protocols: []string{"5.0"}},
+		{name: "protocols 5 and 6", protocols: []string{"5.2", "6.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			reg.editPackage = func(pkg *common.ProviderPackage) { pkg.Protocols = tt.protocols }
+			s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: "hashicorp/null", PlatformFilter: "linux_amd64,darwin_arm64"})
+			if err := s.RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce: %v", err)
+			}
+
+			data, err := os.ReadFile(s.registry.GetProviderVersionJSONPath(s.config.DownloadPath, "hashicorp", "null", "3.2.0"))
+			if err != nil {
+				t.Fatalf("read 3.2.0.json: %v", err)
+			}
+			var versionFile struct {
+				Archives  map[string]json.RawMessage `json:"archives"`
+				Protocols []string                   `json:"protocols"`
+			}
+			if err := json.Unmarshal(data, &versionFile); err != nil {
+				t.Fatalf("decode 3.2.0.json: %v", err)
+			}
+			if !slices.Equal(versionFile.Protocols, tt.protocols) {
+				t.Errorf("protocols = %v, want %v", versionFile.Protocols, tt.protocols)
+			}
+			if len(versionFile.Archives) != 2 {
+				t.Errorf("archives = %s, want both platforms", data)
+			}
+		})
+	}
+}
+
+func TestSigningMaterialSaved(t *testing.T) {
+	tests := []struct {
+		name          string
+		edit          func(pkg *common.ProviderPackage)
+		wantSignature bool
+		wantKeys      bool
+	}{
+		{name: "signature and keys", wantSignature: true, wantKeys: true},
+		{name: "no signature URL", edit: func(pkg *common.ProviderPackage) { pkg.SHASumsSignatureURL = "" }, wantKeys: true},
+		{name: "no signing keys", edit: func(pkg *common.ProviderPackage) { pkg.SigningKeys = common.SigningKeys{} }, wantSignature: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			reg.editPackage = tt.edit
+			s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: "hashicorp/null"})
+			if err := s.RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce: %v", err)
+			}
+
+			providerDir := filepath.Join(s.providerRoot(), "hashicorp", "null")
+			if _, err := os.Stat(filepath.Join(providerDir, "terraform-provider-null_3.2.0_SHA256SUMS.sig")); (err == nil) != tt.wantSignature {
+				t.Errorf("signature saved = %t, want %t", err == nil, tt.wantSignature)
+			}
+			data, err := os.ReadFile(filepath.Join(providerDir, "3.2.0.json"))
+			if err != nil {
+				t.Fatalf("read 3.2.0.json: %v", err)
+			}
+			var versionFile struct {
+				SigningKeys *common.SigningKeys `json:"signing_keys"`
+			}
+			if err := json.Unmarshal(data, &versionFile); err != nil {
+				t.Fatalf("decode 3.2.0.json: %v", err)
+			}
+			if (versionFile.SigningKeys != nil) != tt.wantKeys {
+				t.Fatalf("signing_keys = %+v, want keys %t", versionFile.SigningKeys, tt.wantKeys)
+			}
+			if tt.wantKeys && !reflect.DeepEqual(*versionFile.SigningKeys, fakeSigningKeys) {
+				t.Errorf("signing_keys = %+v, want %+v", *versionFile.SigningKeys, fakeSigningKeys)
+			}
+		})
+	}
+}
+
+func TestUnpublishedPlatformIsNotAFailure(t *testing.T) {
+	const download = "/v1/providers/hashicorp/null/3.2.0/download/darwin/arm64"
+	tests := []struct {
+		name             string
+		status           int
+		wantNotAvailable int
+		wantFailed       int
+	}{
+		{name: "404 means not published", status: http.StatusNotFound, wantNotAvailable: 1},
+		{name: "500 is a failure", status: http.StatusInternalServerError, wantFailed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path != download {
+					return false
+				}
+				w.WriteHeader(tt.status)
+				return true
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{
+				ProviderFilter: "hashicorp/null",
+				PlatformFilter: "linux_amd64,darwin_arm64",
+				MaxAttempts:    3,
+			})
+			var out bytes.Buffer
+			s.events = NewEventEmitter(&out, common.NewLogger())
+
+			err := s.RunOnce(context.Background())
+			if got := errors.Is(err, ErrDownloadsFailed); got != (tt.wantFailed > 0) {
+				t.Errorf("RunOnce = %v, want failed %t", err, tt.wantFailed > 0)
+			}
+			counts := make(map[EventType]int)
+			dec := json.NewDecoder(&out)
+			for dec.More() {
+				var event Event
+				if err := dec.Decode(&event); err != nil {
+					t.Fatalf("decode event: %v", err)
+				}
+				counts[event.Type]++
+			}
+			if counts[EventDownloaded] != 1 || counts[EventNotAvailable] != tt.wantNotAvailable || counts[EventFailed] != tt.wantFailed {
+				t.Errorf("downloaded/not available/failed = %d/%d/%d, want 1/%d/%d",
+					counts[EventDownloaded], counts[EventNotAvailable], counts[EventFailed], tt.wantNotAvailable, tt.wantFailed)
+			}
+			if tt.status == http.StatusNotFound {
+				if got := reg.requestCount(download); got != 1 {
+					t.Errorf("unpublished platform requested %d times, want 1", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRegistryTokenNotLogged(t *testing.T) {
+	const token = "s3cret-token"
+	tests := []struct {
+		name       string
+		failStatus int // status of the archive download, 0 to serve it
+	}{
+		{name: "successful run"},
+		{name: "failed download", failStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			var mu sync.Mutex
+			var missing []string // registry requests sent without the token
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.Header.Get("Authorization") != "Bearer "+token {
+					mu.Lock()
+					missing = append(missing, r.URL.Path)
+					mu.Unlock()
+				}
+				if tt.failStatus != 0 && strings.HasPrefix(r.URL.Path, "/files/") && strings.HasSuffix(r.URL.Path, ".zip") {
+					w.WriteHeader(tt.failStatus)
+					return true
+				}
+				return false
+			}
+
+			var buf bytes.Buffer
+			logger := common.NewLogger()
+			logger.SetOutput(io.Discard)
+			logger.TeeTo(&buf)
+			logger.SetLevel(common.LogLevelDebug)
+			s, err := NewService(&common.DownloaderConfig{
+				DownloadPath:    t.TempDir(),
+				ProviderFilter:  "hashicorp/null",
+				PlatformFilter:  "linux_amd64",
+				MaxConcurrent:   1,
+				MaxAttempts:     1,
+				DownloadTimeout: 10 * time.Second,
+			}, &common.RegistryConfig{BaseURL: reg.URL, Token: token}, logger)
+			if err != nil {
+				t.Fatalf("NewService: %v", err)
+			}
+			defer s.Close()
+
+			err = s.RunOnce(context.Background())
+			if got := err != nil; got != (tt.failStatus != 0) {
+				t.Errorf("RunOnce = %v, want failed %t", err, tt.failStatus != 0)
+			}
+			if len(missing) > 0 {
+				t.Errorf("registry requests without the token: %v", missing)
+			}
+			if buf.Len() == 0 {
+				t.Fatal("nothing logged")
+			}
+			if strings.Contains(buf.String(), token) {
+				t.Errorf("token logged:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+// openFDs returns the number of open descriptors of the test process
+func openFDs(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open descriptors: %v", err)
+	}
+	return len(entries)
+}
+
+func TestDownloadVersionJSONClosesDescriptors(t *testing.T) {
+	const versions = 200
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantFile bool
+	}{
+		{name: "saved", status: http.StatusOK, body: `{"archives":{}}`, wantFile: true},
+		{name: "not published", status: http.StatusNotFound},
+		{name: "invalid json", status: http.StatusOK, body: `{"archives":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, nil)
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if !strings.HasSuffix(r.URL.Path, ".json") {
+					return false
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+				return true
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{})
+			ctx := context.Background()
+
+			dest := func(i int) string {
+				return s.registry.GetProviderVersionJSONPath(s.config.DownloadPath, "hashicorp", "null", fmt.Sprintf("1.0.%d", i))
+			}
+			// Warm up the connection pool so idle connections are not counted
+			s.downloadVersionJSON(ctx, "hashicorp", "null", "0.0.0", dest(versions))
+			before := openFDs(t)
+
+			for i := range versions {
+				s.downloadVersionJSON(ctx, "hashicorp", "null", fmt.Sprintf("1.0.%d", i), dest(i))
+			}
+
+			if after := openFDs(t); after > before+5 {
+				t.Errorf("open descriptors grew from %d to %d over %d versions", before, after, versions)
+			}
+			if _, err := os.Stat(dest(0)); (err == nil) != tt.wantFile {
+				t.Errorf("version json saved = %v, want %v", err == nil, tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestDownloadVersionJSONRetries(t *testing.T) {
+	const versionJSON = `{"archives":{"linux_amd64":{"url":"terraform-provider-null_3.2.1_linux_amd64.zip"}}}`
+
+	tests := []struct {
+		name         string
+		attempts     int
+		failure      func(w http.ResponseWriter) // answers the first request
+		wantRequests int                         // 0 if the client may retry on its own
+		wantSaved    bool
+	}{
+		{
+			name:     "truncated response then success",
+			attempts: 2,
+			failure: func(w http.ResponseWriter) {
+				io.WriteString(w, versionJSON[:20])
+			},
+			wantRequests: 2,
+			wantSaved:    true,
+		},
+		{
+			name:     "dropped connection then success",
+			attempts: 2,
+			failure: func(w http.ResponseWriter) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+			},
+			wantSaved: true,
+		},
+		{
+			name:     "single attempt keeps the failure",
+			attempts: 1,
+			failure: func(w http.ResponseWriter) {
+				io.WriteString(w, versionJSON[:20])
+			},
+			wantRequests: 1,
+		},
+		{
+			name:     "not published is not retried",
+			attempts: 3,
+			failure: func(w http.ResponseWriter) {
+				http.NotFound(w, nil)
+			},
+			wantRequests: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, nil)
+			var mu sync.Mutex
+			requests := 0
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if !strings.HasSuffix(r.URL.Path, "/3.2.1.json") {
+					return false
+				}
+				mu.Lock()
+				requests++
+				first := requests == 1
+				mu.Unlock()
+				if first {
+					tt.failure(w)
+				} else {
+					io.WriteString(w, versionJSON)
+				}
+				return true
+			}
+			s := newFakeService(t, reg, &common.DownloaderConfig{MaxAttempts: tt.attempts, RetryBaseDelay: time.Millisecond})
+			dest := s.registry.GetProviderVersionJSONPath(s.config.DownloadPath, "hashicorp", "null", "3.2.1")
+
+			s.downloadVersionJSON(context.Background(), "hashicorp", "null", "3.2.1", dest)
+
+			mu.Lock()
+			got := requests
+			mu.Unlock()
+			if tt.wantRequests > 0 && got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			data, err := os.ReadFile(dest)
+			if tt.wantSaved {
+				if err != nil {
+					t.Fatalf("version json not saved: %v", err)
+				}
+				if string(data) != versionJSON {
+					t.Errorf("version json = %q, want %q", data, versionJSON)
+				}
+			} else if err == nil {
+				t.Errorf("version json saved after a failure: %q", data)
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(dest), "*.tmp")); len(leftovers) > 0 {
+				t.Errorf("temporary files left behind: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestProviderTimeoutSkipsSlowProvider(t *testing.T) {
+	const archiveDelay = 150 * time.Millisecond
+
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		wantTimeout bool
+	}{
+		{name: "no provider timeout"},
+		{name: "timeout longer than the provider needs", timeout: 10 * time.Second},
+		{name: "slow provider trips the timeout", timeout: 250 * time.Millisecond, wantTimeout: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{
+				"hashicorp/fast": {"1.0.0", "1.1.0"},
+				"hashicorp/slow": {"1.0.0", "1.1.0"},
+			})
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if strings.HasPrefix(r.URL.Path, "/files/terraform-provider-slow_") && strings.HasSuffix(r.URL.Path, ".zip") {
+					select {
+					case <-time.After(archiveDelay):
+					case <-r.Context().Done():
+					}
+				}
+				return false
+			}
+			summaryPath := filepath.Join(t.TempDir(), "summary.json")
+			s := newFakeService(t, reg, &common.DownloaderConfig{
+				MaxConcurrent:   1,
+				PlatformFilter:  "linux_amd64,darwin_arm64",
+				ProviderTimeout: tt.timeout,
+				SummaryJSON:     summaryPath,
+			})
+
+			err := s.RunOnce(context.Background())
+			if errors.Is(err, ErrDownloadsFailed) {
+				t.Errorf("RunOnce = %v, timed out downloads are not failures", err)
+			}
+
+			count := func(name string) int {
+				archives, _ := filepath.Glob(filepath.Join(s.providerRoot(), "hashicorp", name, "*.zip"))
+				return len(archives)
+			}
+			if got := count("fast"); got != 4 {
+				t.Errorf("fast provider: %d of 4 archives downloaded", got)
+			}
+			data, err := os.ReadFile(summaryPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var summary RunSummary
+			if err := json.Unmarshal(data, &summary); err != nil {
+				t.Fatal(err)
+			}
+			slow := count("slow")
+			if !tt.wantTimeout {
+				if slow != 4 || summary.TimedOut != 0 {
+					t.Errorf("slow provider: %d archives, %d timed out; want 4 and 0", slow, summary.TimedOut)
+				}
+				return
+			}
+			if slow == 4 || summary.TimedOut != 4-slow {
+				t.Errorf("slow provider: %d archives, %d timed out; want the rest of 4 timed out", slow, summary.TimedOut)
+			}
+		})
+	}
+}
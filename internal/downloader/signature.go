@@ -0,0 +1,48 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"tf-mirror/internal/common"
+)
+
+// verifySHASumsSignature checks the detached GPG signature over a SHA256SUMS
+// file against the signing keys published with the package. It returns the
+// key ID that produced a valid signature.
+func verifySHASumsSignature(shasumsPath, signaturePath string, keys []common.GPGPublicKey) (string, error) {
+	if len(keys) == 0 {
+		return "", fmt.Errorf("registry returned no signing keys")
+	}
+
+	var keyring openpgp.EntityList
+	keyIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		keyIDs = append(keyIDs, key.KeyID)
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key.ASCIIArmor))
+		if err != nil {
+			return "", fmt.Errorf("failed to parse signing key %s: %w", key.KeyID, err)
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	signed, err := os.ReadFile(shasumsPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", shasumsPath, err)
+	}
+	signature, err := os.ReadFile(signaturePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", signaturePath, err)
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(signed), bytes.NewReader(signature), nil)
+	if err != nil {
+		return "", fmt.Errorf("signature verification failed (tried key IDs: %s): %w", strings.Join(keyIDs, ", "), err)
+	}
+
+	return signer.PrimaryKey.KeyIdString(), nil
+}
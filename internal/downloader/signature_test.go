@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"tf-mirror/internal/common"
+)
+
+// newSigningKey generates a throwaway signing key and returns it with its
+// armored public part as published in signing_keys
+func newSigningKey(t *testing.T, name string) (*openpgp.Entity, common.GPGPublicKey) {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := entity.Serialize(w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	return entity, common.GPGPublicKey{KeyID: entity.PrimaryKey.KeyIdString(), ASCIIArmor: buf.String()}
+}
+
+func TestVerifySHASumsSignature(t *testing.T) {
+	signer, signerKey := newSigningKey(t, "signer")
+	_, otherKey := newSigningKey(t, "other")
+
+	dir := t.TempDir()
+	shasums := "0123456789abcdef  terraform-provider-null_3.2.0_linux_amd64.zip\n"
+	shasumsPath := filepath.Join(dir, "SHA256SUMS")
+	writeTestFile(t, shasumsPath, shasums)
+
+	var signature bytes.Buffer
+	if err := openpgp.DetachSign(&signature, signer, strings.NewReader(shasums), nil); err != nil {
+		t.Fatalf("DetachSign: %v", err)
+	}
+	signaturePath := filepath.Join(dir, "SHA256SUMS.sig")
+	if err := os.WriteFile(signaturePath, signature.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tamperedPath := filepath.Join(dir, "SHA256SUMS.tampered")
+	writeTestFile(t, tamperedPath, strings.Replace(shasums, "0123", "3210", 1))
+
+	tests := []struct {
+		name    string
+		shasums string
+		keys    []common.GPGPublicKey
+		wantErr string
+	}{
+		{name: "signed by the published key", shasums: shasumsPath, keys: []common.GPGPublicKey{signerKey}},
+		{name: "signer among several keys", shasums: shasumsPath, keys: []common.GPGPublicKey{otherKey, signerKey}},
+		{name: "signed by an unknown key", shasums: shasumsPath, keys: []common.GPGPublicKey{otherKey}, wantErr: otherKey.KeyID},
+		{name: "tampered SHA256SUMS", shasums: tamperedPath, keys: []common.GPGPublicKey{signerKey}, wantErr: "signature verification failed"},
+		{name: "no keys", shasums: shasumsPath, wantErr: "no signing keys"},
+		{name: "unparsable key", shasums: shasumsPath, keys: []common.GPGPublicKey{{KeyID: "BROKEN", ASCIIArmor: "not a key"}}, wantErr: "BROKEN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyID, err := verifySHASumsSignature(tt.shasums, signaturePath, tt.keys)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("verifySHASumsSignature error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifySHASumsSignature: %v", err)
+			}
+			if keyID != signerKey.KeyID {
+				t.Errorf("verified with key %s, want %s", keyID, signerKey.KeyID)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"tf-mirror/internal/downloader/indexgen"
+	"tf-mirror/internal/verify"
+)
+
+// stagingRoot returns <staging-path>/<registry-host>
+func (s *Service) stagingRoot() string {
+	return filepath.Join(s.config.StagingPath, s.registry.Hostname())
+}
+
+// writePath returns the base path provider files are written to: the staging
+// path with --staging-path, the download path otherwise
+func (s *Service) writePath() string {
+	if s.config.StagingPath != "" {
+		return s.config.StagingPath
+	}
+	return s.config.DownloadPath
+}
+
+// stageProvider prepares the staging directory of a provider as a working copy
+// of the published one: archives are hardlinked, or copied when the staging
+// path is on another filesystem. JSON metadata is always copied, since it is
+// updated while staged. A staging directory left by an earlier session
+// (interrupted, or with failed downloads) is reused as is.
+func (s *Service) stageProvider(namespace, name string) error {
+	staged := filepath.Join(s.stagingRoot(), namespace, name)
+	if fileExists(staged) {
+		return nil
+	}
+
+	// Seed into a temporary directory, so a crash never leaves an incomplete
+	// working copy that would later replace the published provider
+	seeding := filepath.Join(filepath.Dir(staged), "."+name+".seeding")
+	os.RemoveAll(seeding)
+	if err := createDirAll(seeding, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", seeding, err)
+	}
+
+	published := filepath.Join(s.providerRoot(), namespace, name)
+	entries, err := readDir(published)
+	if err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(seeding)
+		return fmt.Errorf("failed to read %s: %w", published, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		src := filepath.Join(published, entry.Name())
+		dst := filepath.Join(seeding, entry.Name())
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			if err := os.Link(src, dst); err == nil {
+				continue
+			}
+		}
+		if err := copyFile(src, dst); err != nil {
+			os.RemoveAll(seeding)
+			return err
+		}
+	}
+
+	if err := renameFile(seeding, staged); err != nil {
+		os.RemoveAll(seeding)
+		return fmt.Errorf("failed to create %s: %w", staged, err)
+	}
+	s.logger.Debug("Staged %s/%s in %s", namespace, name, staged)
+	return nil
+}
+
+// publishStaged regenerates the index of every staged provider, re-checks the
+// archives added in staging and moves each provider into the download path.
+// Providers in failed, or with an archive that does not verify, stay staged
+// for the next session.
+func (s *Service) publishStaged(failed map[string]struct{}) {
+	root := s.stagingRoot()
+	namespaces, err := readDir(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.logger.Error("Failed to read staging directory %s: %v", root, err)
+		}
+		return
+	}
+
+	var staged []string
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() || strings.HasPrefix(namespace.Name(), ".") {
+			continue
+		}
+		names, err := readDir(filepath.Join(root, namespace.Name()))
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if name.IsDir() && !strings.HasPrefix(name.Name(), ".") {
+				staged = append(staged, namespace.Name()+"/"+name.Name())
+			}
+		}
+	}
+	if len(staged) == 0 {
+		return
+	}
+
+	broken := make(map[string]string)
+	for _, providerKey := range staged {
+		if err := indexgen.GenerateIndexJSON(filepath.Join(root, providerKey)); err != nil {
+			broken[providerKey] = fmt.Sprintf("failed to generate index.json: %v", err)
+		}
+	}
+
+	report, err := verify.Run(s.config.StagingPath, s.registry.Hostname(), verify.Options{Filter: s.stagedArchiveIsNew})
+	if err != nil {
+		s.logger.Error("Failed to verify staged providers, keeping them staged: %v", err)
+		return
+	}
+	for _, failure := range report.Failures {
+		// <registry-host>/<namespace>/<name>/<archive>
+		parts := strings.Split(filepath.ToSlash(failure.Path), "/")
+		if len(parts) == 4 {
+			broken[parts[1]+"/"+parts[2]] = fmt.Sprintf("%s: %s", parts[3], failure.Reason)
+		}
+	}
+
+	published := 0
+	for _, providerKey := range staged {
+		if _, ok := failed[providerKey]; ok {
+			s.logger.Warn("Keeping %s staged: downloads failed in this session", providerKey)
+			continue
+		}
+		if reason, ok := broken[providerKey]; ok {
+			s.logger.Error("Keeping %s staged: %s", providerKey, reason)
+			continue
+		}
+		namespace, name, _ := strings.Cut(providerKey, "/")
+		if err := s.promoteProvider(namespace, name); err != nil {
+			s.logger.Error("Failed to publish %s: %v", providerKey, err)
+			continue
+		}
+		published++
+	}
+	s.logger.Info("Published %d of %d staged providers (%d archives verified)", published, len(staged), report.Checked)
+}
+
+// stagedArchiveIsNew reports whether a staged archive was added in staging
+// rather than seeded from the published copy, which was verified before
+func (s *Service) stagedArchiveIsNew(path string) bool {
+	rel, err := filepath.Rel(s.stagingRoot(), path)
+	if err != nil {
+		return true
+	}
+	stagedInfo, err := os.Stat(path)
+	if err != nil {
+		return true
+	}
+	publishedInfo, err := os.Stat(filepath.Join(s.providerRoot(), rel))
+	if err != nil {
+		return true
+	}
+	if os.SameFile(stagedInfo, publishedInfo) {
+		return false
+	}
+	// A copy seeded across filesystems keeps size and mtime
+	return stagedInfo.Size() != publishedInfo.Size() || !stagedInfo.ModTime().Equal(publishedInfo.ModTime())
+}
+
+// promoteProvider replaces the published provider directory with the staged
+// one. Both end up next to each other first (copied there if the staging path
+// is on another filesystem), so the switch itself is two renames and readers
+// never see a directory with some of the new files missing.
+func (s *Service) promoteProvider(namespace, name string) error {
+	staged := filepath.Join(s.stagingRoot(), namespace, name)
+	target := filepath.Join(s.providerRoot(), namespace, name)
+	parent := filepath.Dir(target)
+	if err := createDirIfNotExists(parent); err != nil {
+		return fmt.Errorf("failed to create %s: %w", parent, err)
+	}
+
+	incoming := filepath.Join(parent, "."+name+".incoming")
+	old := filepath.Join(parent, "."+name+".old")
+	os.RemoveAll(incoming)
+	os.RemoveAll(old)
+
+	moved := true
+	if err := renameFile(staged, incoming); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("failed to move %s: %w", staged, err)
+		}
+		if err := copyDir(staged, incoming); err != nil {
+			os.RemoveAll(incoming)
+			return err
+		}
+		moved = false
+	}
+	restore := func() {
+		if moved {
+			renameFile(incoming, staged)
+		} else {
+			os.RemoveAll(incoming)
+		}
+	}
+
+	hadTarget := fileExists(target)
+	if hadTarget {
+		if err := renameFile(target, old); err != nil {
+			restore()
+			return fmt.Errorf("failed to move %s aside: %w", target, err)
+		}
+	}
+	if err := renameFile(incoming, target); err != nil {
+		if hadTarget {
+			renameFile(old, target)
+		}
+		restore()
+		return fmt.Errorf("failed to publish %s: %w", target, err)
+	}
+
+	os.RemoveAll(old)
+	if !moved {
+		os.RemoveAll(staged)
+	}
+	s.logger.Info("Published %s/%s", namespace, name)
+	return nil
+}
+
+// copyDir copies the files of a provider directory, which has no
+// subdirectories, into dst
+func copyDir(src, dst string) error {
+	entries, err := readDir(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	if err := createDirAll(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := copyFile(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, keeping its mode and mtime
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(dst, info.ModTime(), info.ModTime())
+	}
+	if err != nil {
+		removeFile(dst)
+		return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
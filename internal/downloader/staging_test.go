@@ -0,0 +1,246 @@
+package downloader
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader/indexgen"
+)
+
+// writeTestZip creates a provider archive at path holding one small file
+func writeTestZip(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, err := w.Create("terraform-provider")
+	if err == nil {
+		_, err = entry.Write([]byte(filepath.Base(path)))
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStageProviderKeepsPublishedMetadata(t *testing.T) {
+	s := newTestService(t, &common.DownloaderConfig{StagingPath: t.TempDir()})
+	published := filepath.Join(s.providerRoot(), "hashicorp", "null")
+	archive := "terraform-provider-null_3.2.0_linux_amd64.zip"
+	writeTestZip(t, filepath.Join(published, archive))
+	writeTestFile(t, filepath.Join(published, "index.json"), `{"versions":{"3.1.0":{}}}`+"\n")
+	writeTestFile(t, filepath.Join(published, "3.2.0.json"), `{"archives":{}}`+"\n")
+
+	if err := s.stageProvider("hashicorp", "null"); err != nil {
+		t.Fatalf("stageProvider: %v", err)
+	}
+	staged := filepath.Join(s.stagingRoot(), "hashicorp", "null")
+
+	// Updating the staged copy is what a session does before publishing
+	if err := indexgen.SetProtocols(staged, "3.2.0", []string{"5.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := indexgen.GenerateIndexJSON(staged); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"index.json", `{"versions":{"3.1.0":{}}}` + "\n"},
+		{"3.2.0.json", `{"archives":{}}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(published, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("published %s changed before publishing: %q", tt.file, data)
+			}
+		})
+	}
+
+	stagedIndex, err := os.ReadFile(filepath.Join(staged, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(stagedIndex) == tests[0].want {
+		t.Errorf("staged index.json was not regenerated")
+	}
+}
+
+func TestStageProviderLinksArchives(t *testing.T) {
+	s := newTestService(t, &common.DownloaderConfig{StagingPath: t.TempDir()})
+	published := filepath.Join(s.providerRoot(), "hashicorp", "null")
+	tests := []struct {
+		file   string
+		linked bool
+	}{
+		{"terraform-provider-null_3.2.0_linux_amd64.zip", true},
+		{"terraform-provider-null_3.2.0_SHA256SUMS", true},
+		{"index.json", false},
+		{"3.2.0.json", false},
+	}
+	for _, tt := range tests {
+		writeTestFile(t, filepath.Join(published, tt.file), tt.file)
+	}
+	if err := s.stageProvider("hashicorp", "null"); err != nil {
+		t.Fatalf("stageProvider: %v", err)
+	}
+	staged := filepath.Join(s.stagingRoot(), "hashicorp", "null")
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			src, err := os.Stat(filepath.Join(published, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dst, err := os.Stat(filepath.Join(staged, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := os.SameFile(src, dst); got != tt.linked {
+				t.Errorf("SameFile = %v, want %v", got, tt.linked)
+			}
+		})
+	}
+}
+
+func TestStagedSessionNeverPublishesPartialVersion(t *testing.T) {
+	const stalled = "/files/terraform-provider-null_3.2.1_darwin_arm64.zip"
+
+	tests := []struct {
+		name string
+		// answer replies to the stalled archive once the test has looked at
+		// the published tree
+		answer        func(w http.ResponseWriter, r *http.Request)
+		wantPublished bool
+	}{
+		{
+			name:          "download completes",
+			answer:        func(w http.ResponseWriter, r *http.Request) { w.Write(fakeArchive(path.Base(r.URL.Path))) },
+			wantPublished: true,
+		},
+		{
+			name:   "download fails",
+			answer: func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			s := newFakeService(t, reg, &common.DownloaderConfig{StagingPath: t.TempDir()})
+			if err := s.RunOnce(context.Background()); err != nil {
+				t.Fatalf("first session: %v", err)
+			}
+
+			reg.versions["hashicorp/null"] = []string{"3.2.0", "3.2.1"}
+			reached := make(chan struct{})
+			release := make(chan struct{})
+			reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+				if r.URL.Path != stalled {
+					return false
+				}
+				close(reached)
+				<-release
+				tt.answer(w, r)
+				return true
+			}
+			done := make(chan error, 1)
+			go func() { done <- s.RunOnce(context.Background()) }()
+
+			published := filepath.Join(s.providerRoot(), "hashicorp", "null")
+			select {
+			case <-reached:
+			case err := <-done:
+				t.Fatalf("second session finished without requesting %s: %v", stalled, err)
+			}
+			// Other files of 3.2.1 may already be staged, none may be visible
+			assertPublishedVersions(t, published, []string{"3.2.0"})
+			close(release)
+			err := <-done
+
+			if tt.wantPublished {
+				if err != nil {
+					t.Fatalf("second session: %v", err)
+				}
+				assertPublishedVersions(t, published, []string{"3.2.0", "3.2.1"})
+			} else {
+				if !errors.Is(err, ErrDownloadsFailed) {
+					t.Fatalf("second session = %v, want ErrDownloadsFailed", err)
+				}
+				assertPublishedVersions(t, published, []string{"3.2.0"})
+			}
+		})
+	}
+}
+
+// assertPublishedVersions checks that the provider directory dir lists exactly
+// versions in index.json, each with its <version>.json, checksums and every
+// archive, and holds no file of any other version
+func assertPublishedVersions(t *testing.T, dir string, versions []string) {
+	t.Helper()
+	var index struct {
+		Versions map[string]any `json:"versions"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatalf("published index.json: %v", err)
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("published index.json: %v", err)
+	}
+	if got := slices.Sorted(maps.Keys(index.Versions)); !slices.Equal(got, versions) {
+		t.Errorf("published index.json lists %v, want %v", got, versions)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]bool)
+	for _, entry := range entries {
+		files[entry.Name()] = true
+	}
+	for _, version := range versions {
+		for _, file := range []string{
+			version + ".json",
+			"terraform-provider-null_" + version + "_SHA256SUMS",
+			"terraform-provider-null_" + version + "_SHA256SUMS.sig",
+			"terraform-provider-null_" + version + "_linux_amd64.zip",
+			"terraform-provider-null_" + version + "_darwin_arm64.zip",
+		} {
+			if !files[file] {
+				t.Errorf("published %s is missing", file)
+			}
+			delete(files, file)
+		}
+	}
+	delete(files, "index.json")
+	for file := range files {
+		t.Errorf("published directory holds unexpected %s", file)
+	}
+}
@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"sort"
+	"time"
+)
+
+// RunSummary is the --summary-json report of one download session
+type RunSummary struct {
+	StartedAt       time.Time          `json:"started_at"`
+	FinishedAt      time.Time          `json:"finished_at"`
+	DurationSeconds float64            `json:"duration_seconds"`
+	Registry        string             `json:"registry"`
+	Downloaded      int                `json:"downloaded"`
+	Skipped         int                `json:"skipped"`
+	NotAvailable    int                `json:"not_available"`
+	Failed          int                `json:"failed"`
+	TimedOut        int                `json:"timed_out"` // skipped once their provider hit --provider-timeout
+	PreFiltered     int                `json:"pre_filtered"`
+	TotalBytes      int64              `json:"total_bytes"`
+	EstimatedBytes  int64              `json:"estimated_bytes"` // estimated size of the queued downloads
+	Providers       []*ProviderSummary `json:"providers"`
+	BinariesError   string             `json:"binaries_error,omitempty"`
+	ModulesError    string             `json:"modules_error,omitempty"`
+	Error           string             `json:"error,omitempty"` // why the run counts as failed, empty on success
+}
+
+// ProviderSummary is the per-provider part of a RunSummary
+type ProviderSummary struct {
+	Namespace    string       `json:"namespace"`
+	Name         string       `json:"name"`
+	Downloaded   int          `json:"downloaded"`
+	Skipped      int          `json:"skipped"`
+	NotAvailable int          `json:"not_available"`
+	Failed       int          `json:"failed"`
+	TimedOut     int          `json:"timed_out"`
+	Failures     []JobFailure `json:"failures,omitempty"`
+}
+
+// JobFailure describes one download that still failed at the end of the session
+type JobFailure struct {
+	Version  string `json:"version"`
+	Platform string `json:"platform"`
+	Error    string `json:"error"`
+}
+
+// summaryBuilder collects per-provider results while a session runs
+type summaryBuilder struct {
+	summary   RunSummary
+	providers map[string]*ProviderSummary
+}
+
+func newSummaryBuilder(registry string, startedAt time.Time) *summaryBuilder {
+	return &summaryBuilder{
+		summary:   RunSummary{StartedAt: startedAt.UTC(), Registry: registry},
+		providers: make(map[string]*ProviderSummary),
+	}
+}
+
+// provider returns the breakdown entry for the provider of job
+func (b *summaryBuilder) provider(job DownloadJob) *ProviderSummary {
+	key := job.providerKey()
+	p, ok := b.providers[key]
+	if !ok {
+		p = &ProviderSummary{Namespace: job.Namespace, Name: job.Name}
+		b.providers[key] = p
+	}
+	return p
+}
+
+// timedOut returns the jobs skipped so far by --provider-timeout
+func (b *summaryBuilder) timedOut() int {
+	total := 0
+	for _, p := range b.providers {
+		total += p.TimedOut
+	}
+	return total
+}
+
+// finish fills in the final failures and totals. failedJobs maps each job
+// that still failed to its last error.
+func (b *summaryBuilder) finish(failedJobs map[DownloadJob]string) *RunSummary {
+	for job, errMsg := range failedJobs {
+		p := b.provider(job)
+		p.Failed++
+		p.Failures = append(p.Failures, JobFailure{
+			Version:  job.Version,
+			Platform: job.OS + "_" + job.Arch,
+			Error:    errMsg,
+		})
+	}
+
+	s := &b.summary
+	s.Providers = make([]*ProviderSummary, 0, len(b.providers))
+	for _, p := range b.providers {
+		sort.Slice(p.Failures, func(i, j int) bool {
+			if p.Failures[i].Version != p.Failures[j].Version {
+				return p.Failures[i].Version < p.Failures[j].Version
+			}
+			return p.Failures[i].Platform < p.Failures[j].Platform
+		})
+		s.Downloaded += p.Downloaded
+		s.Skipped += p.Skipped
+		s.NotAvailable += p.NotAvailable
+		s.Failed += p.Failed
+		s.TimedOut += p.TimedOut
+		s.Providers = append(s.Providers, p)
+	}
+	sort.Slice(s.Providers, func(i, j int) bool {
+		if s.Providers[i].Namespace != s.Providers[j].Namespace {
+			return s.Providers[i].Namespace < s.Providers[j].Namespace
+		}
+		return s.Providers[i].Name < s.Providers[j].Name
+	})
+	return s
+}
+
+// writeSummary writes the run summary to --summary-json, if set
+func (s *Service) writeSummary(summary *RunSummary, runErr error) {
+	if s.config.SummaryJSON == "" || summary == nil {
+		return
+	}
+	summary.FinishedAt = time.Now().UTC()
+	summary.DurationSeconds = summary.FinishedAt.Sub(summary.StartedAt).Seconds()
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	if err := writeJSONFile(s.config.SummaryJSON, summary); err != nil {
+		s.logger.Error("Failed to write run summary: %v", err)
+		return
+	}
+	s.logger.Info("Run summary written to %s", s.config.SummaryJSON)
+}
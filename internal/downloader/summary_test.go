@@ -0,0 +1,118 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestSummaryJSONSchema(t *testing.T) {
+	reg := newFakeRegistry(t, map[string][]string{
+		"hashicorp/null":   {"3.2.0"},
+		"hashicorp/random": {"3.6.0"},
+	})
+	reg.intercept = func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/files/terraform-provider-random_3.6.0_linux_amd64.zip" {
+			return false
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	summaryPath := filepath.Join(t.TempDir(), "summary.json")
+	s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: "hashicorp/*", PlatformFilter: "linux_amd64,darwin_arm64", SummaryJSON: summaryPath})
+	if err := s.RunOnce(context.Background()); err == nil {
+		t.Fatal("RunOnce succeeded with a failed download")
+	}
+
+	data, err := os.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatalf("read summary: %v", err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("summary is not JSON: %v", err)
+	}
+
+	// kind is the JSON type of each field: "string", "number" or "array"
+	tests := []struct {
+		field string
+		kind  string
+		want  any // checked when set
+	}{
+		{field: "started_at", kind: "string"},
+		{field: "finished_at", kind: "string"},
+		{field: "duration_seconds", kind: "number"},
+		{field: "registry", kind: "string", want: "127.0.0.1"},
+		{field: "downloaded", kind: "number", want: 3.0},
+		{field: "skipped", kind: "number", want: 0.0},
+		{field: "not_available", kind: "number", want: 0.0},
+		{field: "failed", kind: "number", want: 1.0},
+		{field: "timed_out", kind: "number", want: 0.0},
+		{field: "pre_filtered", kind: "number", want: 0.0},
+		{field: "total_bytes", kind: "number"},
+		{field: "estimated_bytes", kind: "number"},
+		{field: "providers", kind: "array"},
+		{field: "error", kind: "string"},
+	}
+	for _, tt := range tests {
+		value, ok := doc[tt.field]
+		if !ok {
+			t.Errorf("field %q missing", tt.field)
+			continue
+		}
+		if got := jsonKind(value); got != tt.kind {
+			t.Errorf("field %q is a %s, want a %s", tt.field, got, tt.kind)
+		}
+		if tt.want != nil && value != tt.want {
+			t.Errorf("field %q = %v, want %v", tt.field, value, tt.want)
+		}
+	}
+
+	// Strict decoding catches renamed or unexpected fields
+	var summary RunSummary
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&summary); err != nil {
+		t.Fatalf("decode RunSummary: %v", err)
+	}
+	if summary.TotalBytes <= 0 {
+		t.Errorf("total_bytes = %d, want the size of the downloads", summary.TotalBytes)
+	}
+	if len(summary.Providers) != 2 {
+		t.Fatalf("providers = %d, want 2", len(summary.Providers))
+	}
+	null, random := summary.Providers[0], summary.Providers[1]
+	if null.Name != "null" || null.Downloaded != 2 || null.Failed != 0 || len(null.Failures) != 0 {
+		t.Errorf("hashicorp/null = %+v, want 2 downloaded", *null)
+	}
+	if random.Name != "random" || random.Downloaded != 1 || random.Failed != 1 || len(random.Failures) != 1 {
+		t.Fatalf("hashicorp/random = %+v, want 1 downloaded and 1 failed", *random)
+	}
+	if f := random.Failures[0]; f.Version != "3.6.0" || f.Platform != "linux_amd64" || f.Error == "" {
+		t.Errorf("failure = %+v, want 3.6.0 linux_amd64 with an error", f)
+	}
+}
+
+// jsonKind names the JSON type of a decoded value
+func jsonKind(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case bool:
+		return "bool"
+	default:
+		return "null"
+	}
+}
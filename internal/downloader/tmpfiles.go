@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CleanTmpFiles removes *.tmp files under the download path (and the staging
+// path, if set) that were last modified more than CleanTmpAge ago: leftovers
+// of downloads and metadata writes interrupted by a crash or kill. Younger
+// files are kept, as they may belong to another tf-mirror writing to the same
+// path. It returns the number of files removed.
+func (s *Service) CleanTmpFiles() (int, error) {
+	roots := []string{s.config.DownloadPath}
+	if s.config.StagingPath != "" {
+		roots = append(roots, s.config.StagingPath)
+	}
+
+	removed, kept := 0, 0
+	for _, root := range roots {
+		r, k, err := s.cleanTmpFiles(root, time.Now().Add(-s.config.CleanTmpAge))
+		removed += r
+		kept += k
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	if kept > 0 {
+		s.logger.Info("Removed %d stale .tmp files, kept %d modified within the last %v", removed, kept, s.config.CleanTmpAge)
+	} else {
+		s.logger.Info("Removed %d stale .tmp files", removed)
+	}
+	return removed, nil
+}
+
+// cleanTmpFiles removes the *.tmp files under root modified before cutoff
+func (s *Service) cleanTmpFiles(root string, cutoff time.Time) (removed, kept int, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			s.logger.Warn("Skipping %s while cleaning .tmp files: %v", path, err)
+			if d != nil && d.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".tmp") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // removed meanwhile
+		}
+		if info.ModTime().After(cutoff) {
+			kept++
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("Failed to remove stale %s: %v", path, err)
+			return nil
+		}
+		s.logger.Debug("Removed stale %s", path)
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, kept, fmt.Errorf("failed to scan %s for .tmp files: %w", root, err)
+	}
+	return removed, kept, nil
+}
@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestCleanTmpFiles(t *testing.T) {
+	type file struct {
+		path        string // relative to the download path, or the staging path with staged
+		age         time.Duration
+		staged      bool
+		wantRemoved bool
+	}
+
+	tests := []struct {
+		name        string
+		maxAge      time.Duration
+		files       []file
+		wantRemoved int
+	}{
+		{
+			name:   "stale temp files are removed, recent ones kept",
+			maxAge: time.Hour,
+			files: []file{
+				{path: "registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.1_linux_amd64.zip.tmp", age: 2 * time.Hour, wantRemoved: true},
+				{path: "registry.terraform.io/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip.tmp", age: time.Minute},
+				{path: ".tf-mirror-metadata.json.123.tmp", age: 24 * time.Hour, wantRemoved: true},
+			},
+			wantRemoved: 2,
+		},
+		{
+			name:   "other files are never touched",
+			maxAge: time.Hour,
+			files: []file{
+				{path: "registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.1_linux_amd64.zip", age: 48 * time.Hour},
+				{path: "registry.terraform.io/hashicorp/null/index.json", age: 48 * time.Hour},
+				{path: "registry.terraform.io/hashicorp/null/tmp", age: 48 * time.Hour},
+			},
+		},
+		{
+			name:   "zero age removes every temp file",
+			maxAge: 0,
+			files: []file{
+				{path: "a.tmp", age: time.Second, wantRemoved: true},
+				{path: "deep/nested/dir/b.tmp", age: time.Second, wantRemoved: true},
+			},
+			wantRemoved: 2,
+		},
+		{
+			name:   "staging path is cleaned too",
+			maxAge: time.Hour,
+			files: []file{
+				{path: "registry.terraform.io/hashicorp/null/3.2.1.json.tmp", age: 2 * time.Hour, staged: true, wantRemoved: true},
+				{path: "registry.terraform.io/hashicorp/null/index.json.tmp", age: time.Minute, staged: true},
+			},
+			wantRemoved: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, &common.DownloaderConfig{CleanTmpAge: tt.maxAge, StagingPath: t.TempDir()})
+			fullPath := func(f file) string {
+				if f.staged {
+					return filepath.Join(s.config.StagingPath, f.path)
+				}
+				return filepath.Join(s.config.DownloadPath, f.path)
+			}
+			now := time.Now()
+			for _, f := range tt.files {
+				path := fullPath(f)
+				writeTestFile(t, path, "partial")
+				if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			removed, err := s.CleanTmpFiles()
+			if err != nil {
+				t.Fatalf("CleanTmpFiles: %v", err)
+			}
+			if removed != tt.wantRemoved {
+				t.Errorf("removed %d files, want %d", removed, tt.wantRemoved)
+			}
+			for _, f := range tt.files {
+				_, err := os.Stat(fullPath(f))
+				if gone := os.IsNotExist(err); gone != f.wantRemoved {
+					t.Errorf("%s removed = %v, want %v", f.path, gone, f.wantRemoved)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"tf-mirror/internal/downloader/indexgen"
+	"tf-mirror/internal/verify"
+)
+
+// VerifyMirror re-checks every archive on disk against its SHA256SUMS and
+// h1: hash without any network I/O. Corrupt archives are marked corrupt in the
+// metadata so the next download run fetches them again; with deleteCorrupt
+// they are also removed and dropped from index.json and <version>.json.
+func (s *Service) VerifyMirror(deleteCorrupt bool) (*verify.Report, error) {
+	report, err := verify.Run(s.config.DownloadPath, s.registry.Hostname(), verify.Options{Delete: deleteCorrupt})
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify mirror: %w", err)
+	}
+
+	reindex := make(map[string]struct{})
+	for _, failure := range report.Failures {
+		// <registry-host>/<namespace>/<name>/<archive>
+		parts := strings.Split(filepath.ToSlash(failure.Path), "/")
+		if len(parts) != 4 {
+			continue
+		}
+		version, osName, archName, _, ok := parseArchiveStatus(parts[2], parts[3])
+		if !ok {
+			continue
+		}
+		s.logger.Warn("Corrupt archive %s: %s", failure.Path, failure.Reason)
+		s.setFileStatus(DownloadJob{Namespace: parts[1], Name: parts[2], Version: version, OS: osName, Arch: archName}, FileStatusCorrupt)
+
+		if failure.Deleted {
+			providerDir := filepath.Join(s.providerRoot(), parts[1], parts[2])
+			if err := indexgen.RemoveArchive(providerDir, version, osName+"_"+archName); err != nil {
+				s.logger.Error("Failed to update %s.json for %s: %v", version, failure.Path, err)
+			}
+			reindex[providerDir] = struct{}{}
+		}
+	}
+	for providerDir := range reindex {
+		if err := indexgen.GenerateIndexJSON(providerDir); err != nil {
+			s.logger.Error("Failed to regenerate index.json in %s: %v", providerDir, err)
+		}
+	}
+
+	if len(report.Failures) > 0 {
+		if err := s.saveMetadata(); err != nil {
+			return report, fmt.Errorf("failed to save metadata: %w", err)
+		}
+	}
+	s.logger.Info("Verification completed: %d checked, %d unverified, %d corrupt", report.Checked, report.Unverified, len(report.Failures))
+	return report, nil
+}
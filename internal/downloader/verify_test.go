@@ -0,0 +1,63 @@
+package downloader
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestVerifyMirrorQueuesRepair(t *testing.T) {
+	const filename = "terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name          string
+		corrupt       bool
+		deleteCorrupt bool
+		wantFailures  int
+	}{
+		{name: "intact mirror"},
+		{name: "corrupt archive kept", corrupt: true, wantFailures: 1},
+		{name: "corrupt archive deleted", corrupt: true, deleteCorrupt: true, wantFailures: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, map[string][]string{"hashicorp/null": {"3.2.0"}})
+			reg.platforms = []string{"linux_amd64"}
+			s := newFakeService(t, reg, &common.DownloaderConfig{ProviderFilter: "hashicorp/null", PlatformFilter: "linux_amd64"})
+			if err := s.RunOnce(context.Background()); err != nil {
+				t.Fatalf("RunOnce: %v", err)
+			}
+			archive := s.registry.GetProviderPath(s.config.DownloadPath, "hashicorp", "null", "3.2.0", "linux", "amd64", filename)
+			if tt.corrupt {
+				if err := os.WriteFile(archive, []byte("bit rot"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			report, err := s.VerifyMirror(tt.deleteCorrupt)
+			if err != nil {
+				t.Fatalf("VerifyMirror: %v", err)
+			}
+			if report.Checked != 1 || len(report.Failures) != tt.wantFailures {
+				t.Fatalf("checked %d with %d failures, want 1 with %d", report.Checked, len(report.Failures), tt.wantFailures)
+			}
+			if _, err := os.Stat(archive); os.IsNotExist(err) != tt.deleteCorrupt {
+				t.Errorf("archive removed = %t, want %t", os.IsNotExist(err), tt.deleteCorrupt)
+			}
+
+			// The next run fetches exactly the corrupt archives again
+			if err := s.RunOnce(context.Background()); err != nil {
+				t.Fatalf("second RunOnce: %v", err)
+			}
+			wantRequests := 1 + tt.wantFailures
+			if got := reg.requestCount("/files/" + filename); got != wantRequests {
+				t.Errorf("archive requested %d times, want %d", got, wantRequests)
+			}
+			data, err := os.ReadFile(archive)
+			if err != nil || string(data) != string(fakeArchive(filename)) {
+				t.Errorf("archive not repaired: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package lockfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"golang.org/x/mod/sumdb/dirhash"
+
+	"tf-mirror/internal/common"
+)
+
+// Entry is a single provider lock block
+type Entry struct {
+	Hostname  string // provider source hostname, e.g. registry.terraform.io
+	Namespace string
+	Name      string
+	Version   string
+	Hashes    []string // sorted, "h1:" entries first, then "zh:"
+}
+
+// Address returns the fully qualified provider source address
+func (e Entry) Address() string {
+	return fmt.Sprintf("%s/%s/%s", e.Hostname, e.Namespace, e.Name)
+}
+
+// archive is a provider zip found in the mirror
+type archive struct {
+	version  string
+	platform string
+	filename string
+}
+
+// Generate builds lock entries for providers present in the mirror at dataPath.
+// For each provider the newest version allowed by the filter is locked, as a
+// lock file pins exactly one version per provider. h1: hashes cover the
+// mirrored platforms selected by the platform filter; zh: hashes are taken
+// from the SHA256SUMS file when present, so they cover every published platform.
+func Generate(dataPath, hostname string, providerFilter *common.ProviderFilter, platformFilter *common.PlatformFilter) ([]Entry, error) {
+	root := filepath.Join(dataPath, hostname)
+	namespaces, err := os.ReadDir(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mirror root %s: %w", root, err)
+	}
+
+	var entries []Entry
+	for _, ns := range namespaces {
+		if !ns.IsDir() {
+			continue
+		}
+		names, err := os.ReadDir(filepath.Join(root, ns.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read namespace %s: %w", ns.Name(), err)
+		}
+		for _, n := range names {
+			if !n.IsDir() || !providerFilter.ShouldInclude(ns.Name(), n.Name()) {
+				continue
+			}
+			entry, ok, err := providerEntry(filepath.Join(root, ns.Name(), n.Name()), ns.Name(), n.Name(), providerFilter, platformFilter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				entry.Hostname = hostname
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Address() < entries[j].Address()
+	})
+	return entries, nil
+}
+
+// providerEntry builds the lock entry for the newest matching version of one provider
+func providerEntry(providerDir, namespace, name string, providerFilter *common.ProviderFilter, platformFilter *common.PlatformFilter) (Entry, bool, error) {
+	files, err := os.ReadDir(providerDir)
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to read provider dir %s: %w", providerDir, err)
+	}
+
+	byVersion := make(map[string][]archive)
+	for _, file := range files {
+		a, ok := parseArchive(name, file.Name())
+		if !ok {
+			continue
+		}
+		osName, arch, _ := strings.Cut(a.platform, "_")
+		if !platformFilter.ShouldInclude(osName, arch) {
+			continue
+		}
+		byVersion[a.version] = append(byVersion[a.version], a)
+	}
+
+	versions := make([]string, 0, len(byVersion))
+	for v := range byVersion {
+		versions = append(versions, v)
+	}
+	versions = common.FilterVersionsByRange(versions,
+		providerFilter.GetMinVersion(namespace, name), providerFilter.GetMaxVersion(namespace, name))
+	latest := common.KeepLatestVersions(versions, 1)
+	if len(latest) == 0 {
+		return Entry{}, false, nil
+	}
+	version := latest[0]
+
+	hashes, err := versionHashes(providerDir, name, version, byVersion[version])
+	if err != nil {
+		return Entry{}, false, fmt.Errorf("failed to hash %s/%s %s: %w", namespace, name, version, err)
+	}
+
+	return Entry{
+		Namespace: namespace,
+		Name:      name,
+		Version:   version,
+		Hashes:    hashes,
+	}, true, nil
+}
+
+// versionHashes collects h1: and zh: hashes for one provider version, reusing
+// the hashes stored in <version>.json and SHA256SUMS where available
+func versionHashes(providerDir, name, version string, archives []archive) ([]string, error) {
+	stored := storedH1Hashes(filepath.Join(providerDir, version+".json"))
+
+	var h1, zh []string
+	for _, a := range archives {
+		if hash, ok := stored[a.platform]; ok {
+			h1 = append(h1, hash)
+			continue
+		}
+		hash, err := dirhash.HashZip(filepath.Join(providerDir, a.filename), dirhash.Hash1)
+		if err != nil {
+			return nil, err
+		}
+		h1 = append(h1, hash)
+	}
+
+	shasumsPath := filepath.Join(providerDir, fmt.Sprintf("terraform-provider-%s_%s_SHA256SUMS", name, version))
+	if sums, err := common.ReadSHASums(shasumsPath); err == nil {
+		for filename, sum := range sums {
+			if strings.HasSuffix(filename, ".zip") {
+				zh = append(zh, "zh:"+sum)
+			}
+		}
+	} else {
+		for _, a := range archives {
+			sum, err := common.FileSHA256(filepath.Join(providerDir, a.filename))
+			if err != nil {
+				return nil, err
+			}
+			zh = append(zh, "zh:"+sum)
+		}
+	}
+
+	sort.Strings(h1)
+	sort.Strings(zh)
+	return append(dedup(h1), dedup(zh)...), nil
+}
+
+// storedH1Hashes reads platform -> h1 hash from a generated <version>.json
+func storedH1Hashes(path string) map[string]string {
+	hashes := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return hashes
+	}
+	var index struct {
+		Archives map[string]struct {
+			Hashes []string `json:"hashes"`
+		} `json:"archives"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return hashes
+	}
+	for platform, a := range index.Archives {
+		for _, hash := range a.Hashes {
+			if strings.HasPrefix(hash, "h1:") {
+				hashes[platform] = hash
+				break
+			}
+		}
+	}
+	return hashes
+}
+
+// parseArchive parses terraform-provider-<name>_<version>_<os>_<arch>.zip
+func parseArchive(name, filename string) (archive, bool) {
+	prefix := "terraform-provider-" + name + "_"
+	if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ".zip") {
+		return archive{}, false
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(filename, prefix), ".zip"), "_")
+	if len(parts) != 3 {
+		return archive{}, false
+	}
+	if _, err := semver.ParseTolerant(parts[0]); err != nil {
+		return archive{}, false
+	}
+	return archive{
+		version:  parts[0],
+		platform: parts[1] + "_" + parts[2],
+		filename: filename,
+	}, true
+}
+
+// dedup removes adjacent duplicates from a sorted slice
+func dedup(values []string) []string {
+	out := values[:0]
+	for i, v := range values {
+		if i == 0 || v != values[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Write renders entries as .terraform.lock.hcl provider blocks
+func Write(w io.Writer, entries []Entry) error {
+	if _, err := fmt.Fprintf(w, "# This file is maintained automatically by \"terraform init\".\n# Manual edits may be lost in future updates.\n"); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var b strings.Builder
+		fmt.Fprintf(&b, "\nprovider %q {\n", e.Address())
+		fmt.Fprintf(&b, "  version = %q\n", e.Version)
+		b.WriteString("  hashes = [\n")
+		for _, hash := range e.Hashes {
+			fmt.Fprintf(&b, "    %q,\n", hash)
+		}
+		b.WriteString("  ]\n}\n")
+		if _, err := io.WriteString(w, b.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package lockfile
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/sumdb/dirhash"
+
+	"tf-mirror/internal/common"
+)
+
+// writeArchive writes a provider zip into dir and returns its h1: and zh: hashes
+func writeArchive(t *testing.T, dir, filename string) (string, string) {
+	t.Helper()
+	path := filepath.Join(dir, filename)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, _ := w.Create("terraform-provider")
+	entry.Write([]byte(filename))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	h1, err := dirhash.HashZip(path, dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	sum := sha256.Sum256(data)
+	return h1, "zh:" + hex.EncodeToString(sum[:])
+}
+
+func TestGenerate(t *testing.T) {
+	const hostname = "registry.terraform.io"
+	dataPath := t.TempDir()
+	dir := filepath.Join(dataPath, hostname, "hashicorp", "null")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(map[string][2]string) // filename -> h1, zh
+	for _, filename := range []string{
+		"terraform-provider-null_3.1.0_linux_amd64.zip",
+		"terraform-provider-null_3.2.0_linux_amd64.zip",
+		"terraform-provider-null_3.2.0_darwin_arm64.zip",
+	} {
+		h1, zh := writeArchive(t, dir, filename)
+		hashes[filename] = [2]string{h1, zh}
+	}
+	h1 := func(filename string) string { return hashes[filename][0] }
+	zh := func(filename string) string { return hashes[filename][1] }
+	sorted := func(values ...string) []string {
+		slices.Sort(values)
+		return values
+	}
+
+	linux320 := "terraform-provider-null_3.2.0_linux_amd64.zip"
+	darwin320 := "terraform-provider-null_3.2.0_darwin_arm64.zip"
+	linux310 := "terraform-provider-null_3.1.0_linux_amd64.zip"
+
+	tests := []struct {
+		name           string
+		providerFilter string
+		platformFilter string
+		setup          func(t *testing.T)
+		wantVersion    string
+		wantHashes     []string
+	}{
+		{
+			name:        "newest version, hashed from the archives",
+			wantVersion: "3.2.0",
+			wantHashes:  append(sorted(h1(darwin320), h1(linux320)), sorted(zh(darwin320), zh(linux320))...),
+		},
+		{
+			name:           "platform filter",
+			platformFilter: "linux_amd64",
+			wantVersion:    "3.2.0",
+			wantHashes:     []string{h1(linux320), zh(linux320)},
+		},
+		{
+			name:           "version range from the provider filter",
+			providerFilter: "hashicorp/null<3.2.0",
+			wantVersion:    "3.1.0",
+			wantHashes:     []string{h1(linux310), zh(linux310)},
+		},
+		{
+			name:           "stored h1 hashes are reused",
+			platformFilter: "linux_amd64",
+			setup: func(t *testing.T) {
+				writeFile(t, filepath.Join(dir, "3.2.0.json"), `{"archives":{"linux_amd64":{"url":"x","hashes":["h1:stored="]}}}`)
+			},
+			wantVersion: "3.2.0",
+			wantHashes:  []string{"h1:stored=", zh(linux320)},
+		},
+		{
+			name:           "zh hashes from SHA256SUMS cover unmirrored platforms",
+			platformFilter: "linux_amd64",
+			setup: func(t *testing.T) {
+				writeFile(t, filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"),
+					strings.TrimPrefix(zh(linux320), "zh:")+"  "+linux320+"\n"+
+						strings.Repeat("a", 64)+"  terraform-provider-null_3.2.0_windows_amd64.zip\n"+
+						strings.Repeat("b", 64)+"  terraform-provider-null_3.2.0_manifest.json\n")
+			},
+			wantVersion: "3.2.0",
+			wantHashes:  append([]string{h1(linux320)}, sorted("zh:"+strings.Repeat("a", 64), zh(linux320))...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(filepath.Join(dir, "3.2.0.json"))
+			os.Remove(filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"))
+			if tt.setup != nil {
+				tt.setup(t)
+			}
+			providerFilter, err := common.NewProviderFilter(tt.providerFilter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			platformFilter, err := common.NewPlatformFilter(tt.platformFilter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := Generate(dataPath, hostname, providerFilter, platformFilter)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(entries))
+			}
+			if entries[0].Version != tt.wantVersion {
+				t.Errorf("version = %s, want %s", entries[0].Version, tt.wantVersion)
+			}
+			if !slices.Equal(entries[0].Hashes, tt.wantHashes) {
+				t.Errorf("hashes = %v, want %v", entries[0].Hashes, tt.wantHashes)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b strings.Builder
+	err := Write(&b, []Entry{{
+		Hostname:  "registry.terraform.io",
+		Namespace: "hashicorp",
+		Name:      "null",
+		Version:   "3.2.0",
+		Hashes:    []string{"h1:abc=", "zh:0123"},
+	}})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	want := `# This file is maintained automatically by "terraform init".
+# Manual edits may be lost in future updates.
+
+provider "registry.terraform.io/hashicorp/null" {
+  version = "3.2.0"
+  hashes = [
+    "h1:abc=",
+    "zh:0123",
+  ]
+}
+`
+	if b.String() != want {
+		t.Errorf("Write output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+// writeFile creates path with content
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
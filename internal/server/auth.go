@@ -0,0 +1,90 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// probePaths are the liveness and readiness probes, exempt from the auth and
+// CIDR checks so orchestrators can reach them
+var probePaths = map[string]bool{"/health": true, "/ready": true}
+
+// authMiddleware requires "Authorization: Bearer <token>" on every route
+// except the probes when an auth token is configured
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	if s.config.AuthToken == "" {
+		return next
+	}
+	expected := []byte(s.config.AuthToken)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tf-mirror"`)
+			s.writeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cidrMiddleware rejects clients outside the configured CIDRs with 403.
+// /health and /ready stay open so orchestrator probes keep working.
+func (s *Server) cidrMiddleware(next http.Handler) http.Handler {
+	if len(s.config.AllowedCIDRs) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, ok := s.clientAddr(r)
+		if !ok || !s.isAllowedAddr(addr) {
+			s.logger.Debug("Rejected client %s (remote %s)", addr, r.RemoteAddr)
+			s.writeErrorResponse(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientAddr returns the client IP. With TrustProxy, the right-most
+// X-Forwarded-For entry is used: it was appended by the proxy in front of
+// the mirror and, unlike earlier entries, cannot be forged by the client.
+func (s *Server) clientAddr(r *http.Request) (netip.Addr, bool) {
+	if s.config.TrustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			addr, err := netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1]))
+			return addr.Unmap(), err == nil
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	return addr.Unmap(), err == nil
+}
+
+// isAllowedAddr reports whether addr falls within one of the allowed CIDRs
+func (s *Server) isAllowedAddr(addr netip.Addr) bool {
+	for _, prefix := range s.config.AllowedCIDRs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestAuthToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantStatus    int
+	}{
+		{name: "authorized", path: "/version", authorization: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "authorized archive", path: "/registry.terraform.io/hashicorp/null/index.json", authorization: "Bearer secret", wantStatus: http.StatusOK},
+		{name: "no header", path: "/version", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", path: "/version", authorization: "Bearer secrets", wantStatus: http.StatusUnauthorized},
+		{name: "token prefix", path: "/version", authorization: "Bearer secr", wantStatus: http.StatusUnauthorized},
+		{name: "basic scheme", path: "/version", authorization: "Basic c2VjcmV0", wantStatus: http.StatusUnauthorized},
+		{name: "unauthorized archive", path: "/registry.terraform.io/hashicorp/null/index.json", wantStatus: http.StatusUnauthorized},
+		{name: "health bypass", path: "/health", wantStatus: http.StatusOK},
+		{name: "ready bypass", path: "/ready", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{AuthToken: "secret"})
+			writeTestFile(t, filepath.Join(srv.config.DataPath, "registry.terraform.io", "hashicorp", "null", "index.json"), `{"versions":{"3.2.0":{}}}`)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := serve(srv, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusUnauthorized {
+				return
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got == "" {
+				t.Error("401 without a WWW-Authenticate header")
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var body common.ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || len(body.Errors) != 1 || body.Errors[0].Status != "401" {
+				t.Errorf("401 body %q is not a JSON error: %v", rec.Body.String(), err)
+			}
+		})
+	}
+}
+
+func TestAllowCIDR(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string // defaults to /version
+		cidrs      string
+		trustProxy bool
+		remoteAddr string
+		forwarded  []string // X-Forwarded-For header values
+		wantStatus int
+	}{
+		{name: "direct inside", cidrs: "10.0.0.0/8", remoteAddr: "10.1.2.3:1234", wantStatus: http.StatusOK},
+		{name: "direct outside", cidrs: "10.0.0.0/8", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusForbidden},
+		{name: "single address", cidrs: "192.0.2.1", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+		{name: "second range", cidrs: "10.0.0.0/8,192.0.2.0/24", remoteAddr: "192.0.2.7:1234", wantStatus: http.StatusOK},
+		{name: "IPv4-mapped IPv6", cidrs: "10.0.0.0/8", remoteAddr: "[::ffff:10.1.2.3]:1234", wantStatus: http.StatusOK},
+		{name: "IPv6", cidrs: "2001:db8::/32", remoteAddr: "[2001:db8::1]:1234", wantStatus: http.StatusOK},
+		{name: "forwarded header ignored without trust", cidrs: "10.0.0.0/8", remoteAddr: "192.0.2.1:1234", forwarded: []string{"10.1.2.3"}, wantStatus: http.StatusForbidden},
+		{name: "forwarded inside", cidrs: "10.0.0.0/8", trustProxy: true, remoteAddr: "192.0.2.1:1234", forwarded: []string{"10.1.2.3"}, wantStatus: http.StatusOK},
+		{name: "forwarded outside", cidrs: "10.0.0.0/8", trustProxy: true, remoteAddr: "10.1.2.3:1234", forwarded: []string{"192.0.2.1"}, wantStatus: http.StatusForbidden},
+		{name: "forged first hop", cidrs: "10.0.0.0/8", trustProxy: true, remoteAddr: "10.9.9.9:1234", forwarded: []string{"10.1.2.3, 192.0.2.1"}, wantStatus: http.StatusForbidden},
+		{name: "last header wins", cidrs: "10.0.0.0/8", trustProxy: true, remoteAddr: "192.0.2.9:1234", forwarded: []string{"192.0.2.1", "10.1.2.3"}, wantStatus: http.StatusOK},
+		{name: "trusted proxy without header", cidrs: "10.0.0.0/8", trustProxy: true, remoteAddr: "10.1.2.3:1234", wantStatus: http.StatusOK},
+		{name: "unparsable forwarded address", cidrs: "10.0.0.0/8", trustProxy: true, remoteAddr: "10.1.2.3:1234", forwarded: []string{"unknown"}, wantStatus: http.StatusForbidden},
+		{name: "health outside", path: "/health", cidrs: "10.0.0.0/8", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+		{name: "ready outside", path: "/ready", cidrs: "10.0.0.0/8", remoteAddr: "192.0.2.1:1234", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cidrs, err := common.ParseCIDRList(tt.cidrs)
+			if err != nil {
+				t.Fatalf("ParseCIDRList(%q): %v", tt.cidrs, err)
+			}
+			srv := newTestServer(t, &common.ServerConfig{AllowedCIDRs: cidrs, TrustProxy: tt.trustProxy})
+			writeTestFile(t, filepath.Join(srv.config.DataPath, "registry.terraform.io", "hashicorp", "null", "index.json"), `{"versions":{"3.2.0":{}}}`)
+
+			path := tt.path
+			if path == "" {
+				path = "/version"
+			}
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			if rec := serve(srv, req); rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// certCheckInterval limits how often the certificate files are stat'ed during
+// handshakes
+var certCheckInterval = 5 * time.Second
+
+// certStore serves the TLS certificates and reloads them when the files
+// change on disk, e.g. after a renewal by cert-manager or certbot. A pair that
+// fails to load keeps serving its previous certificate until it is fixed.
+type certStore struct {
+	pairs  []common.CertKeyPair
+	logger *common.Logger
+
+	mu        sync.Mutex
+	certs     []tls.Certificate
+	stamps    []certStamp
+	lastCheck time.Time
+}
+
+// certStamp identifies the version of a pair on disk
+type certStamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// newCertStore loads the default certificate followed by the SNI ones
+func newCertStore(config *common.ServerConfig, logger *common.Logger) (*certStore, error) {
+	store := &certStore{
+		pairs:  append([]common.CertKeyPair{{Cert: config.TLSCert, Key: config.TLSKey}}, config.TLSSNICerts...),
+		logger: logger,
+	}
+	store.certs = make([]tls.Certificate, len(store.pairs))
+	store.stamps = make([]certStamp, len(store.pairs))
+	for i, pair := range store.pairs {
+		stamp, _ := statPair(pair)
+		cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate %s: %w", pair.Cert, err)
+		}
+		store.certs[i] = cert
+		store.stamps[i] = stamp
+	}
+	store.lastCheck = time.Now()
+	return store, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate. Like crypto/tls with a
+// static Certificates list, it returns the first certificate valid for the
+// client's server name and falls back to the default one.
+func (c *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	if time.Since(c.lastCheck) >= certCheckInterval {
+		c.lastCheck = time.Now()
+		c.reloadChanged()
+	}
+	certs := c.certs
+	c.mu.Unlock()
+
+	for i := range certs {
+		if hello.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i], nil
+		}
+	}
+	return &certs[0], nil
+}
+
+// reload checks the files right away instead of waiting for the next
+// handshake after certCheckInterval. It returns the number of reloaded pairs.
+func (c *certStore) reload() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCheck = time.Now()
+	return c.reloadChanged()
+}
+
+// reloadChanged reloads the pairs whose files changed since they were last
+// read and returns how many were replaced. The caller holds c.mu.
+func (c *certStore) reloadChanged() int {
+	reloaded := 0
+	var certs []tls.Certificate
+	for i, pair := range c.pairs {
+		stamp, err := statPair(pair)
+		if err != nil || stamp == c.stamps[i] {
+			continue
+		}
+		// Remember the attempt, so a bad pair is retried (and logged) only
+		// after the files change again
+		c.stamps[i] = stamp
+
+		cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+		if err != nil {
+			c.logger.Warn("Keeping previous TLS certificate for %s: %v", pair.Cert, err)
+			continue
+		}
+		if certs == nil {
+			// Handshakes in progress keep using the old slice
+			certs = append([]tls.Certificate(nil), c.certs...)
+		}
+		certs[i] = cert
+		reloaded++
+		c.logger.Info("Reloaded TLS certificate %s", pair.Cert)
+	}
+	if certs != nil {
+		c.certs = certs
+	}
+	return reloaded
+}
+
+// statPair returns the size and mtime of a certificate and its key
+func statPair(pair common.CertKeyPair) (certStamp, error) {
+	certInfo, err := os.Stat(pair.Cert)
+	if err != nil {
+		return certStamp{}, err
+	}
+	keyInfo, err := os.Stat(pair.Key)
+	if err != nil {
+		return certStamp{}, err
+	}
+	return certStamp{
+		certMod:  certInfo.ModTime(),
+		keyMod:   keyInfo.ModTime(),
+		certSize: certInfo.Size(),
+		keySize:  keyInfo.Size(),
+	}, nil
+}
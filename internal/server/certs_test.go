@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// writeTestCert writes a self-signed certificate for commonName, valid for
+// the DNS names dnsNames, and its key as PEM files
+func writeTestCert(t *testing.T, certPath, keyPath, commonName string, dnsNames ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, certPath, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeTestFile(t, keyPath, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+}
+
+// startTLSServer serves srv over TLS as Start does and returns its address
+func startTLSServer(t *testing.T, srv *Server) string {
+	t.Helper()
+	tlsConfig, err := srv.newTLSConfig()
+	if err != nil {
+		t.Fatalf("newTLSConfig: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := srv.newHTTPServer(ln.Addr().String())
+	httpServer.TLSConfig = tlsConfig
+	httpServer.ErrorLog = log.New(io.Discard, "", 0) // rejected handshakes are expected
+	go httpServer.ServeTLS(ln, "", "")
+	t.Cleanup(func() { httpServer.Close() })
+	return ln.Addr().String()
+}
+
+// getTLS requests /health from addr with the client TLS settings of config
+// and returns the response, its body closed
+func getTLS(addr string, config *tls.Config) (*http.Response, error) {
+	config.InsecureSkipVerify = true
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("https://" + addr + "/health")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverMin  uint16
+		clientMax  uint16
+		wantFailed bool
+	}{
+		{name: "default rejects TLS 1.1", clientMax: tls.VersionTLS11, wantFailed: true},
+		{name: "default accepts TLS 1.2", clientMax: tls.VersionTLS12},
+		{name: "1.2 rejects TLS 1.0", serverMin: tls.VersionTLS12, clientMax: tls.VersionTLS10, wantFailed: true},
+		{name: "1.3 rejects TLS 1.2", serverMin: tls.VersionTLS13, clientMax: tls.VersionTLS12, wantFailed: true},
+		{name: "1.3 accepts TLS 1.3", serverMin: tls.VersionTLS13, clientMax: tls.VersionTLS13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := &common.ServerConfig{
+				EnableTLS:     true,
+				TLSCert:       filepath.Join(dir, "tls.crt"),
+				TLSKey:        filepath.Join(dir, "tls.key"),
+				TLSMinVersion: tt.serverMin,
+			}
+			writeTestCert(t, config.TLSCert, config.TLSKey, "mirror.test")
+			addr := startTLSServer(t, newTestServer(t, config))
+
+			resp, err := getTLS(addr, &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tt.clientMax})
+			if failed := err != nil; failed != tt.wantFailed {
+				t.Fatalf("handshake error = %v, want failure %t", err, tt.wantFailed)
+			}
+			if err != nil {
+				return
+			}
+			if resp.TLS.Version != tt.clientMax {
+				t.Errorf("negotiated %s, want %s", tls.VersionName(resp.TLS.Version), tls.VersionName(tt.clientMax))
+			}
+			if resp.ProtoMajor != 2 {
+				t.Errorf("protocol = %s, want HTTP/2", resp.Proto)
+			}
+		})
+	}
+}
+
+func TestCertificateReloadedWhileServing(t *testing.T) {
+	interval := certCheckInterval
+	certCheckInterval = 0
+	t.Cleanup(func() { certCheckInterval = interval })
+
+	tests := []struct {
+		name string
+		swap func(t *testing.T, certPath, keyPath string)
+		want string // common name served after the swap
+	}{
+		{
+			name: "renewed pair",
+			swap: func(t *testing.T, certPath, keyPath string) {
+				writeTestCert(t, certPath, keyPath, "renewed")
+			},
+			want: "renewed",
+		},
+		{
+			name: "certificate written before its key",
+			swap: func(t *testing.T, certPath, keyPath string) {
+				dir := t.TempDir()
+				writeTestCert(t, filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "renewed")
+				data, err := os.ReadFile(filepath.Join(dir, "tls.crt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, certPath, string(data))
+			},
+			want: "original",
+		},
+		{
+			name: "truncated certificate",
+			swap: func(t *testing.T, certPath, keyPath string) {
+				writeTestFile(t, certPath, "-----BEGIN CERTIFICATE-----\n")
+			},
+			want: "original",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := &common.ServerConfig{
+				EnableTLS: true,
+				TLSCert:   filepath.Join(dir, "tls.crt"),
+				TLSKey:    filepath.Join(dir, "tls.key"),
+			}
+			writeTestCert(t, config.TLSCert, config.TLSKey, "original")
+			addr := startTLSServer(t, newTestServer(t, config))
+			servedName := func() string {
+				t.Helper()
+				resp, err := getTLS(addr, &tls.Config{})
+				if err != nil {
+					t.Fatalf("GET: %v", err)
+				}
+				return resp.TLS.PeerCertificates[0].Subject.CommonName
+			}
+			if got := servedName(); got != "original" {
+				t.Fatalf("served %q before the swap", got)
+			}
+
+			tt.swap(t, config.TLSCert, config.TLSKey)
+			// Make the change visible on filesystems with coarse timestamps
+			later := time.Now().Add(time.Second)
+			os.Chtimes(config.TLSCert, later, later)
+
+			if got := servedName(); got != tt.want {
+				t.Errorf("served %q after the swap, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// archiveETags caches the content ETag of served archives, keyed by path and
+// invalidated when the file's size or mtime changes
+type archiveETags struct {
+	mu      sync.Mutex
+	entries map[string]archiveETag
+}
+
+type archiveETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// archiveETagHandler sets a strong ETag (the archive's SHA256) on .zip
+// responses of the static file server. http.FileServer already sends
+// Last-Modified and answers If-None-Match/If-Modified-Since with 304 based on
+// the headers set before it runs.
+func (s *Server) archiveETagHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".zip") {
+			filePath := filepath.Join(s.config.DataPath, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+			if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+				if etag := s.archiveETag(filePath, info); etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// archiveETag returns the quoted SHA256 of an archive. It is taken from the
+// SHA256SUMS file next to the archive when listed there, otherwise computed
+// once per size and mtime.
+func (s *Server) archiveETag(filePath string, info os.FileInfo) string {
+	s.etags.mu.Lock()
+	cached, ok := s.etags.entries[filePath]
+	s.etags.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.etag
+	}
+
+	sum := sumFromSHASums(filePath)
+	if sum == "" {
+		var err error
+		if sum, err = common.FileSHA256(filePath); err != nil {
+			s.logger.Warn("Failed to hash %s for ETag: %v", filePath, err)
+			return ""
+		}
+	}
+	etag := `"` + strings.ToLower(sum) + `"`
+
+	s.etags.mu.Lock()
+	if s.etags.entries == nil {
+		s.etags.entries = make(map[string]archiveETag)
+	}
+	s.etags.entries[filePath] = archiveETag{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	s.etags.mu.Unlock()
+	return etag
+}
+
+// sumFromSHASums looks the archive up in the *_SHA256SUMS files of its directory
+func sumFromSHASums(filePath string) string {
+	sumsFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(filePath), "*_SHA256SUMS"))
+	name := filepath.Base(filePath)
+	for _, sumsFile := range sumsFiles {
+		sums, err := common.ReadSHASums(sumsFile)
+		if err != nil {
+			continue
+		}
+		if sum, ok := sums[name]; ok && len(sum) == 64 {
+			return sum
+		}
+	}
+	return ""
+}
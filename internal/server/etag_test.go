@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestArchiveConditionalRequests(t *testing.T) {
+	const (
+		archivePath = "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+		content     = "archive content"
+	)
+	sum := sha256.Sum256([]byte(content))
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	tests := []struct {
+		name       string
+		shaSums    string // SHA256SUMS content next to the archive, if any
+		header     string
+		value      string
+		wantStatus int
+		wantETag   string
+	}{
+		{name: "plain request", wantStatus: http.StatusOK, wantETag: etag},
+		{name: "matching If-None-Match", header: "If-None-Match", value: etag, wantStatus: http.StatusNotModified, wantETag: etag},
+		{name: "other If-None-Match", header: "If-None-Match", value: `"0000"`, wantStatus: http.StatusOK, wantETag: etag},
+		{name: "If-Modified-Since after the change", header: "If-Modified-Since", value: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), wantStatus: http.StatusNotModified, wantETag: etag},
+		{name: "If-Modified-Since before the change", header: "If-Modified-Since", value: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), wantStatus: http.StatusOK, wantETag: etag},
+		{name: "ETag from SHA256SUMS", shaSums: "ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789  terraform-provider-null_3.2.0_linux_amd64.zip\n",
+			wantStatus: http.StatusOK, wantETag: `"abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			writeTestFile(t, filepath.Join(srv.config.DataPath, filepath.FromSlash(archivePath)), content)
+			if tt.shaSums != "" {
+				writeTestFile(t, filepath.Join(srv.config.DataPath, "registry.terraform.io", "hashicorp", "null", "terraform-provider-null_3.2.0_SHA256SUMS"), tt.shaSums)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, archivePath, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := serve(srv, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("ETag = %s, want %s", got, tt.wantETag)
+			}
+			if rec.Header().Get("Last-Modified") == "" && rec.Code == http.StatusOK {
+				t.Error("no Last-Modified header")
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+func TestGzipResponses(t *testing.T) {
+	const (
+		archive    = "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+		bigIndex   = "/registry.terraform.io/hashicorp/null/index.json"
+		smallIndex = "/registry.terraform.io/hashicorp/random/index.json"
+	)
+	versions := make(map[string]any)
+	for i := range 100 {
+		versions[fmt.Sprintf("3.%d.0", i)] = map[string]any{}
+	}
+	bigJSON, _ := json.Marshal(map[string]any{"versions": versions})
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		rangeH   string
+		wantGzip bool
+		wantVary bool
+	}{
+		{name: "provider listing", path: "/providers", accept: "gzip", wantGzip: true, wantVary: true},
+		{name: "large index.json", path: bigIndex, accept: "gzip, deflate", wantGzip: true, wantVary: true},
+		{name: "small index.json", path: smallIndex, accept: "gzip", wantVary: true},
+		{name: "client without gzip", path: bigIndex, wantVary: true},
+		{name: "gzip refused with q=0", path: bigIndex, accept: "gzip;q=0", wantVary: true},
+		{name: "range of index.json", path: bigIndex, accept: "gzip", rangeH: "bytes=0-99", wantVary: true},
+		{name: "zip archive", path: archive, accept: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			dataPath := srv.config.DataPath
+			writeProviderFixture(t, dataPath)
+			writeTestFile(t, filepath.Join(dataPath, filepath.FromSlash(bigIndex)), string(bigJSON))
+			writeTestFile(t, filepath.Join(dataPath, filepath.FromSlash(smallIndex)), `{"versions":{}}`)
+			writeTestFile(t, filepath.Join(dataPath, filepath.FromSlash(archive)), strings.Repeat("PK", 1000))
+
+			ts := httptest.NewServer(srv.router)
+			defer ts.Close()
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept-Encoding", tt.accept)
+			}
+			if tt.rangeH != "" {
+				req.Header.Set("Range", tt.rangeH)
+			}
+			// Keep the transport from negotiating and decoding gzip itself
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+				t.Fatalf("status = %d", resp.StatusCode)
+			}
+
+			gzipped := resp.Header.Get("Content-Encoding") == "gzip"
+			if gzipped != tt.wantGzip {
+				t.Errorf("Content-Encoding = %q, want gzip %t", resp.Header.Get("Content-Encoding"), tt.wantGzip)
+			}
+			if vary := strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding"); vary != tt.wantVary {
+				t.Errorf("Vary = %q, want Accept-Encoding %t", resp.Header.Get("Vary"), tt.wantVary)
+			}
+
+			var body io.Reader = resp.Body
+			if gzipped {
+				if resp.Header.Get("Content-Length") != "" && resp.ContentLength >= int64(len(bigJSON)) {
+					t.Errorf("Content-Length %d is not the compressed size", resp.ContentLength)
+				}
+				gz, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("gzip body: %v", err)
+				}
+				body = gz
+			}
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+			if strings.HasSuffix(tt.path, ".zip") || tt.rangeH != "" {
+				return
+			}
+			if !json.Valid(data) {
+				t.Errorf("body is not JSON after decoding: %.80q", data)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,8 +19,14 @@ type Metrics struct {
 	StartTime       time.Time               `json:"start_time"`
 	RequestCount    int64                   `json:"request_count"`
 	ErrorCount      int64                   `json:"error_count"`
+	BytesServed     int64                   `json:"bytes_served"`
+	TotalProviders  int                     `json:"total_providers"`
+	TotalVersions   int                     `json:"total_versions"`
+	TotalPlatforms  int                     `json:"total_platforms"`
 	ProvidersServed map[string]int64        `json:"providers_served"`
-	ResponseTimes   []time.Duration         `json:"-"`
+	RequestsByCode  map[requestKey]int64    `json:"-"`
+	DurationBuckets []int64                 `json:"-"` // cumulative counts per durationBuckets bound
+	DurationSum     float64                 `json:"-"` // seconds
 	AverageResponse time.Duration           `json:"average_response_time"`
 	LastRequestTime time.Time               `json:"last_request_time"`
 	DiskUsage       int64                   `json:"disk_usage_bytes"`
@@ -27,6 +34,29 @@ type Metrics struct {
 	EndpointStats   map[string]EndpointStat `json:"endpoint_stats"`
 }
 
+// requestKey identifies a tfmirror_requests_total series
+type requestKey struct {
+	Method string
+	Code   string
+}
+
+// knownMethods bounds the method label; anything else is reported as "other"
+var knownMethods = map[string]struct{}{
+	http.MethodGet: {}, http.MethodHead: {}, http.MethodPost: {}, http.MethodPut: {},
+	http.MethodPatch: {}, http.MethodDelete: {}, http.MethodOptions: {},
+}
+
+// normalizeMethod maps a request method to a bounded label value
+func normalizeMethod(method string) string {
+	if _, ok := knownMethods[method]; ok {
+		return method
+	}
+	return "other"
+}
+
+// durationBuckets are the upper bounds (seconds) of the request duration histogram
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
 // SystemInfo represents system information
 type SystemInfo struct {
 	GoVersion    string `json:"go_version"`
@@ -52,34 +82,35 @@ func NewMetrics() *Metrics {
 	return &Metrics{
 		StartTime:       time.Now(),
 		ProvidersServed: make(map[string]int64),
-		ResponseTimes:   make([]time.Duration, 0, 100), // Keep last 100 response times
+		RequestsByCode:  make(map[requestKey]int64),
+		DurationBuckets: make([]int64, len(durationBuckets)),
 		EndpointStats:   make(map[string]EndpointStat),
 		SystemInfo:      getSystemInfo(),
 	}
 }
 
-// RecordRequest records a request with response time
-func (m *Metrics) RecordRequest(endpoint string, duration time.Duration, isError bool) {
+// RecordRequest records a request with its method, final status code and response time
+func (m *Metrics) RecordRequest(endpoint, method string, status int, duration time.Duration) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	isError := status >= 400
+
 	m.RequestCount++
 	m.LastRequestTime = time.Now()
+	m.RequestsByCode[requestKey{Method: normalizeMethod(method), Code: strconv.Itoa(status)}]++
 
-	// Update response times (keep only last 100)
-	if len(m.ResponseTimes) >= 100 {
-		m.ResponseTimes = m.ResponseTimes[1:]
-	}
-	m.ResponseTimes = append(m.ResponseTimes, duration)
-
-	// Calculate average response time
-	if len(m.ResponseTimes) > 0 {
-		var total time.Duration
-		for _, rt := range m.ResponseTimes {
-			total += rt
+	// Update the duration histogram (buckets are cumulative, as in Prometheus)
+	seconds := duration.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.DurationBuckets[i]++
 		}
-		m.AverageResponse = total / time.Duration(len(m.ResponseTimes))
 	}
+	m.DurationSum += seconds
+
+	// Average over all requests, derived from the histogram sum
+	m.AverageResponse = time.Duration(m.DurationSum / float64(m.RequestCount) * float64(time.Second))
 
 	// Update endpoint statistics
 	stat := m.EndpointStats[endpoint]
@@ -108,9 +139,22 @@ func (m *Metrics) RecordProviderServed(provider string) {
 	m.ProvidersServed[provider]++
 }
 
-// UpdateCounts is now a no-op (TotalProviders/Versions/Platforms removed)
+// RecordBytesServed adds n response body bytes to the egress counter
+func (m *Metrics) RecordBytesServed(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.BytesServed += n
+}
+
+// UpdateCounts updates the provider, version and platform gauges
 func (m *Metrics) UpdateCounts(providers, versions, platforms int) {
-	// No-op
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.TotalProviders = providers
+	m.TotalVersions = versions
+	m.TotalPlatforms = platforms
 }
 
 // UpdateDiskUsage updates disk usage information
@@ -132,17 +176,25 @@ func (m *Metrics) GetMetrics() *Metrics {
 		StartTime:       m.StartTime,
 		RequestCount:    m.RequestCount,
 		ErrorCount:      m.ErrorCount,
+		BytesServed:     m.BytesServed,
+		TotalProviders:  m.TotalProviders,
+		TotalVersions:   m.TotalVersions,
+		TotalPlatforms:  m.TotalPlatforms,
 		AverageResponse: m.AverageResponse,
 		LastRequestTime: m.LastRequestTime,
+		DurationBuckets: slices.Clone(m.DurationBuckets),
+		DurationSum:     m.DurationSum,
 
 		DiskUsage:       m.DiskUsage,
 		SystemInfo:      m.SystemInfo,
 		ProvidersServed: make(map[string]int64, len(m.ProvidersServed)),
+		RequestsByCode:  make(map[requestKey]int64, len(m.RequestsByCode)),
 		EndpointStats:   make(map[string]EndpointStat, len(m.EndpointStats)),
 	}
 
 	// Use maps.Copy (Go 1.21+) for copying maps
 	maps.Copy(metrics.ProvidersServed, m.ProvidersServed)
+	maps.Copy(metrics.RequestsByCode, m.RequestsByCode)
 	maps.Copy(metrics.EndpointStats, m.EndpointStats)
 
 	return metrics
@@ -173,6 +225,10 @@ func calculateDiskUsage(path string) int64 {
 		if err != nil {
 			return nil // Skip errors and continue
 		}
+		// _blobs hardlinks the archives (--dedupe), counting it would double the size
+		if info.IsDir() && info.Name() == "_blobs" {
+			return filepath.SkipDir
+		}
 		if !info.IsDir() {
 			size += info.Size()
 		}
@@ -191,11 +247,7 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	// Update disk usage before returning metrics
 	go s.metrics.UpdateDiskUsage(s.config.DataPath)
 
-	// Update provider counts (no-op, metrics removed)
-	providers, _ := s.scanProviders()
-	totalVersions, totalPlatforms := s.countVersionsAndPlatforms()
-	s.metrics.UpdateCounts(len(providers), totalVersions, totalPlatforms)
-
+	// Provider counts are refreshed by refreshCounts, not per scrape
 	metrics := s.metrics.GetMetrics()
 
 	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
@@ -212,12 +264,25 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	sb.WriteString(formatFloat(uptime))
 	sb.WriteString("\n")
 
-	// Request count
-	sb.WriteString("# HELP tfmirror_requests_total Total number of HTTP requests\n")
+	// Request count by method and status code, sorted for stable output
+	sb.WriteString("# HELP tfmirror_requests_total Total number of HTTP requests by method and status code\n")
 	sb.WriteString("# TYPE tfmirror_requests_total counter\n")
-	sb.WriteString("tfmirror_requests_total ")
-	sb.WriteString(formatInt(metrics.RequestCount))
-	sb.WriteString("\n")
+	keys := slices.Collect(maps.Keys(metrics.RequestsByCode))
+	slices.SortFunc(keys, func(a, b requestKey) int {
+		if c := strings.Compare(a.Method, b.Method); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Code, b.Code)
+	})
+	for _, key := range keys {
+		sb.WriteString("tfmirror_requests_total{method=\"")
+		sb.WriteString(escapeLabel(key.Method))
+		sb.WriteString("\",code=\"")
+		sb.WriteString(escapeLabel(key.Code))
+		sb.WriteString("\"} ")
+		sb.WriteString(formatInt(metrics.RequestsByCode[key]))
+		sb.WriteString("\n")
+	}
 
 	// Error count
 	sb.WriteString("# HELP tfmirror_errors_total Total number of HTTP errors\n")
@@ -226,13 +291,57 @@ func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
 	sb.WriteString(formatInt(metrics.ErrorCount))
 	sb.WriteString("\n")
 
+	// Mirror contents
+	sb.WriteString("# HELP tfmirror_providers_total Number of mirrored providers\n")
+	sb.WriteString("# TYPE tfmirror_providers_total gauge\n")
+	sb.WriteString("tfmirror_providers_total ")
+	sb.WriteString(formatInt(int64(metrics.TotalProviders)))
+	sb.WriteString("\n")
+	sb.WriteString("# HELP tfmirror_versions_total Number of mirrored provider versions\n")
+	sb.WriteString("# TYPE tfmirror_versions_total gauge\n")
+	sb.WriteString("tfmirror_versions_total ")
+	sb.WriteString(formatInt(int64(metrics.TotalVersions)))
+	sb.WriteString("\n")
+	sb.WriteString("# HELP tfmirror_platforms_total Number of mirrored provider archives (version and platform pairs)\n")
+	sb.WriteString("# TYPE tfmirror_platforms_total gauge\n")
+	sb.WriteString("tfmirror_platforms_total ")
+	sb.WriteString(formatInt(int64(metrics.TotalPlatforms)))
+	sb.WriteString("\n")
+
+	// Bytes served
+	sb.WriteString("# HELP tfmirror_bytes_served_total Total response body bytes written\n")
+	sb.WriteString("# TYPE tfmirror_bytes_served_total counter\n")
+	sb.WriteString("tfmirror_bytes_served_total ")
+	sb.WriteString(formatInt(metrics.BytesServed))
+	sb.WriteString("\n")
+
 	// Average response time
-	sb.WriteString("# HELP tfmirror_average_response_seconds Average response time (last 100 requests)\n")
+	sb.WriteString("# HELP tfmirror_average_response_seconds Average response time\n")
 	sb.WriteString("# TYPE tfmirror_average_response_seconds gauge\n")
 	sb.WriteString("tfmirror_average_response_seconds ")
 	sb.WriteString(formatFloat(metrics.AverageResponse.Seconds()))
 	sb.WriteString("\n")
 
+	// Request duration histogram
+	sb.WriteString("# HELP tfmirror_request_duration_seconds HTTP request duration in seconds\n")
+	sb.WriteString("# TYPE tfmirror_request_duration_seconds histogram\n")
+	for i, bound := range durationBuckets {
+		sb.WriteString("tfmirror_request_duration_seconds_bucket{le=\"")
+		sb.WriteString(strconv.FormatFloat(bound, 'g', -1, 64))
+		sb.WriteString("\"} ")
+		sb.WriteString(formatInt(metrics.DurationBuckets[i]))
+		sb.WriteString("\n")
+	}
+	sb.WriteString("tfmirror_request_duration_seconds_bucket{le=\"+Inf\"} ")
+	sb.WriteString(formatInt(metrics.RequestCount))
+	sb.WriteString("\n")
+	sb.WriteString("tfmirror_request_duration_seconds_sum ")
+	sb.WriteString(formatFloat(metrics.DurationSum))
+	sb.WriteString("\n")
+	sb.WriteString("tfmirror_request_duration_seconds_count ")
+	sb.WriteString(formatInt(metrics.RequestCount))
+	sb.WriteString("\n")
+
 	// Last request time (as unix timestamp)
 	sb.WriteString("# HELP tfmirror_last_request_unixtime Last request time as unix timestamp\n")
 	sb.WriteString("# TYPE tfmirror_last_request_unixtime gauge\n")
@@ -341,38 +450,71 @@ func escapeLabel(s string) string {
 	return s
 }
 
-// countVersionsAndPlatforms counts total versions and platforms
+// countsRefreshInterval is how often the provider/version/platform gauges are recomputed
+const countsRefreshInterval = time.Minute
+
+// refreshCounts recomputes the content gauges at startup and then every
+// countsRefreshInterval until done is closed, keeping the walk off the scrape path
+func (s *Server) refreshCounts(done <-chan struct{}) {
+	ticker := time.NewTicker(countsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		providers, _ := s.cachedProviders()
+		totalVersions, totalPlatforms := s.countVersionsAndPlatforms()
+		s.metrics.UpdateCounts(len(providers), totalVersions, totalPlatforms)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// countVersionsAndPlatforms counts distinct provider versions and archives
+// (one per version and platform) under <registry-host>/<namespace>/<name>
 func (s *Server) countVersionsAndPlatforms() (int, int) {
-	totalVersions := 0
+	root := s.providerRoot()
+	versions := make(map[string]struct{})
 	totalPlatforms := 0
 
-	err := filepath.Walk(s.config.DataPath, func(path string, info os.FileInfo, err error) error {
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(root, path)
 		if err != nil {
 			return nil
 		}
-
-		if info.IsDir() {
-			relPath, err := filepath.Rel(s.config.DataPath, path)
-			if err != nil {
-				return nil
-			}
-
-			parts := strings.Split(relPath, string(os.PathSeparator))
-			if len(parts) == 3 { // namespace/name/version
-				totalVersions++
-			} else if len(parts) == 4 && len(parts[3]) > 0 { // namespace/name/version/platform
-				totalPlatforms++
-			}
+		parts := strings.Split(relPath, string(filepath.Separator))
+		if len(parts) != 3 { // namespace/name/file
+			return nil
+		}
+		if archive, ok := parseArchiveName(parts[1], parts[2]); ok {
+			versions[parts[0]+"/"+parts[1]+"@"+archive.Version] = struct{}{}
+			totalPlatforms++
 		}
-
 		return nil
 	})
 
-	if err != nil {
-		return 0, 0
-	}
+	return len(versions), totalPlatforms
+}
 
-	return totalVersions, totalPlatforms
+// isFullDownload reports whether a request fetched a resource rather than probing
+// it: a GET answered with 200, or a 206 for a range starting at byte 0
+func isFullDownload(r *http.Request, status int) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	switch status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		return strings.HasPrefix(r.Header.Get("Range"), "bytes=0-")
+	default:
+		return false
+	}
 }
 
 // metricsMiddleware wraps handlers to collect metrics
@@ -387,13 +529,22 @@ func (s *Server) metricsMiddleware(next http.Handler) http.Handler {
 
 		duration := time.Since(start)
 		endpoint := r.URL.Path
-		isError := wrapped.statusCode >= 400
+		status := wrapped.statusCode
+		if status == 0 {
+			// Handler wrote nothing, net/http sends an implicit 200
+			status = http.StatusOK
+		}
 
 		// Record metrics
-		s.metrics.RecordRequest(endpoint, duration, isError)
+		s.metrics.RecordRequest(endpoint, r.Method, status, duration)
+		// net/http discards HEAD bodies, so nothing actually went out
+		if r.Method != http.MethodHead {
+			s.metrics.RecordBytesServed(wrapped.bytesWritten)
+		}
 
-		// Record provider served for download endpoints
-		if r.URL.Path != "" && len(r.URL.Path) > 1 {
+		// Record provider served for download endpoints. HEAD probes and
+		// follow-up Range chunks are not counted as separate downloads.
+		if isFullDownload(r, status) && len(r.URL.Path) > 1 {
 			// Check if this is a provider download
 			pathParts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
 			if len(pathParts) >= 2 {
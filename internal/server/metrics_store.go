@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// metricsFile holds counters persisted across restarts, inside the data path
+	metricsFile = ".tf-mirror-metrics.json"
+
+	// metricsPersistInterval is how often counters are flushed to disk
+	metricsPersistInterval = 30 * time.Second
+)
+
+// persistedMetrics is the on-disk form of the monotonic counters in Metrics.
+// Gauges (disk usage, system info, last access times) are not persisted.
+type persistedMetrics struct {
+	SavedAt         time.Time        `json:"saved_at"`
+	RequestCount    int64            `json:"request_count"`
+	ErrorCount      int64            `json:"error_count"`
+	BytesServed     int64            `json:"bytes_served"`
+	ProvidersServed map[string]int64 `json:"providers_served"`
+	RequestsByCode  map[string]int64 `json:"requests_by_code"` // "METHOD CODE" -> count
+	DurationBuckets []int64          `json:"duration_buckets"`
+	DurationSum     float64          `json:"duration_sum_seconds"`
+	Endpoints       map[string]int64 `json:"endpoint_requests"`
+	EndpointErrors  map[string]int64 `json:"endpoint_errors"`
+}
+
+// Save writes the counters to path atomically
+func (m *Metrics) Save(path string) error {
+	m.mu.RLock()
+	state := persistedMetrics{
+		SavedAt:         time.Now().UTC(),
+		RequestCount:    m.RequestCount,
+		ErrorCount:      m.ErrorCount,
+		BytesServed:     m.BytesServed,
+		ProvidersServed: m.ProvidersServed,
+		RequestsByCode:  make(map[string]int64, len(m.RequestsByCode)),
+		DurationBuckets: m.DurationBuckets,
+		DurationSum:     m.DurationSum,
+		Endpoints:       make(map[string]int64, len(m.EndpointStats)),
+		EndpointErrors:  make(map[string]int64, len(m.EndpointStats)),
+	}
+	for key, count := range m.RequestsByCode {
+		state.RequestsByCode[key.Method+" "+key.Code] = count
+	}
+	for endpoint, stat := range m.EndpointStats {
+		state.Endpoints[endpoint] = stat.RequestCount
+		state.EndpointErrors[endpoint] = stat.ErrorCount
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	m.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// Load adds the counters stored at path to the in-memory ones, so Prometheus
+// counters keep increasing across restarts. A missing file is not an error.
+func (m *Metrics) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read metrics: %w", err)
+	}
+
+	var state persistedMetrics
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse metrics: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.RequestCount += state.RequestCount
+	m.ErrorCount += state.ErrorCount
+	m.BytesServed += state.BytesServed
+	for provider, count := range state.ProvidersServed {
+		m.ProvidersServed[provider] += count
+	}
+	for key, count := range state.RequestsByCode {
+		method, code, ok := strings.Cut(key, " ")
+		if !ok {
+			continue
+		}
+		m.RequestsByCode[requestKey{Method: normalizeMethod(method), Code: code}] += count
+	}
+	// Bucket bounds may change between releases; only reuse counts if they line up
+	if len(state.DurationBuckets) == len(m.DurationBuckets) {
+		for i, count := range state.DurationBuckets {
+			m.DurationBuckets[i] += count
+		}
+		m.DurationSum += state.DurationSum
+	}
+	for endpoint, count := range state.Endpoints {
+		stat := m.EndpointStats[endpoint]
+		stat.RequestCount += count
+		stat.ErrorCount += state.EndpointErrors[endpoint]
+		m.EndpointStats[endpoint] = stat
+	}
+	if m.RequestCount > 0 {
+		m.AverageResponse = time.Duration(m.DurationSum / float64(m.RequestCount) * float64(time.Second))
+	}
+	return nil
+}
+
+// metricsPath returns where the server persists its counters
+func (s *Server) metricsPath() string {
+	return filepath.Join(s.config.DataPath, metricsFile)
+}
+
+// persistMetrics flushes the counters every metricsPersistInterval until done
+// is closed. Only the first failure is logged as a warning, since a read-only
+// data path would otherwise repeat it every interval.
+func (s *Server) persistMetrics(done <-chan struct{}) {
+	ticker := time.NewTicker(metricsPersistInterval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.metrics.Save(s.metricsPath()); err != nil {
+				if !warned {
+					s.logger.Warn("Failed to persist metrics: %v", err)
+					warned = true
+				} else {
+					s.logger.Debug("Failed to persist metrics: %v", err)
+				}
+			}
+		}
+	}
+}
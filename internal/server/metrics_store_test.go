@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestMetricsPersistAcrossRestarts(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      string // file content instead of a saved run ("" = save one)
+		wantErr     bool
+		wantResumed bool
+	}{
+		{name: "saved counters resume", wantResumed: true},
+		{name: "missing file starts at zero", stored: "-"},
+		{name: "corrupt file is reported", stored: "{", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), metricsFile)
+			switch tt.stored {
+			case "":
+				previous := NewMetrics()
+				previous.RecordRequest("/v1/providers", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+				previous.RecordRequest("/missing", http.MethodGet, http.StatusNotFound, time.Millisecond)
+				previous.RecordProviderServed("hashicorp/null")
+				previous.RecordBytesServed(1000)
+				if err := previous.Save(path); err != nil {
+					t.Fatalf("Save: %v", err)
+				}
+			case "-":
+			default:
+				if err := os.WriteFile(path, []byte(tt.stored), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			m := NewMetrics()
+			err := m.Load(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load error = %v, want error %t", err, tt.wantErr)
+			}
+			// Requests after the restart add to the resumed counters
+			m.RecordRequest("/v1/providers", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+			got := m.GetMetrics()
+
+			want := struct {
+				requests, errors, bytes, served, ok200, bucket int64
+			}{requests: 1, ok200: 1, bucket: 1}
+			if tt.wantResumed {
+				want.requests, want.errors, want.bytes, want.served, want.ok200, want.bucket = 3, 1, 1000, 1, 2, 3
+			}
+			if got.RequestCount != want.requests || got.ErrorCount != want.errors || got.BytesServed != want.bytes {
+				t.Errorf("requests, errors, bytes = %d, %d, %d, want %d, %d, %d",
+					got.RequestCount, got.ErrorCount, got.BytesServed, want.requests, want.errors, want.bytes)
+			}
+			if served := got.ProvidersServed["hashicorp/null"]; served != want.served {
+				t.Errorf("hashicorp/null served %d times, want %d", served, want.served)
+			}
+			if ok := got.RequestsByCode[requestKey{Method: http.MethodGet, Code: "200"}]; ok != want.ok200 {
+				t.Errorf("GET 200 count = %d, want %d", ok, want.ok200)
+			}
+			// 1ms and 20ms both fall into the cumulative 0.025s bucket
+			if bucket := got.DurationBuckets[2]; bucket != want.bucket {
+				t.Errorf("le=0.025 bucket = %d, want %d", bucket, want.bucket)
+			}
+		})
+	}
+}
+
+func TestServerResumesMetrics(t *testing.T) {
+	dataPath := t.TempDir()
+	previous := NewMetrics()
+	previous.RecordBytesServed(4096)
+	if err := previous.Save(filepath.Join(dataPath, metricsFile)); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	srv := newTestServer(t, &common.ServerConfig{DataPath: dataPath})
+	if got := scrape(t, srv)["tfmirror_bytes_served_total"]; got != 4096 {
+		t.Errorf("tfmirror_bytes_served_total = %v after a restart, want 4096", got)
+	}
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// scrape returns the samples of /metrics, keyed by series with labels
+func scrape(t *testing.T, srv *Server) map[string]float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	srv.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("bad sample %q: %v", line, err)
+		}
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+func TestDurationHistogram(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      map[string]float64
+	}{
+		{
+			name: "no requests",
+			want: map[string]float64{`tfmirror_request_duration_seconds_bucket{le="0.005"}`: 0, `tfmirror_request_duration_seconds_bucket{le="+Inf"}`: 0},
+		},
+		{
+			name:      "spread over the buckets",
+			durations: []time.Duration{time.Millisecond, 20 * time.Millisecond, 300 * time.Millisecond, 3 * time.Second, 30 * time.Second},
+			want: map[string]float64{
+				`tfmirror_request_duration_seconds_bucket{le="0.005"}`: 1,
+				`tfmirror_request_duration_seconds_bucket{le="0.025"}`: 2,
+				`tfmirror_request_duration_seconds_bucket{le="0.5"}`:   3,
+				`tfmirror_request_duration_seconds_bucket{le="5"}`:     4,
+				`tfmirror_request_duration_seconds_bucket{le="10"}`:    4,
+				`tfmirror_request_duration_seconds_bucket{le="+Inf"}`:  5,
+				`tfmirror_request_duration_seconds_count`:              5,
+			},
+		},
+		{
+			name:      "on a bucket bound",
+			durations: []time.Duration{100 * time.Millisecond},
+			want: map[string]float64{
+				`tfmirror_request_duration_seconds_bucket{le="0.05"}`: 0,
+				`tfmirror_request_duration_seconds_bucket{le="0.1"}`:  1,
+				`tfmirror_request_duration_seconds_sum`:               0.1,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			for _, d := range tt.durations {
+				srv.metrics.RecordRequest("/v1/providers", http.MethodGet, http.StatusOK, d)
+			}
+			samples := scrape(t, srv)
+
+			for series, want := range tt.want {
+				if got, ok := samples[series]; !ok || got != want {
+					t.Errorf("%s = %v (present %t), want %v", series, got, ok, want)
+				}
+			}
+
+			// Buckets are cumulative: never decreasing, ending at the count
+			bounds := []string{}
+			for _, bound := range durationBuckets {
+				bounds = append(bounds, strconv.FormatFloat(bound, 'g', -1, 64))
+			}
+			previous := 0.0
+			for _, le := range append(bounds, "+Inf") {
+				got := samples[`tfmirror_request_duration_seconds_bucket{le="`+le+`"}`]
+				if got < previous {
+					t.Errorf("bucket le=%s = %v, below the previous bucket %v", le, got, previous)
+				}
+				previous = got
+			}
+			if count := samples["tfmirror_request_duration_seconds_count"]; previous != count {
+				t.Errorf("+Inf bucket = %v, want the count %v", previous, count)
+			}
+		})
+	}
+}
+
+func TestBytesServed(t *testing.T) {
+	archive := "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name   string
+		method string
+		rangeH string
+		want   int64
+	}{
+		{name: "full download", method: http.MethodGet, want: 1000},
+		{name: "range", method: http.MethodGet, rangeH: "bytes=0-99", want: 100},
+		{name: "head", method: http.MethodHead, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			writeTestFile(t, filepath.Join(srv.config.DataPath, filepath.FromSlash(archive)), strings.Repeat("a", 1000))
+			before := srv.metrics.GetMetrics().BytesServed
+
+			req := httptest.NewRequest(tt.method, archive, nil)
+			if tt.rangeH != "" {
+				req.Header.Set("Range", tt.rangeH)
+			}
+			serve(srv, req)
+
+			if got := srv.metrics.GetMetrics().BytesServed - before; got != tt.want {
+				t.Errorf("bytes served grew by %d, want %d", got, tt.want)
+			}
+			if got := scrape(t, srv)["tfmirror_bytes_served_total"]; int64(got) != before+tt.want {
+				t.Errorf("tfmirror_bytes_served_total = %v, want %d", got, before+tt.want)
+			}
+		})
+	}
+}
+
+func TestContentGauges(t *testing.T) {
+	tests := []struct {
+		name          string
+		files         []string // under registry.terraform.io
+		wantProviders float64
+		wantVersions  float64
+		wantPlatforms float64
+	}{
+		{name: "empty mirror"},
+		{
+			name: "one provider",
+			files: []string{
+				"hashicorp/null/terraform-provider-null_3.1.0_linux_amd64.zip",
+				"hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip",
+				"hashicorp/null/terraform-provider-null_3.2.0_darwin_arm64.zip",
+				"hashicorp/null/index.json",
+				"hashicorp/null/3.2.0.json",
+			},
+			wantProviders: 1, wantVersions: 2, wantPlatforms: 3,
+		},
+		{
+			name: "same version in two providers",
+			files: []string{
+				"hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip",
+				"hashicorp/random/terraform-provider-random_3.2.0_linux_amd64.zip",
+				"integrations/github/terraform-provider-github_6.0.0_windows_amd64.zip",
+			},
+			wantProviders: 3, wantVersions: 3, wantPlatforms: 3,
+		},
+		{
+			name:          "archive of another provider name",
+			files:         []string{"hashicorp/null/terraform-provider-random_3.2.0_linux_amd64.zip"},
+			wantProviders: 1, wantVersions: 0, wantPlatforms: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataPath := t.TempDir()
+			for _, file := range tt.files {
+				writeTestFile(t, filepath.Join(dataPath, "registry.terraform.io", filepath.FromSlash(file)), "x")
+			}
+			srv := newTestServer(t, &common.ServerConfig{DataPath: dataPath})
+			// The gauges are filled by the background refresh Start begins
+			srv.startBackground()
+
+			want := map[string]float64{
+				"tfmirror_providers_total": tt.wantProviders,
+				"tfmirror_versions_total":  tt.wantVersions,
+				"tfmirror_platforms_total": tt.wantPlatforms,
+			}
+			mismatch := func(samples map[string]float64) []string {
+				var errs []string
+				for series, value := range want {
+					if samples[series] != value {
+						errs = append(errs, fmt.Sprintf("%s = %v, want %v", series, samples[series], value))
+					}
+				}
+				return errs
+			}
+			errs := mismatch(scrape(t, srv))
+			for deadline := time.Now().Add(2 * time.Second); len(errs) > 0 && time.Now().Before(deadline); {
+				time.Sleep(10 * time.Millisecond)
+				errs = mismatch(scrape(t, srv))
+			}
+			for _, err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
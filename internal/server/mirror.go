@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// handleMirrorIndex serves <hostname>/<namespace>/<type>/index.json
+// as defined by the Network Mirror Protocol
+func (s *Server) handleMirrorIndex(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s.serveMirrorJSON(w, r, vars["hostname"], vars["namespace"], vars["type"], "index.json", "Provider not found")
+}
+
+// handleMirrorVersion serves <hostname>/<namespace>/<type>/<version>.json
+// as defined by the Network Mirror Protocol
+func (s *Server) handleMirrorVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s.serveMirrorJSON(w, r, vars["hostname"], vars["namespace"], vars["type"], vars["version"]+".json", "Provider version not found")
+}
+
+// serveMirrorJSON serves a generated mirror JSON file with the proper content type.
+// http.ServeContent takes care of HEAD, Range and conditional requests.
+func (s *Server) serveMirrorJSON(w http.ResponseWriter, r *http.Request, hostname, namespace, providerType, filename, notFound string) {
+	for _, segment := range []string{hostname, namespace, providerType, filename} {
+		if !isSafePathSegment(segment) {
+			s.writeErrorResponse(w, http.StatusNotFound, notFound)
+			return
+		}
+	}
+
+	filePath := filepath.Join(s.config.DataPath, hostname, namespace, providerType, filename)
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		s.writeErrorResponse(w, http.StatusNotFound, notFound)
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to read %s: %v", filePath, err)
+		s.writeErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		s.writeErrorResponse(w, http.StatusNotFound, notFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeContent(w, r, filename, info.ModTime(), file)
+}
+
+// isSafePathSegment rejects empty, hidden and traversal path segments
+func isSafePathSegment(segment string) bool {
+	return segment != "" && !strings.HasPrefix(segment, ".") && !strings.ContainsAny(segment, `/\`)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"tf-mirror/internal/common"
+)
+
+// modulesDir is where the downloader stores module archives under the data path
+const modulesDir = "modules"
+
+// handleModuleList handles /v1/modules and lists the mirrored modules
+func (s *Server) handleModuleList(w http.ResponseWriter, r *http.Request) {
+	modules := []common.ModuleListItem{}
+
+	root := filepath.Join(s.config.DataPath, modulesDir)
+	matches, _ := filepath.Glob(filepath.Join(root, "*", "*", "*", "index.json"))
+	for _, match := range matches {
+		rel, err := filepath.Rel(root, filepath.Dir(match))
+		if err != nil {
+			continue
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			continue
+		}
+		index, err := s.readModuleIndex(parts[0], parts[1], parts[2])
+		if err != nil || len(index.Versions) == 0 {
+			continue
+		}
+		versions := make([]string, 0, len(index.Versions))
+		for version := range index.Versions {
+			versions = append(versions, version)
+		}
+		sortVersions(versions)
+		modules = append(modules, common.ModuleListItem{
+			Namespace: parts[0],
+			Name:      parts[1],
+			System:    parts[2],
+			Versions:  versions,
+		})
+	}
+
+	s.writeJSONResponse(w, map[string]any{"modules": modules})
+}
+
+// handleModuleVersions handles /v1/modules/{namespace}/{name}/{system}/versions
+// from the Module Registry Protocol
+func (s *Server) handleModuleVersions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	index, err := s.readModuleIndex(vars["namespace"], vars["name"], vars["system"])
+	if err != nil || len(index.Versions) == 0 {
+		s.writeErrorResponse(w, http.StatusNotFound, "Module not found")
+		return
+	}
+
+	versions := make([]string, 0, len(index.Versions))
+	for version := range index.Versions {
+		versions = append(versions, version)
+	}
+	sortVersions(versions)
+
+	list := common.ModuleVersionList{Versions: make([]common.ModuleVersion, 0, len(versions))}
+	for _, version := range versions {
+		list.Versions = append(list.Versions, common.ModuleVersion{Version: version})
+	}
+	s.writeJSONResponse(w, common.ModuleVersions{Modules: []common.ModuleVersionList{list}})
+}
+
+// handleModuleDownload handles /v1/modules/{namespace}/{name}/{system}/{version}/download
+// from the Module Registry Protocol: an empty 204 response whose
+// X-Terraform-Get header points at the mirrored archive
+func (s *Server) handleModuleDownload(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace, name, system := vars["namespace"], vars["name"], vars["system"]
+
+	index, err := s.readModuleIndex(namespace, name, system)
+	if err != nil {
+		s.writeErrorResponse(w, http.StatusNotFound, "Module not found")
+		return
+	}
+	archive, ok := index.Versions[vars["version"]]
+	if !ok || !isSafePathSegment(archive.Filename) {
+		s.writeErrorResponse(w, http.StatusNotFound, "Module version not found")
+		return
+	}
+	if _, err := os.Stat(filepath.Join(s.config.DataPath, modulesDir, namespace, name, system, archive.Filename)); err != nil {
+		s.writeErrorResponse(w, http.StatusNotFound, "Module version not found")
+		return
+	}
+
+	location := s.baseURL(r) + "/" + modulesDir + "/" + namespace + "/" + name + "/" + system + "/" + archive.Filename
+	if archive.Subdir != "" {
+		location += "//" + archive.Subdir
+	}
+	w.Header().Set("X-Terraform-Get", location)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readModuleIndex reads modules/<namespace>/<name>/<system>/index.json
+func (s *Server) readModuleIndex(namespace, name, system string) (common.ModuleIndex, error) {
+	var index common.ModuleIndex
+	for _, segment := range []string{namespace, name, system} {
+		if !isSafePathSegment(segment) {
+			return index, os.ErrNotExist
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.config.DataPath, modulesDir, namespace, name, system, "index.json"))
+	if err != nil {
+		return index, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return index, err
+	}
+	return index, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// providerCacheTTL bounds how long a provider scan is reused even when no
+// directory mtime changed (e.g. on filesystems with coarse mtimes)
+const providerCacheTTL = 5 * time.Minute
+
+// providerCheckInterval limits how often requests compare directory mtimes.
+// Changes within it are picked up by watchProviders, which refreshes the
+// cache as soon as the tree changes.
+const providerCheckInterval = 5 * time.Second
+
+// providerCache holds the last scanProviders result. It is invalidated when
+// the mtime of the registry host directory or any namespace directory changes,
+// which happens whenever a provider directory is added or removed.
+type providerCache struct {
+	mu        sync.RWMutex
+	providers []common.ProviderListItem
+	signature time.Time // latest mtime seen across the watched directories
+	scannedAt time.Time
+	checkedAt time.Time // last time signature was compared with the tree
+}
+
+// cachedProviders returns the provider list, rescanning only when stale.
+// The directory mtimes are read at most once per providerCheckInterval.
+func (s *Server) cachedProviders() ([]common.ProviderListItem, error) {
+	s.providers.mu.RLock()
+	scanned := !s.providers.scannedAt.IsZero() && time.Since(s.providers.scannedAt) < providerCacheTTL
+	checked := time.Since(s.providers.checkedAt) < providerCheckInterval
+	providers, known := s.providers.providers, s.providers.signature
+	s.providers.mu.RUnlock()
+
+	if scanned && checked {
+		return slices.Clone(providers), nil
+	}
+	if scanned && s.providerTreeSignature().Equal(known) {
+		s.providers.mu.Lock()
+		s.providers.checkedAt = time.Now()
+		s.providers.mu.Unlock()
+		return slices.Clone(providers), nil
+	}
+	return s.RefreshProviders()
+}
+
+// RefreshProviders rescans the data directory and replaces the cached provider list
+func (s *Server) RefreshProviders() ([]common.ProviderListItem, error) {
+	signature := s.providerTreeSignature()
+	providers, err := s.scanProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	s.providers.mu.Lock()
+	s.providers.providers = providers
+	s.providers.signature = signature
+	s.providers.scannedAt = time.Now()
+	s.providers.checkedAt = s.providers.scannedAt
+	s.providers.mu.Unlock()
+
+	return slices.Clone(providers), nil
+}
+
+// providerTreeSignature returns the newest mtime of the provider root and its
+// namespace directories. Only two directory levels are read, not the archives.
+func (s *Server) providerTreeSignature() time.Time {
+	root := s.providerRoot()
+	info, err := os.Stat(root)
+	if err != nil {
+		return time.Time{}
+	}
+	latest := info.ModTime()
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return latest
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if nsInfo, err := entry.Info(); err == nil && nsInfo.ModTime().After(latest) {
+			latest = nsInfo.ModTime()
+		}
+	}
+	return latest
+}
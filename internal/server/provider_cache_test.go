@@ -0,0 +1,50 @@
+package server
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestCachedProvidersCheckInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		advance  func(srv *Server) // what happens after the new provider appears
+		wantSeen bool
+	}{
+		{name: "within the check interval", advance: func(*Server) {}},
+		{name: "check interval elapsed", advance: func(srv *Server) {
+			srv.providers.checkedAt = time.Now().Add(-providerCheckInterval)
+		}, wantSeen: true},
+		{name: "cache TTL elapsed", advance: func(srv *Server) {
+			srv.providers.scannedAt = time.Now().Add(-providerCacheTTL)
+		}, wantSeen: true},
+		{name: "invalidated by the watcher", advance: func(srv *Server) { srv.invalidateCaches() }, wantSeen: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			root := filepath.Join(srv.config.DataPath, "registry.terraform.io")
+			writeTestFile(t, filepath.Join(root, "hashicorp", "null", "index.json"), `{"versions":{}}`)
+			if providers, err := srv.cachedProviders(); err != nil || len(providers) != 1 {
+				t.Fatalf("cachedProviders = %v, %v, want hashicorp/null", providers, err)
+			}
+
+			// A new namespace changes the mtime of the registry host directory
+			writeTestFile(t, filepath.Join(root, "other", "tool", "index.json"), `{"versions":{}}`)
+			tt.advance(srv)
+
+			providers, err := srv.cachedProviders()
+			if err != nil {
+				t.Fatalf("cachedProviders: %v", err)
+			}
+			seen := slices.ContainsFunc(providers, func(p common.ProviderListItem) bool { return p.Namespace == "other" })
+			if seen != tt.wantSeen {
+				t.Errorf("other/tool listed = %t, want %t", seen, tt.wantSeen)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+// fixtureProviders is the tree written by writeProviderFixture:
+// "namespace/name" -> version -> platforms
+var fixtureProviders = map[string]map[string][]string{
+	"acme/nullish":     {"1.0.0": {"windows_amd64"}},
+	"hashicorp/aws":    {"5.0.0": {"linux_amd64", "darwin_arm64"}},
+	"hashicorp/null":   {"3.1.0": {"linux_amd64"}, "3.2.0": {"linux_amd64", "darwin_arm64"}},
+	"hashicorp/random": {"3.6.0": {"linux_amd64"}},
+	"other/tool":       {"0.1.0": {"linux_arm64"}},
+}
+
+// writeProviderFixture writes fixtureProviders under dataPath as the
+// downloader does: archives, index.json and <version>.json
+func writeProviderFixture(t *testing.T, dataPath string) {
+	t.Helper()
+	for key, versions := range fixtureProviders {
+		namespace, name, _ := strings.Cut(key, "/")
+		dir := filepath.Join(dataPath, common.DefaultRegistryHost, namespace, name)
+		index := map[string]map[string]any{"versions": {}}
+		for version, platforms := range versions {
+			index["versions"][version] = map[string]any{}
+			archives := make(map[string]any)
+			for _, platform := range platforms {
+				filename := fmt.Sprintf("terraform-provider-%s_%s_%s.zip", name, version, platform)
+				writeTestFile(t, filepath.Join(dir, filename), filename)
+				archives[platform] = map[string]any{"url": filename, "hashes": []string{"h1:" + filename}}
+			}
+			writeTestJSON(t, filepath.Join(dir, version+".json"), map[string]any{"archives": archives})
+		}
+		writeTestJSON(t, filepath.Join(dir, "index.json"), index)
+	}
+}
+
+// writeTestJSON writes v as JSON to path
+func writeTestJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, string(data))
+}
+
+// listProviders requests /providers?query and returns the listing as
+// "namespace/name" entries
+func listProviders(t *testing.T, srv *Server, query string) ([]string, *common.ListMeta, int) {
+	t.Helper()
+	rec := serve(srv, httptest.NewRequest(http.MethodGet, "/providers?"+query, nil))
+	if rec.Code != http.StatusOK {
+		return nil, nil, rec.Code
+	}
+	var list common.ProviderList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode /providers: %v", err)
+	}
+	names := []string{}
+	for _, p := range list.Providers {
+		names = append(names, p.Namespace+"/"+p.Name)
+	}
+	return names, list.Meta, rec.Code
+}
+
+func TestProviderListPagination(t *testing.T) {
+	srv := newTestServer(t, &common.ServerConfig{})
+	writeProviderFixture(t, srv.config.DataPath)
+	all := []string{"acme/nullish", "hashicorp/aws", "hashicorp/null", "hashicorp/random", "other/tool"}
+	offset := func(n int) *int { return &n }
+
+	tests := []struct {
+		name       string
+		query      string
+		want       []string
+		wantStatus int
+		wantNext   *int
+		wantPrev   *int
+		wantURL    string
+	}{
+		{name: "default page", query: "", want: all},
+		{name: "first page", query: "limit=2", want: all[:2], wantNext: offset(2), wantURL: "/providers?limit=2&offset=2"},
+		{name: "middle page", query: "limit=2&offset=2", want: all[2:4], wantNext: offset(4), wantPrev: offset(0), wantURL: "/providers?limit=2&offset=4"},
+		{name: "last page", query: "limit=2&offset=4", want: all[4:], wantPrev: offset(2)},
+		{name: "past the end", query: "limit=2&offset=10", want: []string{}, wantPrev: offset(8)},
+		{name: "limit too large", query: "limit=1001", wantStatus: http.StatusBadRequest},
+		{name: "zero limit", query: "limit=0", wantStatus: http.StatusBadRequest},
+		{name: "negative offset", query: "offset=-1", wantStatus: http.StatusBadRequest},
+		{name: "not a number", query: "limit=ten", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantStatus := tt.wantStatus
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			got, meta, status := listProviders(t, srv, tt.query)
+			if status != wantStatus {
+				t.Fatalf("status = %d, want %d", status, wantStatus)
+			}
+			if status != http.StatusOK {
+				return
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("providers = %v, want %v", got, tt.want)
+			}
+			if !sameOffset(meta.NextOffset, tt.wantNext) || !sameOffset(meta.PrevOffset, tt.wantPrev) {
+				t.Errorf("next/prev offset = %v/%v, want %v/%v", deref(meta.NextOffset), deref(meta.PrevOffset), deref(tt.wantNext), deref(tt.wantPrev))
+			}
+			if meta.NextURL != tt.wantURL {
+				t.Errorf("next_url = %q, want %q", meta.NextURL, tt.wantURL)
+			}
+		})
+	}
+}
+
+func sameOffset(a, b *int) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// deref formats an optional offset for messages
+func deref(p *int) string {
+	if p == nil {
+		return "none"
+	}
+	return fmt.Sprint(*p)
+}
+
+func TestProviderListFilters(t *testing.T) {
+	srv := newTestServer(t, &common.ServerConfig{})
+	writeProviderFixture(t, srv.config.DataPath)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "namespace", query: "namespace=hashicorp", want: []string{"hashicorp/aws", "hashicorp/null", "hashicorp/random"}},
+		{name: "namespace is case-insensitive", query: "namespace=HashiCorp", want: []string{"hashicorp/aws", "hashicorp/null", "hashicorp/random"}},
+		{name: "unknown namespace", query: "namespace=nobody", want: []string{}},
+		{name: "name substring", query: "q=null", want: []string{"acme/nullish", "hashicorp/null"}},
+		{name: "name substring is case-insensitive", query: "q=RAND", want: []string{"hashicorp/random"}},
+		{name: "q does not match namespaces", query: "q=hashicorp", want: []string{}},
+		{name: "platform", query: "platform=darwin_arm64", want: []string{"hashicorp/aws", "hashicorp/null"}},
+		{name: "platform with a slash", query: "platform=linux/arm64", want: []string{"other/tool"}},
+		{name: "namespace and q", query: "namespace=hashicorp&q=null", want: []string{"hashicorp/null"}},
+		{name: "namespace and platform", query: "namespace=acme&platform=windows_amd64", want: []string{"acme/nullish"}},
+		{name: "q and platform", query: "q=null&platform=linux_amd64", want: []string{"hashicorp/null"}},
+		{name: "all three", query: "namespace=hashicorp&q=an&platform=linux_amd64", want: []string{"hashicorp/random"}},
+		{name: "no match", query: "namespace=hashicorp&platform=windows_amd64", want: []string{}},
+		{name: "filters before pagination", query: "namespace=hashicorp&limit=1&offset=1", want: []string{"hashicorp/null"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, status := listProviders(t, srv, tt.query)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want %d", status, http.StatusOK)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("providers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderDetail(t *testing.T) {
+	srv := newTestServer(t, &common.ServerConfig{})
+	writeProviderFixture(t, srv.config.DataPath)
+	dir := filepath.Join(srv.config.DataPath, common.DefaultRegistryHost, "hashicorp", "random")
+	// a version with recorded protocols, and one listed before its file exists
+	writeTestJSON(t, filepath.Join(dir, "3.7.0.json"), map[string]any{
+		"archives":  map[string]any{"linux_arm64": map[string]any{"url": "x.zip"}, "darwin_amd64": map[string]any{"url": "y.zip"}},
+		"protocols": []string{"5.0", "6.0"},
+	})
+	writeTestJSON(t, filepath.Join(dir, "index.json"), map[string]any{"versions": map[string]any{"3.6.0": map[string]any{}, "3.7.0": map[string]any{}, "3.8.0": map[string]any{}}})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		want       []common.ProviderVersionDetail // newest first
+	}{
+		{
+			path:       "/providers/hashicorp/null",
+			wantStatus: http.StatusOK,
+			want: []common.ProviderVersionDetail{
+				{Version: "3.2.0", Protocols: []string{"5.0"}, Platforms: []string{"darwin_arm64", "linux_amd64"}},
+				{Version: "3.1.0", Protocols: []string{"5.0"}, Platforms: []string{"linux_amd64"}},
+			},
+		},
+		{
+			path:       "/providers/hashicorp/random",
+			wantStatus: http.StatusOK,
+			want: []common.ProviderVersionDetail{
+				{Version: "3.7.0", Protocols: []string{"5.0", "6.0"}, Platforms: []string{"darwin_amd64", "linux_arm64"}},
+				{Version: "3.6.0", Protocols: []string{"5.0"}, Platforms: []string{"linux_amd64"}},
+			},
+		},
+		{path: "/providers/hashicorp/unknown", wantStatus: http.StatusNotFound},
+		{path: "/providers/nobody/null", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("Content-Type = %q, want JSON", ct)
+			}
+			if rec.Code != http.StatusOK {
+				var errResp common.ErrorResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || len(errResp.Errors) != 1 || errResp.Errors[0].Status != "404" {
+					t.Errorf("error body = %s, want a JSON 404", rec.Body)
+				}
+				return
+			}
+
+			var detail common.ProviderDetail
+			if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			got, _ := json.Marshal(detail.Versions)
+			want, _ := json.Marshal(tt.want)
+			if string(got) != string(want) {
+				t.Errorf("versions = %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader"
+)
+
+// pullThroughTimeout bounds a single on-demand fetch, retries included
+const pullThroughTimeout = 15 * time.Minute
+
+// Fetcher fetches provider archives missing from the data path from the
+// upstream registry. The downloader service implements it.
+type Fetcher interface {
+	FetchArchive(ctx context.Context, namespace, name, version, os, arch string) error
+	ProviderVersions(ctx context.Context, namespace, name string) (*common.ProviderVersions, error)
+}
+
+// pullThrough runs on-demand fetches, one per archive: concurrent requests
+// for the same missing archive wait for the fetch already in flight
+type pullThrough struct {
+	fetcher  Fetcher
+	mu       sync.Mutex
+	inflight map[string]*pullCall
+}
+
+type pullCall struct {
+	done chan struct{}
+	err  error
+}
+
+// EnablePullThrough makes the server fetch missing provider archives from the
+// upstream registry, store them in the data path and serve them. It must be
+// called before Start.
+func (s *Server) EnablePullThrough(fetcher Fetcher) {
+	s.pull.fetcher = fetcher
+	s.pull.inflight = make(map[string]*pullCall)
+}
+
+// pullArchive fetches a missing archive, or waits for the fetch of another
+// request. The fetch is not tied to the request, so a client giving up does
+// not abort it for the others.
+func (s *Server) pullArchive(w http.ResponseWriter, r *http.Request, namespace, name, version, osName, arch string) error {
+	key := namespace + "/" + name + "/" + version + "/" + osName + "_" + arch
+
+	s.pull.mu.Lock()
+	call, ok := s.pull.inflight[key]
+	if !ok {
+		call = &pullCall{done: make(chan struct{})}
+		s.pull.inflight[key] = call
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), pullThroughTimeout)
+			defer cancel()
+			s.logger.Info("Pull-through: fetching %s/%s %s %s_%s", namespace, name, version, osName, arch)
+			call.err = s.pull.fetcher.FetchArchive(ctx, namespace, name, version, osName, arch)
+			if errors.Is(call.err, downloader.ErrPackageNotFound) || errors.Is(call.err, downloader.ErrNotMirrored) {
+				s.logger.Info("Pull-through: %v", call.err)
+			} else if call.err != nil {
+				s.logger.Warn("Pull-through: failed to fetch %s/%s %s %s_%s: %v", namespace, name, version, osName, arch, call.err)
+			}
+
+			s.pull.mu.Lock()
+			delete(s.pull.inflight, key)
+			s.pull.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	s.pull.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+	if call.err != nil {
+		return call.err
+	}
+
+	// The fetch may have used up most of --write-timeout
+	if s.config.WriteTimeout > 0 {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(s.config.WriteTimeout))
+	}
+	return nil
+}
+
+// pullThroughHandler fetches archives requested by their static path
+// (<registry-host>/<namespace>/<name>/terraform-provider-<name>_<version>_<os>_<arch>.zip)
+// before the file server looks for them
+func (s *Server) pullThroughHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.pull.fetcher == nil || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/"), "/")
+		if len(parts) != 4 || parts[0] != s.registryHost() || !isSafePathSegment(parts[1]) || !isSafePathSegment(parts[2]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		namespace, name, filename := parts[1], parts[2], parts[3]
+		archive, ok := parseArchiveName(name, filename)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := os.Stat(filepath.Join(s.providerDir(namespace, name), filename)); !os.IsNotExist(err) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := s.pullArchive(w, r, namespace, name, archive.Version, archive.OS, archive.Arch); err != nil {
+			s.writePullError(w, err, "Not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writePullError answers a failed fetch: 404 when upstream has no such
+// archive or it is filtered out, 502 when upstream could not be reached
+func (s *Server) writePullError(w http.ResponseWriter, err error, notFound string) {
+	if errors.Is(err, context.Canceled) {
+		return // the client went away
+	}
+	if errors.Is(err, downloader.ErrPackageNotFound) || errors.Is(err, downloader.ErrNotMirrored) {
+		s.writeErrorResponse(w, http.StatusNotFound, notFound)
+		return
+	}
+	s.writeErrorResponse(w, http.StatusBadGateway, "Upstream registry fetch failed")
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader"
+)
+
+// fakeFetcher stores an archive in the data path, or returns err. If release
+// is set, fetches wait for it to be closed.
+type fakeFetcher struct {
+	dataPath string
+	err      error
+	release  chan struct{}
+	calls    atomic.Int32
+}
+
+func (f *fakeFetcher) FetchArchive(ctx context.Context, namespace, name, version, osName, arch string) error {
+	f.calls.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	if f.err != nil {
+		return f.err
+	}
+	filename := fmt.Sprintf("terraform-provider-%s_%s_%s_%s.zip", name, version, osName, arch)
+	dir := filepath.Join(f.dataPath, common.DefaultRegistryHost, namespace, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, filename), []byte("archive "+version), 0644)
+}
+
+func (f *fakeFetcher) ProviderVersions(ctx context.Context, namespace, name string) (*common.ProviderVersions, error) {
+	return nil, downloader.ErrNotMirrored
+}
+
+func TestPullThroughArchive(t *testing.T) {
+	const archivePath = "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name       string
+		fetchErr   error
+		onDisk     bool
+		wantStatus int
+		wantBody   string
+		wantCalls  int32
+	}{
+		{name: "missing archive is fetched and served", wantStatus: http.StatusOK, wantBody: "archive 3.2.0", wantCalls: 1},
+		{name: "archive on disk is served without a fetch", onDisk: true, wantStatus: http.StatusOK, wantBody: "on disk", wantCalls: 0},
+		{name: "not published upstream", fetchErr: downloader.ErrPackageNotFound, wantStatus: http.StatusNotFound, wantCalls: 1},
+		{name: "filtered out", fetchErr: fmt.Errorf("%w: hashicorp/null 3.2.0", downloader.ErrNotMirrored), wantStatus: http.StatusNotFound, wantCalls: 1},
+		{name: "upstream unreachable", fetchErr: fmt.Errorf("connection refused"), wantStatus: http.StatusBadGateway, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			if tt.onDisk {
+				writeTestFile(t, filepath.Join(srv.config.DataPath, filepath.FromSlash(archivePath)), "on disk")
+			}
+			fetcher := &fakeFetcher{dataPath: srv.config.DataPath, err: tt.fetchErr}
+			srv.EnablePullThrough(fetcher)
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, archivePath, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if got := fetcher.calls.Load(); got != tt.wantCalls {
+				t.Errorf("fetches = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPullThroughMissThenHit(t *testing.T) {
+	const archivePath = "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name       string
+		concurrent int // requests of the first round, made at once
+	}{
+		{name: "sequential requests", concurrent: 1},
+		{name: "concurrent misses share one fetch", concurrent: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			fetcher := &fakeFetcher{dataPath: srv.config.DataPath, release: make(chan struct{})}
+			srv.EnablePullThrough(fetcher)
+
+			// First round: every request misses the disk
+			var wg sync.WaitGroup
+			codes := make([]int, tt.concurrent)
+			for i := range tt.concurrent {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					codes[i] = serve(srv, httptest.NewRequest(http.MethodGet, archivePath, nil)).Code
+				}()
+			}
+			// Let the requests pile up on the fetch before it completes
+			for fetcher.calls.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(50 * time.Millisecond)
+			close(fetcher.release)
+			wg.Wait()
+			for i, code := range codes {
+				if code != http.StatusOK {
+					t.Errorf("request %d of the miss: status %d", i, code)
+				}
+			}
+
+			// Second round: the archive is cached on disk
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, archivePath, nil))
+			if rec.Code != http.StatusOK || rec.Body.String() != "archive 3.2.0" {
+				t.Errorf("hit: status %d, body %q", rec.Code, rec.Body.String())
+			}
+			if got := fetcher.calls.Load(); got != 1 {
+				t.Errorf("fetches = %d, want 1", got)
+			}
+		})
+	}
+}
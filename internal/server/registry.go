@@ -0,0 +1,258 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver/v4"
+	"github.com/gorilla/mux"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/downloader"
+)
+
+// defaultProtocols is reported when the plugin protocol versions of an archive are unknown
+var defaultProtocols = []string{"5.0"}
+
+// providerArchive describes a provider archive found on disk
+type providerArchive struct {
+	Version  string
+	OS       string
+	Arch     string
+	Filename string
+}
+
+// handleRegistryVersions handles /v1/providers/{namespace}/{name}/versions
+// from the Provider Registry Protocol
+func (s *Server) handleRegistryVersions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace, name := vars["namespace"], vars["name"]
+
+	// With pull-through, clients see every upstream version and missing
+	// archives are fetched on download
+	if s.pull.fetcher != nil && isSafePathSegment(namespace) && isSafePathSegment(name) {
+		upstream, err := s.pull.fetcher.ProviderVersions(r.Context(), namespace, name)
+		if err == nil {
+			s.writeJSONResponse(w, upstream)
+			return
+		}
+		if !errors.Is(err, downloader.ErrNotMirrored) {
+			s.logger.Warn("Pull-through: serving local versions of %s/%s: %v", namespace, name, err)
+		}
+	}
+
+	archives, err := s.listProviderArchives(namespace, name)
+	if err != nil || len(archives) == 0 {
+		s.writeErrorResponse(w, http.StatusNotFound, "Provider not found")
+		return
+	}
+
+	byVersion := make(map[string]*common.Version)
+	var order []string
+	for _, archive := range archives {
+		v, ok := byVersion[archive.Version]
+		if !ok {
+			v = &common.Version{Version: archive.Version, Protocols: s.versionProtocols(namespace, name, archive.Version)}
+			byVersion[archive.Version] = v
+			order = append(order, archive.Version)
+		}
+		v.Platforms = append(v.Platforms, common.Platform{OS: archive.OS, Arch: archive.Arch})
+	}
+	sortVersions(order)
+
+	response := common.ProviderVersions{Versions: make([]common.Version, 0, len(order))}
+	for _, version := range order {
+		response.Versions = append(response.Versions, *byVersion[version])
+	}
+
+	s.writeJSONResponse(w, response)
+}
+
+// handleRegistryDownload handles /v1/providers/{namespace}/{name}/{version}/download/{os}/{arch}
+// from the Provider Registry Protocol
+func (s *Server) handleRegistryDownload(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	namespace, name, version := vars["namespace"], vars["name"], vars["version"]
+	osName, arch := vars["os"], vars["arch"]
+
+	archives, listErr := s.listProviderArchives(namespace, name)
+	found := findArchive(archives, version, osName, arch)
+	if found == nil && s.pull.fetcher != nil && isSafePathSegment(namespace) && isSafePathSegment(name) &&
+		isSafePathSegment(version) && isSafePathSegment(osName) && isSafePathSegment(arch) {
+		if err := s.pullArchive(w, r, namespace, name, version, osName, arch); err != nil {
+			s.writePullError(w, err, "Provider package not found")
+			return
+		}
+		archives, listErr = s.listProviderArchives(namespace, name)
+		found = findArchive(archives, version, osName, arch)
+	}
+	if found == nil {
+		if listErr != nil {
+			s.writeErrorResponse(w, http.StatusNotFound, "Provider not found")
+		} else {
+			s.writeErrorResponse(w, http.StatusNotFound, "Provider package not found")
+		}
+		return
+	}
+
+	providerDir := s.providerDir(namespace, name)
+	providerURL := s.baseURL(r) + "/" + s.registryHost() + "/" + namespace + "/" + name + "/"
+
+	pkg := common.ProviderPackage{
+		Protocols:   s.versionProtocols(namespace, name, version),
+		OS:          found.OS,
+		Arch:        found.Arch,
+		Filename:    found.Filename,
+		DownloadURL: providerURL + found.Filename,
+	}
+
+	// Terraform verifies the package against SHA256SUMS, its signature and the
+	// signing keys, so a package missing any of them cannot be installed
+	shasumsName := "terraform-provider-" + name + "_" + version + "_SHA256SUMS"
+	sums, err := common.ReadSHASums(filepath.Join(providerDir, shasumsName))
+	if err == nil && sums[found.Filename] == "" {
+		err = fmt.Errorf("%s not listed in %s", found.Filename, shasumsName)
+	}
+	if err == nil {
+		_, err = os.Stat(filepath.Join(providerDir, shasumsName+".sig"))
+	}
+	if err == nil {
+		pkg.SigningKeys, err = s.versionSigningKeys(namespace, name, version)
+	}
+	if err != nil {
+		s.logger.Warn("Cannot serve %s: %v", found.Filename, err)
+		s.writeErrorResponse(w, http.StatusNotFound, "Provider package has no verifiable SHA256SUMS")
+		return
+	}
+	pkg.Shasum = sums[found.Filename]
+	pkg.SHASumsURL = providerURL + shasumsName
+	pkg.SHASumsSignatureURL = providerURL + shasumsName + ".sig"
+
+	s.metrics.RecordProviderServed(namespace + "/" + name)
+	s.writeJSONResponse(w, pkg)
+}
+
+// findArchive returns the archive of a version and platform, or nil
+func findArchive(archives []providerArchive, version, osName, arch string) *providerArchive {
+	for i := range archives {
+		if a := &archives[i]; a.Version == version && a.OS == osName && a.Arch == arch {
+			return a
+		}
+	}
+	return nil
+}
+
+// versionProtocols returns the plugin protocols recorded in <version>.json,
+// or defaultProtocols if the downloader did not record any
+func (s *Server) versionProtocols(namespace, name, version string) []string {
+	data, err := os.ReadFile(filepath.Join(s.providerDir(namespace, name), version+".json"))
+	if err != nil {
+		return defaultProtocols
+	}
+	var versionFile struct {
+		Protocols []string `json:"protocols"`
+	}
+	if err := json.Unmarshal(data, &versionFile); err != nil || len(versionFile.Protocols) == 0 {
+		return defaultProtocols
+	}
+	return versionFile.Protocols
+}
+
+// versionSigningKeys returns the signing keys the downloader recorded in
+// <version>.json from the upstream download response
+func (s *Server) versionSigningKeys(namespace, name, version string) (common.SigningKeys, error) {
+	versionPath := filepath.Join(s.providerDir(namespace, name), version+".json")
+	data, err := os.ReadFile(versionPath)
+	if err != nil {
+		return common.SigningKeys{}, err
+	}
+	var versionFile struct {
+		SigningKeys common.SigningKeys `json:"signing_keys"`
+	}
+	if err := json.Unmarshal(data, &versionFile); err != nil {
+		return common.SigningKeys{}, fmt.Errorf("failed to parse %s: %w", versionPath, err)
+	}
+	if len(versionFile.SigningKeys.GPGPublicKeys) == 0 {
+		return common.SigningKeys{}, fmt.Errorf("no signing keys recorded in %s", versionPath)
+	}
+	return versionFile.SigningKeys, nil
+}
+
+// registryHost returns the upstream registry hostname served by the provider registry endpoints
+func (s *Server) registryHost() string {
+	if s.config.RegistryHost != "" {
+		return s.config.RegistryHost
+	}
+	return common.DefaultRegistryHost
+}
+
+// providerRoot returns <data-path>/<registry-host>
+func (s *Server) providerRoot() string {
+	return filepath.Join(s.config.DataPath, s.registryHost())
+}
+
+// providerDir returns the on-disk directory of a provider
+func (s *Server) providerDir(namespace, name string) string {
+	return filepath.Join(s.providerRoot(), namespace, name)
+}
+
+// listProviderArchives lists provider archives present on disk for namespace/name
+func (s *Server) listProviderArchives(namespace, name string) ([]providerArchive, error) {
+	if !isSafePathSegment(namespace) || !isSafePathSegment(name) {
+		return nil, os.ErrNotExist
+	}
+
+	entries, err := os.ReadDir(s.providerDir(namespace, name))
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []providerArchive
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if archive, ok := parseArchiveName(name, entry.Name()); ok {
+			archives = append(archives, archive)
+		}
+	}
+	return archives, nil
+}
+
+// parseArchiveName parses terraform-provider-<name>_<version>_<os>_<arch>.zip
+func parseArchiveName(name, filename string) (providerArchive, bool) {
+	prefix := "terraform-provider-" + name + "_"
+	if !strings.HasPrefix(filename, prefix) || !strings.HasSuffix(filename, ".zip") {
+		return providerArchive{}, false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(filename, prefix), ".zip"), "_")
+	if len(parts) != 3 {
+		return providerArchive{}, false
+	}
+
+	return providerArchive{
+		Version:  parts[0],
+		OS:       parts[1],
+		Arch:     parts[2],
+		Filename: filename,
+	}, true
+}
+
+// sortVersions sorts version strings by semver, falling back to string order
+func sortVersions(versions []string) {
+	sort.Slice(versions, func(i, j int) bool {
+		vi, errI := semver.ParseTolerant(versions[i])
+		vj, errJ := semver.ParseTolerant(versions[j])
+		if errI != nil || errJ != nil {
+			return versions[i] < versions[j]
+		}
+		return vi.LT(vj)
+	})
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tf-mirror/internal/common"
+)
+
+// registryFixtureKeys are the signing keys recorded for the registry fixture
+var registryFixtureKeys = common.SigningKeys{GPGPublicKeys: []common.GPGPublicKey{{
+	KeyID:      "34365D9472D7468F",
+	ASCIIArmor: "-----BEGIN PGP PUBLIC KEY BLOCK-----\n...\n-----END PGP PUBLIC KEY BLOCK-----\n",
+}}}
+
+// writeRegistryFixture writes hashicorp/null 3.2.0 for linux_amd64 as the
+// downloader leaves it: the archive, SHA256SUMS with its signature and a
+// 3.2.0.json holding the protocols and signing keys. It returns the
+// provider directory.
+func writeRegistryFixture(t *testing.T, dataPath string) string {
+	t.Helper()
+	dir := filepath.Join(dataPath, common.DefaultRegistryHost, "hashicorp", "null")
+	archive := "terraform-provider-null_3.2.0_linux_amd64.zip"
+	writeTestFile(t, filepath.Join(dir, archive), archive)
+	writeTestFile(t, filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"), "5d2d1c3a  "+archive+"\n")
+	writeTestFile(t, filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS.sig"), "signature")
+	writeTestJSON(t, filepath.Join(dir, "3.2.0.json"), map[string]any{
+		"archives":     map[string]any{"linux_amd64": map[string]any{"url": archive}},
+		"protocols":    []string{"5.0"},
+		"signing_keys": registryFixtureKeys,
+	})
+	return dir
+}
+
+func TestRegistryDownload(t *testing.T) {
+	const (
+		path    = "/v1/providers/hashicorp/null/3.2.0/download/linux/amd64"
+		baseURL = "http://mirror.example.com/registry.terraform.io/hashicorp/null/"
+	)
+	tests := []struct {
+		name       string
+		change     func(t *testing.T, dir string)
+		path       string
+		wantStatus int
+	}{
+		{name: "complete package", wantStatus: http.StatusOK},
+		{name: "platform not mirrored", path: "/v1/providers/hashicorp/null/3.2.0/download/darwin/arm64", wantStatus: http.StatusNotFound},
+		{
+			name: "no SHA256SUMS",
+			change: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"))
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "archive missing from SHA256SUMS",
+			change: func(t *testing.T, dir string) {
+				writeTestFile(t, filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"), "5d2d1c3a  terraform-provider-null_3.2.0_darwin_arm64.zip\n")
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "no signature",
+			change: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS.sig"))
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "no signing keys",
+			change: func(t *testing.T, dir string) {
+				writeTestJSON(t, filepath.Join(dir, "3.2.0.json"), map[string]any{"archives": map[string]any{}, "protocols": []string{"5.0"}})
+			},
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{Hostname: "mirror.example.com"})
+			dir := writeRegistryFixture(t, srv.config.DataPath)
+			if tt.change != nil {
+				tt.change(t, dir)
+			}
+			reqPath := path
+			if tt.path != "" {
+				reqPath = tt.path
+			}
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, reqPath, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var pkg common.ProviderPackage
+			if err := json.NewDecoder(rec.Body).Decode(&pkg); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			want := common.ProviderPackage{
+				Protocols:           []string{"5.0"},
+				OS:                  "linux",
+				Arch:                "amd64",
+				Filename:            "terraform-provider-null_3.2.0_linux_amd64.zip",
+				DownloadURL:         baseURL + "terraform-provider-null_3.2.0_linux_amd64.zip",
+				SHASumsURL:          baseURL + "terraform-provider-null_3.2.0_SHA256SUMS",
+				SHASumsSignatureURL: baseURL + "terraform-provider-null_3.2.0_SHA256SUMS.sig",
+				Shasum:              "5d2d1c3a",
+				SigningKeys:         registryFixtureKeys,
+			}
+			if !reflect.DeepEqual(pkg, want) {
+				t.Errorf("package = %+v\nwant %+v", pkg, want)
+			}
+		})
+	}
+}
+
+func TestDownloadURLHost(t *testing.T) {
+	const path = "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name     string
+		hostname string
+		allowed  []string
+		tls      bool
+		host     string // Host header of the request
+		want     string
+	}{
+		{name: "allowlisted host", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, host: "mirror.internal", want: "http://mirror.internal"},
+		{name: "allowlisted host with port", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, host: "mirror.internal:8080", want: "http://mirror.internal:8080"},
+		{name: "allowlist is case-insensitive", hostname: "mirror.example.com", allowed: []string{"Mirror.Internal"}, host: "mirror.internal", want: "http://mirror.internal"},
+		{name: "host not on the list", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, host: "evil.example.net", want: "http://mirror.example.com"},
+		{name: "no allowlist", hostname: "mirror.example.com", host: "mirror.internal", want: "http://mirror.example.com"},
+		{name: "no hostname configured", host: "mirror.internal", want: "http://mirror.internal"},
+		{name: "TLS", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, tls: true, host: "mirror.internal", want: "https://mirror.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{Hostname: tt.hostname, AllowedHosts: tt.allowed, EnableTLS: tt.tls})
+			writeRegistryFixture(t, srv.config.DataPath)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/providers/hashicorp/null/3.2.0/download/linux/amd64", nil)
+			req.Host = tt.host
+			rec := serve(srv, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var pkg common.ProviderPackage
+			if err := json.NewDecoder(rec.Body).Decode(&pkg); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if want := tt.want + path; pkg.DownloadURL != want {
+				t.Errorf("download_url = %q, want %q", pkg.DownloadURL, want)
+			}
+		})
+	}
+}
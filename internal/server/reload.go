@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+)
+
+// ReloadResult reports what a reload changed
+type ReloadResult struct {
+	Providers            int       `json:"providers"`
+	Added                []string  `json:"added"`
+	Removed              []string  `json:"removed"`
+	Versions             int       `json:"versions"`
+	Platforms            int       `json:"platforms"`
+	CertificatesReloaded int       `json:"certificates_reloaded"`
+	ReloadedAt           time.Time `json:"reloaded_at"`
+}
+
+// Reload rescans the data directory, recomputes the provider, version and
+// platform gauges, drops the cached /stats report and archive ETags, and
+// reloads TLS certificates whose files changed. It backs SIGHUP and POST /reload.
+func (s *Server) Reload() (*ReloadResult, error) {
+	s.providers.mu.RLock()
+	before := make(map[string]struct{}, len(s.providers.providers))
+	for _, provider := range s.providers.providers {
+		before[provider.Namespace+"/"+provider.Name] = struct{}{}
+	}
+	s.providers.mu.RUnlock()
+
+	providers, err := s.RefreshProviders()
+	if err != nil {
+		return nil, fmt.Errorf("failed to rescan providers: %w", err)
+	}
+
+	result := &ReloadResult{
+		Providers:  len(providers),
+		Added:      []string{},
+		Removed:    []string{},
+		ReloadedAt: time.Now().UTC(),
+	}
+	for _, provider := range providers {
+		key := provider.Namespace + "/" + provider.Name
+		if _, ok := before[key]; ok {
+			delete(before, key)
+		} else {
+			result.Added = append(result.Added, key)
+		}
+	}
+	for key := range before {
+		result.Removed = append(result.Removed, key)
+	}
+	sort.Strings(result.Removed)
+
+	result.Versions, result.Platforms = s.countVersionsAndPlatforms()
+	s.metrics.UpdateCounts(result.Providers, result.Versions, result.Platforms)
+
+	s.stats.mu.Lock()
+	s.stats.report = nil
+	s.stats.mu.Unlock()
+
+	s.etags.mu.Lock()
+	s.etags.entries = nil
+	s.etags.mu.Unlock()
+
+	if certs := s.certs.Load(); certs != nil {
+		result.CertificatesReloaded = certs.reload()
+	}
+
+	s.logger.Info("Reloaded: %d providers (%d added, %d removed), %d versions, %d platforms, %d certificates reloaded",
+		result.Providers, len(result.Added), len(result.Removed), result.Versions, result.Platforms, result.CertificatesReloaded)
+	for _, key := range result.Added {
+		s.logger.Info("  + %s", key)
+	}
+	for _, key := range result.Removed {
+		s.logger.Info("  - %s", key)
+	}
+	return result, nil
+}
+
+// ReloadOnSignal calls Reload whenever the process receives one of signals,
+// e.g. SIGHUP, until stop is called
+func (s *Server) ReloadOnSignal(signals ...os.Signal) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, signals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-sigChan:
+				s.logger.Info("Received signal: %s, reloading", sig)
+				if _, err := s.Reload(); err != nil {
+					s.logger.Error("Reload failed: %v", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
+
+// handleReload handles POST /reload, registered only when --auth-token is set
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	result, err := s.Reload()
+	if err != nil {
+		s.logger.Error("Reload failed: %v", err)
+		s.writeErrorResponse(w, http.StatusInternalServerError, "Reload failed")
+		return
+	}
+	s.writeJSONResponse(w, result)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, dataPath string)
+		want   string // provider expected in /providers after the reload
+		gone   string // provider expected to be gone
+		delta  int    // change of the provider gauge
+	}{
+		{
+			name: "provider added out-of-band",
+			change: func(t *testing.T, dataPath string) {
+				dir := filepath.Join(dataPath, common.DefaultRegistryHost, "acme", "fresh")
+				archive := "terraform-provider-fresh_1.0.0_linux_amd64.zip"
+				writeTestFile(t, filepath.Join(dir, archive), archive)
+				writeTestJSON(t, filepath.Join(dir, "1.0.0.json"), map[string]any{"archives": map[string]any{"linux_amd64": map[string]any{"url": archive}}})
+				writeTestJSON(t, filepath.Join(dir, "index.json"), map[string]any{"versions": map[string]any{"1.0.0": map[string]any{}}})
+			},
+			want:  "acme/fresh",
+			delta: 1,
+		},
+		{
+			name: "provider removed out-of-band",
+			change: func(t *testing.T, dataPath string) {
+				if err := os.RemoveAll(filepath.Join(dataPath, common.DefaultRegistryHost, "other", "tool")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			gone:  "other/tool",
+			delta: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			writeProviderFixture(t, srv.config.DataPath)
+			stop := srv.ReloadOnSignal(syscall.SIGHUP)
+			defer stop()
+
+			// The gauge is otherwise refreshed only once a minute
+			gauge := func() int {
+				srv.metrics.mu.Lock()
+				defer srv.metrics.mu.Unlock()
+				return srv.metrics.TotalProviders
+			}
+			waitFor := func(what string, want int) {
+				t.Helper()
+				deadline := time.Now().Add(5 * time.Second)
+				for gauge() != want {
+					if time.Now().After(deadline) {
+						t.Fatalf("%s: provider gauge = %d, want %d", what, gauge(), want)
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+			srv.Reload()
+			waitFor("before the change", len(fixtureProviders))
+
+			tt.change(t, srv.config.DataPath)
+			process, err := os.FindProcess(os.Getpid())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := process.Signal(syscall.SIGHUP); err != nil {
+				t.Skipf("cannot send SIGHUP: %v", err)
+			}
+			waitFor("after SIGHUP", len(fixtureProviders)+tt.delta)
+
+			listed, _, status := listProviders(t, srv, "limit=100")
+			if status != http.StatusOK {
+				t.Fatalf("/providers status = %d", status)
+			}
+			if tt.want != "" && !slices.Contains(listed, tt.want) {
+				t.Errorf("/providers = %v, want %s listed", listed, tt.want)
+			}
+			if tt.gone != "" && slices.Contains(listed, tt.gone) {
+				t.Errorf("/providers = %v, want %s gone", listed, tt.gone)
+			}
+		})
+	}
+}
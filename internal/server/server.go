@@ -5,11 +5,16 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"tf-mirror/internal/common"
@@ -24,6 +29,17 @@ type Server struct {
 	httpServer *http.Server
 	router     *mux.Router
 	metrics    *Metrics
+	verify     verifyState
+	stats      statsCache
+	ready      readyCache
+	providers  providerCache
+	etags      archiveETags
+	pull       pullThrough
+	stop       chan struct{} // closed by Stop to end background loops
+	stopOnce   sync.Once
+
+	// certs is set by Start when TLS is enabled
+	certs atomic.Pointer[certStore]
 }
 
 // NewServer creates a new registry mirror server
@@ -32,18 +48,42 @@ func NewServer(config *common.ServerConfig, logger *common.Logger) *Server {
 		config:  config,
 		logger:  logger,
 		metrics: NewMetrics(),
+		stop:    make(chan struct{}),
+	}
+
+	// Resume counters from the previous run
+	if err := server.metrics.Load(server.metricsPath()); err != nil {
+		logger.Warn("Ignoring persisted metrics: %v", err)
 	}
 
 	server.setupRoutes()
 	return server
 }
 
+// startBackground starts the loops that run until Stop: metrics persistence,
+// gauge refresh and the provider tree watcher
+func (s *Server) startBackground() {
+	go s.persistMetrics(s.stop)
+	go s.refreshCounts(s.stop)
+	go s.watchProviders(s.stop)
+}
+
 // setupRoutes configures the HTTP routes
 func (s *Server) setupRoutes() {
 	s.router = mux.NewRouter()
 
-	// Health check endpoint
+	// Liveness and readiness probes
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
+	s.router.HandleFunc("/ready", s.handleReady).Methods("GET")
+
+	// Mirrored providers, paginated like the upstream /v1/providers listing
+	s.router.HandleFunc("/providers", s.handleProviderList).Methods("GET")
+	s.router.HandleFunc("/providers/{namespace}/{name}", s.handleProviderDetail).Methods("GET")
+
+	// Optional HTML overview
+	if s.config.EnableUI {
+		s.router.HandleFunc("/ui", s.handleUI).Methods("GET")
+	}
 
 	// Version endpoint
 	s.router.HandleFunc("/version", s.handleVersion).Methods("GET")
@@ -51,39 +91,84 @@ func (s *Server) setupRoutes() {
 	// Metrics endpoint
 	s.router.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
 
+	// Per-provider disk usage
+	s.router.HandleFunc("/stats", s.handleStats).Methods("GET")
+
+	// Integrity verification report
+	s.router.HandleFunc("/api/verify", s.handleVerify).Methods("GET", "POST")
+
+	// Rescan on demand; it needs a token, as anyone could trigger it otherwise
+	if s.config.AuthToken != "" {
+		s.router.HandleFunc("/reload", s.handleReload).Methods("POST")
+	}
+
+	// Service discovery endpoint
+	s.router.HandleFunc("/.well-known/terraform.json", s.handleWellKnown).Methods("GET")
+
+	// Provider Registry Protocol
+	s.router.HandleFunc("/v1/providers/{namespace}/{name}/versions", s.handleRegistryVersions).Methods("GET")
+	s.router.HandleFunc("/v1/providers/{namespace}/{name}/{version}/download/{os}/{arch}", s.handleRegistryDownload).Methods("GET")
+
+	// Module Registry Protocol
+	s.router.HandleFunc("/v1/modules", s.handleModuleList).Methods("GET")
+	s.router.HandleFunc("/v1/modules/{namespace}/{name}/{system}/versions", s.handleModuleVersions).Methods("GET")
+	s.router.HandleFunc("/v1/modules/{namespace}/{name}/{system}/{version}/download", s.handleModuleDownload).Methods("GET")
+
+	// Network Mirror Protocol JSON documents
+	s.router.HandleFunc("/{hostname}/{namespace}/{type}/index.json", s.handleMirrorIndex).Methods("GET", "HEAD")
+	s.router.HandleFunc("/{hostname}/{namespace}/{type}/{version}.json", s.handleMirrorVersion).Methods("GET", "HEAD")
+
 	// Static file serving for provider binaries
-	s.router.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(s.config.DataPath))))
+	s.router.PathPrefix("/").Handler(s.allowlistHandler(s.pullThroughHandler(s.archiveETagHandler(http.StripPrefix("/", http.FileServer(http.Dir(s.config.DataPath)))))))
 
 	// Add middlewares
 	s.router.Use(s.loggingMiddleware)
 	s.router.Use(s.metricsMiddleware)
+	s.router.Use(s.cidrMiddleware)
+	s.router.Use(s.authMiddleware)
+	s.router.Use(s.gzipMiddleware)
 }
 
-// Start starts the HTTP server
-func (s *Server) Start() error {
-	addr := fmt.Sprintf("%s:%d", s.config.ListenHost, s.config.ListenPort)
-
-	s.httpServer = &http.Server{
+// newHTTPServer returns the HTTP server for addr with the configured timeouts
+func (s *Server) newHTTPServer(addr string) *http.Server {
+	return &http.Server{
 		Addr:         addr,
 		Handler:      s.router,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		ReadTimeout:  s.config.ReadTimeout,
+		WriteTimeout: s.config.WriteTimeout,
+		IdleTimeout:  s.config.IdleTimeout,
 	}
+}
+
+// newTLSConfig loads the certificates into a store that picks up renewed
+// files and returns the server's TLS configuration. NextProtos is left unset,
+// so net/http offers h2 and http/1.1.
+func (s *Server) newTLSConfig() (*tls.Config, error) {
+	certs, err := newCertStore(s.config, s.logger)
+	if err != nil {
+		return nil, err
+	}
+	s.certs.Store(certs)
+	return &tls.Config{
+		MinVersion:     s.config.TLSMinVersion,
+		GetCertificate: certs.getCertificate,
+	}, nil
+}
+
+// Start starts the HTTP server
+func (s *Server) Start() error {
+	addr := fmt.Sprintf("%s:%d", s.config.ListenHost, s.config.ListenPort)
+	s.httpServer = s.newHTTPServer(addr)
+	s.startBackground()
 
 	if s.config.EnableTLS {
 		s.logger.Info("Starting HTTPS server on %s", addr)
 
-		// Load TLS configuration
-		cert, err := tls.LoadX509KeyPair(s.config.TLSCert, s.config.TLSKey)
+		tlsConfig, err := s.newTLSConfig()
 		if err != nil {
-			return fmt.Errorf("failed to load TLS certificate: %w", err)
+			return err
 		}
-
-		s.httpServer.TLSConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
-
+		s.httpServer.TLSConfig = tlsConfig
 		return s.httpServer.ListenAndServeTLS("", "")
 	} else {
 		s.logger.Info("Starting HTTP server on %s", addr)
@@ -91,26 +176,204 @@ func (s *Server) Start() error {
 	}
 }
 
-// Stop gracefully stops the HTTP server
+// Stop gracefully stops the HTTP server and the background loops. It is
+// safe to call before Start and more than once; later calls do nothing.
 func (s *Server) Stop(ctx context.Context) error {
-	s.logger.Info("Shutting down server...")
-	return s.httpServer.Shutdown(ctx)
+	var err error
+	s.stopOnce.Do(func() {
+		s.logger.Info("Shutting down server...")
+		if s.httpServer != nil {
+			err = s.httpServer.Shutdown(ctx)
+		}
+
+		close(s.stop)
+		if saveErr := s.metrics.Save(s.metricsPath()); saveErr != nil {
+			s.logger.Warn("Failed to persist metrics: %v", saveErr)
+		}
+	})
+	return err
 }
 
-// handleProviderList handles the /providers endpoint
+// handleProviderList handles the /providers endpoint. It can be narrowed with
+// ?namespace= (exact), ?q= (substring of the name) and ?platform=os_arch
+// (providers with at least one archive for that platform on disk).
 func (s *Server) handleProviderList(w http.ResponseWriter, r *http.Request) {
-	providers, err := s.scanProviders()
+	limit, err := queryInt(r, "limit", defaultListLimit)
+	if err != nil || limit < 1 || limit > maxListLimit {
+		s.writeErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		return
+	}
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		s.writeErrorResponse(w, http.StatusBadRequest, "offset must not be negative")
+		return
+	}
+
+	providers, err := s.cachedProviders()
 	if err != nil {
 		s.logger.Error("Failed to scan providers: %v", err)
 		s.writeErrorResponse(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
-	response := common.ProviderList{
-		Providers: providers,
+	query := r.URL.Query()
+	providers = s.filterProviders(providers, query.Get("namespace"), query.Get("q"), query.Get("platform"))
+
+	meta := &common.ListMeta{Limit: limit, CurrentOffset: offset}
+	page := []common.ProviderListItem{}
+	if offset < len(providers) {
+		page = providers[offset:min(offset+limit, len(providers))]
+	}
+	if next := offset + limit; next < len(providers) {
+		meta.NextOffset = &next
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(next))
+		meta.NextURL = "/providers?" + query.Encode()
+	}
+	if offset > 0 {
+		prev := max(offset-limit, 0)
+		meta.PrevOffset = &prev
 	}
 
-	s.writeJSONResponse(w, response)
+	s.writeJSONResponse(w, common.ProviderList{Meta: meta, Providers: page})
+}
+
+// filterProviders applies the /providers query filters; empty ones match everything
+func (s *Server) filterProviders(providers []common.ProviderListItem, namespace, q, platform string) []common.ProviderListItem {
+	namespace = strings.ToLower(strings.TrimSpace(namespace))
+	q = strings.ToLower(strings.TrimSpace(q))
+	platform = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(platform)), "/", "_")
+	if namespace == "" && q == "" && platform == "" {
+		return providers
+	}
+
+	var out []common.ProviderListItem
+	for _, provider := range providers {
+		if namespace != "" && strings.ToLower(provider.Namespace) != namespace {
+			continue
+		}
+		if q != "" && !strings.Contains(strings.ToLower(provider.Name), q) {
+			continue
+		}
+		if platform != "" && !s.hasPlatform(provider.Namespace, provider.Name, platform) {
+			continue
+		}
+		out = append(out, provider)
+	}
+	return out
+}
+
+// hasPlatform reports whether a provider has an archive for os_arch on disk
+func (s *Server) hasPlatform(namespace, name, platform string) bool {
+	archives, err := s.listProviderArchives(namespace, name)
+	if err != nil {
+		return false
+	}
+	for _, archive := range archives {
+		if archive.OS+"_"+archive.Arch == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// handleProviderDetail handles /providers/{namespace}/{name}: the mirrored
+// versions and, per version, the platforms present on disk. It reads the
+// generated index.json and <version>.json instead of walking the directory.
+func (s *Server) handleProviderDetail(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	detail, err := s.providerDetail(vars["namespace"], vars["name"])
+	if os.IsNotExist(err) {
+		s.writeErrorResponse(w, http.StatusNotFound, "Provider not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to read provider %s/%s: %v", vars["namespace"], vars["name"], err)
+		s.writeErrorResponse(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	s.writeJSONResponse(w, detail)
+}
+
+// providerDetail aggregates index.json and the per-version files of a provider
+func (s *Server) providerDetail(namespace, name string) (*common.ProviderDetail, error) {
+	if !isSafePathSegment(namespace) || !isSafePathSegment(name) {
+		return nil, os.ErrNotExist
+	}
+	dir := s.providerDir(namespace, name)
+
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	var index struct {
+		Versions map[string]json.RawMessage `json:"versions"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse index.json: %w", err)
+	}
+
+	versions := make([]string, 0, len(index.Versions))
+	for version := range index.Versions {
+		if isSafePathSegment(version) {
+			versions = append(versions, version)
+		}
+	}
+	sortVersions(versions)
+
+	detail := &common.ProviderDetail{
+		Namespace: namespace,
+		Name:      name,
+		Versions:  make([]common.ProviderVersionDetail, 0, len(versions)),
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+		data, err := os.ReadFile(filepath.Join(dir, version+".json"))
+		if os.IsNotExist(err) {
+			continue // listed in index.json but not written yet
+		}
+		if err != nil {
+			return nil, err
+		}
+		var versionFile struct {
+			Archives  map[string]json.RawMessage `json:"archives"`
+			Protocols []string                   `json:"protocols"`
+		}
+		if err := json.Unmarshal(data, &versionFile); err != nil {
+			return nil, fmt.Errorf("failed to parse %s.json: %w", version, err)
+		}
+
+		platforms := make([]string, 0, len(versionFile.Archives))
+		for platform := range versionFile.Archives {
+			platforms = append(platforms, platform)
+		}
+		sort.Strings(platforms)
+		protocols := versionFile.Protocols
+		if len(protocols) == 0 {
+			protocols = defaultProtocols
+		}
+		detail.Versions = append(detail.Versions, common.ProviderVersionDetail{
+			Version:   version,
+			Protocols: protocols,
+			Platforms: platforms,
+		})
+	}
+	return detail, nil
+}
+
+// Page sizes of the /providers listing
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// queryInt reads an integer query parameter, returning def if it is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
 }
 
 // handleHealth handles the /health endpoint
@@ -123,6 +386,7 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 	// Check if data directory is accessible
 	if _, err := os.Stat(s.config.DataPath); os.IsNotExist(err) {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusServiceUnavailable)
 		health["status"] = "unhealthy"
 		health["error"] = "data directory not accessible"
@@ -131,17 +395,91 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	s.writeJSONResponse(w, health)
 }
 
+// readyCacheTTL bounds how long a readiness result is reused between probes
+const readyCacheTTL = 10 * time.Second
+
+// readyCache keeps the last readiness check, so probes do not read index
+// files on every request. invalidateCaches drops it when the tree changes.
+type readyCache struct {
+	mu        sync.Mutex
+	providers int  // providers found by the scan
+	usable    bool // at least one of them has a parsable index.json
+	checkedAt time.Time
+}
+
+// handleReady handles the /ready endpoint. Unlike /health it requires usable
+// content: at least one provider with a parsable index.json. A corrupt index
+// of one provider is logged as a warning rather than taking the whole
+// replica out of rotation.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	s.ready.mu.Lock()
+	if s.ready.checkedAt.IsZero() || time.Since(s.ready.checkedAt) >= readyCacheTTL {
+		s.ready.providers, s.ready.usable = s.checkReady()
+		s.ready.checkedAt = time.Now()
+	}
+	providers, usable := s.ready.providers, s.ready.usable
+	s.ready.mu.Unlock()
+
+	ready := map[string]any{
+		"status":    "ready",
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"providers": providers,
+	}
+	if !usable {
+		ready["status"] = "not ready"
+		ready["error"] = "no providers with index.json available"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	s.writeJSONResponse(w, ready)
+}
+
+// checkReady reads provider index files until one parses, so a healthy
+// mirror costs one read. It returns the number of providers and whether a
+// usable index was found.
+func (s *Server) checkReady() (int, bool) {
+	providers, err := s.cachedProviders()
+	if err != nil {
+		s.logger.Error("Failed to scan providers: %v", err)
+	}
+
+	for _, provider := range providers {
+		indexPath := filepath.Join(s.providerRoot(), provider.Namespace, provider.Name, "index.json")
+		data, err := os.ReadFile(indexPath)
+		if err != nil {
+			continue // not generated yet
+		}
+		var index struct {
+			Versions map[string]any `json:"versions"`
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			s.logger.Warn("Invalid index.json of %s/%s: %v", provider.Namespace, provider.Name, err)
+			continue
+		}
+		return len(providers), true
+	}
+	return len(providers), false
+}
+
 // handleVersion handles the /version endpoint
 func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
 	s.writeJSONResponse(w, common.GetVersionInfo())
 }
 
+// handleWellKnown handles the /.well-known/terraform.json service discovery endpoint
+func (s *Server) handleWellKnown(w http.ResponseWriter, r *http.Request) {
+	s.writeJSONResponse(w, common.WellKnownConfig{
+		ProvidersV1: "/v1/providers/",
+		ModulesV1:   "/v1/modules/",
+	})
+}
+
 // scanProviders scans the data directory for available providers
 func (s *Server) scanProviders() ([]common.ProviderListItem, error) {
 	var providers []common.ProviderListItem
 	providerMap := make(map[string]bool)
 
-	err := filepath.Walk(s.config.DataPath+"/registry.terraform.io", func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(s.providerRoot(), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip errors
 		}
@@ -149,8 +487,12 @@ func (s *Server) scanProviders() ([]common.ProviderListItem, error) {
 		if !info.IsDir() {
 			return nil
 		}
+		// .<name>.incoming/.old exist while the downloader publishes a staged provider
+		if path != s.providerRoot() && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
 
-		relPath, err := filepath.Rel(s.config.DataPath+"/registry.terraform.io", path)
+		relPath, err := filepath.Rel(s.providerRoot(), path)
 		if err != nil {
 			return nil
 		}
@@ -176,6 +518,40 @@ func (s *Server) scanProviders() ([]common.ProviderListItem, error) {
 	return providers, err
 }
 
+// baseURL returns the scheme and host clients should use for absolute URLs.
+// The request Host is used when it is in the allowlist, so a mirror reached
+// via several DNS names hands out links on the name the client used.
+func (s *Server) baseURL(r *http.Request) string {
+	scheme := "http"
+	if s.config.EnableTLS || r.TLS != nil {
+		scheme = "https"
+	}
+
+	host := s.config.Hostname
+	if r.Host != "" && s.isAllowedHost(r.Host) {
+		host = r.Host
+	}
+	if host == "" {
+		host = r.Host
+	}
+
+	return scheme + "://" + host
+}
+
+// isAllowedHost reports whether host (optionally with port) is in the allowlist
+func (s *Server) isAllowedHost(host string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	for _, allowed := range s.config.AllowedHosts {
+		if strings.EqualFold(allowed, host) || strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
+
 // writeJSONResponse writes a JSON response
 func (s *Server) writeJSONResponse(w http.ResponseWriter, data any) {
 	w.Header().Set("Content-Type", "application/json")
@@ -203,6 +579,42 @@ func (s *Server) writeErrorResponse(w http.ResponseWriter, statusCode int, messa
 	json.NewEncoder(w).Encode(errorResponse)
 }
 
+// allowlistHandler restricts the static file server to the configured suffixes.
+// Dotfiles and directory listings are rejected whenever an allowlist is set.
+func (s *Server) allowlistHandler(next http.Handler) http.Handler {
+	if len(s.config.AllowedSuffixes) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAllowedFile(r.URL.Path) {
+			s.writeErrorResponse(w, http.StatusNotFound, "Not found")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAllowedFile reports whether the request path names a file with an allowed suffix
+func (s *Server) isAllowedFile(urlPath string) bool {
+	if strings.HasSuffix(urlPath, "/") {
+		return false
+	}
+	for _, part := range strings.Split(urlPath, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+
+	name := path.Base(urlPath)
+	for _, suffix := range s.config.AllowedSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
 // loggingMiddleware logs HTTP requests
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -218,10 +630,11 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// responseWriterWrapper wraps http.ResponseWriter to capture status code
+// responseWriterWrapper wraps http.ResponseWriter to capture status code and body size
 type responseWriterWrapper struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64
 }
 
 func (w *responseWriterWrapper) WriteHeader(statusCode int) {
@@ -233,5 +646,12 @@ func (w *responseWriterWrapper) Write(data []byte) (int, error) {
 	if w.statusCode == 0 {
 		w.statusCode = http.StatusOK
 	}
-	return w.ResponseWriter.Write(data)
+	n, err := w.ResponseWriter.Write(data)
+	w.bytesWritten += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *responseWriterWrapper) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
 }
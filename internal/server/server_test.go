@@ -0,0 +1,402 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+// newTestServer returns a Server for config, serving t.TempDir() unless
+// DataPath is set. It is stopped when the test ends.
+func newTestServer(t *testing.T, config *common.ServerConfig) *Server {
+	t.Helper()
+	if config.DataPath == "" {
+		config.DataPath = t.TempDir()
+	}
+	srv := NewServer(config, common.NewLogger())
+	t.Cleanup(func() { srv.Stop(context.Background()) })
+	return srv
+}
+
+// serve runs req through the server's router
+func serve(srv *Server, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// writeTestFile creates path with content, and its parent directories
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAllowedFiles(t *testing.T) {
+	const dir = "/registry.terraform.io/hashicorp/null/"
+	tests := []struct {
+		name       string
+		suffixes   []string
+		path       string
+		wantStatus int
+	}{
+		{name: "allowed suffix", suffixes: []string{".zip", "SHA256SUMS"}, path: dir + "terraform-provider-null_3.2.0_linux_amd64.zip", wantStatus: http.StatusOK},
+		{name: "allowed file name suffix", suffixes: []string{".zip", "SHA256SUMS"}, path: dir + "terraform-provider-null_3.2.0_SHA256SUMS", wantStatus: http.StatusOK},
+		{name: "disallowed suffix", suffixes: []string{".zip", "SHA256SUMS"}, path: dir + "notes.txt", wantStatus: http.StatusNotFound},
+		{name: "dotfile with an allowed suffix", suffixes: []string{".zip"}, path: dir + ".hidden.zip", wantStatus: http.StatusNotFound},
+		{name: "directory listing", suffixes: []string{".zip"}, path: dir, wantStatus: http.StatusNotFound},
+		{name: "no allowlist", path: dir + "notes.txt", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{AllowedSuffixes: tt.suffixes})
+			for _, name := range []string{"terraform-provider-null_3.2.0_linux_amd64.zip", "terraform-provider-null_3.2.0_SHA256SUMS", "notes.txt", ".hidden.zip"} {
+				writeTestFile(t, filepath.Join(srv.config.DataPath, filepath.FromSlash(dir), name), name)
+			}
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("GET %s status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		allowed  []string
+		tls      bool
+		host     string // Host header of the request
+		want     string
+	}{
+		{name: "allowlisted host", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, host: "mirror.internal", want: "http://mirror.internal"},
+		{name: "allowlisted host with port", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, host: "mirror.internal:8080", want: "http://mirror.internal:8080"},
+		{name: "allowlist is case-insensitive", hostname: "mirror.example.com", allowed: []string{"Mirror.Internal"}, host: "mirror.internal", want: "http://mirror.internal"},
+		{name: "host not on the list", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, host: "evil.example.net", want: "http://mirror.example.com"},
+		{name: "no allowlist", hostname: "mirror.example.com", host: "mirror.internal", want: "http://mirror.example.com"},
+		{name: "no hostname configured", host: "mirror.internal", want: "http://mirror.internal"},
+		{name: "TLS", hostname: "mirror.example.com", allowed: []string{"mirror.internal"}, tls: true, host: "mirror.internal", want: "https://mirror.internal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{Hostname: tt.hostname, AllowedHosts: tt.allowed, EnableTLS: tt.tls})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			if got := srv.baseURL(req); got != tt.want {
+				t.Errorf("baseURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	tests := []struct {
+		name  string
+		start bool // run the background loops as Start does
+		stops int
+	}{
+		{name: "before Start", stops: 1},
+		{name: "twice before Start", stops: 2},
+		{name: "twice after the loops started", start: true, stops: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			if tt.start {
+				srv.startBackground()
+			}
+			for i := range tt.stops {
+				if err := srv.Stop(context.Background()); err != nil {
+					t.Errorf("Stop #%d: %v", i+1, err)
+				}
+			}
+			select {
+			case <-srv.stop:
+			default:
+				t.Error("background loops were not told to stop")
+			}
+			if _, err := os.Stat(srv.metricsPath()); err != nil {
+				t.Errorf("metrics were not persisted on Stop: %v", err)
+			}
+		})
+	}
+}
+
+func TestWellKnown(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		wantStatus int
+		wantBody   map[string]string
+	}{
+		{
+			name:       "service discovery",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]string{"providers.v1": "/v1/providers/", "modules.v1": "/v1/modules/"},
+		},
+		{name: "only GET is served", method: http.MethodPost, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			rec := serve(srv, httptest.NewRequest(tt.method, "/.well-known/terraform.json", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body map[string]string
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !maps.Equal(body, tt.wantBody) {
+				t.Errorf("body = %v, want %v", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestArchiveRangeAndHead(t *testing.T) {
+	archive := "/registry.terraform.io/hashicorp/null/terraform-provider-null_3.2.0_linux_amd64.zip"
+	content := strings.Repeat("0123456789", 100)
+	tests := []struct {
+		name             string
+		method           string
+		rangeH           string
+		wantStatus       int
+		wantBody         string
+		wantLength       string
+		wantContentRange string
+	}{
+		{name: "full GET", method: http.MethodGet, wantStatus: http.StatusOK, wantBody: content, wantLength: "1000"},
+		{name: "first 100 bytes", method: http.MethodGet, rangeH: "bytes=0-99", wantStatus: http.StatusPartialContent, wantBody: content[:100], wantLength: "100", wantContentRange: "bytes 0-99/1000"},
+		{name: "open-ended range", method: http.MethodGet, rangeH: "bytes=900-", wantStatus: http.StatusPartialContent, wantBody: content[900:], wantLength: "100", wantContentRange: "bytes 900-999/1000"},
+		{name: "unsatisfiable range", method: http.MethodGet, rangeH: "bytes=2000-", wantStatus: http.StatusRequestedRangeNotSatisfiable, wantContentRange: "bytes */1000"},
+		{name: "HEAD", method: http.MethodHead, wantStatus: http.StatusOK, wantBody: "", wantLength: "1000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			writeTestFile(t, filepath.Join(srv.config.DataPath, filepath.FromSlash(archive)), content)
+
+			// A real server, so HEAD bodies are dropped as on the wire
+			ts := httptest.NewServer(srv.router)
+			defer ts.Close()
+			req, _ := http.NewRequest(tt.method, ts.URL+archive, nil)
+			if tt.rangeH != "" {
+				req.Header.Set("Range", tt.rangeH)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.method, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Accept-Ranges"); tt.wantStatus != http.StatusRequestedRangeNotSatisfiable && got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.wantContentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.wantContentRange)
+			}
+			if tt.wantLength != "" && resp.Header.Get("Content-Length") != tt.wantLength {
+				t.Errorf("Content-Length = %q, want %s", resp.Header.Get("Content-Length"), tt.wantLength)
+			}
+			if tt.wantStatus != http.StatusRequestedRangeNotSatisfiable && string(body) != tt.wantBody {
+				t.Errorf("body has %d bytes, want %d", len(body), len(tt.wantBody))
+			}
+		})
+	}
+}
+
+func TestProvidersPerRegistryHost(t *testing.T) {
+	dataPath := t.TempDir()
+	writeTestFile(t, filepath.Join(dataPath, "registry.terraform.io", "hashicorp", "null", "index.json"), `{"versions":{"3.2.0":{}}}`)
+	writeTestFile(t, filepath.Join(dataPath, "app.terraform.io", "acme", "tool", "index.json"), `{"versions":{"1.0.0":{}}}`)
+
+	tests := []struct {
+		registryHost string
+		want         string // the only provider listed
+	}{
+		{registryHost: "", want: "hashicorp/null"},
+		{registryHost: "app.terraform.io", want: "acme/tool"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{DataPath: dataPath, RegistryHost: tt.registryHost})
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/providers", nil))
+			var list common.ProviderList
+			if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+				t.Fatalf("decode /providers: %v", err)
+			}
+			var got []string
+			for _, p := range list.Providers {
+				got = append(got, p.Namespace+"/"+p.Name)
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("/providers = %v, want [%s]", got, tt.want)
+			}
+
+			// mirror documents are served for every hostname on disk
+			for _, path := range []string{"/registry.terraform.io/hashicorp/null/index.json", "/app.terraform.io/acme/tool/index.json"} {
+				if rec := serve(srv, httptest.NewRequest(http.MethodGet, path, nil)); rec.Code != http.StatusOK {
+					t.Errorf("GET %s = %d, want %d", path, rec.Code, http.StatusOK)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteTimeoutWithSlowClient(t *testing.T) {
+	const (
+		archive = "/registry.terraform.io/hashicorp/aws/terraform-provider-aws_5.0.0_linux_amd64.zip"
+		size    = 32 << 20 // more than the socket buffers hold
+		chunk   = 1 << 20
+	)
+
+	tests := []struct {
+		name         string
+		writeTimeout time.Duration
+		wantComplete bool
+	}{
+		{name: "write timeout cuts a slow transfer", writeTimeout: 200 * time.Millisecond},
+		{name: "disabled write timeout", writeTimeout: 0, wantComplete: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{WriteTimeout: tt.writeTimeout})
+			path := filepath.Join(srv.config.DataPath, filepath.FromSlash(archive))
+			writeTestFile(t, path, "")
+			if err := os.Truncate(path, size); err != nil {
+				t.Fatal(err)
+			}
+
+			ts := httptest.NewUnstartedServer(srv.router)
+			ts.Config = srv.newHTTPServer("")
+			ts.Start()
+			defer ts.Close()
+
+			resp, err := http.Get(ts.URL + archive)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var received int64
+			for {
+				n, err := io.CopyN(io.Discard, resp.Body, chunk)
+				received += n
+				if err != nil {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+			if complete := received == size; complete != tt.wantComplete {
+				t.Errorf("received %d of %d bytes, want complete %t", received, size, tt.wantComplete)
+			}
+		})
+	}
+}
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		setup      func(t *testing.T, dataPath string)
+		wantStatus int
+		wantState  string
+	}{
+		{name: "health with data path", path: "/health", wantStatus: http.StatusOK, wantState: "healthy"},
+		{name: "health without data path", path: "/health", setup: func(t *testing.T, dataPath string) {
+			if err := os.Remove(dataPath); err != nil {
+				t.Fatal(err)
+			}
+		}, wantStatus: http.StatusServiceUnavailable, wantState: "unhealthy"},
+		{name: "ready without providers", path: "/ready", wantStatus: http.StatusServiceUnavailable, wantState: "not ready"},
+		{name: "ready with a provider", path: "/ready", setup: func(t *testing.T, dataPath string) {
+			writeTestFile(t, filepath.Join(dataPath, "registry.terraform.io", "hashicorp", "null", "index.json"), `{"versions":{"3.2.0":{}}}`)
+		}, wantStatus: http.StatusOK, wantState: "ready"},
+		{name: "ready with one corrupt index", path: "/ready", setup: func(t *testing.T, dataPath string) {
+			writeTestFile(t, filepath.Join(dataPath, "registry.terraform.io", "hashicorp", "null", "index.json"), `{"versions":{"3.2.0":{}}}`)
+			writeTestFile(t, filepath.Join(dataPath, "registry.terraform.io", "hashicorp", "aws", "index.json"), `{"versions":`)
+		}, wantStatus: http.StatusOK, wantState: "ready"},
+		{name: "ready with only corrupt indexes", path: "/ready", setup: func(t *testing.T, dataPath string) {
+			writeTestFile(t, filepath.Join(dataPath, "registry.terraform.io", "hashicorp", "aws", "index.json"), `{"versions":`)
+		}, wantStatus: http.StatusServiceUnavailable, wantState: "not ready"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataPath := filepath.Join(t.TempDir(), "data")
+			if err := os.Mkdir(dataPath, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if tt.setup != nil {
+				tt.setup(t, dataPath)
+			}
+			srv := newTestServer(t, &common.ServerConfig{DataPath: dataPath})
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Result().Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var body struct {
+				Status string `json:"status"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON %q: %v", rec.Body.String(), err)
+			}
+			if body.Status != tt.wantState {
+				t.Errorf("status field = %q, want %q", body.Status, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestReadyCached(t *testing.T) {
+	srv := newTestServer(t, &common.ServerConfig{})
+	probe := func() int {
+		return serve(srv, httptest.NewRequest(http.MethodGet, "/ready", nil)).Code
+	}
+
+	if got := probe(); got != http.StatusServiceUnavailable {
+		t.Fatalf("empty mirror: status = %d, want %d", got, http.StatusServiceUnavailable)
+	}
+	writeTestFile(t, filepath.Join(srv.config.DataPath, "registry.terraform.io", "hashicorp", "null", "index.json"), `{"versions":{"3.2.0":{}}}`)
+	if got := probe(); got != http.StatusServiceUnavailable {
+		t.Errorf("within the cache TTL: status = %d, want the cached %d", got, http.StatusServiceUnavailable)
+	}
+	srv.invalidateCaches()
+	if got := probe(); got != http.StatusOK {
+		t.Errorf("after invalidation: status = %d, want %d", got, http.StatusOK)
+	}
+}
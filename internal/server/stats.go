@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStatsTTL is used when ServerConfig.StatsTTL is not set
+const defaultStatsTTL = time.Minute
+
+// ProviderStats describes the disk footprint of one provider
+type ProviderStats struct {
+	Provider   string `json:"provider"`
+	Bytes      int64  `json:"bytes"`
+	Versions   int    `json:"versions"`
+	Archives   int    `json:"archives"`
+	TotalFiles int    `json:"files"`
+}
+
+// StatsReport is the /stats response
+type StatsReport struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	TotalBytes  int64           `json:"total_bytes"`
+	Providers   []ProviderStats `json:"providers"`
+}
+
+// statsCache keeps the last report for StatsTTL
+type statsCache struct {
+	mu     sync.Mutex
+	report *StatsReport
+}
+
+// handleStats handles the /stats endpoint: per-provider disk usage, largest first
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	ttl := s.config.StatsTTL
+	if ttl <= 0 {
+		ttl = defaultStatsTTL
+	}
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	if s.stats.report == nil || time.Since(s.stats.report.GeneratedAt) >= ttl {
+		s.stats.report = s.collectStats()
+	}
+
+	s.writeJSONResponse(w, s.stats.report)
+}
+
+// collectStats walks the provider tree and aggregates sizes by namespace/name
+func (s *Server) collectStats() *StatsReport {
+	root := s.providerRoot()
+	byProvider := make(map[string]*ProviderStats)
+	versions := make(map[string]map[string]struct{})
+
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil // Skip errors and continue
+		}
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		parts := strings.Split(relPath, string(filepath.Separator))
+		if len(parts) != 3 {
+			return nil
+		}
+
+		key := parts[0] + "/" + parts[1]
+		stat, ok := byProvider[key]
+		if !ok {
+			stat = &ProviderStats{Provider: key}
+			byProvider[key] = stat
+			versions[key] = make(map[string]struct{})
+		}
+		stat.Bytes += info.Size()
+		stat.TotalFiles++
+
+		if archive, ok := parseArchiveName(parts[1], parts[2]); ok {
+			stat.Archives++
+			versions[key][archive.Version] = struct{}{}
+		}
+		return nil
+	})
+
+	report := &StatsReport{
+		GeneratedAt: time.Now().UTC(),
+		Providers:   make([]ProviderStats, 0, len(byProvider)),
+	}
+	for key, stat := range byProvider {
+		stat.Versions = len(versions[key])
+		report.TotalBytes += stat.Bytes
+		report.Providers = append(report.Providers, *stat)
+	}
+	sort.Slice(report.Providers, func(i, j int) bool {
+		if report.Providers[i].Bytes != report.Providers[j].Bytes {
+			return report.Providers[i].Bytes > report.Providers[j].Bytes
+		}
+		return report.Providers[i].Provider < report.Providers[j].Provider
+	})
+	return report
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"tf-mirror/internal/common"
+)
+
+// uiPage is the data rendered by uiTemplate
+type uiPage struct {
+	RegistryHost string
+	Providers    []uiProvider
+	Binaries     []uiBinary
+}
+
+// uiProvider is one provider row group of the /ui page
+type uiProvider struct {
+	Namespace string
+	Name      string
+	Versions  []uiVersion
+}
+
+// uiVersion is one version row of the /ui page
+type uiVersion struct {
+	Version   string
+	Platforms []string
+	Bytes     int64
+}
+
+// uiBinary is one HashiCorp tool row of the /ui page
+type uiBinary struct {
+	Tool string
+	common.BinaryInfo
+}
+
+var uiTemplate = template.Must(template.New("ui").Funcs(template.FuncMap{
+	"bytes": common.FormatBytes,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>tf-mirror</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+</style>
+</head>
+<body>
+<h1>tf-mirror</h1>
+<h2>Providers ({{.RegistryHost}})</h2>
+{{if .Providers}}
+<table>
+<tr><th>Provider</th><th>Version</th><th>Platforms</th><th>Size</th></tr>
+{{range $p := .Providers}}{{range $i, $v := $p.Versions}}
+<tr>{{if eq $i 0}}<td rowspan="{{len $p.Versions}}">{{$p.Namespace}}/{{$p.Name}}</td>{{end}}<td>{{$v.Version}}</td><td>{{range $j, $platform := $v.Platforms}}{{if $j}}, {{end}}{{$platform}}{{end}}</td><td>{{bytes $v.Bytes}}</td></tr>
+{{end}}{{end}}
+</table>
+{{else}}
+<p>No providers mirrored yet.</p>
+{{end}}
+<h2>Binaries</h2>
+{{if .Binaries}}
+<table>
+<tr><th>Tool</th><th>Versions</th><th>Platforms</th><th>Downloaded</th></tr>
+{{range .Binaries}}
+<tr><td>{{.Tool}}</td><td>{{range $j, $v := .Versions}}{{if $j}}, {{end}}{{$v}}{{end}}</td><td>{{range $j, $platform := .Platforms}}{{if $j}}, {{end}}{{$platform}}{{end}}</td><td>{{.Downloaded.Format "2006-01-02 15:04 MST"}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No binaries mirrored.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// handleUI handles the /ui endpoint: a plain HTML overview of the mirror
+func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
+	page, err := s.uiPage()
+	if err != nil {
+		s.logger.Error("Failed to build UI page: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// Render into a buffer so a template error does not leave half a page
+	var buf bytes.Buffer
+	if err := renderUI(&buf, page); err != nil {
+		s.logger.Error("Failed to render UI page: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// renderUI writes the /ui page for page to w
+func renderUI(w io.Writer, page *uiPage) error {
+	return uiTemplate.Execute(w, page)
+}
+
+// uiPage collects the providers from the cached scan and the binaries from
+// the downloader metadata
+func (s *Server) uiPage() (*uiPage, error) {
+	providers, err := s.cachedProviders()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &uiPage{RegistryHost: s.registryHost()}
+	for _, provider := range providers {
+		detail, err := s.providerDetail(provider.Namespace, provider.Name)
+		if os.IsNotExist(err) {
+			continue // no index.json yet
+		}
+		if err != nil {
+			s.logger.Warn("Skipping %s/%s in UI: %v", provider.Namespace, provider.Name, err)
+			continue
+		}
+
+		item := uiProvider{Namespace: detail.Namespace, Name: detail.Name}
+		for _, version := range detail.Versions {
+			item.Versions = append(item.Versions, uiVersion{
+				Version:   version.Version,
+				Platforms: version.Platforms,
+				Bytes:     s.versionBytes(detail.Namespace, detail.Name, version),
+			})
+		}
+		if len(item.Versions) > 0 {
+			page.Providers = append(page.Providers, item)
+		}
+	}
+
+	page.Binaries = s.mirroredBinaries()
+	return page, nil
+}
+
+// versionBytes sums the archive sizes of one provider version on disk
+func (s *Server) versionBytes(namespace, name string, version common.ProviderVersionDetail) int64 {
+	var total int64
+	for _, platform := range version.Platforms {
+		filename := fmt.Sprintf("terraform-provider-%s_%s_%s.zip", name, version.Version, platform)
+		if info, err := os.Stat(filepath.Join(s.providerDir(namespace, name), filename)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// mirroredBinaries reads the binaries recorded in the downloader metadata
+// files (one per mirrored registry) in the data path
+func (s *Server) mirroredBinaries() []uiBinary {
+	paths, _ := filepath.Glob(filepath.Join(s.config.DataPath, ".tf-mirror-metadata*.json"))
+
+	byTool := make(map[string]common.BinaryInfo)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var metadata struct {
+			Binaries map[string]common.BinaryInfo `json:"binaries"`
+		}
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			s.logger.Warn("Ignoring unreadable metadata %s: %v", path, err)
+			continue
+		}
+		// Binaries are shared by all registries, keep the latest record
+		for tool, info := range metadata.Binaries {
+			if existing, ok := byTool[tool]; !ok || info.Downloaded.After(existing.Downloaded) {
+				byTool[tool] = info
+			}
+		}
+	}
+
+	binaries := make([]uiBinary, 0, len(byTool))
+	for _, tool := range slices.Sorted(maps.Keys(byTool)) {
+		binaries = append(binaries, uiBinary{Tool: tool, BinaryInfo: byTool[tool]})
+	}
+	return binaries
+}
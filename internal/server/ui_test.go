@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+)
+
+func TestRenderUIEscapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     *uiPage
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:     "empty mirror",
+			page:     &uiPage{RegistryHost: "registry.terraform.io"},
+			want:     []string{"No providers mirrored yet.", "No binaries mirrored."},
+			wantNone: []string{"<table>"},
+		},
+		{
+			name: "script in a provider name",
+			page: &uiPage{Providers: []uiProvider{{
+				Namespace: "evil", Name: "<script>alert(1)</script>",
+				Versions: []uiVersion{{Version: "1.0.0", Platforms: []string{"linux_amd64"}}},
+			}}},
+			want:     []string{"evil/&lt;script&gt;alert(1)&lt;/script&gt;"},
+			wantNone: []string{"<script>"},
+		},
+		{
+			name: "markup in versions and platforms",
+			page: &uiPage{Providers: []uiProvider{{
+				Namespace: "a&b", Name: `"quoted"`,
+				Versions: []uiVersion{{Version: "1.0.0<b>", Platforms: []string{"linux_<i>amd64</i>"}}},
+			}}},
+			want:     []string{"a&amp;b/&#34;quoted&#34;", "1.0.0&lt;b&gt;", "linux_&lt;i&gt;amd64&lt;/i&gt;"},
+			wantNone: []string{"<b>", "<i>"},
+		},
+		{
+			name: "markup in a binary",
+			page: &uiPage{Binaries: []uiBinary{{
+				Tool:       "<img src=x onerror=alert(1)>",
+				BinaryInfo: common.BinaryInfo{Versions: []string{"1.7.0"}, Platforms: []string{"linux_amd64"}},
+			}}},
+			want:     []string{"&lt;img src=x onerror=alert(1)&gt;", "1.7.0"},
+			wantNone: []string{"<img"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := renderUI(&buf, tt.page); err != nil {
+				t.Fatalf("renderUI: %v", err)
+			}
+			html := buf.String()
+			for _, want := range tt.want {
+				if !strings.Contains(html, want) {
+					t.Errorf("page does not contain %q", want)
+				}
+			}
+			for _, unwanted := range tt.wantNone {
+				if strings.Contains(html, unwanted) {
+					t.Errorf("page contains %q", unwanted)
+				}
+			}
+		})
+	}
+}
+
+func TestUIRoute(t *testing.T) {
+	tests := []struct {
+		name       string
+		enableUI   bool
+		wantStatus int
+		want       []string
+	}{
+		{name: "disabled", wantStatus: http.StatusNotFound},
+		{
+			name:       "enabled",
+			enableUI:   true,
+			wantStatus: http.StatusOK,
+			want: []string{
+				"Providers (registry.terraform.io)",
+				"hashicorp/null", "3.2.0", "darwin_arm64, linux_amd64", "3.1.0",
+				"acme/nullish", "windows_amd64",
+				"<td>terraform</td>", "1.6.0, 1.7.0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{EnableUI: tt.enableUI})
+			writeProviderFixture(t, srv.config.DataPath)
+			writeTestJSON(t, filepath.Join(srv.config.DataPath, ".tf-mirror-metadata.json"), map[string]any{
+				"binaries": map[string]common.BinaryInfo{
+					"terraform": {Versions: []string{"1.6.0", "1.7.0"}, Platforms: []string{"linux_amd64"}, Downloaded: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)},
+				},
+			})
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/ui", nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want text/html", ct)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(rec.Body.String(), want) {
+					t.Errorf("page does not contain %q", want)
+				}
+			}
+		})
+	}
+}
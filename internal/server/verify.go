@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"tf-mirror/internal/verify"
+)
+
+// minVerifyInterval limits how often a verification pass can be triggered over HTTP
+const minVerifyInterval = 5 * time.Minute
+
+// verifyState caches the last verification report. Passes run in the
+// background, one at a time, so requests never wait on hashing the mirror.
+type verifyState struct {
+	mu      sync.Mutex
+	report  *verify.Report
+	running bool
+}
+
+// handleVerify handles the /api/verify endpoint. GET returns the cached report;
+// POST (or ?refresh=true) starts a new pass unless the last one finished less
+// than minVerifyInterval ago. A started pass, or the first one while no report
+// exists, is answered with 202 Accepted and the report is fetched again later.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	refresh := r.Method == http.MethodPost || r.URL.Query().Get("refresh") == "true"
+
+	s.verify.mu.Lock()
+	report := s.verify.report
+	started := false
+	if !s.verify.running && (report == nil || (refresh && time.Since(report.FinishedAt) >= minVerifyInterval)) {
+		s.verify.running = true
+		started = true
+		go s.runVerify()
+	}
+	running := s.verify.running
+	s.verify.mu.Unlock()
+
+	if report == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"running"}` + "\n"))
+		return
+	}
+	if started || (refresh && running) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+	}
+	s.writeJSONResponse(w, report)
+}
+
+// runVerify runs a verification pass and caches its report
+func (s *Server) runVerify() {
+	report, err := verify.Run(s.config.DataPath, s.registryHost(), verify.Options{})
+
+	s.verify.mu.Lock()
+	defer s.verify.mu.Unlock()
+	s.verify.running = false
+	if err != nil {
+		s.logger.Error("Verification failed: %v", err)
+		return
+	}
+	s.verify.report = report
+	s.logger.Info("Verification pass completed: %d checked, %d failures", report.Checked, len(report.Failures))
+}
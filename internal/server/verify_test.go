@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tf-mirror/internal/common"
+	"tf-mirror/internal/verify"
+)
+
+// getVerifyReport polls /api/verify until the background pass has produced
+// a report and returns it
+func getVerifyReport(t *testing.T, srv *Server) *verify.Report {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec := serve(srv, httptest.NewRequest(http.MethodGet, "/api/verify", nil))
+		if rec.Code == http.StatusOK {
+			var report verify.Report
+			if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			return &report
+		}
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no verification report after 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVerifyReport(t *testing.T) {
+	const archive = "terraform-provider-null_3.2.0_linux_amd64.zip"
+	tests := []struct {
+		name         string
+		content      string // archive content on disk, the published content if empty
+		wantFailures []string
+	}{
+		{name: "intact archive"},
+		{name: "tampered archive", content: "tampered", wantFailures: []string{archive}},
+		{name: "truncated archive", content: "arch", wantFailures: []string{archive}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, &common.ServerConfig{})
+			dir := filepath.Join(srv.config.DataPath, common.DefaultRegistryHost, "hashicorp", "null")
+			sum := sha256.Sum256([]byte("archive"))
+			writeTestFile(t, filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"), hex.EncodeToString(sum[:])+"  "+archive+"\n")
+			content := "archive"
+			if tt.content != "" {
+				content = tt.content
+			}
+			writeTestFile(t, filepath.Join(dir, archive), content)
+
+			rec := serve(srv, httptest.NewRequest(http.MethodGet, "/api/verify", nil))
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("first GET status = %d, want %d while the pass runs", rec.Code, http.StatusAccepted)
+			}
+			report := getVerifyReport(t, srv)
+			if report.Checked != 1 {
+				t.Errorf("checked = %d, want 1", report.Checked)
+			}
+			var failed []string
+			for _, failure := range report.Failures {
+				failed = append(failed, filepath.Base(failure.Path))
+			}
+			if strings.Join(failed, ",") != strings.Join(tt.wantFailures, ",") {
+				t.Errorf("failures = %v, want %v", failed, tt.wantFailures)
+			}
+		})
+	}
+}
+
+func TestVerifyRefreshIsRateLimited(t *testing.T) {
+	srv := newTestServer(t, &common.ServerConfig{})
+	writeTestFile(t, filepath.Join(srv.config.DataPath, common.DefaultRegistryHost, "hashicorp", "null", "terraform-provider-null_3.2.0_linux_amd64.zip"), "archive")
+	first := getVerifyReport(t, srv)
+
+	rec := serve(srv, httptest.NewRequest(http.MethodPost, "/api/verify", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST status = %d, want %d within the minimum interval", rec.Code, http.StatusOK)
+	}
+	var report verify.Report
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !report.FinishedAt.Equal(first.FinishedAt) {
+		t.Errorf("POST ran a new pass within the minimum interval")
+	}
+}
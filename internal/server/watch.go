@@ -0,0 +1,142 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// watchDebounce coalesces bursts of filesystem events into one refresh
+	watchDebounce = 2 * time.Second
+
+	// watchPollInterval is the refresh period when fsnotify is unavailable
+	watchPollInterval = 30 * time.Second
+)
+
+// watchProviders keeps the provider cache current while a separate downloader
+// writes into the data path. It watches the registry host directory, its namespaces
+// and provider directories (fsnotify is not recursive) and refreshes the cache
+// after a quiet period. If the watcher cannot be set up, e.g. on network
+// filesystems without inotify support, it falls back to polling.
+func (s *Server) watchProviders(done <-chan struct{}) {
+	root := s.providerRoot()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(root)
+		if err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		s.logger.Warn("Filesystem watcher unavailable, polling %s every %s: %v", root, watchPollInterval, err)
+		s.pollProviders(done)
+		return
+	}
+	defer watcher.Close()
+
+	s.addProviderWatches(watcher, root, 2)
+
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Has(fsnotify.Create) {
+				// Watch new namespace/provider directories as they appear
+				if rel, err := filepath.Rel(root, event.Name); err == nil {
+					s.addProviderWatches(watcher, event.Name, 2-pathDepth(rel))
+				}
+			}
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				debounce.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			s.logger.Warn("Filesystem watcher error: %v", err)
+		case <-debounce.C:
+			s.invalidateCaches()
+		}
+	}
+}
+
+// addProviderWatches watches dir and its subdirectories down to depth more levels
+func (s *Server) addProviderWatches(watcher *fsnotify.Watcher, dir string, depth int) {
+	if depth < 0 {
+		return
+	}
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return
+	}
+	if err := watcher.Add(dir); err != nil {
+		s.logger.Debug("Cannot watch %s: %v", dir, err)
+		return
+	}
+	if depth == 0 {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			s.addProviderWatches(watcher, filepath.Join(dir, entry.Name()), depth-1)
+		}
+	}
+}
+
+// pollProviders refreshes the caches every watchPollInterval until done is closed
+func (s *Server) pollProviders(done <-chan struct{}) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.invalidateCaches()
+		}
+	}
+}
+
+// invalidateCaches rescans providers and drops the cached /stats report and
+// readiness result
+func (s *Server) invalidateCaches() {
+	if _, err := s.RefreshProviders(); err != nil {
+		s.logger.Warn("Failed to refresh provider list: %v", err)
+	}
+
+	s.stats.mu.Lock()
+	s.stats.report = nil
+	s.stats.mu.Unlock()
+
+	s.ready.mu.Lock()
+	s.ready.checkedAt = time.Time{}
+	s.ready.mu.Unlock()
+}
+
+// pathDepth returns the number of elements in a relative path
+func pathDepth(rel string) int {
+	depth := 1
+	for _, r := range filepath.ToSlash(rel) {
+		if r == '/' {
+			depth++
+		}
+	}
+	return depth
+}
@@ -0,0 +1,178 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tf-mirror/internal/common"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// Report summarizes an integrity verification pass over the mirror
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Checked    int       `json:"checked"`
+	Unverified int       `json:"unverified"`
+	Failures   []Failure `json:"failures"`
+}
+
+// Failure describes an archive that does not match its recorded hash
+type Failure struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	Reason   string `json:"reason"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+// Options controls a verification pass
+type Options struct {
+	// Delete removes archives that fail verification
+	Delete bool
+	// Filter, if set, limits the pass to the archives it returns true for
+	Filter func(archivePath string) bool
+}
+
+// OK returns true if no failures were found
+func (r *Report) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// Run walks <dataPath>/<hostname> and checks every provider archive against
+// the SHA256SUMS file stored next to it and the h1: hash in its <version>.json.
+// Archives without any recorded hash are counted as unverified. No network I/O
+// is performed.
+func Run(dataPath, hostname string, opts Options) (*Report, error) {
+	report := &Report{
+		StartedAt: time.Now().UTC(),
+		Failures:  []Failure{},
+	}
+
+	root := filepath.Join(dataPath, hostname)
+	if _, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("failed to access %s: %w", root, err)
+	}
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil // Skip errors, files are handled per directory
+		}
+
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil
+		}
+
+		sums := make(map[string]string)
+		h1 := make(map[string]string)
+		for _, entry := range entries {
+			switch {
+			case entry.IsDir():
+			case strings.HasSuffix(entry.Name(), "_SHA256SUMS"):
+				fileSums, _ := common.ReadSHASums(filepath.Join(path, entry.Name()))
+				for name, sum := range fileSums {
+					sums[name] = sum
+				}
+			case strings.HasSuffix(entry.Name(), ".json") && entry.Name() != "index.json":
+				for name, hash := range readH1Hashes(filepath.Join(path, entry.Name())) {
+					h1[name] = hash
+				}
+			}
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasPrefix(name, "terraform-provider-") || !strings.HasSuffix(name, ".zip") {
+				continue
+			}
+			archivePath := filepath.Join(path, name)
+			if opts.Filter != nil && !opts.Filter(archivePath) {
+				continue
+			}
+			relPath, _ := filepath.Rel(dataPath, archivePath)
+
+			expectedSum, hasSum := sums[name]
+			expectedH1, hasH1 := h1[name]
+			if !hasSum && !hasH1 {
+				report.Unverified++
+				continue
+			}
+
+			report.Checked++
+			failure := checkArchive(archivePath, expectedSum, expectedH1)
+			if failure == nil {
+				continue
+			}
+			failure.Path = relPath
+			if opts.Delete {
+				if err := os.Remove(archivePath); err == nil {
+					failure.Deleted = true
+				}
+			}
+			report.Failures = append(report.Failures, *failure)
+		}
+		return nil
+	})
+
+	report.FinishedAt = time.Now().UTC()
+	return report, err
+}
+
+// checkArchive compares an archive with its expected SHA256 and h1: hash,
+// either of which may be empty. It returns nil if the archive matches.
+func checkArchive(archivePath, expectedSum, expectedH1 string) *Failure {
+	if expectedSum != "" {
+		actual, err := common.FileSHA256(archivePath)
+		if err != nil {
+			return &Failure{Expected: expectedSum, Reason: err.Error()}
+		}
+		if !strings.EqualFold(actual, expectedSum) {
+			return &Failure{Expected: expectedSum, Actual: actual, Reason: "sha256 mismatch"}
+		}
+	}
+	if expectedH1 != "" {
+		actual, err := dirhash.HashZip(archivePath, dirhash.Hash1)
+		if err != nil {
+			return &Failure{Expected: expectedH1, Reason: fmt.Sprintf("invalid zip archive: %v", err)}
+		}
+		if actual != expectedH1 {
+			return &Failure{Expected: expectedH1, Actual: actual, Reason: "h1 mismatch"}
+		}
+	}
+	return nil
+}
+
+// readH1Hashes returns the h1: hash of every archive listed in a <version>.json,
+// keyed by archive filename. Unreadable files yield no hashes.
+func readH1Hashes(path string) map[string]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var versionFile struct {
+		Archives map[string]struct {
+			URL    string   `json:"url"`
+			Hashes []string `json:"hashes"`
+		} `json:"archives"`
+	}
+	if err := json.Unmarshal(data, &versionFile); err != nil {
+		return nil
+	}
+
+	hashes := make(map[string]string)
+	for _, archive := range versionFile.Archives {
+		for _, hash := range archive.Hashes {
+			if strings.HasPrefix(hash, "h1:") {
+				hashes[filepath.Base(archive.URL)] = hash
+				break
+			}
+		}
+	}
+	return hashes
+}
@@ -0,0 +1,162 @@
+package verify
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/sumdb/dirhash"
+
+	"tf-mirror/internal/common"
+)
+
+const (
+	host    = "registry.terraform.io"
+	archive = "terraform-provider-null_3.2.0_linux_amd64.zip"
+)
+
+// writeFixture writes a verified provider version under dataPath: the archive,
+// its SHA256SUMS and a 3.2.0.json with its h1: hash. It returns the archive path.
+func writeFixture(t *testing.T, dataPath string) string {
+	t.Helper()
+	dir := filepath.Join(dataPath, host, "hashicorp", "null")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, archive)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, _ := w.Create("terraform-provider-null")
+	entry.Write([]byte("provider binary"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	sum, err := common.FileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, err := dirhash.HashZip(path, dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"), fmt.Sprintf("%s  %s\n", sum, archive))
+	versionFile, _ := json.Marshal(map[string]any{
+		"archives": map[string]any{"linux_amd64": map[string]any{"url": archive, "hashes": []string{h1}}},
+	})
+	writeFile(t, filepath.Join(dir, "3.2.0.json"), string(versionFile))
+	return path
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name           string
+		corrupt        func(t *testing.T, archivePath string)
+		delete         bool
+		wantChecked    int
+		wantUnverified int
+		wantReason     string // of the single failure, empty if none
+	}{
+		{name: "intact archive", wantChecked: 1},
+		{
+			name: "flipped byte",
+			corrupt: func(t *testing.T, archivePath string) {
+				data, _ := os.ReadFile(archivePath)
+				data[len(data)/2] ^= 0xff
+				writeFile(t, archivePath, string(data))
+			},
+			wantChecked: 1, wantReason: "sha256 mismatch",
+		},
+		{
+			name: "truncated write",
+			corrupt: func(t *testing.T, archivePath string) {
+				data, _ := os.ReadFile(archivePath)
+				writeFile(t, archivePath, string(data[:len(data)/2]))
+			},
+			wantChecked: 1, wantReason: "sha256 mismatch",
+		},
+		{
+			name: "corrupt and deleted",
+			corrupt: func(t *testing.T, archivePath string) {
+				writeFile(t, archivePath, "garbage")
+			},
+			delete:      true,
+			wantChecked: 1, wantReason: "sha256 mismatch",
+		},
+		{
+			name: "only the h1 hash recorded",
+			corrupt: func(t *testing.T, archivePath string) {
+				os.Remove(filepath.Join(filepath.Dir(archivePath), "terraform-provider-null_3.2.0_SHA256SUMS"))
+				writeFile(t, archivePath, "garbage")
+			},
+			wantChecked: 1, wantReason: "invalid zip archive",
+		},
+		{
+			name: "no recorded hash",
+			corrupt: func(t *testing.T, archivePath string) {
+				dir := filepath.Dir(archivePath)
+				os.Remove(filepath.Join(dir, "terraform-provider-null_3.2.0_SHA256SUMS"))
+				os.Remove(filepath.Join(dir, "3.2.0.json"))
+			},
+			wantUnverified: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataPath := t.TempDir()
+			archivePath := writeFixture(t, dataPath)
+			if tt.corrupt != nil {
+				tt.corrupt(t, archivePath)
+			}
+
+			report, err := Run(dataPath, host, Options{Delete: tt.delete})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if report.Checked != tt.wantChecked || report.Unverified != tt.wantUnverified {
+				t.Errorf("checked/unverified = %d/%d, want %d/%d", report.Checked, report.Unverified, tt.wantChecked, tt.wantUnverified)
+			}
+			if tt.wantReason == "" {
+				if !report.OK() {
+					t.Errorf("failures = %+v, want none", report.Failures)
+				}
+				return
+			}
+			if len(report.Failures) != 1 {
+				t.Fatalf("failures = %+v, want one", report.Failures)
+			}
+			failure := report.Failures[0]
+			if failure.Path != filepath.Join(host, "hashicorp", "null", archive) {
+				t.Errorf("path = %q, want relative to the data path", failure.Path)
+			}
+			if !strings.HasPrefix(failure.Reason, tt.wantReason) {
+				t.Errorf("reason = %q, want %q", failure.Reason, tt.wantReason)
+			}
+			_, statErr := os.Stat(archivePath)
+			if deleted := os.IsNotExist(statErr); deleted != tt.delete || failure.Deleted != tt.delete {
+				t.Errorf("archive deleted = %t (reported %t), want %t", deleted, failure.Deleted, tt.delete)
+			}
+		})
+	}
+}
+
+func TestRunMissingHost(t *testing.T) {
+	if _, err := Run(t.TempDir(), host, Options{}); err == nil {
+		t.Error("Run succeeded without a mirror for the host")
+	}
+}